@@ -0,0 +1,114 @@
+// Package amsign signs the requests Will.IAM makes to services' AM
+// endpoints and lets services verify them. A signed request carries the
+// service account it's made on behalf of, a timestamp and an HMAC-SHA256
+// of method, request URI, timestamp and service account, keyed with the
+// service's AM secret
+package amsign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of a signed request
+const (
+	ServiceAccountHeader = "X-WillIAM-Service-Account"
+	TimestampHeader      = "X-WillIAM-Timestamp"
+	SignatureHeader      = "X-WillIAM-Signature"
+)
+
+// DefaultMaxSkew is how old (or how far in the future) a signed request
+// may be
+const DefaultMaxSkew = 5 * time.Minute
+
+const signatureVersion = "v1"
+
+// Verify errors
+var (
+	ErrUnsigned         = errors.New("amsign: request is not signed")
+	ErrExpired          = errors.New("amsign: request timestamp out of range")
+	ErrInvalidSignature = errors.New("amsign: invalid signature")
+)
+
+type ctxKey struct{}
+
+// Sign adds to req the headers proving Will.IAM made it on behalf of
+// serviceAccountID at now
+func Sign(
+	req *http.Request, secret []byte, serviceAccountID string, now time.Time,
+) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(ServiceAccountHeader, serviceAccountID)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, fmt.Sprintf(
+		"%s=%s", signatureVersion, sign(req, secret, ts, serviceAccountID),
+	))
+}
+
+// Verify checks req was signed with secret within maxSkew of now and
+// returns the service account it was made on behalf of
+func Verify(
+	req *http.Request, secret []byte, maxSkew time.Duration, now time.Time,
+) (string, error) {
+	ts := req.Header.Get(TimestampHeader)
+	signature := req.Header.Get(SignatureHeader)
+	if ts == "" || signature == "" {
+		return "", ErrUnsigned
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrExpired
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return "", ErrExpired
+	}
+	serviceAccountID := req.Header.Get(ServiceAccountHeader)
+	expected := fmt.Sprintf(
+		"%s=%s", signatureVersion, sign(req, secret, ts, serviceAccountID),
+	)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrInvalidSignature
+	}
+	return serviceAccountID, nil
+}
+
+// Middleware answers 401 to requests not signed with secret and puts the
+// service account of the others in their context, see ServiceAccountID
+func Middleware(
+	secret []byte, maxSkew time.Duration,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serviceAccountID, err := Verify(r, secret, maxSkew, time.Now())
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(
+				context.WithValue(r.Context(), ctxKey{}, serviceAccountID),
+			))
+		})
+	}
+}
+
+// ServiceAccountID returns the service account Middleware verified
+func ServiceAccountID(ctx context.Context) (string, bool) {
+	serviceAccountID, ok := ctx.Value(ctxKey{}).(string)
+	return serviceAccountID, ok
+}
+
+func sign(req *http.Request, secret []byte, ts, serviceAccountID string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(
+		mac, "%s\n%s\n%s\n%s\n%s", signatureVersion, req.Method,
+		req.URL.RequestURI(), ts, serviceAccountID,
+	)
+	return hex.EncodeToString(mac.Sum(nil))
+}
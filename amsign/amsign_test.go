@@ -0,0 +1,80 @@
+// +build unit
+
+package amsign_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/amsign"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	req := httptest.NewRequest("GET", "/am?prefix=RL%3A%3A", nil)
+	amsign.Sign(req, secret, "sa-id", now)
+	saID, err := amsign.Verify(req, secret, amsign.DefaultMaxSkew, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if saID != "sa-id" {
+		t.Errorf("Expected sa-id. Got %s", saID)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	type testCase struct {
+		name     string
+		tamper   func(*http.Request)
+		at       time.Time
+		expected error
+	}
+	testCases := []testCase{
+		testCase{
+			name:     "other service account",
+			tamper:   func(r *http.Request) { r.Header.Set(amsign.ServiceAccountHeader, "other") },
+			at:       now,
+			expected: amsign.ErrInvalidSignature,
+		},
+		testCase{
+			name:     "other prefix",
+			tamper:   func(r *http.Request) { r.URL.RawQuery = "prefix=RL%3A%3AX" },
+			at:       now,
+			expected: amsign.ErrInvalidSignature,
+		},
+		testCase{
+			name:     "unsigned",
+			tamper:   func(r *http.Request) { r.Header.Del(amsign.SignatureHeader) },
+			at:       now,
+			expected: amsign.ErrUnsigned,
+		},
+		testCase{
+			name:     "expired",
+			tamper:   func(r *http.Request) {},
+			at:       now.Add(amsign.DefaultMaxSkew + time.Second),
+			expected: amsign.ErrExpired,
+		},
+	}
+	for _, tt := range testCases {
+		req := httptest.NewRequest("GET", "/am?prefix=RL%3A%3A", nil)
+		amsign.Sign(req, secret, "sa-id", now)
+		tt.tamper(req)
+		if _, err := amsign.Verify(
+			req, secret, amsign.DefaultMaxSkew, tt.at,
+		); err != tt.expected {
+			t.Errorf("%s: expected %v. Got %v", tt.name, tt.expected, err)
+		}
+	}
+	req := httptest.NewRequest("GET", "/am?prefix=RL%3A%3A", nil)
+	amsign.Sign(req, secret, "sa-id", now)
+	if _, err := amsign.Verify(
+		req, []byte("other"), amsign.DefaultMaxSkew, now,
+	); err != amsign.ErrInvalidSignature {
+		t.Errorf("other secret: expected %v. Got %v", amsign.ErrInvalidSignature, err)
+	}
+}
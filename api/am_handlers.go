@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/ghostec/Will.IAM/usecases"
@@ -19,8 +20,17 @@ func amListHandler(
 		if len(prefixSl) != 0 {
 			prefix = prefixSl[0]
 		}
+		listOptions, err := buildListOptions(r)
+		if err != nil {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				fmt.Sprintf(`{ "error": "%s"  }`, err.Error()),
+			)
+			return
+		}
 		saID, _ := getServiceAccountID(r.Context())
-		results, err := amUC.WithContext(r.Context()).List(saID, prefix)
+		results, err := amUC.WithContext(r.Context()).
+			List(saID, prefix, listOptions)
 		if err != nil {
 			l.WithError(err).Error("usecases.AM.List error")
 			w.WriteHeader(http.StatusInternalServerError)
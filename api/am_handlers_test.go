@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
 	helpers "github.com/ghostec/Will.IAM/testing"
 )
 
@@ -55,7 +57,9 @@ func TestAMListHandler(t *testing.T) {
 			expectedOutput: []string{
 				"Will.IAM::*", "Will.IAM::CreateRoles", "Will.IAM::EditRole",
 				"Will.IAM::CreateServiceAccounts", "Will.IAM::EditServiceAccount",
+				"Will.IAM::ImpersonateServiceAccount",
 				"Will.IAM::CreateServices", "Will.IAM::EditService",
+				"Will.IAM::ProvisionSCIM",
 			},
 		},
 		testCase{
@@ -127,3 +131,75 @@ func TestAMListHandler(t *testing.T) {
 		}
 	}
 }
+
+func listAMPrefixes(
+	t *testing.T, app *api.App, authorization, reqPath string,
+) []string {
+	t.Helper()
+	req, _ := http.NewRequest("GET", reqPath, nil)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", rec.Code)
+	}
+	ams := []models.AM{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ams); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	prefixes := make([]string, len(ams))
+	for i := range ams {
+		prefixes[i] = ams[i].Prefix
+	}
+	return prefixes
+}
+
+func TestAMListHandlerServiceAccounts(t *testing.T) {
+	beforeEachAMHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	alice, err := saUC.CreateKeyPairType("alice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	bob, err := saUC.CreateKeyPairType("bob")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission(
+		models.BuildWillIAMPermissionOwner("EditServiceAccount", bob.ID),
+	)
+	if err := saUC.CreatePermission(alice.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	rootAuth := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+
+	prefixes := listAMPrefixes(
+		t, app, rootAuth, "/am?prefix=Will.IAM::EditServiceAccount::al",
+	)
+	expected := []string{
+		"Will.IAM::EditServiceAccount::*",
+		fmt.Sprintf("Will.IAM::EditServiceAccount::%s", alice.ID),
+	}
+	if fmt.Sprint(prefixes) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v. Got %v", expected, prefixes)
+	}
+
+	prefixes = listAMPrefixes(
+		t, app, rootAuth, "/am?prefix=Will.IAM::EditServiceAccount::&pageSize=1",
+	)
+	if len(prefixes) != 2 {
+		t.Errorf("Expected * and 1 service account. Got %v", prefixes)
+	}
+
+	prefixes = listAMPrefixes(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", alice.KeyID, alice.KeySecret,
+	), "/am?prefix=Will.IAM::EditServiceAccount::")
+	expected = []string{
+		"Will.IAM::EditServiceAccount::*",
+		fmt.Sprintf("Will.IAM::EditServiceAccount::%s", bob.ID),
+	}
+	if fmt.Sprint(prefixes) != fmt.Sprint(expected) {
+		t.Errorf("Expected only bob. Got %v", prefixes)
+	}
+}
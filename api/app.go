@@ -1,9 +1,15 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/ghostec/Will.IAM/constants"
 	"github.com/ghostec/Will.IAM/models"
@@ -28,7 +34,21 @@ type App struct {
 	server          *http.Server
 	metricsReporter middleware.MetricsReporter
 	storage         *repositories.Storage
-	oauth2Provider  oauth2.Provider
+	oauth2Providers *oauth2.Providers
+	sso             ssoConfig
+	authThrottle    *authThrottle
+	tokenUsage      *usecases.TokenUsageRecorder
+	amClient        *usecases.AMClient
+	keyRotation     keyRotationConfig
+	tls             tlsConfig
+	decisions       *usecases.DecisionLogger
+}
+
+// tlsConfig holds how App serves TLS; config is nil when it doesn't
+type tlsConfig struct {
+	certFile string
+	keyFile  string
+	config   *tls.Config
 }
 
 // NewApp creates a new app
@@ -65,7 +85,24 @@ func (a *App) configureApp() error {
 		return err
 	}
 
-	a.configureGoogleOAuth2Provider()
+	if err := a.configureOAuth2Providers(); err != nil {
+		return err
+	}
+	if err := a.configureSSO(); err != nil {
+		return err
+	}
+	if err := a.configureAuthThrottle(); err != nil {
+		return err
+	}
+	if err := a.configureTLS(); err != nil {
+		return err
+	}
+	if err := a.configureDecisions(); err != nil {
+		return err
+	}
+	a.configureSessions()
+	a.configureAM()
+	a.configureKeyRotation()
 	a.configureServer()
 
 	return nil
@@ -82,11 +119,50 @@ func (a *App) configureServer() {
 	})
 	handler := c.Handler(a.router)
 	a.server = &http.Server{
-		Addr:    a.address,
-		Handler: wrapHandlerWithResponseWriter(handler),
+		Addr:      a.address,
+		Handler:   wrapHandlerWithResponseWriter(handler),
+		TLSConfig: a.tls.config,
 	}
 }
 
+// configureTLS reads tls.*. Client certificates issued by a CA in
+// tls.clientCAFiles are verified if given, or required with
+// tls.clientAuth: require, and authenticate certificate service accounts
+func (a *App) configureTLS() error {
+	if !a.config.GetBool("tls.enabled") {
+		return nil
+	}
+	a.tls.certFile = a.config.GetString("tls.certFile")
+	a.tls.keyFile = a.config.GetString("tls.keyFile")
+	a.tls.config = &tls.Config{ClientAuth: tls.NoClientCert}
+	caFiles := a.config.GetStringSlice("tls.clientCAFiles")
+	if len(caFiles) == 0 {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	for _, caFile := range caFiles {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls.clientCAFiles: no certificates in %s", caFile)
+		}
+	}
+	a.tls.config.ClientCAs = pool
+	switch clientAuth := a.config.GetString("tls.clientAuth"); clientAuth {
+	case "", "verifyIfGiven":
+		a.tls.config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		a.tls.config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf(
+			"tls.clientAuth: %s is not verifyIfGiven or require", clientAuth,
+		)
+	}
+	return nil
+}
+
 func (a *App) configurePG() error {
 	if a.storage != nil && a.storage.PG != nil {
 		return nil
@@ -107,20 +183,155 @@ func (a *App) configureJaeger() error {
 	return err
 }
 
-func (a *App) configureGoogleOAuth2Provider() {
-	repo := repositories.New(a.storage)
-	google := oauth2.NewGoogle(oauth2.GoogleConfig{
-		ClientID:      a.config.GetString("oauth2.google.clientId"),
-		ClientSecret:  a.config.GetString("oauth2.google.clientSecret"),
-		RedirectURL:   a.config.GetString("oauth2.google.redirectUrl"),
-		HostedDomains: a.config.GetStringSlice("oauth2.google.hostedDomains"),
-	}, repo)
-	a.oauth2Provider = google
+func (a *App) configureOAuth2Providers() error {
+	providers, err := oauth2.NewProvidersFromConfig(
+		a.config, repositories.New(a.storage),
+	)
+	if err != nil {
+		return err
+	}
+	a.oauth2Providers = providers
+	return nil
+}
+
+func (a *App) configureSSO() error {
+	secret := []byte(a.config.GetString("sso.stateSecret"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		a.logger.Warn(
+			"sso.stateSecret not set, using a random one: SSO will fail if " +
+				"login starts and ends in different instances",
+		)
+	}
+	origins := a.config.GetStringSlice("sso.redirectOrigins")
+	for _, origin := range origins {
+		if !models.IsOrigin(origin) {
+			return fmt.Errorf("sso.redirectOrigins: %s is not an origin", origin)
+		}
+	}
+	a.sso = ssoConfig{stateSecret: secret, redirectOrigins: origins}
+	return nil
+}
+
+func (a *App) configureAuthThrottle() error {
+	throttle, err := newAuthThrottle(a.config)
+	if err != nil {
+		return err
+	}
+	a.authThrottle = throttle
+	return nil
+}
+
+// configureSessions starts recording when access tokens are used, written
+// every sessions.flushInterval
+func (a *App) configureSessions() {
+	interval := a.config.GetDuration("sessions.flushInterval")
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	maxPending := a.config.GetInt("sessions.maxPending")
+	if maxPending <= 0 {
+		maxPending = 10000
+	}
+	a.tokenUsage = usecases.NewTokenUsageRecorder(
+		repositories.New(a.storage), a.logger, interval, maxPending,
+	)
+	a.tokenUsage.Start()
+}
+
+// configureDecisions reads decisions.*, where and how much of permission
+// checks' decisions are logged
+func (a *App) configureDecisions() error {
+	bufferSize := a.config.GetInt("decisions.bufferSize")
+	if bufferSize <= 0 {
+		bufferSize = 10000
+	}
+	var sink usecases.DecisionSink
+	switch name := a.config.GetString("decisions.sink"); name {
+	case "", "none":
+		return nil
+	case "stdout":
+		sink = usecases.NewWriterDecisionSink(os.Stdout, bufferSize, a.logger)
+	case "file":
+		fileSink, err := usecases.NewFileDecisionSink(
+			a.config.GetString("decisions.file"), bufferSize, a.logger,
+		)
+		if err != nil {
+			return err
+		}
+		sink = fileSink
+	default:
+		return fmt.Errorf("decisions.sink: %s is not none, stdout or file", name)
+	}
+	config := usecases.DecisionLoggerConfig{
+		AllowedSampleRate: 1,
+		DeniedSampleRate:  1,
+	}
+	if a.config.IsSet("decisions.sampleRate.allowed") {
+		config.AllowedSampleRate = a.config.GetFloat64("decisions.sampleRate.allowed")
+	}
+	if a.config.IsSet("decisions.sampleRate.denied") {
+		config.DeniedSampleRate = a.config.GetFloat64("decisions.sampleRate.denied")
+	}
+	a.decisions = usecases.NewDecisionLogger(sink, config)
+	return nil
+}
+
+// configureKeyRotation reads services.keyRotation.*, for how long service
+// accounts' previous key pairs keep working after a rotation
+func (a *App) configureKeyRotation() {
+	a.keyRotation = keyRotationConfig{
+		overlap:    a.config.GetDuration("services.keyRotation.overlap"),
+		maxOverlap: a.config.GetDuration("services.keyRotation.maxOverlap"),
+	}
+	if a.keyRotation.maxOverlap <= 0 {
+		a.keyRotation.maxOverlap = 7 * 24 * time.Hour
+	}
+	if !a.config.IsSet("services.keyRotation.overlap") {
+		a.keyRotation.overlap = 24 * time.Hour
+	}
+	if a.keyRotation.overlap > a.keyRotation.maxOverlap {
+		a.keyRotation.overlap = a.keyRotation.maxOverlap
+	}
+}
+
+// configureAM reads am.*, how services' AM endpoints are called
+func (a *App) configureAM() {
+	config := usecases.AMClientConfig{
+		Timeout:         a.config.GetDuration("am.timeout"),
+		MaxFailures:     a.config.GetInt("am.breaker.maxFailures"),
+		Cooldown:        a.config.GetDuration("am.breaker.cooldown"),
+		CacheTTL:        a.config.GetDuration("am.cache.ttl"),
+		MaxCacheEntries: a.config.GetInt("am.cache.maxEntries"),
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+	if config.MaxCacheEntries <= 0 {
+		config.MaxCacheEntries = 10000
+	}
+	a.amClient = usecases.NewAMClient(config, a.logger)
 }
 
-// SetOAuth2Provider sets a provider in App
+// SetOAuth2Provider sets a provider in App as the only one available to
+// log in; tokens issued by Will.IAM itself are still accepted
 func (a *App) SetOAuth2Provider(provider oauth2.Provider) {
-	a.oauth2Provider = provider
+	repo := repositories.New(a.storage)
+	providers := oauth2.NewProviders(repo)
+	providers.SetIssuer(constants.AppInfo.Name, oauth2.NewLocal(repo))
+	providers.Add(oauth2.GoogleProviderName, provider)
+	a.oauth2Providers = providers
+}
+
+// SetOAuth2Providers sets all providers in App
+func (a *App) SetOAuth2Providers(providers *oauth2.Providers) {
+	a.oauth2Providers = providers
 }
 
 // GetRouter returns App's *mux.Router reference
@@ -129,6 +340,7 @@ func (a *App) GetRouter() *mux.Router {
 	r.Use(middleware.Version(constants.AppInfo.Version))
 	r.Use(middleware.Logging(a.logger))
 	r.Use(middleware.Metrics(a.metricsReporter))
+	r.Use(requestIDMiddleware)
 
 	repo := repositories.New(a.storage)
 
@@ -136,28 +348,63 @@ func (a *App) GetRouter() *mux.Router {
 		usecases.NewHealthcheck(repo),
 	)).Methods("GET").Name("healthcheck")
 
+	ssUC := usecases.NewServices(repo)
+
 	r.HandleFunc("/sso/auth/do",
-		authenticationBuildURLHandler(a.oauth2Provider),
+		authenticationBuildURLHandler(a.oauth2Providers, a.sso, ssUC),
 	).Methods("GET").Name("ssoAuthDo")
 
+	r.HandleFunc("/sso/providers",
+		authenticationProvidersHandler(a.oauth2Providers),
+	).Methods("GET").Name("ssoProviders")
+
 	psUC := usecases.NewPermissions(repo)
-	sasUC := usecases.NewServiceAccounts(repo, a.oauth2Provider)
+	sasUC := usecases.NewServiceAccounts(repo, a.oauth2Providers, a.decisions)
+
+	var groupRoleMappings []models.GroupRoleMapping
+	if err := a.config.UnmarshalKey(
+		"oauth2.groupRoleMappings", &groupRoleMappings,
+	); err != nil {
+		a.logger.WithError(err).Error("invalid oauth2.groupRoleMappings")
+	}
+	gmUC := usecases.NewGroupMappings(repo, groupRoleMappings)
+
+	pfUC := usecases.NewProfiles(repo, a.oauth2Providers)
 
 	r.HandleFunc("/sso/auth/done",
-		authenticationExchangeCodeHandler(a.oauth2Provider, sasUC),
+		authenticationExchangeCodeHandler(
+			a.oauth2Providers, a.sso, sasUC, gmUC, pfUC,
+		),
 	).Methods("GET").Name("ssoAuthDone")
 
 	r.HandleFunc("/sso/auth/valid",
-		authenticationValidHandler(sasUC),
+		authenticationValidHandler(sasUC, a.sso, ssUC),
 	).Methods("GET").Name("ssoAuthValid")
 
-	ssUC := usecases.NewServices(repo)
-	authMiddle := authMiddleware(sasUC)
+	authMiddle := authMiddleware(sasUC, a.authThrottle, a.tokenUsage)
 
 	r.Handle("/sso/auth",
 		authMiddle(http.HandlerFunc(authenticationHandler)),
 	).Methods("GET").Name("ssoAuth")
 
+	o2sUC := usecases.NewOAuth2Server(repo)
+
+	r.HandleFunc("/oauth2/authorize",
+		oauth2AuthorizeRedirectHandler,
+	).Methods("GET").Name("oauth2AuthorizeRedirect")
+
+	r.Handle("/oauth2/authorize/info",
+		authMiddle(http.HandlerFunc(oauth2AuthorizeInfoHandler(o2sUC))),
+	).Methods("GET").Name("oauth2AuthorizeInfo")
+
+	r.Handle("/oauth2/authorize",
+		authMiddle(http.HandlerFunc(oauth2AuthorizeHandler(o2sUC))),
+	).Methods("POST").Name("oauth2Authorize")
+
+	r.HandleFunc("/oauth2/token",
+		oauth2TokenHandler(o2sUC, a.authThrottle),
+	).Methods("POST").Name("oauth2Token")
+
 	r.PathPrefix("/sso").Handler(http.StripPrefix("/sso", http.FileServer(
 		http.Dir("./assets/sso/")),
 	)).Methods("GET").Name("sso")
@@ -170,14 +417,15 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("GET").Name("servicesListHandler")
 
-	hasPermissionMiddle := hasPermissionMiddlewareBuilder(sasUC)
+	mfaUC := usecases.NewMFA(repo)
+	hasPermissionMiddle := hasPermissionMiddlewareBuilder(sasUC, mfaUC, a.decisions)
 
 	r.Handle(
 		"/services/{id}",
 		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
 			"EditService", "{id}",
 		), http.HandlerFunc(
-			servicesGetHandler(ssUC),
+			servicesGetHandler(sasUC, ssUC),
 		))),
 	).
 		Methods("GET").Name("servicesGetHandler")
@@ -202,6 +450,118 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("PUT").Name("servicesUpdateHandler")
 
+	r.Handle(
+		"/services/{id}/catalog",
+		authMiddle(http.HandlerFunc(servicesGetCatalogHandler(ssUC))),
+	).
+		Methods("GET").Name("servicesGetCatalogHandler")
+
+	r.Handle(
+		"/services/{id}/catalog",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			servicesSetCatalogHandler(ssUC),
+		))),
+	).
+		Methods("PUT").Name("servicesSetCatalogHandler")
+
+	r.Handle(
+		"/services/{permissionName}/manifest",
+		authMiddle(http.HandlerFunc(servicesApplyManifestHandler(sasUC, ssUC))),
+	).
+		Methods("PUT").Name("servicesApplyManifestHandler")
+
+	r.Handle(
+		"/services/{id}/service_account",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			servicesGetServiceAccountHandler(ssUC),
+		))),
+	).
+		Methods("GET").Name("servicesGetServiceAccountHandler")
+
+	r.Handle(
+		"/services/{id}/service_account/key",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			servicesRotateServiceAccountKeyHandler(ssUC, a.keyRotation),
+		))),
+	).
+		Methods("POST").Name("servicesRotateServiceAccountKeyHandler")
+
+	r.Handle(
+		"/services/{id}/owners",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			serviceOwnersListHandler(ssUC),
+		))),
+	).
+		Methods("GET").Name("serviceOwnersListHandler")
+
+	r.Handle(
+		"/services/{id}/owners",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			serviceOwnersAddHandler(sasUC, ssUC),
+		))),
+	).
+		Methods("POST").Name("serviceOwnersAddHandler")
+
+	r.Handle(
+		"/services/{id}/owners/transfer",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			serviceOwnersTransferHandler(sasUC, ssUC),
+		))),
+	).
+		Methods("POST").Name("serviceOwnersTransferHandler")
+
+	r.Handle(
+		"/services/{id}/owners/{serviceAccountId}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			serviceOwnersRemoveHandler(sasUC, ssUC),
+		))),
+	).
+		Methods("DELETE").Name("serviceOwnersRemoveHandler")
+
+	r.Handle(
+		"/services/{id}/am_secret",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			servicesRotateAMSecretHandler(ssUC),
+		))),
+	).
+		Methods("POST").Name("servicesRotateAMSecretHandler")
+
+	r.Handle(
+		"/services/{id}/oauth2_clients",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			oauth2ClientsListHandler(o2sUC),
+		))),
+	).
+		Methods("GET").Name("oauth2ClientsListHandler")
+
+	r.Handle(
+		"/services/{id}/oauth2_clients",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"EditService", "{id}",
+		), http.HandlerFunc(
+			oauth2ClientsCreateHandler(o2sUC),
+		))),
+	).
+		Methods("POST").Name("oauth2ClientsCreateHandler")
+
 	r.Handle(
 		"/service_accounts",
 		authMiddle(http.HandlerFunc(serviceAccountsListHandler(sasUC))),
@@ -244,6 +604,34 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("PUT").Name("serviceAccountsUpdateHandler")
 
+	ssnUC := usecases.NewSessions(repo)
+
+	r.Handle(
+		"/service_accounts/{id}/sessions",
+		authMiddle(http.HandlerFunc(
+			serviceAccountsSessionsListHandler(sasUC, ssnUC),
+		)),
+	).
+		Methods("GET").Name("serviceAccountsSessionsListHandler")
+
+	r.Handle(
+		"/service_accounts/{id}/sessions/{sessionId}",
+		authMiddle(http.HandlerFunc(
+			serviceAccountsSessionsRevokeHandler(sasUC, ssnUC),
+		)),
+	).
+		Methods("DELETE").Name("serviceAccountsSessionsRevokeHandler")
+
+	r.Handle(
+		"/service_accounts/{id}/impersonate",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ImpersonateServiceAccount", "{id}",
+		), http.HandlerFunc(
+			serviceAccountsImpersonateHandler(o2sUC),
+		))),
+	).
+		Methods("POST").Name("serviceAccountsImpersonateHandler")
+
 	rsUC := usecases.NewRoles(repo)
 
 	r.Handle(
@@ -328,6 +716,32 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("PUT").Name("permissionsAttributeHandler")
 
+	r.Handle(
+		"/mfa/totp",
+		authMiddle(http.HandlerFunc(mfaTOTPEnrollHandler(mfaUC))),
+	).
+		Methods("POST").Name("mfaTOTPEnrollHandler")
+
+	r.Handle(
+		"/mfa/totp/confirm",
+		authMiddle(http.HandlerFunc(mfaTOTPConfirmHandler(mfaUC, a.authThrottle))),
+	).
+		Methods("POST").Name("mfaTOTPConfirmHandler")
+
+	r.Handle(
+		"/mfa/step_up",
+		authMiddle(http.HandlerFunc(mfaStepUpHandler(mfaUC, a.authThrottle))),
+	).
+		Methods("POST").Name("mfaStepUpHandler")
+
+	r.Handle(
+		"/tokens/scoped",
+		authMiddle(http.HandlerFunc(
+			tokensCreateScopedHandler(sasUC, o2sUC),
+		)),
+	).
+		Methods("POST").Name("tokensCreateScopedHandler")
+
 	r.Handle(
 		"/permissions/has",
 		authMiddle(http.HandlerFunc(
@@ -352,7 +766,7 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("PUT").Name("permissionsCreatePermissionRequestHandler")
 
-	amUseCase := usecases.NewAM(repo, rsUC)
+	amUseCase := usecases.NewAM(repo, a.amClient)
 
 	r.Handle(
 		"/am",
@@ -360,6 +774,96 @@ func (a *App) GetRouter() *mux.Router {
 	).
 		Methods("GET").Name("permissionsHasHandler")
 
+	r.Handle(
+		"/audit",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ListAudit", "*",
+		), http.HandlerFunc(auditListHandler(usecases.NewAudit(repo))))),
+	).
+		Methods("GET").Name("auditListHandler")
+
+	scimUC := usecases.NewSCIM(repo)
+
+	r.Handle(
+		"/scim/v2/Users",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimListUsersHandler(scimUC)))),
+	).
+		Methods("GET").Name("scimListUsersHandler")
+
+	r.Handle(
+		"/scim/v2/Users",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimCreateUserHandler(scimUC)))),
+	).
+		Methods("POST").Name("scimCreateUserHandler")
+
+	r.Handle(
+		"/scim/v2/Users/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimGetUserHandler(scimUC)))),
+	).
+		Methods("GET").Name("scimGetUserHandler")
+
+	r.Handle(
+		"/scim/v2/Users/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimPatchUserHandler(scimUC)))),
+	).
+		Methods("PATCH").Name("scimPatchUserHandler")
+
+	r.Handle(
+		"/scim/v2/Users/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimDeleteUserHandler(scimUC)))),
+	).
+		Methods("DELETE").Name("scimDeleteUserHandler")
+
+	r.Handle(
+		"/scim/v2/Groups",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimListGroupsHandler(scimUC)))),
+	).
+		Methods("GET").Name("scimListGroupsHandler")
+
+	r.Handle(
+		"/scim/v2/Groups",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimCreateGroupHandler(scimUC)))),
+	).
+		Methods("POST").Name("scimCreateGroupHandler")
+
+	r.Handle(
+		"/scim/v2/Groups/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimGetGroupHandler(scimUC)))),
+	).
+		Methods("GET").Name("scimGetGroupHandler")
+
+	r.Handle(
+		"/scim/v2/Groups/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimPatchGroupHandler(scimUC)))),
+	).
+		Methods("PATCH").Name("scimPatchGroupHandler")
+
+	r.Handle(
+		"/scim/v2/Groups/{id}",
+		authMiddle(hasPermissionMiddle(models.BuildWillIAMPermissionLender(
+			"ProvisionSCIM", "*",
+		), http.HandlerFunc(scimDeleteGroupHandler(scimUC)))),
+	).
+		Methods("DELETE").Name("scimDeleteGroupHandler")
+
 	return r
 }
 
@@ -372,8 +876,16 @@ func (a *App) ListenAndServe() {
 
 	defer listener.Close()
 
-	err = a.server.Serve(listener)
+	if a.tls.config != nil {
+		err = a.server.ServeTLS(listener, a.tls.certFile, a.tls.keyFile)
+	} else {
+		err = a.server.Serve(listener)
+	}
 	if err != nil {
 		a.logger.WithError(err).Error("Closed http listener")
 	}
+	a.tokenUsage.Stop()
+	if err := a.decisions.Close(); err != nil {
+		a.logger.WithError(err).Error("Failed to close decisions sink")
+	}
 }
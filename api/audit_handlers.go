@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+// buildAuditFilter reads the audit filters in r's querystring; since and
+// until are RFC3339 timestamps
+func buildAuditFilter(r *http.Request) (*models.AuditFilter, error) {
+	qs := r.URL.Query()
+	f := &models.AuditFilter{
+		ActorID:    qs.Get("actorId"),
+		Action:     qs.Get("action"),
+		TargetType: qs.Get("targetType"),
+		TargetID:   qs.Get("targetId"),
+		RequestID:  qs.Get("requestId"),
+	}
+	for name, t := range map[string]*time.Time{
+		"since": &f.Since, "until": &f.Until,
+	} {
+		str := qs.Get(name)
+		if str == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+		}
+		*t = parsed
+	}
+	return f, nil
+}
+
+func auditListHandler(
+	auditUC usecases.Audit,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		listOptions, err := buildListOptions(r)
+		if err != nil {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				fmt.Sprintf(`{ "error": "%s"  }`, err.Error()),
+			)
+			return
+		}
+		filter, err := buildAuditFilter(r)
+		if err != nil {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				fmt.Sprintf(`{ "error": "%s"  }`, err.Error()),
+			)
+			return
+		}
+		entries, count, err := auditUC.WithContext(r.Context()).
+			List(filter, listOptions)
+		if err != nil {
+			l.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"count":   count,
+			"results": entries,
+		})
+	}
+}
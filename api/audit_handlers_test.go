@@ -0,0 +1,163 @@
+// +build integration
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/gofrs/uuid"
+)
+
+func TestAuditListHandler(t *testing.T) {
+	beforeEachRolesHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	authorization := helpers.GetSteppedUpAuthorization(t, rootSA)
+	requestID := uuid.Must(uuid.NewV4()).String()
+	p := "SomeService::RO::SomeAction::*"
+	req, _ := http.NewRequest("POST", fmt.Sprintf(
+		"/roles/%s/permissions?permission=%s", sa.BaseRoleID, p,
+	), nil)
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("X-Request-ID", requestID)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != requestID {
+		t.Errorf("Expected X-Request-ID %s to be echoed. Got %s", requestID, got)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/audit?requestId=%s", requestID), nil)
+	req.Header.Set("Authorization", authorization)
+	rec = helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", rec.Code)
+	}
+	res := struct {
+		Count   int64               `json:"count"`
+		Results []models.AuditEntry `json:"results"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if res.Count != 1 || len(res.Results) != 1 {
+		t.Fatalf("Expected 1 audit entry. Got %d", res.Count)
+	}
+	e := res.Results[0]
+	if e.ActorID != rootSA.ID {
+		t.Errorf("Expected actor %s. Got %s", rootSA.ID, e.ActorID)
+	}
+	if e.TargetType != "role" || e.TargetID != sa.BaseRoleID {
+		t.Errorf("Expected target role %s. Got %s %s", sa.BaseRoleID, e.TargetType, e.TargetID)
+	}
+
+	storage := helpers.GetStorage(t)
+	if _, err := storage.PG.DB.Exec(
+		"UPDATE audit_log SET action = 'x' WHERE id = ?", e.ID,
+	); err == nil {
+		t.Errorf("Expected audit_log to be append-only")
+	}
+	if _, err := storage.PG.DB.Exec(
+		"DELETE FROM audit_log WHERE id = ?", e.ID,
+	); err == nil {
+		t.Errorf("Expected audit_log to be append-only")
+	}
+}
+
+func TestAuditListHandlerNonRootSA(t *testing.T) {
+	beforeEachRolesHandlers(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	req, _ := http.NewRequest("GET", "/audit", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("KeyPair %s:%s", sa.KeyID, sa.KeySecret))
+	rec := helpers.DoRequest(t, req, helpers.GetApp(t).GetRouter())
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403. Got %d", rec.Code)
+	}
+}
+
+func auditEntriesFor(
+	t *testing.T, action, targetID string,
+) []models.AuditEntry {
+	t.Helper()
+	entries, err := helpers.GetRepo(t).Audit.List(&models.AuditFilter{
+		Action: action, TargetID: targetID,
+	}, &repositories.ListOptions{PageSize: 10, Page: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return entries
+}
+
+func TestAuditOAuth2ClientsMFAAndImpersonation(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	entries := auditEntriesFor(t, "oauth2Server.createClient", c.ID)
+	if len(entries) != 1 || entries[0].TargetType != "oauth2Client" {
+		t.Fatalf("Expected 1 oauth2Client entry. Got %v", entries)
+	}
+	if c.ClientSecret != "" &&
+		strings.Contains(string(entries[0].After), c.ClientSecret) {
+		t.Errorf("Expected client secret not to be audited")
+	}
+
+	token := issueTestAccessToken(t, app, c)
+	sa, err := helpers.GetRepo(t).ServiceAccounts.ForEmail("any@email.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, body := postMFA(t, app, token, "/mfa/totp", "unused")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d: %s", status, body)
+	}
+	var enrollment map[string]string
+	if err := json.Unmarshal(body, &enrollment); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	entries = auditEntriesFor(t, "mfa.enrollTOTP", sa.ID)
+	if len(entries) != 1 || entries[0].ActorID != sa.ID {
+		t.Fatalf("Expected 1 enrollment entry by %s. Got %v", sa.ID, entries)
+	}
+	if strings.Contains(string(entries[0].After), enrollment["secret"]) {
+		t.Errorf("Expected TOTP secret not to be audited")
+	}
+	code, _ := models.TOTPCode(enrollment["secret"], models.TOTPStep(time.Now()))
+	if status, body := postMFA(
+		t, app, token, "/mfa/totp/confirm", code,
+	); status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %s", status, body)
+	}
+	if entries := auditEntriesFor(t, "mfa.confirmTOTP", sa.ID); len(entries) != 1 {
+		t.Errorf("Expected 1 confirmation entry. Got %v", entries)
+	}
+
+	rootSA := helpers.CreateRootServiceAccount(t)
+	if status, _ := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), sa.ID,
+	); status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	entries = auditEntriesFor(t, "oauth2Server.impersonate", sa.ID)
+	if len(entries) != 1 || entries[0].ActorID != rootSA.ID {
+		t.Fatalf("Expected 1 impersonation entry by %s. Got %v", rootSA.ID, entries)
+	}
+	if !strings.Contains(string(entries[0].After), rootSA.ID) {
+		t.Errorf("Expected impersonator in entry. Got %s", entries[0].After)
+	}
+}
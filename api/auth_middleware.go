@@ -2,11 +2,14 @@ package api
 
 import (
 	"context"
+	"crypto/x509"
 	"net/http"
 	"strings"
 
 	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/sirupsen/logrus"
 	"github.com/topfreegames/extensions/middleware"
 )
 
@@ -14,6 +17,27 @@ type serviceAccountIDCtxKeyType string
 
 const serviceAccountIDCtxKey = serviceAccountIDCtxKeyType("serviceAccountID")
 
+type accessTokenCtxKeyType string
+
+const accessTokenCtxKey = accessTokenCtxKeyType("accessToken")
+
+// getAccessToken returns the (maybe refreshed) Bearer token of the request
+func getAccessToken(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(accessTokenCtxKey).(string)
+	return v, ok
+}
+
+type impersonatorIDCtxKeyType string
+
+const impersonatorIDCtxKey = impersonatorIDCtxKeyType("impersonatorID")
+
+// getImpersonatorID returns the real actor of requests made with an
+// impersonation token; getServiceAccountID returns who they act as
+func getImpersonatorID(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(impersonatorIDCtxKey).(string)
+	return v, ok
+}
+
 func getServiceAccountID(ctx context.Context) (string, bool) {
 	v := ctx.Value(serviceAccountIDCtxKey)
 	vv, ok := v.(string)
@@ -23,29 +47,89 @@ func getServiceAccountID(ctx context.Context) (string, bool) {
 	return vv, true
 }
 
-// authMiddleware authenticates either access_token or key pair
+// verifiedClientCertificate returns the leaf of r's client certificate
+// chain if the TLS handshake verified it against a trusted CA
+func verifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 ||
+		len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// authMiddleware authenticates either access_token, key pair or, when no
+// Authorization header is sent, a verified client certificate. Failed
+// attempts count towards throttle's lockouts and authenticated service
+// accounts are rate limited. Access token uses are handed to usage, which
+// writes them in batches
 func authMiddleware(
-	sasUC usecases.ServiceAccounts,
+	sasUC usecases.ServiceAccounts, throttle *authThrottle,
+	usage *usecases.TokenUsageRecorder,
 ) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authorization := r.Header.Get("authorization")
 			parts := strings.Split(authorization, " ")
-			if authorization == "" || len(parts) != 2 {
+			cert := verifiedClientCertificate(r)
+			if (authorization == "" && cert == nil) ||
+				(authorization != "" && len(parts) != 2) {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 			var ctx context.Context
 			l := middleware.GetLogger(r.Context())
-			if parts[0] == "KeyPair" {
-				keyPair := strings.Split(parts[1], ":")
+			if d := throttle.locked(r, ""); d > 0 {
+				l.WithField("ip", throttle.clientIP(r)).Warn("auth locked out")
+				writeTooManyRequests(w, d)
+				return
+			}
+			if authorization == "" {
+				saID, err := sasUC.WithContext(r.Context()).
+					AuthenticateCertificate(models.CertificateSubjects(cert))
+				if err != nil {
+					l.WithError(err).Info("auth failed")
+					if _, ok := err.(*errors.EntityNotFoundError); ok {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					if _, ok := err.(*errors.DisabledServiceAccountError); ok {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				ctx = context.WithValue(r.Context(), serviceAccountIDCtxKey, saID)
+			} else if parts[0] == "KeyPair" {
+				keyPair := strings.SplitN(parts[1], ":", 2)
+				if len(keyPair) != 2 {
+					throttle.fail(r, "")
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if d := throttle.locked(r, keyPair[0]); d > 0 {
+					l.WithField("keyID", keyPair[0]).Warn("auth locked out")
+					writeTooManyRequests(w, d)
+					return
+				}
 				saID, err := sasUC.WithContext(r.Context()).
 					AuthenticateKeyPair(keyPair[0], keyPair[1])
 				if err != nil {
+					if _, ok := err.(*errors.EntityNotFoundError); ok {
+						l.WithError(err).Info("auth failed")
+						throttle.fail(r, keyPair[0])
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
 					l.WithError(err).Error("auth failed")
+					if _, ok := err.(*errors.DisabledServiceAccountError); ok {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
 					w.WriteHeader(http.StatusInternalServerError)
 					return
 				}
+				throttle.succeed(r, keyPair[0])
 				ctx = context.WithValue(r.Context(), serviceAccountIDCtxKey, saID)
 			} else if parts[0] == "Bearer" {
 				accessToken := parts[1]
@@ -54,6 +138,22 @@ func authMiddleware(
 				if err != nil {
 					l.WithError(err).Info("auth failed")
 					if _, ok := err.(*errors.EntityNotFoundError); ok {
+						throttle.fail(r, "")
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					if _, ok := err.(*errors.DisabledServiceAccountError); ok {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					if _, ok := err.(*errors.TokenReuseError); ok {
+						l.WithError(err).Warn("rotated access token reused")
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					// known tokens that expired aren't guesses, so they don't
+					// count towards the lockout
+					if _, ok := err.(*errors.TokenExpiredError); ok {
 						w.WriteHeader(http.StatusUnauthorized)
 						return
 					}
@@ -61,6 +161,7 @@ func authMiddleware(
 					w.WriteHeader(http.StatusInternalServerError)
 					return
 				}
+				usage.Record(accessTokenAuth.AccessToken, r.UserAgent(), throttle.clientIP(r))
 				w.Header().Set("x-email", accessTokenAuth.Email)
 				if accessTokenAuth.AccessToken != accessToken {
 					w.Header().Set("x-access-token", accessTokenAuth.AccessToken)
@@ -68,11 +169,50 @@ func authMiddleware(
 				ctx = context.WithValue(
 					r.Context(), serviceAccountIDCtxKey, accessTokenAuth.ServiceAccountID,
 				)
+				ctx = context.WithValue(
+					ctx, accessTokenCtxKey, accessTokenAuth.AccessToken,
+				)
+				ctx = usecases.WithAccessToken(ctx, accessTokenAuth.AccessToken)
+				if accessTokenAuth.Scope != nil {
+					scope, err := usecases.BuildTokenScope(
+						accessTokenAuth.ServiceAccountID, accessTokenAuth.Scope,
+					)
+					if err != nil {
+						l.WithError(err).Error("auth failed: invalid token scope")
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					ctx = usecases.WithTokenScope(ctx, scope)
+				}
+				if accessTokenAuth.ImpersonatorID != "" {
+					ctx = context.WithValue(
+						ctx, impersonatorIDCtxKey, accessTokenAuth.ImpersonatorID,
+					)
+					l.WithFields(logrus.Fields{
+						"serviceAccountID": accessTokenAuth.ServiceAccountID,
+						"impersonatorID":   accessTokenAuth.ImpersonatorID,
+						"method":           r.Method,
+						"path":             r.URL.Path,
+					}).Info("impersonated request")
+				}
 			} else {
 				l.WithError(errors.NewInvalidAuthorizationTypeError()).Error("auth failed")
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
+			saID, _ := getServiceAccountID(ctx)
+			if ok, retryAfter := throttle.allow(saID); !ok {
+				l.WithField("serviceAccountID", saID).Info("rate limited")
+				writeTooManyRequests(w, retryAfter)
+				return
+			}
+			impersonatorID, _ := getImpersonatorID(ctx)
+			requestID, _ := getRequestID(ctx)
+			ctx = usecases.WithAuditActor(ctx, &usecases.AuditActor{
+				ServiceAccountID: saID,
+				ImpersonatorID:   impersonatorID,
+				RequestID:        requestID,
+			})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -0,0 +1,141 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+func doCertificateRequest(
+	t *testing.T, app *api.App, cert *x509.Certificate,
+) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/service_accounts", nil)
+	// as set by a handshake that verified cert against tls.clientCAFiles
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}
+	return helpers.DoRequest(t, req, app.GetRouter()).Code
+}
+
+func TestAuthMiddlewareClientCertificate(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	bts, _ := json.Marshal(map[string]interface{}{
+		"name":               "billing",
+		"authenticationType": "certificate",
+		"certificateSubject": "dns:billing.internal",
+	})
+	req, _ := http.NewRequest("POST", "/service_accounts", bytes.NewBuffer(bts))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	status := doCertificateRequest(t, app, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"billing.internal"},
+	})
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200. Got %d", status)
+	}
+	status = doCertificateRequest(t, app, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "billing.internal"},
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected common name not to match a DNS subject. Got %d", status)
+	}
+	status = doCertificateRequest(t, app, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "billing.internal"},
+		URIs:    []*url.URL{{Scheme: "spiffe", Host: "billing.internal"}},
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected URI SAN not to match a DNS subject. Got %d", status)
+	}
+	status = doCertificateRequest(t, app, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "unknown.internal"},
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401. Got %d", status)
+	}
+}
+
+func TestAuthMiddlewareClientCertificateCommonName(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	if err := saUC.CreateWithNested(&usecases.ServiceAccountWithNested{
+		Name:               "billing",
+		AuthenticationType: models.AuthenticationTypes.Certificate,
+		CertificateSubject: "cn:billing",
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status := doCertificateRequest(t, app, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "billing"},
+	})
+	if status != http.StatusOK {
+		t.Errorf("Expected common name to authenticate. Got %d", status)
+	}
+	status = doCertificateRequest(t, app, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"other.internal"},
+	})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Expected common name to be ignored with SANs. Got %d", status)
+	}
+}
+
+func TestServiceAccountCreateCertificateRequiresTypedSubject(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	bts, _ := json.Marshal(map[string]interface{}{
+		"name":               "billing",
+		"authenticationType": "certificate",
+		"certificateSubject": "billing.internal",
+	})
+	req, _ := http.NewRequest("POST", "/service_accounts", bytes.NewBuffer(bts))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", rec.Code)
+	}
+}
+
+func TestServiceAccountCreateCertificateRequiresSubject(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	bts, _ := json.Marshal(map[string]interface{}{
+		"name":               "billing",
+		"authenticationType": "certificate",
+	})
+	req, _ := http.NewRequest("POST", "/service_accounts", bytes.NewBuffer(bts))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", rec.Code)
+	}
+}
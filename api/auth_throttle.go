@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghostec/Will.IAM/ratelimit"
+	"github.com/spf13/viper"
+)
+
+// authThrottle protects authentication from brute force, locking client
+// IPs, and keys (key pair key ids and oauth2 client ids) tried from a
+// client IP, out after repeated failures, and rate limits the requests of
+// authenticated service accounts. Keys are only locked out for the IP
+// failing them, since key ids aren't secret and anyone could otherwise
+// lock a service out. Second factors are locked out per service account:
+// only its tokens can try its codes, from any IP. Client IPs are taken
+// from X-Forwarded-For only when the request comes from a trusted proxy
+type authThrottle struct {
+	ips            ratelimit.Lockout
+	keys           ratelimit.Lockout
+	totp           ratelimit.Lockout
+	limiter        ratelimit.Limiter
+	trustedProxies []*net.IPNet
+}
+
+func newAuthThrottle(config *viper.Viper) (*authThrottle, error) {
+	lockoutConfig := func(maxFailuresKey string) ratelimit.LockoutConfig {
+		return ratelimit.LockoutConfig{
+			MaxFailures: config.GetInt(maxFailuresKey),
+			BaseDelay:   config.GetDuration("auth.lockout.baseDelay"),
+			MaxDelay:    config.GetDuration("auth.lockout.maxDelay"),
+			ResetAfter:  config.GetDuration("auth.lockout.resetAfter"),
+		}
+	}
+	overrides := map[string]int{}
+	if err := config.UnmarshalKey(
+		"rateLimit.serviceAccounts", &overrides,
+	); err != nil {
+		return nil, err
+	}
+	trustedProxies, err := parseTrustedProxies(
+		config.GetStringSlice("auth.trustedProxies"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &authThrottle{
+		ips:  ratelimit.NewMemoryLockout(lockoutConfig("auth.lockout.ipMaxFailures")),
+		keys: ratelimit.NewMemoryLockout(lockoutConfig("auth.lockout.keyMaxFailures")),
+		totp: ratelimit.NewMemoryLockout(lockoutConfig("auth.lockout.totpMaxFailures")),
+		limiter: ratelimit.NewMemoryLimiter(ratelimit.LimiterConfig{
+			Requests:  config.GetInt("rateLimit.requests"),
+			Window:    config.GetDuration("rateLimit.window"),
+			Overrides: overrides,
+		}),
+		trustedProxies: trustedProxies,
+	}, nil
+}
+
+// parseTrustedProxies parses CIDRs and single IPs
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", proxy)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %s", proxy, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// locked returns for how long r's client IP, or key tried from it, are
+// locked out; key may be empty
+func (at *authThrottle) locked(r *http.Request, key string) time.Duration {
+	ip := at.clientIP(r)
+	d := at.ips.Locked(ip)
+	if key == "" {
+		return d
+	}
+	if kd := at.keys.Locked(ipKey(ip, key)); kd > d {
+		return kd
+	}
+	return d
+}
+
+// fail records a failed authentication of r's client IP and key
+func (at *authThrottle) fail(r *http.Request, key string) {
+	ip := at.clientIP(r)
+	at.ips.Fail(ip)
+	if key != "" {
+		at.keys.Fail(ipKey(ip, key))
+	}
+}
+
+// succeed forgets the failures of key from r's client IP. The client IP
+// keeps its failures, otherwise one valid key would let it guess others
+// indefinitely
+func (at *authThrottle) succeed(r *http.Request, key string) {
+	at.keys.Reset(ipKey(at.clientIP(r), key))
+}
+
+// lockedTOTP returns for how long saID's second factor is locked out
+func (at *authThrottle) lockedTOTP(saID string) time.Duration {
+	return at.totp.Locked(saID)
+}
+
+// failTOTP records a wrong code of saID's second factor, tried from r's
+// client IP
+func (at *authThrottle) failTOTP(r *http.Request, saID string) {
+	at.ips.Fail(at.clientIP(r))
+	at.totp.Fail(saID)
+}
+
+// succeedTOTP forgets the wrong codes of saID's second factor
+func (at *authThrottle) succeedTOTP(saID string) {
+	at.totp.Reset(saID)
+}
+
+// allow takes one request of saID from the rate limiter
+func (at *authThrottle) allow(saID string) (bool, time.Duration) {
+	return at.limiter.Allow(saID)
+}
+
+func ipKey(ip, key string) string {
+	return ip + " " + key
+}
+
+// clientIP is r's peer address or, when the peer is a trusted proxy, the
+// rightmost X-Forwarded-For address that isn't one
+func (at *authThrottle) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !at.isTrustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !at.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (at *authThrottle) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range at.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
@@ -0,0 +1,165 @@
+// +build integration
+
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func doKeyPairRequest(
+	t *testing.T, app *api.App, authorization string,
+) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/service_accounts", nil)
+	req.Header.Set("Authorization", authorization)
+	return helpers.DoRequest(t, req, app.GetRouter()).Result()
+}
+
+func TestAuthMiddlewareWrongKeySecret(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	res := doKeyPairRequest(t, app, fmt.Sprintf("KeyPair %s:wrong", rootSA.KeyID))
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401. Got %d", res.StatusCode)
+	}
+	res = doKeyPairRequest(t, app, "KeyPair malformed")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for malformed key pair. Got %d", res.StatusCode)
+	}
+}
+
+func TestAuthMiddlewareLocksKeyOut(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	// testing/config.yaml allows 5 failures per key
+	for i := 0; i < 5; i++ {
+		res := doKeyPairRequest(
+			t, app, fmt.Sprintf("KeyPair %s:wrong", rootSA.KeyID),
+		)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401. Got %d", res.StatusCode)
+		}
+	}
+	res := doKeyPairRequest(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected locked out key to get 429. Got %d", res.StatusCode)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header")
+	}
+}
+
+func TestAuthMiddlewareRateLimitsServiceAccount(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	config := helpers.GetConfig(t)
+	config.Set("rateLimit.requests", 2)
+	config.Set("rateLimit.window", "1m")
+	app, err := api.NewApp("0.0.0.0", 4040, config, helpers.GetLogger(t), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	for i := 0; i < 2; i++ {
+		if res := doKeyPairRequest(t, app, authorization); res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200. Got %d", res.StatusCode)
+		}
+	}
+	res := doKeyPairRequest(t, app, authorization)
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429. Got %d", res.StatusCode)
+	}
+}
+
+func doKeyPairRequestFrom(
+	t *testing.T, app *api.App, authorization, remoteAddr, forwardedFor string,
+) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/service_accounts", nil)
+	req.Header.Set("Authorization", authorization)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	return helpers.DoRequest(t, req, app.GetRouter()).Result()
+}
+
+func TestAuthMiddlewareLocksKeyOutPerIP(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	for i := 0; i < 5; i++ {
+		res := doKeyPairRequestFrom(
+			t, app, fmt.Sprintf("KeyPair %s:wrong", rootSA.KeyID),
+			"198.51.100.1:1234", "",
+		)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401. Got %d", res.StatusCode)
+		}
+	}
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	res := doKeyPairRequestFrom(t, app, authorization, "198.51.100.1:1234", "")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected failing IP to get 429. Got %d", res.StatusCode)
+	}
+	res = doKeyPairRequestFrom(t, app, authorization, "198.51.100.2:1234", "")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected other IPs to still use the key. Got %d", res.StatusCode)
+	}
+}
+
+func TestAuthMiddlewareTrustedProxies(t *testing.T) {
+	beforeEachServiceAccountsHandlers(t)
+	config := helpers.GetConfig(t)
+	config.Set("auth.trustedProxies", []string{"198.51.100.0/24"})
+	app, err := api.NewApp("0.0.0.0", 4040, config, helpers.GetLogger(t), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	rootSA := helpers.CreateRootServiceAccount(t)
+	for i := 0; i < 5; i++ {
+		res := doKeyPairRequestFrom(
+			t, app, fmt.Sprintf("KeyPair %s:wrong", rootSA.KeyID),
+			"198.51.100.1:1234", "203.0.113.1",
+		)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401. Got %d", res.StatusCode)
+		}
+	}
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	res := doKeyPairRequestFrom(
+		t, app, authorization, "198.51.100.1:1234", "203.0.113.1",
+	)
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected failing client to get 429. Got %d", res.StatusCode)
+	}
+	res = doKeyPairRequestFrom(
+		t, app, authorization, "198.51.100.1:1234", "203.0.113.2",
+	)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected other clients behind the proxy to pass. Got %d", res.StatusCode)
+	}
+	// X-Forwarded-For of untrusted peers is ignored
+	res = doKeyPairRequestFrom(
+		t, app, fmt.Sprintf("KeyPair %s:wrong", rootSA.KeyID),
+		"192.0.2.10:1234", "203.0.113.3",
+	)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401. Got %d", res.StatusCode)
+	}
+	res = doKeyPairRequestFrom(
+		t, app, authorization, "198.51.100.1:1234", "203.0.113.3",
+	)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected spoofed client not to be penalized. Got %d", res.StatusCode)
+	}
+}
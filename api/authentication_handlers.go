@@ -1,10 +1,12 @@
 package api
 
 import (
+	"crypto/hmac"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
@@ -13,10 +15,51 @@ import (
 	"github.com/topfreegames/extensions/middleware"
 )
 
+const ssoNonceCookie = "william_sso_nonce"
+
+const ssoStateTTL = 10 * time.Minute
+
+// ssoConfig holds what SSO handlers need to protect the flow: the secret
+// states are signed with and origins SSO may redirect to besides the ones
+// services allow
+type ssoConfig struct {
+	stateSecret     []byte
+	redirectOrigins []string
+}
+
+func (c ssoConfig) isRedirectAllowed(
+	ssUC usecases.Services, referer string,
+) (bool, error) {
+	// paths in Will.IAM itself, e.g. the oauth2 authorization page
+	if models.IsLocalPath(referer) {
+		return true, nil
+	}
+	origin := models.OriginOf(referer)
+	if origin == "" {
+		return false, nil
+	}
+	for _, allowed := range c.redirectOrigins {
+		if origin == allowed {
+			return true, nil
+		}
+	}
+	return ssUC.IsRedirectAllowed(referer)
+}
+
+// buildRefererRedirect appends v to referer as fragment, so tokens never
+// reach servers or logs through the query string
+func buildRefererRedirect(referer string, v url.Values) string {
+	if i := strings.Index(referer, "#"); i >= 0 {
+		referer = referer[:i]
+	}
+	return fmt.Sprintf("%s#%s", referer, v.Encode())
+}
+
 func authenticationBuildURLHandler(
-	provider oauth2.Provider,
+	providers *oauth2.Providers, sso ssoConfig, ssUC usecases.Services,
 ) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
 		qs := r.URL.Query()
 		if len(qs["referer"]) == 0 {
 			Write(
@@ -25,13 +68,67 @@ func authenticationBuildURLHandler(
 			)
 			return
 		}
-		authURL := provider.WithContext(r.Context()).BuildAuthURL(qs["referer"][0])
+		name := qs.Get("provider")
+		if name == "" {
+			name = providers.Default()
+		}
+		provider, err := providers.Get(name)
+		if err != nil {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				`{ "error": "querystrings.provider is not a valid provider" }`,
+			)
+			return
+		}
+		referer := qs["referer"][0]
+		allowed, err := sso.isRedirectAllowed(ssUC.WithContext(r.Context()), referer)
+		if err != nil {
+			l.WithError(err).Error("authenticationBuildURLHandler isRedirectAllowed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				`{ "error": "querystrings.referer is not an allowed origin" }`,
+			)
+			return
+		}
+		state, err := models.NewSSOState(name, referer, ssoStateTTL)
+		if err != nil {
+			l.WithError(err).Error("authenticationBuildURLHandler NewSSOState")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     ssoNonceCookie,
+			Value:    state.Nonce,
+			Path:     "/sso/auth/done",
+			MaxAge:   int(ssoStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+		})
+		authURL := provider.WithContext(r.Context()).
+			BuildAuthURL(state.Sign(sso.stateSecret))
 		http.Redirect(w, r, authURL, http.StatusSeeOther)
 	}
 }
 
+func authenticationProvidersHandler(
+	providers *oauth2.Providers,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"default":   providers.Default(),
+			"providers": providers.Names(),
+		})
+	}
+}
+
 func authenticationExchangeCodeHandler(
-	provider oauth2.Provider, sasUC usecases.ServiceAccounts,
+	providers *oauth2.Providers, sso ssoConfig,
+	sasUC usecases.ServiceAccounts, gmUC usecases.GroupMappings,
+	pfUC usecases.Profiles,
 ) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := middleware.GetLogger(r.Context())
@@ -44,9 +141,39 @@ func authenticationExchangeCodeHandler(
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		state, err := models.VerifySSOState(qs["state"][0], sso.stateSecret)
+		if err != nil {
+			l.WithError(err).Error("authenticationExchangeCodeHandler VerifySSOState")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		cookie, err := r.Cookie(ssoNonceCookie)
+		if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(state.Nonce)) {
+			l.Error("authenticationExchangeCodeHandler state nonce mismatch")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     ssoNonceCookie,
+			Path:     "/sso/auth/done",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+		})
+		name, referer := state.Provider, state.Referer
+		if name == "" {
+			name = providers.Default()
+		}
+		provider, err := providers.Get(name)
+		if err != nil {
+			l.WithError(err).Error("authenticationExchangeCodeHandler providers.Get")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		code := qs["code"][0]
 		authResult, err := provider.WithContext(r.Context()).ExchangeCode(code)
-		if _, ok := err.(*errors.NonAllowedEmailDomainError); ok {
+		switch err.(type) {
+		case *errors.NonAllowedEmailDomainError, *errors.UnverifiedEmailError:
 			l.WithError(err).Error("oauth2.ExchangeCode failed")
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -56,33 +183,61 @@ func authenticationExchangeCodeHandler(
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		sa := &models.ServiceAccount{
-			Name:               authResult.Email,
-			Email:              authResult.Email,
-			Picture:            authResult.Picture,
-			AuthenticationType: models.AuthenticationTypes.OAuth2,
-		}
-		if _, err = sasUC.WithContext(r.Context()).ForEmail(authResult.Email); err != nil {
-			if _, ok := err.(*errors.EntityNotFoundError); ok {
-				if err = sasUC.WithContext(r.Context()).Create(sa); err != nil {
-					l.WithError(err).
-						Error("authenticationExchangeCodeHandler sasUC.Create failed")
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
+		sa := models.BuildOAuth2ServiceAccount(authResult.Email, authResult.Email)
+		sa.OAuth2Provider = name
+		if existing, err := sasUC.WithContext(r.Context()).
+			ForOAuth2Login(authResult.Email, name); err == nil {
+			if existing.Disabled {
+				l.WithError(errors.NewDisabledServiceAccountError(existing.ID)).
+					Error("authenticationExchangeCodeHandler")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
 			}
+			sa = existing
+		} else if _, ok := err.(*errors.EntityNotFoundError); ok {
+			if err = sasUC.WithContext(r.Context()).Create(sa); err != nil {
+				l.WithError(err).
+					Error("authenticationExchangeCodeHandler sasUC.Create failed")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		} else if _, ok := err.(*errors.OAuth2ProviderMismatchError); ok {
+			l.WithError(err).Error("authenticationExchangeCodeHandler")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else {
+			l.WithError(err).
+				Error("authenticationExchangeCodeHandler sasUC.ForOAuth2Login failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := pfUC.WithContext(r.Context()).
+			SyncLogin(sa.ID, authResult.Profile()); err != nil {
+			l.WithError(err).
+				Error("authenticationExchangeCodeHandler pfUC.SyncLogin failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := gmUC.WithContext(r.Context()).
+			Sync(sa.ID, name, authResult.Groups); err != nil {
+			l.WithError(err).
+				Error("authenticationExchangeCodeHandler gmUC.Sync failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 		v := url.Values{}
 		v.Add("accessToken", authResult.AccessToken)
 		v.Add("email", authResult.Email)
-		v.Add("referer", qs["state"][0])
-		redirectTo := fmt.Sprintf("/sso?%s", v.Encode())
-		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+		v.Add("referer", referer)
+		http.Redirect(w, r, buildRefererRedirect("/sso", v), http.StatusSeeOther)
 	}
 }
 
+// authenticationValidHandler is called by the SSO page with the stored
+// access token as Bearer. It answers where the browser should go next:
+// the referer with the (maybe refreshed) token in the fragment
 func authenticationValidHandler(
-	sasUC usecases.ServiceAccounts,
+	sasUC usecases.ServiceAccounts, sso ssoConfig, ssUC usecases.Services,
 ) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := middleware.GetLogger(r.Context())
@@ -94,36 +249,40 @@ func authenticationValidHandler(
 			)
 			return
 		}
-		if len(qs["accessToken"]) == 0 {
+		referer := qs["referer"][0]
+		allowed, err := sso.isRedirectAllowed(ssUC.WithContext(r.Context()), referer)
+		if err != nil {
+			l.WithError(err).Error("authenticationValidHandler isRedirectAllowed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
 			Write(
 				w, http.StatusUnprocessableEntity,
-				`{ "error": "querystrings.accessToken is required" }`,
+				`{ "error": "querystrings.referer is not an allowed origin" }`,
 			)
 			return
 		}
+		parts := strings.Split(r.Header.Get("authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		authResult, err := sasUC.WithContext(r.Context()).
-			AuthenticateAccessToken(qs["accessToken"][0])
-		referer := qs["referer"][0]
+			AuthenticateAccessToken(parts[1])
 		if err != nil {
-			l.WithError(err).Error("authenticationValidHandler AuthenticateAccessToken failed")
-			v := url.Values{}
-			v.Add("referer", referer)
-			http.Redirect(
-				w, r, fmt.Sprintf("/sso/auth/do?%s", v.Encode()), http.StatusSeeOther,
-			)
+			l.WithError(err).Info("authenticationValidHandler AuthenticateAccessToken failed")
+			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 		v := url.Values{}
 		v.Add("accessToken", authResult.AccessToken)
 		v.Add("email", authResult.Email)
-		sep := "?"
-		if strings.Contains(referer, "?") {
-			sep = "&"
-		}
-		http.Redirect(
-			w, r, fmt.Sprintf("%s%s%s", referer, sep, v.Encode()),
-			http.StatusSeeOther,
-		)
+		WriteJSON(w, http.StatusOK, map[string]string{
+			"accessToken": authResult.AccessToken,
+			"email":       authResult.Email,
+			"redirectTo":  buildRefererRedirect(referer, v),
+		})
 	}
 }
 
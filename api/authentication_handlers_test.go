@@ -0,0 +1,163 @@
+// +build integration
+
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func beforeEachAuthenticationHandlers(t *testing.T) {
+	t.Helper()
+	storage := helpers.GetStorage(t)
+	rels := []string{
+		"permissions", "role_bindings", "services", "service_accounts", "roles",
+	}
+	for _, rel := range rels {
+		if _, err := storage.PG.DB.Exec(
+			fmt.Sprintf("DELETE FROM %s", rel),
+		); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// testing/config.yaml sso.stateSecret
+var testSSOStateSecret = []byte("test-secret")
+
+func TestAuthenticationBuildURLHandlerRejectsUnknownReferer(t *testing.T) {
+	beforeEachAuthenticationHandlers(t)
+	app := helpers.GetApp(t)
+	req, _ := http.NewRequest("GET", "/sso/auth/do?referer="+url.QueryEscape(
+		"http://evil.com/steal",
+	), nil)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", rec.Code)
+	}
+}
+
+func TestAuthenticationBuildURLHandlerSetsNonceCookie(t *testing.T) {
+	beforeEachAuthenticationHandlers(t)
+	app := helpers.GetApp(t)
+	req, _ := http.NewRequest("GET", "/sso/auth/do?referer="+url.QueryEscape(
+		"http://localhost:3000/some/page",
+	), nil)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303. Got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Header().Get("Set-Cookie"), "william_sso_nonce=") {
+		t.Errorf("Expected nonce cookie to be set")
+	}
+}
+
+func TestAuthenticationBuildURLHandlerAllowsServiceOrigin(t *testing.T) {
+	beforeEachAuthenticationHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	if err := helpers.GetServicesUseCase(t).Create(&models.Service{
+		Name:                    "Some Service",
+		PermissionName:          "SomeService",
+		CreatorServiceAccountID: rootSA.ID,
+		RedirectOrigins:         []string{"https://someservice.com"},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	req, _ := http.NewRequest("GET", "/sso/auth/do?referer="+url.QueryEscape(
+		"https://someservice.com/login",
+	), nil)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected status 303. Got %d", rec.Code)
+	}
+}
+
+func TestAuthenticationExchangeCodeHandlerState(t *testing.T) {
+	beforeEachAuthenticationHandlers(t)
+	app := helpers.GetApp(t)
+	state, err := models.NewSSOState(
+		"", "http://localhost:3000/some/page", time.Minute,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	type testCase struct {
+		state          string
+		nonce          string
+		expectedStatus int
+	}
+	tt := []testCase{
+		testCase{
+			state:          "http://localhost:3000/some/page",
+			nonce:          state.Nonce,
+			expectedStatus: http.StatusForbidden,
+		},
+		testCase{
+			state:          state.Sign([]byte("other-secret")),
+			nonce:          state.Nonce,
+			expectedStatus: http.StatusForbidden,
+		},
+		testCase{
+			state:          state.Sign(testSSOStateSecret),
+			nonce:          "other-nonce",
+			expectedStatus: http.StatusForbidden,
+		},
+		testCase{
+			state:          state.Sign(testSSOStateSecret),
+			nonce:          state.Nonce,
+			expectedStatus: http.StatusSeeOther,
+		},
+	}
+	for _, tc := range tt {
+		req, _ := http.NewRequest("GET", fmt.Sprintf(
+			"/sso/auth/done?code=any&state=%s", url.QueryEscape(tc.state),
+		), nil)
+		req.AddCookie(&http.Cookie{Name: "william_sso_nonce", Value: tc.nonce})
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		if rec.Code != tc.expectedStatus {
+			t.Errorf("Expected status %d. Got %d", tc.expectedStatus, rec.Code)
+			continue
+		}
+		if rec.Code != http.StatusSeeOther {
+			continue
+		}
+		location := rec.Header().Get("Location")
+		if !strings.HasPrefix(location, "/sso#") {
+			t.Errorf("Expected token in fragment. Got %s", location)
+		}
+	}
+}
+
+func TestAuthenticationValidHandler(t *testing.T) {
+	beforeEachAuthenticationHandlers(t)
+	app := helpers.GetApp(t)
+	req, _ := http.NewRequest("GET", "/sso/auth/valid?referer="+url.QueryEscape(
+		"http://evil.com",
+	), nil)
+	req.Header.Set("Authorization", "Bearer any")
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", rec.Code)
+	}
+	req, _ = http.NewRequest("GET", "/sso/auth/valid?referer="+url.QueryEscape(
+		"http://localhost:3000/some/page?a=b",
+	), nil)
+	req.Header.Set("Authorization", "Bearer any")
+	rec = helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", rec.Code)
+	}
+	if !strings.Contains(
+		rec.Body.String(), `"redirectTo":"http://localhost:3000/some/page?a=b#`,
+	) {
+		t.Errorf("Expected token in referer fragment. Got %s", rec.Body.String())
+	}
+}
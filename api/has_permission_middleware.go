@@ -4,15 +4,23 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/usecases"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"github.com/topfreegames/extensions/middleware"
 )
 
+// hasPermissionMiddlewareBuilder's permission checks are logged, see
+// usecases.DecisionLogger, as guarding their route; so are requests denied
+// for lacking a recent second factor
 func hasPermissionMiddlewareBuilder(
-	sasUC usecases.ServiceAccounts,
+	sasUC usecases.ServiceAccounts, mfaUC usecases.MFA,
+	decisions *usecases.DecisionLogger,
 ) func(string, http.Handler) http.Handler {
-	return func(permission string, next http.Handler) http.Handler {
+	return func(permissionTemplate string, next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			l := middleware.GetLogger(r.Context())
 			saID, ok := getServiceAccountID(r.Context())
@@ -21,20 +29,61 @@ func hasPermissionMiddlewareBuilder(
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+			permission := permissionTemplate
 			if id, ok := mux.Vars(r)["id"]; ok {
 				permission = strings.Replace(permission, "{id}", id, -1)
 			}
-			has, err := sasUC.WithContext(r.Context()).
+			ctx := r.Context()
+			if route := mux.CurrentRoute(r); route != nil {
+				ctx = usecases.WithDecisionRoute(ctx, route.GetName())
+			}
+			has, err := sasUC.WithContext(ctx).
 				HasPermissionString(saID, permission)
 			if err != nil {
 				l.Error(err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+			if impersonatorID, ok := getImpersonatorID(r.Context()); ok {
+				l.WithFields(logrus.Fields{
+					"serviceAccountID": saID,
+					"impersonatorID":   impersonatorID,
+					"permission":       permission,
+					"allowed":          has,
+				}).Info("impersonated permission check")
+			}
 			if !has {
 				w.WriteHeader(http.StatusForbidden)
 				return
 			}
+			p, err := models.BuildPermission(permission)
+			if err != nil {
+				l.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			action := string(p.Action)
+			if maxAge, ok := constants.MFAStepUpActions[action]; ok &&
+				p.Service == constants.AppInfo.Name {
+				accessToken, _ := getAccessToken(r.Context())
+				stepped, err := mfaUC.WithContext(r.Context()).
+					HasRecentStepUp(saID, accessToken, maxAge)
+				if err != nil {
+					l.Error(err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if !stepped {
+					decisions.Log(ctx, models.Decision{
+						ServiceAccountID: saID,
+						Permission:       p.String(),
+						Reason:           models.DecisionReasonMFARequired,
+					})
+					err := errors.NewMFARequiredError(action)
+					WriteBytes(w, err.StatusCode(), err.Serialize())
+					return
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -90,3 +90,18 @@ func buildListOptions(r *http.Request) (*repositories.ListOptions, error) {
 		PageSize: pageSize,
 	}, nil
 }
+
+// writePermissionGrantError answers 422 if err is a permission its
+// service's catalog doesn't have and 403 if granting it needs a second
+// factor, returning whether it did
+func writePermissionGrantError(w http.ResponseWriter, err error) bool {
+	switch e := err.(type) {
+	case *errors.PermissionNotInCatalogError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	case *errors.MFARequiredError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	default:
+		return false
+	}
+	return true
+}
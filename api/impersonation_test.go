@@ -0,0 +1,107 @@
+// +build integration
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func impersonate(
+	t *testing.T, app *api.App, authorization, saID string,
+) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(
+		"POST", fmt.Sprintf("/service_accounts/%s/impersonate", saID), nil,
+	)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusCreated {
+		return rec.Code, rec.Body.String()
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return rec.Code, res["accessToken"].(string)
+}
+
+func TestServiceAccountsImpersonateHandler(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission("Maestro::RL::Deploy::*")
+	if err := saUC.CreatePermission(sa.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, token := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), sa.ID,
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "Maestro::RL::Deploy::NA",
+	); status != http.StatusOK {
+		t.Errorf("Expected impersonated account's permission. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "Maestro::RL::Delete::NA",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected impersonator's own permissions not to apply. Got %d", status)
+	}
+}
+
+func TestServiceAccountsImpersonateHandlerBlocksChaining(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	otherRootSA, err := saUC.CreateKeyPairType("other root")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission("*::RO::*::*")
+	if err := saUC.CreatePermission(otherRootSA.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, token := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), otherRootSA.ID,
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	status, body := impersonate(t, app, "Bearer "+token, rootSA.ID)
+	if status != http.StatusForbidden ||
+		!strings.Contains(body, "ImpersonationError") {
+		t.Errorf("Expected ImpersonationError. Got %d: %s", status, body)
+	}
+}
+
+func TestServiceAccountsImpersonateHandlerRequiresPermission(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, _ := impersonate(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", sa.KeyID, sa.KeySecret,
+	), rootSA.ID)
+	if status != http.StatusForbidden {
+		t.Errorf("Expected status 403. Got %d", status)
+	}
+}
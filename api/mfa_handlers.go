@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/sirupsen/logrus"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// mfaRequester returns who is proving a second factor and with which
+// token. Impersonators and key pairs can't
+func mfaRequester(r *http.Request) (string, string, error) {
+	if _, ok := getImpersonatorID(r.Context()); ok {
+		return "", "", errors.NewImpersonationError(
+			"second factors can't be used while impersonating",
+		)
+	}
+	accessToken, ok := getAccessToken(r.Context())
+	if !ok {
+		return "", "", errors.NewInvalidMFAError("requires an access token")
+	}
+	saID, _ := getServiceAccountID(r.Context())
+	return saID, accessToken, nil
+}
+
+func writeMFAError(w http.ResponseWriter, l logrus.FieldLogger, err error) {
+	switch e := err.(type) {
+	case *errors.InvalidMFAError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	case *errors.MFARequiredError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	case *errors.ImpersonationError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	default:
+		l.WithError(err).Error("mfa failed")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func readMFACode(r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req := &mfaCodeRequest{}
+	if err := json.Unmarshal(body, req); err != nil || req.Code == "" {
+		return "", errors.NewInvalidMFAError("code is required")
+	}
+	return req.Code, nil
+}
+
+func mfaTOTPEnrollHandler(
+	mfaUC usecases.MFA,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		saID, accessToken, err := mfaRequester(r)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		enrollment, err := mfaUC.WithContext(r.Context()).
+			EnrollTOTP(saID, accessToken)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		WriteJSON(w, http.StatusCreated, enrollment)
+	}
+}
+
+// verifyMFACode writes why verify, a check of saID's code, failed, if it
+// did, and tells whether it succeeded. Wrong codes lock saID's second
+// factor out, and it isn't checked while locked out
+func verifyMFACode(
+	w http.ResponseWriter, r *http.Request, throttle *authThrottle,
+	saID string, verify func() error,
+) bool {
+	l := middleware.GetLogger(r.Context())
+	if d := throttle.lockedTOTP(saID); d > 0 {
+		writeTooManyRequests(w, d)
+		return false
+	}
+	err := verify()
+	if _, ok := err.(*errors.InvalidMFAError); ok {
+		throttle.failTOTP(r, saID)
+	}
+	if err != nil {
+		writeMFAError(w, l, err)
+		return false
+	}
+	throttle.succeedTOTP(saID)
+	return true
+}
+
+func mfaTOTPConfirmHandler(
+	mfaUC usecases.MFA, throttle *authThrottle,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		saID, accessToken, err := mfaRequester(r)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		code, err := readMFACode(r)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		if !verifyMFACode(w, r, throttle, saID, func() error {
+			return mfaUC.WithContext(r.Context()).
+				ConfirmTOTP(saID, accessToken, code)
+		}) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// mfaStepUpHandler marks the requester's token as having proved a second
+// factor now, as sensitive actions require. Repeated wrong codes answer 429
+// for a while
+func mfaStepUpHandler(
+	mfaUC usecases.MFA, throttle *authThrottle,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		saID, accessToken, err := mfaRequester(r)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		code, err := readMFACode(r)
+		if err != nil {
+			writeMFAError(w, l, err)
+			return
+		}
+		if !verifyMFACode(w, r, throttle, saID, func() error {
+			return mfaUC.WithContext(r.Context()).StepUp(saID, accessToken, code)
+		}) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,299 @@
+// +build integration
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+// issueTestAccessToken gets a Will.IAM token for the mock provider's
+// any@email.com through the authorization code flow
+func issueTestAccessToken(
+	t *testing.T, app *api.App, c *models.OAuth2Client,
+) string {
+	t.Helper()
+	code := authorizeTestOAuth2Client(t, app, c)
+	status, res := postOAuth2Token(t, app, exchangeCodeValues(c, code))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	return res["access_token"].(string)
+}
+
+func postMFA(
+	t *testing.T, app *api.App, token, path, code string,
+) (int, []byte) {
+	t.Helper()
+	body := fmt.Sprintf(`{"code": "%s"}`, code)
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	return rec.Code, rec.Body.Bytes()
+}
+
+func TestMFAStepUpRequiredForSensitiveActions(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	enrolledToken := issueTestAccessToken(t, app, c)
+	sa, err := helpers.GetRepo(t).ServiceAccounts.ForEmail("any@email.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	p, _ := models.BuildPermission("Will.IAM::RL::ImpersonateServiceAccount::*")
+	if err := saUC.CreatePermission(sa.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	target, err := saUC.CreateKeyPairType("target")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	status, body := postMFA(t, app, enrolledToken, "/mfa/totp", "unused")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d: %s", status, body)
+	}
+	var enrollment map[string]string
+	if err := json.Unmarshal(body, &enrollment); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	step := models.TOTPStep(time.Now())
+	code, _ := models.TOTPCode(enrollment["secret"], step)
+	if status, body := postMFA(
+		t, app, enrolledToken, "/mfa/totp/confirm", code,
+	); status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %s", status, body)
+	}
+	if status, _ := impersonate(
+		t, app, "Bearer "+enrolledToken, target.ID,
+	); status != http.StatusCreated {
+		t.Errorf("Expected stepped up token to impersonate. Got %d", status)
+	}
+
+	token := issueTestAccessToken(t, app, c)
+	status, resBody := impersonate(t, app, "Bearer "+token, target.ID)
+	if status != http.StatusForbidden ||
+		!strings.Contains(resBody, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError. Got %d: %s", status, resBody)
+	}
+	if status, _ := postMFA(
+		t, app, token, "/mfa/step_up", code,
+	); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected used code to be rejected. Got %d", status)
+	}
+	nextCode, _ := models.TOTPCode(enrollment["secret"], step+1)
+	if status, body := postMFA(
+		t, app, token, "/mfa/step_up", nextCode,
+	); status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %s", status, body)
+	}
+	if status, _ := impersonate(
+		t, app, "Bearer "+token, target.ID,
+	); status != http.StatusCreated {
+		t.Errorf("Expected stepped up token to impersonate. Got %d", status)
+	}
+}
+
+// TestMFAEnrollRejectsKeyPair checks key pairs enroll through a token, not
+// by themselves
+func TestMFAEnrollRejectsKeyPair(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	req, _ := http.NewRequest("POST", "/mfa/totp", nil)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", rec.Code)
+	}
+}
+
+func TestMFAStepUpRequiredForKeyPairs(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	target, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("target")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, resBody := impersonate(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	), target.ID)
+	if status != http.StatusForbidden ||
+		!strings.Contains(resBody, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError. Got %d: %s", status, resBody)
+	}
+
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(rootSA, ""))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	token := res["access_token"].(string)
+	status, body := postMFA(t, app, token, "/mfa/totp", "unused")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d: %s", status, body)
+	}
+	var enrollment map[string]string
+	if err := json.Unmarshal(body, &enrollment); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code, _ := models.TOTPCode(enrollment["secret"], models.TOTPStep(time.Now()))
+	if status, body := postMFA(
+		t, app, token, "/mfa/totp/confirm", code,
+	); status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %s", status, body)
+	}
+	if status, _ := impersonate(
+		t, app, "Bearer "+token, target.ID,
+	); status != http.StatusCreated {
+		t.Errorf("Expected stepped up token to impersonate. Got %d", status)
+	}
+}
+
+func TestMFAFirstEnrollmentRequiresFreshLogin(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(rootSA, ""))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	token := res["access_token"].(string)
+	if _, err := helpers.GetStorage(t).PG.DB.Exec(
+		`UPDATE tokens SET created_at = now() - INTERVAL '1 hour'
+		WHERE access_token = ?`, token,
+	); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, body := postMFA(t, app, token, "/mfa/totp", "unused")
+	if status != http.StatusForbidden ||
+		!strings.Contains(string(body), "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError. Got %d: %s", status, body)
+	}
+}
+
+// TestMFALocksOutWrongCodes checks a service account's second factor stops
+// being checked, even for the right code, after repeated wrong codes
+func TestMFALocksOutWrongCodes(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(rootSA, ""))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	token := res["access_token"].(string)
+	status, body := postMFA(t, app, token, "/mfa/totp", "unused")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d: %s", status, body)
+	}
+	var enrollment map[string]string
+	if err := json.Unmarshal(body, &enrollment); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for i := 0; i < 5; i++ {
+		if status, body := postMFA(
+			t, app, token, "/mfa/totp/confirm", "wrong",
+		); status != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected status 422. Got %d: %s", status, body)
+		}
+	}
+	code, _ := models.TOTPCode(enrollment["secret"], models.TOTPStep(time.Now()))
+	if status, body := postMFA(
+		t, app, token, "/mfa/totp/confirm", code,
+	); status != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429. Got %d: %s", status, body)
+	}
+	if status, body := postMFA(
+		t, app, token, "/mfa/step_up", code,
+	); status != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429. Got %d: %s", status, body)
+	}
+}
+
+func TestMFAStepUpRequiredToGrantOwnershipOfAll(t *testing.T) {
+	beforeEachRolesHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	grant := func(authorization, permission string) (int, string) {
+		req, _ := http.NewRequest("POST", fmt.Sprintf(
+			"/roles/%s/permissions?permission=%s", sa.BaseRoleID, permission,
+		), nil)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		return rec.Code, rec.Body.String()
+	}
+	keyPair := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	if status, _ := grant(
+		keyPair, "SomeService::RO::SomeAction::NA",
+	); status != http.StatusCreated {
+		t.Errorf("Expected status 201. Got %d", status)
+	}
+	status, body := grant(keyPair, "SomeService::RO::SomeAction::*")
+	if status != http.StatusForbidden ||
+		!strings.Contains(body, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError. Got %d: %s", status, body)
+	}
+	if status, _ := grant(
+		helpers.GetSteppedUpAuthorization(t, rootSA),
+		"SomeService::RO::SomeAction::*",
+	); status != http.StatusCreated {
+		t.Errorf("Expected status 201. Got %d", status)
+	}
+}
+
+// TestMFAStepUpRequiredToBindRolesOwningAll checks binding a role holding RO
+// over every resource needs a step-up, as granting its permissions would
+func TestMFAStepUpRequiredToBindRolesOwningAll(t *testing.T) {
+	beforeEachRolesHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	p, _ := models.BuildPermission("SomeService::RO::SomeAction::*")
+	rwn := &usecases.RoleWithNested{
+		Name: "owners", Permissions: []models.Permission{p},
+	}
+	if err := helpers.GetRolesUseCase(t).Create(rwn); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	create := func(authorization string) (int, string) {
+		body := fmt.Sprintf(
+			`{"name": "bound", "authenticationType": "keypair", "rolesIds": ["%s"]}`,
+			rwn.ID,
+		)
+		req, _ := http.NewRequest(
+			"POST", "/service_accounts", strings.NewReader(body),
+		)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		return rec.Code, rec.Body.String()
+	}
+	status, body := create(fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	if status != http.StatusForbidden ||
+		!strings.Contains(body, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError. Got %d: %s", status, body)
+	}
+	if status, body := create(
+		helpers.GetSteppedUpAuthorization(t, rootSA),
+	); status != http.StatusCreated {
+		t.Errorf("Expected status 201. Got %d: %s", status, body)
+	}
+}
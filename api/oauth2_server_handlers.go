@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/gorilla/mux"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+func writeOAuth2Error(w http.ResponseWriter, err *errors.OAuth2Error) {
+	if err.Kind == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Will.IAM"`)
+	}
+	WriteBytes(w, err.StatusCode(), err.Serialize())
+}
+
+func oauth2ClientsCreateHandler(
+	o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("oauth2ClientsCreateHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c := &models.OAuth2Client{}
+		if err := json.Unmarshal(body, c); err != nil {
+			Write(w, http.StatusUnprocessableEntity, `{ "error": "invalid body" }`)
+			return
+		}
+		c.ServiceID = mux.Vars(r)["id"]
+		v := c.Validate()
+		if !v.Valid() {
+			WriteBytes(w, http.StatusUnprocessableEntity, v.Errors())
+			return
+		}
+		err = o2sUC.WithContext(r.Context()).CreateClient(c)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("oauth2ClientsCreateHandler CreateClient failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusCreated, c)
+	}
+}
+
+func oauth2ClientsListHandler(
+	o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		cSl, err := o2sUC.WithContext(r.Context()).ListClients(mux.Vars(r)["id"])
+		if err != nil {
+			l.WithError(err).Error("oauth2ClientsListHandler ListClients failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bts, err := keepJSONFieldsBytes(
+			cSl, "id", "clientId", "serviceId", "name", "redirectUris",
+			"confidential",
+		)
+		if err != nil {
+			l.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteBytes(w, http.StatusOK, bts)
+	}
+}
+
+// oauth2AuthorizeRedirectHandler sends the browser to the authorization
+// page, which logs the user in through SSO and asks for consent
+func oauth2AuthorizeRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(
+		w, r, fmt.Sprintf("/sso/authorize.html?%s", r.URL.RawQuery),
+		http.StatusFound,
+	)
+}
+
+func oauth2AuthorizeInfoHandler(
+	o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		qs := r.URL.Query()
+		o2sUC := o2sUC.WithContext(r.Context())
+		c, err := o2sUC.ClientForRedirect(
+			qs.Get("client_id"), qs.Get("redirect_uri"),
+		)
+		if oErr, ok := err.(*errors.OAuth2Error); ok {
+			writeOAuth2Error(w, oErr)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("oauth2AuthorizeInfoHandler ClientForRedirect")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		saID, _ := getServiceAccountID(r.Context())
+		consented, err := o2sUC.HasConsent(c.ClientID, saID)
+		if err != nil {
+			l.WithError(err).Error("oauth2AuthorizeInfoHandler HasConsent")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"clientId":  c.ClientID,
+			"name":      c.Name,
+			"consented": consented,
+		})
+	}
+}
+
+type oauth2AuthorizeRequest struct {
+	ClientID            string `json:"clientId"`
+	RedirectURI         string `json:"redirectUri"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"codeChallenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod"`
+	Approve             bool   `json:"approve"`
+}
+
+// oauth2AuthorizeHandler is called by the authorization page once the user
+// approves or denies the client. It answers where the browser goes next
+func oauth2AuthorizeHandler(
+	o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("oauth2AuthorizeHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req := &oauth2AuthorizeRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			writeOAuth2Error(w, errors.NewOAuth2Error("invalid_request", "invalid body"))
+			return
+		}
+		o2sUC := o2sUC.WithContext(r.Context())
+		if _, err := o2sUC.ClientForRedirect(
+			req.ClientID, req.RedirectURI,
+		); err != nil {
+			if oErr, ok := err.(*errors.OAuth2Error); ok {
+				writeOAuth2Error(w, oErr)
+				return
+			}
+			l.WithError(err).Error("oauth2AuthorizeHandler ClientForRedirect")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// from here on redirect_uri is trusted, so errors go to the client
+		v := url.Values{}
+		if req.State != "" {
+			v.Set("state", req.State)
+		}
+		if !req.Approve {
+			v.Set("error", "access_denied")
+			writeOAuth2Redirect(w, req.RedirectURI, v)
+			return
+		}
+		saID, _ := getServiceAccountID(r.Context())
+		ac := &models.OAuth2AuthorizationCode{
+			ClientID:            req.ClientID,
+			RedirectURI:         req.RedirectURI,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+		}
+		err = o2sUC.Authorize(saID, ac)
+		if oErr, ok := err.(*errors.OAuth2Error); ok {
+			v.Set("error", oErr.Kind)
+			writeOAuth2Redirect(w, req.RedirectURI, v)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("oauth2AuthorizeHandler Authorize failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		v.Set("code", ac.Code)
+		writeOAuth2Redirect(w, req.RedirectURI, v)
+	}
+}
+
+func writeOAuth2Redirect(
+	w http.ResponseWriter, redirectURI string, v url.Values,
+) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	for k := range v {
+		q.Set(k, v.Get(k))
+	}
+	u.RawQuery = q.Encode()
+	WriteJSON(w, http.StatusOK, map[string]string{"redirectTo": u.String()})
+}
+
+func oauth2TokenHandler(
+	o2sUC usecases.OAuth2Server, throttle *authThrottle,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		if err := r.ParseForm(); err != nil {
+			writeOAuth2Error(w, errors.NewOAuth2Error("invalid_request", "invalid form"))
+			return
+		}
+		form := r.PostForm
+		clientID, secret, ok := r.BasicAuth()
+		if !ok {
+			clientID, secret = form.Get("client_id"), form.Get("client_secret")
+		}
+		if d := throttle.locked(r, clientID); d > 0 {
+			l.WithField("clientID", clientID).Warn("oauth2 client locked out")
+			writeTooManyRequests(w, d)
+			return
+		}
+		o2sUC := o2sUC.WithContext(r.Context())
+		var token *models.Token
+		var err error
+		switch form.Get("grant_type") {
+		case "authorization_code":
+			token, err = o2sUC.ExchangeCode(
+				clientID, secret, form.Get("code"), form.Get("redirect_uri"),
+				form.Get("code_verifier"),
+			)
+		case "refresh_token":
+			token, err = o2sUC.Refresh(clientID, secret, form.Get("refresh_token"))
+		case "client_credentials":
+			token, err = o2sUC.ClientCredentials(
+				clientID, secret, strings.Fields(form.Get("scope")),
+			)
+		default:
+			err = errors.NewOAuth2Error(
+				"unsupported_grant_type", "grant_type must be authorization_code, "+
+					"refresh_token or client_credentials",
+			)
+		}
+		if oErr, ok := err.(*errors.OAuth2Error); ok {
+			l.WithError(err).Info("oauth2TokenHandler failed")
+			if oErr.Kind == "invalid_client" {
+				throttle.fail(r, clientID)
+			}
+			writeOAuth2Error(w, oErr)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("oauth2TokenHandler failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		throttle.succeed(r, clientID)
+		res := map[string]interface{}{
+			"access_token": token.AccessToken,
+			"token_type":   token.TokenType,
+			"expires_in":   int(usecases.OAuth2AccessTokenTTL.Seconds()),
+		}
+		if token.RefreshToken != "" {
+			res["refresh_token"] = token.RefreshToken
+		}
+		if len(token.Scope) > 0 {
+			res["scope"] = strings.Join(token.Scope, " ")
+		}
+		WriteJSON(w, http.StatusOK, res)
+	}
+}
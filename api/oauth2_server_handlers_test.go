@@ -0,0 +1,362 @@
+// +build integration
+
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func beforeEachOAuth2ServerHandlers(t *testing.T) {
+	t.Helper()
+	storage := helpers.GetStorage(t)
+	rels := []string{
+		"oauth2_codes", "oauth2_consents", "oauth2_clients", "tokens",
+		"permissions", "role_bindings", "services", "service_accounts", "roles",
+	}
+	for _, rel := range rels {
+		if _, err := storage.PG.DB.Exec(
+			fmt.Sprintf("DELETE FROM %s", rel),
+		); err != nil {
+			panic(err)
+		}
+	}
+}
+
+const testCodeVerifier = "some-code-verifier-that-is-long-enough-for-pkce-s256"
+
+func testCodeChallenge() string {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func createTestOAuth2Client(t *testing.T, app *api.App) *models.OAuth2Client {
+	t.Helper()
+	rootSA := helpers.CreateRootServiceAccount(t)
+	service := &models.Service{
+		Name:                    "Some Service",
+		PermissionName:          "SomeService",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	body := `{"name": "Some App", "redirectUris": ["http://localhost:3000/cb"]}`
+	req, _ := http.NewRequest(
+		"POST", fmt.Sprintf("/services/%s/oauth2_clients", service.ID),
+		strings.NewReader(body),
+	)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", rec.Code)
+	}
+	c := &models.OAuth2Client{}
+	if err := json.Unmarshal(rec.Body.Bytes(), c); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return c
+}
+
+func authorizeTestOAuth2Client(
+	t *testing.T, app *api.App, c *models.OAuth2Client,
+) string {
+	t.Helper()
+	body := fmt.Sprintf(`{"clientId": "%s", "redirectUri": "%s",
+	"state": "some-state", "codeChallenge": "%s",
+	"codeChallengeMethod": "S256", "approve": true}`,
+		c.ClientID, c.RedirectURIs[0], testCodeChallenge())
+	req, _ := http.NewRequest("POST", "/oauth2/authorize", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer any")
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", rec.Code)
+	}
+	var res map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	u, err := url.Parse(res["redirectTo"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if u.Query().Get("state") != "some-state" {
+		t.Errorf("Expected state some-state. Got %s", u.Query().Get("state"))
+	}
+	if u.Query().Get("code") == "" {
+		t.Fatalf("Expected code in %s", res["redirectTo"])
+	}
+	return u.Query().Get("code")
+}
+
+func postOAuth2Token(
+	t *testing.T, app *api.App, v url.Values,
+) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(
+		"POST", "/oauth2/token", strings.NewReader(v.Encode()),
+	)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return rec.Code, res
+}
+
+func authenticateWithToken(t *testing.T, app *api.App, token string) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "/sso/auth", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return helpers.DoRequest(t, req, app.GetRouter()).Code
+}
+
+func exchangeCodeValues(c *models.OAuth2Client, code string) url.Values {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("client_id", c.ClientID)
+	v.Set("code", code)
+	v.Set("redirect_uri", c.RedirectURIs[0])
+	v.Set("code_verifier", testCodeVerifier)
+	return v
+}
+
+func TestOAuth2ServerAuthorizationCodeFlow(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	if c.ClientSecret != "" {
+		t.Errorf("Expected public client without secret")
+	}
+	code := authorizeTestOAuth2Client(t, app, c)
+	status, res := postOAuth2Token(t, app, exchangeCodeValues(c, code))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	accessToken := res["access_token"].(string)
+	if status := authenticateWithToken(t, app, accessToken); status != 200 {
+		t.Errorf("Expected issued token to authenticate. Got %d", status)
+	}
+	status, res = postOAuth2Token(t, app, exchangeCodeValues(c, code))
+	if status != http.StatusBadRequest || res["error"] != "invalid_grant" {
+		t.Errorf("Expected invalid_grant replaying code. Got %d: %v", status, res)
+	}
+	if status := authenticateWithToken(t, app, accessToken); status != 401 {
+		t.Errorf("Expected replayed code to revoke its tokens. Got %d", status)
+	}
+}
+
+func TestOAuth2ServerRejectsWrongCodeVerifier(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	code := authorizeTestOAuth2Client(t, app, c)
+	v := exchangeCodeValues(c, code)
+	v.Set("code_verifier", strings.Repeat("x", 43))
+	status, res := postOAuth2Token(t, app, v)
+	if status != http.StatusBadRequest || res["error"] != "invalid_grant" {
+		t.Errorf("Expected invalid_grant. Got %d: %v", status, res)
+	}
+}
+
+func TestOAuth2ServerRejectsUnregisteredRedirectURI(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	body := fmt.Sprintf(`{"clientId": "%s", "redirectUri": "http://evil.com",
+	"codeChallenge": "%s", "codeChallengeMethod": "S256", "approve": true}`,
+		c.ClientID, testCodeChallenge())
+	req, _ := http.NewRequest("POST", "/oauth2/authorize", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer any")
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400. Got %d", rec.Code)
+	}
+}
+
+func TestOAuth2ServerRefreshTokenRotation(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	code := authorizeTestOAuth2Client(t, app, c)
+	_, res := postOAuth2Token(t, app, exchangeCodeValues(c, code))
+	refreshToken := res["refresh_token"].(string)
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("client_id", c.ClientID)
+	v.Set("refresh_token", refreshToken)
+	status, res := postOAuth2Token(t, app, v)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	refreshed := res["access_token"].(string)
+	if status := authenticateWithToken(t, app, refreshed); status != 200 {
+		t.Errorf("Expected refreshed token to authenticate. Got %d", status)
+	}
+	status, res = postOAuth2Token(t, app, v)
+	if status != http.StatusBadRequest || res["error"] != "invalid_grant" {
+		t.Errorf("Expected invalid_grant reusing refresh_token. Got %d: %v", status, res)
+	}
+	if status := authenticateWithToken(t, app, refreshed); status != 401 {
+		t.Errorf("Expected reuse to revoke the family. Got %d", status)
+	}
+}
+
+func TestOAuth2ServerTokenRejectsUnknownClient(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("client_id", "unknown")
+	v.Set("code", "some-code")
+	status, res := postOAuth2Token(t, app, v)
+	if status != http.StatusUnauthorized || res["error"] != "invalid_client" {
+		t.Errorf("Expected invalid_client. Got %d: %v", status, res)
+	}
+}
+
+func clientCredentialsValues(sa *models.ServiceAccount, scope string) url.Values {
+	v := url.Values{}
+	v.Set("grant_type", "client_credentials")
+	v.Set("client_id", sa.KeyID)
+	v.Set("client_secret", sa.KeySecret)
+	if scope != "" {
+		v.Set("scope", scope)
+	}
+	return v
+}
+
+func hasPermissionWithToken(
+	t *testing.T, app *api.App, token, permission string,
+) int {
+	t.Helper()
+	req, _ := http.NewRequest(
+		"GET", "/permissions/has?permission="+url.QueryEscape(permission), nil,
+	)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return helpers.DoRequest(t, req, app.GetRouter()).Code
+}
+
+func TestOAuth2ServerClientCredentials(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(rootSA, ""))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	if _, ok := res["refresh_token"]; ok {
+		t.Errorf("Expected no refresh_token for client_credentials")
+	}
+	token := res["access_token"].(string)
+	if status := hasPermissionWithToken(
+		t, app, token, "SomeService::RO::SomeAction::*",
+	); status != http.StatusOK {
+		t.Errorf("Expected unscoped token to have root access. Got %d", status)
+	}
+}
+
+func TestOAuth2ServerClientCredentialsScope(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(
+		rootSA, "SomeService::RL::Deploy::*",
+	))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	token := res["access_token"].(string)
+	if status := hasPermissionWithToken(
+		t, app, token, "SomeService::RL::Deploy::x",
+	); status != http.StatusOK {
+		t.Errorf("Expected permission in scope. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "SomeService::RL::Delete::x",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected permission out of scope to be denied. Got %d", status)
+	}
+}
+
+func TestOAuth2ServerClientCredentialsRejectsScopeNotHeld(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(
+		sa, "SomeService::RL::Deploy::*",
+	))
+	if status != http.StatusBadRequest || res["error"] != "invalid_scope" {
+		t.Errorf("Expected invalid_scope. Got %d: %v", status, res)
+	}
+	v := clientCredentialsValues(sa, "")
+	v.Set("client_secret", "wrong")
+	status, res = postOAuth2Token(t, app, v)
+	if status != http.StatusUnauthorized || res["error"] != "invalid_client" {
+		t.Errorf("Expected invalid_client. Got %d: %v", status, res)
+	}
+}
+
+func TestOAuth2ServerAuthorizeRejectsScopedAndImpersonationTokens(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	c := createTestOAuth2Client(t, app)
+	if status := authenticateWithToken(t, app, "any"); status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", status)
+	}
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.ForEmail("any@email.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission("Maestro::RL::Deploy::*")
+	if err := saUC.CreatePermission(sa.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, scoped := mintScopedToken(t, app, "Bearer any", p.String())
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, impersonation := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), sa.ID,
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	body := fmt.Sprintf(`{"clientId": "%s", "redirectUri": "%s",
+	"codeChallenge": "%s", "codeChallengeMethod": "S256", "approve": true}`,
+		c.ClientID, c.RedirectURIs[0], testCodeChallenge())
+	for name, token := range map[string]string{
+		"scoped": scoped, "impersonation": impersonation,
+	} {
+		req, _ := http.NewRequest(
+			"POST", "/oauth2/authorize", strings.NewReader(body),
+		)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		var res map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		u, err := url.Parse(res["redirectTo"])
+		if err != nil || u.Query().Get("error") != "access_denied" ||
+			u.Query().Get("code") != "" {
+			t.Errorf("Expected %s token to be denied. Got %d: %v", name, rec.Code, res)
+		}
+	}
+}
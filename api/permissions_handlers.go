@@ -86,6 +86,9 @@ func permissionsCreatePermissionRequestHandler(
 
 		err = psUC.WithContext(r.Context()).CreateRequest(saID, pr)
 		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -138,6 +141,9 @@ func permissionsAttributeHandler(
 		}
 		err = psUC.WithContext(r.Context()).Attribute(pa)
 		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -190,6 +196,9 @@ func permissionsAttributeToEmailsHandler(
 		}
 		err = psUC.WithContext(r.Context()).AttributeToEmails(pa)
 		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.WithError(err).Error("AttributeToEmails failed")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
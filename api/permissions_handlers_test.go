@@ -34,9 +34,7 @@ func TestPermissionsDeleteHandlerNonExistentID(t *testing.T) {
 	req, _ := http.NewRequest("DELETE", fmt.Sprintf(
 		"/permissions/%s", uuid.Must(uuid.NewV4()).String(),
 	), nil)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec := helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusNoContent {
 		t.Errorf("Expected status 204. Got %d", rec.Code)
@@ -57,9 +55,7 @@ func TestPermissionsDeleteHandlerNonRootSA(t *testing.T) {
 	req, _ := http.NewRequest("POST", fmt.Sprintf(
 		"/roles/%s/permissions?permission=%s", sa.BaseRoleID, p,
 	), nil)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec := helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusCreated {
 		t.Errorf("Expected status 201. Got %d", rec.Code)
@@ -100,9 +96,7 @@ func TestPermissionsDeleteHandler(t *testing.T) {
 	req, _ := http.NewRequest("POST", fmt.Sprintf(
 		"/roles/%s/permissions?permission=%s", sa.BaseRoleID, p,
 	), nil)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec := helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusCreated {
 		t.Errorf("Expected status 201. Got %d", rec.Code)
@@ -119,9 +113,7 @@ func TestPermissionsDeleteHandler(t *testing.T) {
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf(
 		"/permissions/%s", permissions[0].ID,
 	), nil)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec = helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected status 200. Got %d", rec.Code)
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gofrs/uuid"
+)
+
+// requestIDHeader carries the id of a request, either given by the client
+// (or a proxy) or generated, and is echoed in its response
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request ids given by clients
+const maxRequestIDLength = 200
+
+type requestIDCtxKeyType string
+
+const requestIDCtxKey = requestIDCtxKeyType("requestID")
+
+func getRequestID(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(requestIDCtxKey).(string)
+	return v, ok
+}
+
+// requestIDMiddleware puts each request's id in its context, see
+// getRequestID
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.Must(uuid.NewV4()).String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(
+			context.WithValue(r.Context(), requestIDCtxKey, id),
+		))
+	})
+}
@@ -51,6 +51,9 @@ func rolesCreatePermissionHandler(
 		}
 		err = rsUC.WithContext(r.Context()).CreatePermission(rID, &p)
 		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -82,6 +85,9 @@ func rolesCreateHandler(
 		}
 		err = rsUC.WithContext(r.Context()).Create(rwn)
 		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -113,11 +119,13 @@ func rolesUpdateHandler(
 		}
 		rwn.ID = mux.Vars(r)["id"]
 		if err = rsUC.WithContext(r.Context()).Update(rwn); err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.WithError(err).Error("rolesUpdateHandler rsUC.Update")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		// TODO: audit
 		w.WriteHeader(http.StatusOK)
 	}
 }
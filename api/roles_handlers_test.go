@@ -40,9 +40,7 @@ func TestRolesCreatePermissionHandler(t *testing.T) {
 	req, _ := http.NewRequest("POST", fmt.Sprintf(
 		"/roles/%s/permissions?permission=%s", sa.BaseRoleID, p,
 	), nil)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec := helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusCreated {
 		t.Errorf("Expected status 201. Got %d", rec.Code)
@@ -118,9 +116,7 @@ func TestRolesUpdateHandlerRootSA(t *testing.T) {
 		fmt.Sprintf("/roles/%s", creatorSA.BaseRoleID),
 		bytes.NewBuffer(bts),
 	)
-	req.Header.Set("Authorization", fmt.Sprintf(
-		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
-	))
+	req.Header.Set("Authorization", helpers.GetSteppedUpAuthorization(t, rootSA))
 	rec := helpers.DoRequest(t, req, app.GetRouter())
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected status 200. Got %d", rec.Code)
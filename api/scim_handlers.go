@@ -0,0 +1,303 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/gorilla/mux"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+// writeSCIM writes i as a SCIM response body
+func writeSCIM(w http.ResponseWriter, status int, i interface{}) {
+	bts, err := json.Marshal(i)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	w.Write(bts)
+}
+
+// writeSCIMError writes a SCIM error body, picking the status code from err
+func writeSCIMError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	detail := http.StatusText(status)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		status = http.StatusNotFound
+		detail = err.Error()
+	} else if ewsc, ok := err.(errors.ErrorWithStatusCode); ok {
+		status = ewsc.StatusCode()
+		detail = err.Error()
+	}
+	writeSCIMErrorStatus(w, status, detail)
+}
+
+func writeSCIMErrorStatus(w http.ResponseWriter, status int, detail string) {
+	writeSCIM(w, status, map[string]interface{}{
+		"schemas": []string{models.SCIMSchemas.Error},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+}
+
+// buildSCIMListOptions translates SCIM's 1-based startIndex and count
+// into ListOptions
+func buildSCIMListOptions(
+	r *http.Request,
+) (*repositories.ListOptions, int, error) {
+	startIndex := 1
+	if str := r.URL.Query().Get("startIndex"); str != "" {
+		i, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, 0, errors.NewInvalidPageError(str)
+		}
+		if i > 1 {
+			startIndex = i
+		}
+	}
+	count := constants.DefaultListOptionsPageSize
+	if str := r.URL.Query().Get("count"); str != "" {
+		i, err := strconv.Atoi(str)
+		if err != nil || i < 1 {
+			return nil, 0, errors.NewInvalidPageSizeError(str)
+		}
+		count = i
+	}
+	return &repositories.ListOptions{
+		Page:     (startIndex - 1) / count,
+		PageSize: count,
+	}, startIndex, nil
+}
+
+func readSCIMBody(r *http.Request, i interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, i)
+}
+
+func writeSCIMList(
+	w http.ResponseWriter, resources interface{}, total int64,
+	startIndex, itemsPerPage int,
+) {
+	writeSCIM(w, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemas.ListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: itemsPerPage,
+		Resources:    resources,
+	})
+}
+
+func scimListUsersHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		lo, startIndex, err := buildSCIMListOptions(r)
+		if err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter, err := models.ParseSCIMFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			writeSCIMError(w, errors.NewInvalidSCIMFilterError(err.Error()))
+			return
+		}
+		users, total, err := scimUC.WithContext(r.Context()).
+			ListUsers(filter, lo)
+		if err != nil {
+			l.WithError(err).Error("scimListUsersHandler scimUC.ListUsers")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMList(w, users, total, startIndex, len(users))
+	}
+}
+
+func scimGetUserHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		u, err := scimUC.WithContext(r.Context()).GetUser(mux.Vars(r)["id"])
+		if err != nil {
+			l.WithError(err).Error("scimGetUserHandler scimUC.GetUser")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusOK, u)
+	}
+}
+
+func scimCreateUserHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		u := &models.SCIMUser{}
+		if err := readSCIMBody(r, u); err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v := u.Validate()
+		if !v.Valid() {
+			WriteBytes(w, http.StatusUnprocessableEntity, v.Errors())
+			return
+		}
+		if err := scimUC.WithContext(r.Context()).CreateUser(u); err != nil {
+			l.WithError(err).Error("scimCreateUserHandler scimUC.CreateUser")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusCreated, u)
+	}
+}
+
+func scimPatchUserHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		p := &models.SCIMPatchOp{}
+		if err := readSCIMBody(r, p); err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		u, err := scimUC.WithContext(r.Context()).
+			PatchUser(mux.Vars(r)["id"], p)
+		if err != nil {
+			l.WithError(err).Error("scimPatchUserHandler scimUC.PatchUser")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusOK, u)
+	}
+}
+
+func scimDeleteUserHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		if err := scimUC.WithContext(r.Context()).
+			DeleteUser(mux.Vars(r)["id"]); err != nil {
+			l.WithError(err).Error("scimDeleteUserHandler scimUC.DeleteUser")
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func scimListGroupsHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		lo, startIndex, err := buildSCIMListOptions(r)
+		if err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter, err := models.ParseSCIMFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			writeSCIMError(w, errors.NewInvalidSCIMFilterError(err.Error()))
+			return
+		}
+		groups, total, err := scimUC.WithContext(r.Context()).
+			ListGroups(filter, lo)
+		if err != nil {
+			l.WithError(err).Error("scimListGroupsHandler scimUC.ListGroups")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMList(w, groups, total, startIndex, len(groups))
+	}
+}
+
+func scimGetGroupHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		g, err := scimUC.WithContext(r.Context()).GetGroup(mux.Vars(r)["id"])
+		if err != nil {
+			l.WithError(err).Error("scimGetGroupHandler scimUC.GetGroup")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusOK, g)
+	}
+}
+
+func scimCreateGroupHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		g := &models.SCIMGroup{}
+		if err := readSCIMBody(r, g); err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v := g.Validate()
+		if !v.Valid() {
+			WriteBytes(w, http.StatusUnprocessableEntity, v.Errors())
+			return
+		}
+		if err := scimUC.WithContext(r.Context()).CreateGroup(g); err != nil {
+			l.WithError(err).Error("scimCreateGroupHandler scimUC.CreateGroup")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusCreated, g)
+	}
+}
+
+func scimPatchGroupHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		p := &models.SCIMPatchOp{}
+		if err := readSCIMBody(r, p); err != nil {
+			writeSCIMErrorStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		g, err := scimUC.WithContext(r.Context()).
+			PatchGroup(mux.Vars(r)["id"], p)
+		if err != nil {
+			l.WithError(err).Error("scimPatchGroupHandler scimUC.PatchGroup")
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIM(w, http.StatusOK, g)
+	}
+}
+
+func scimDeleteGroupHandler(
+	scimUC usecases.SCIM,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		if err := scimUC.WithContext(r.Context()).
+			DeleteGroup(mux.Vars(r)["id"]); err != nil {
+			l.WithError(err).Error("scimDeleteGroupHandler scimUC.DeleteGroup")
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,258 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+func beforeEachSCIMHandlers(t *testing.T) {
+	t.Helper()
+	storage := helpers.GetStorage(t)
+	rels := []string{
+		"tokens", "permissions", "role_bindings", "service_accounts", "roles",
+	}
+	for _, rel := range rels {
+		if _, err := storage.PG.DB.Exec(
+			fmt.Sprintf("DELETE FROM %s", rel),
+		); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func doSCIMRequest(
+	t *testing.T, rootSA *models.ServiceAccount, method, path string,
+	body interface{},
+) (int, map[string]interface{}) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		bts, _ := json.Marshal(body)
+		reader = bytes.NewReader(bts)
+	} else {
+		reader = bytes.NewReader([]byte{})
+	}
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	))
+	rec := helpers.DoRequest(t, req, helpers.GetApp(t).GetRouter())
+	res := map[string]interface{}{}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	return rec.Code, res
+}
+
+func TestSCIMUsersLifecycle(t *testing.T) {
+	beforeEachSCIMHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	code, res := doSCIMRequest(t, rootSA, "POST", "/scim/v2/Users", map[string]interface{}{
+		"schemas":     []string{models.SCIMSchemas.User},
+		"userName":    "some@email.com",
+		"displayName": "Some User",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", code)
+	}
+	id := res["id"].(string)
+	code, _ = doSCIMRequest(t, rootSA, "POST", "/scim/v2/Users", map[string]interface{}{
+		"userName": "some@email.com",
+	})
+	if code != http.StatusConflict {
+		t.Errorf("Expected status 409. Got %d", code)
+	}
+	code, res = doSCIMRequest(
+		t, rootSA, "GET", `/scim/v2/Users?filter=userName%20eq%20%22some@email.com%22`, nil,
+	)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if res["totalResults"].(float64) != 1 {
+		t.Errorf("Expected 1 result. Got %v", res["totalResults"])
+	}
+	code, res = doSCIMRequest(t, rootSA, "PATCH", "/scim/v2/Users/"+id, map[string]interface{}{
+		"schemas": []string{models.SCIMSchemas.PatchOp},
+		"Operations": []map[string]interface{}{
+			map[string]interface{}{"op": "replace", "path": "active", "value": false},
+		},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if res["active"] != false {
+		t.Errorf("Expected user to be inactive")
+	}
+	sa, err := helpers.GetServiceAccountsUseCase(t).Get(id)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !sa.Disabled {
+		t.Errorf("Expected service account to be disabled")
+	}
+	code, _ = doSCIMRequest(t, rootSA, "DELETE", "/scim/v2/Users/"+id, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("Expected status 204. Got %d", code)
+	}
+	code, _ = doSCIMRequest(t, rootSA, "GET", "/scim/v2/Users/"+id, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("Expected status 404. Got %d", code)
+	}
+}
+
+func TestSCIMGroupsLifecycle(t *testing.T) {
+	beforeEachSCIMHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).
+		CreateOAuth2Type("some sa", "some@email.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code, res := doSCIMRequest(t, rootSA, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{models.SCIMSchemas.Group},
+		"displayName": "some group",
+		"members":     []map[string]string{map[string]string{"value": sa.ID}},
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", code)
+	}
+	id := res["id"].(string)
+	if len(res["members"].([]interface{})) != 1 {
+		t.Errorf("Expected group to have 1 member")
+	}
+	code, res = doSCIMRequest(t, rootSA, "PATCH", "/scim/v2/Groups/"+id, map[string]interface{}{
+		"schemas": []string{models.SCIMSchemas.PatchOp},
+		"Operations": []map[string]interface{}{
+			map[string]interface{}{
+				"op": "remove", "path": fmt.Sprintf(`members[value eq "%s"]`, sa.ID),
+			},
+		},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if len(res["members"].([]interface{})) != 0 {
+		t.Errorf("Expected group to have no members")
+	}
+	code, _ = doSCIMRequest(t, rootSA, "DELETE", "/scim/v2/Groups/"+id, nil)
+	if code != http.StatusNoContent {
+		t.Errorf("Expected status 204. Got %d", code)
+	}
+}
+
+func TestSCIMRequiresPermission(t *testing.T) {
+	beforeEachSCIMHandlers(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code, _ := doSCIMRequest(t, sa, "GET", "/scim/v2/Users", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("Expected status 403. Got %d", code)
+	}
+}
+
+func TestSCIMGroupsRejectNonUserMembers(t *testing.T) {
+	beforeEachSCIMHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	kp, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some kp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code, _ := doSCIMRequest(t, rootSA, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{models.SCIMSchemas.Group},
+		"displayName": "some group",
+		"members":     []map[string]string{map[string]string{"value": kp.ID}},
+	})
+	if code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400. Got %d", code)
+	}
+	code, res := doSCIMRequest(t, rootSA, "POST", "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{models.SCIMSchemas.Group},
+		"displayName": "some group",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", code)
+	}
+	id := res["id"].(string)
+	code, _ = doSCIMRequest(t, rootSA, "PATCH", "/scim/v2/Groups/"+id, map[string]interface{}{
+		"schemas": []string{models.SCIMSchemas.PatchOp},
+		"Operations": []map[string]interface{}{
+			map[string]interface{}{
+				"op": "add", "path": "members",
+				"value": []map[string]string{map[string]string{"value": kp.ID}},
+			},
+		},
+	})
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected status 400. Got %d", code)
+	}
+	code, res = doSCIMRequest(t, rootSA, "GET", "/scim/v2/Groups/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if len(res["members"].([]interface{})) != 0 {
+		t.Errorf("Expected group to have no members")
+	}
+}
+
+func TestSCIMGroupsOnlySCIMManagedRoles(t *testing.T) {
+	beforeEachSCIMHandlers(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	rsUC := helpers.GetRolesUseCase(t)
+	unmanaged := &usecases.RoleWithNested{Name: "admins"}
+	if err := rsUC.Create(unmanaged); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	managed := &usecases.RoleWithNested{Name: "engineers", SCIMManaged: true}
+	if err := rsUC.Create(managed); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code, _ := doSCIMRequest(t, rootSA, "GET", "/scim/v2/Groups/"+unmanaged.ID, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("Expected status 404. Got %d", code)
+	}
+	code, _ = doSCIMRequest(t, rootSA, "PATCH", "/scim/v2/Groups/"+unmanaged.ID, map[string]interface{}{
+		"schemas": []string{models.SCIMSchemas.PatchOp},
+		"Operations": []map[string]interface{}{
+			map[string]interface{}{"op": "replace", "path": "displayName", "value": "x"},
+		},
+	})
+	if code != http.StatusNotFound {
+		t.Errorf("Expected status 404. Got %d", code)
+	}
+	code, _ = doSCIMRequest(t, rootSA, "DELETE", "/scim/v2/Groups/"+unmanaged.ID, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("Expected status 404. Got %d", code)
+	}
+	if _, err := rsUC.Get(unmanaged.ID); err != nil {
+		t.Errorf("Expected role to still exist. Got %s", err.Error())
+	}
+	code, res := doSCIMRequest(
+		t, rootSA, "GET", `/scim/v2/Groups?filter=displayName%20eq%20%22admins%22`, nil,
+	)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if res["totalResults"].(float64) != 0 {
+		t.Errorf("Expected 0 results. Got %v", res["totalResults"])
+	}
+	code, res = doSCIMRequest(t, rootSA, "GET", "/scim/v2/Groups", nil)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", code)
+	}
+	if res["totalResults"].(float64) != 1 {
+		t.Errorf("Expected 1 result. Got %v", res["totalResults"])
+	}
+	code, _ = doSCIMRequest(t, rootSA, "GET", "/scim/v2/Groups/"+managed.ID, nil)
+	if code != http.StatusOK {
+		t.Errorf("Expected status 200. Got %d", code)
+	}
+}
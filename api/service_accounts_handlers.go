@@ -10,6 +10,7 @@ import (
 	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/usecases"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"github.com/topfreegames/extensions/middleware"
 )
 
@@ -60,6 +61,9 @@ func serviceAccountsCreateHandler(
 		}
 		sawn.ID = mux.Vars(r)["id"]
 		if err := sasUC.WithContext(r.Context()).CreateWithNested(sawn); err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.WithError(err).Error("sasUC.CreateWithNested failed")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -93,6 +97,9 @@ func serviceAccountsUpdateHandler(
 		}
 		sawn.ID = mux.Vars(r)["id"]
 		if err := sasUC.WithContext(r.Context()).UpdateWithNested(sawn); err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
 			l.WithError(err).Error("sasUC.UpdateWithNested failed")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -213,3 +220,43 @@ func serviceAccountsSearchHandler(
 		WriteJSON(w, 200, ret)
 	}
 }
+
+// serviceAccountsImpersonateHandler issues a short lived token with which
+// the requester acts as the service account. Impersonators can't chain
+func serviceAccountsImpersonateHandler(
+	o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		if _, ok := getImpersonatorID(r.Context()); ok {
+			err := errors.NewImpersonationError("already impersonating")
+			WriteBytes(w, err.StatusCode(), err.Serialize())
+			return
+		}
+		impersonatorID, _ := getServiceAccountID(r.Context())
+		saID := mux.Vars(r)["id"]
+		token, err := o2sUC.WithContext(r.Context()).Impersonate(impersonatorID, saID)
+		if err != nil {
+			if _, ok := err.(*errors.EntityNotFoundError); ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if iErr, ok := err.(*errors.ImpersonationError); ok {
+				WriteBytes(w, iErr.StatusCode(), iErr.Serialize())
+				return
+			}
+			l.WithError(err).Error("serviceAccountsImpersonateHandler failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		l.WithFields(logrus.Fields{
+			"serviceAccountID": saID,
+			"impersonatorID":   impersonatorID,
+		}).Info("impersonation token issued")
+		WriteJSON(w, http.StatusCreated, map[string]interface{}{
+			"accessToken": token.AccessToken,
+			"email":       token.Email,
+			"expiresIn":   int(usecases.ImpersonationTokenTTL.Seconds()),
+		})
+	}
+}
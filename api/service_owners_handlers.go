@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+// canGrantServiceOwnership is true if the requester owns the permissions
+// owners of service id get, so it may hand them to another service account
+// or take them away from one
+func canGrantServiceOwnership(
+	r *http.Request, sasUC usecases.ServiceAccounts, ssUC usecases.Services,
+	id string,
+) (bool, error) {
+	svc, err := ssUC.WithContext(r.Context()).Get(id)
+	if err != nil {
+		return false, err
+	}
+	if svc.ID == "" {
+		return false, errors.NewEntityNotFoundError(models.Service{}, id)
+	}
+	saID, _ := getServiceAccountID(r.Context())
+	return sasUC.WithContext(r.Context()).HasAllOwnerPermissions(
+		saID, usecases.ServiceOwnerPermissions(svc),
+	)
+}
+
+// writeServiceOwnersError writes errors of owner changes whose status the
+// client can act upon and tells whether err was one of them
+func writeServiceOwnersError(w http.ResponseWriter, err error) bool {
+	switch e := err.(type) {
+	case *errors.EntityNotFoundError:
+		w.WriteHeader(http.StatusNotFound)
+	case *errors.LastServiceOwnerError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	case *errors.MFARequiredError:
+		WriteBytes(w, e.StatusCode(), e.Serialize())
+	default:
+		return false
+	}
+	return true
+}
+
+func serviceOwnersListHandler(
+	ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		owners, err := ssUC.WithContext(r.Context()).
+			ListOwners(mux.Vars(r)["id"])
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersListHandler ssUC.ListOwners failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		results, err := keepJSONFields(owners, "id", "name", "email", "picture")
+		if err != nil {
+			l.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"results": results,
+		})
+	}
+}
+
+func serviceOwnersAddHandler(
+	sasUC usecases.ServiceAccounts, ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersAddHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		owner := struct {
+			ServiceAccountID string `json:"serviceAccountId"`
+		}{}
+		if err := json.Unmarshal(body, &owner); err != nil {
+			Write(w, http.StatusBadRequest, `{"error": "body malformed"}`)
+			return
+		}
+		if _, err := uuid.FromString(owner.ServiceAccountID); err != nil {
+			Write(w, http.StatusUnprocessableEntity, `{"error": "serviceAccountId must be a service account id"}`)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		can, err := canGrantServiceOwnership(r, sasUC, ssUC, id)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersAddHandler canGrantServiceOwnership failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !can {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		err = ssUC.WithContext(r.Context()).AddOwner(id, owner.ServiceAccountID)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersAddHandler ssUC.AddOwner failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func serviceOwnersRemoveHandler(
+	sasUC usecases.ServiceAccounts, ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		saID := mux.Vars(r)["serviceAccountId"]
+		if _, err := uuid.FromString(saID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		can, err := canGrantServiceOwnership(r, sasUC, ssUC, id)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersRemoveHandler canGrantServiceOwnership failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !can {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		err = ssUC.WithContext(r.Context()).RemoveOwner(id, saID)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersRemoveHandler ssUC.RemoveOwner failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serviceOwnersTransferHandler hands a service's ownership from one
+// service account, the requester by default, to another
+func serviceOwnersTransferHandler(
+	sasUC usecases.ServiceAccounts, ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersTransferHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		transfer := struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		}{}
+		if err := json.Unmarshal(body, &transfer); err != nil {
+			Write(w, http.StatusBadRequest, `{"error": "body malformed"}`)
+			return
+		}
+		if transfer.From == "" {
+			transfer.From, _ = getServiceAccountID(r.Context())
+		}
+		_, errFrom := uuid.FromString(transfer.From)
+		_, errTo := uuid.FromString(transfer.To)
+		if errFrom != nil || errTo != nil {
+			Write(w, http.StatusUnprocessableEntity, `{"error": "from and to must be service account ids"}`)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		can, err := canGrantServiceOwnership(r, sasUC, ssUC, id)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersTransferHandler canGrantServiceOwnership failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !can {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		err = ssUC.WithContext(r.Context()).
+			TransferOwnership(id, transfer.From, transfer.To)
+		if writeServiceOwnersError(w, err) {
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("serviceOwnersTransferHandler ssUC.TransferOwnership failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
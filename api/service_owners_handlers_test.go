@@ -0,0 +1,200 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func TestServiceOwnersHandlers(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := helpers.GetSteppedUpAuthorization(t, rootSA)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	other, err := saUC.CreateKeyPairType("other")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	service := &models.Service{
+		Name:                    "Maestro",
+		PermissionName:          "Maestro",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", authorization)
+		return helpers.DoRequest(t, req, app.GetRouter()).Code
+	}
+	owners := func() []string {
+		req, _ := http.NewRequest(
+			"GET", fmt.Sprintf("/services/%s/owners", service.ID), nil,
+		)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		res := struct {
+			Results []models.ServiceAccount `json:"results"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		ids := []string{}
+		for _, sa := range res.Results {
+			ids = append(ids, sa.ID)
+		}
+		return ids
+	}
+	canEdit := func(saID string) bool {
+		has, err := saUC.HasPermissionString(
+			saID, models.BuildWillIAMPermissionLender("EditService", service.ID),
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		return has
+	}
+	ownersPath := fmt.Sprintf("/services/%s/owners", service.ID)
+
+	if ids := owners(); len(ids) != 1 || ids[0] != rootSA.ID {
+		t.Fatalf("Expected creator to be the only owner. Got %v", ids)
+	}
+	code := do("POST", ownersPath, fmt.Sprintf(`{"serviceAccountId": "%s"}`, other.ID))
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201. Got %d", code)
+	}
+	if !canEdit(other.ID) {
+		t.Errorf("Expected new owner to be able to edit the service")
+	}
+	code = do("DELETE", fmt.Sprintf("%s/%s", ownersPath, rootSA.ID), "")
+	if code != http.StatusNoContent {
+		t.Fatalf("Expected 204. Got %d", code)
+	}
+	code = do("DELETE", fmt.Sprintf("%s/%s", ownersPath, other.ID), "")
+	if code != http.StatusConflict {
+		t.Errorf("Expected 409 removing the last owner. Got %d", code)
+	}
+	code = do("POST", ownersPath+"/transfer", fmt.Sprintf(
+		`{"from": "%s", "to": "%s"}`, other.ID, rootSA.ID,
+	))
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200. Got %d", code)
+	}
+	if ids := owners(); len(ids) != 1 || ids[0] != rootSA.ID {
+		t.Errorf("Expected ownership to be transferred back. Got %v", ids)
+	}
+	if canEdit(other.ID) {
+		t.Errorf("Expected previous owner not to be able to edit the service")
+	}
+}
+
+func TestServiceOwnersHandlersForbidden(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	service := &models.Service{
+		Name:                    "Maestro",
+		PermissionName:          "Maestro",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// lent EditService only: it may manage the service, not its ownership
+	lent, err := saUC.CreateKeyPairType("lent")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission(
+		models.BuildWillIAMPermissionLender("EditService", service.ID),
+	)
+	if err := saUC.CreatePermission(lent.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", fmt.Sprintf(
+			"KeyPair %s:%s", lent.KeyID, lent.KeySecret,
+		))
+		return helpers.DoRequest(t, req, app.GetRouter()).Code
+	}
+	ownersPath := fmt.Sprintf("/services/%s/owners", service.ID)
+
+	code := do("POST", ownersPath, fmt.Sprintf(`{"serviceAccountId": "%s"}`, lent.ID))
+	if code != http.StatusForbidden {
+		t.Errorf("Expected 403 adding an owner. Got %d", code)
+	}
+	code = do("DELETE", fmt.Sprintf("%s/%s", ownersPath, rootSA.ID), "")
+	if code != http.StatusForbidden {
+		t.Errorf("Expected 403 removing an owner. Got %d", code)
+	}
+	code = do("POST", ownersPath+"/transfer", fmt.Sprintf(
+		`{"from": "%s", "to": "%s"}`, rootSA.ID, lent.ID,
+	))
+	if code != http.StatusForbidden {
+		t.Errorf("Expected 403 transferring ownership. Got %d", code)
+	}
+	owners, err := helpers.GetServicesUseCase(t).ListOwners(service.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(owners) != 1 || owners[0].ID != rootSA.ID {
+		t.Errorf("Expected creator to still be the only owner. Got %v", owners)
+	}
+}
+
+// TestServiceOwnersHandlersRequireStepUp checks making someone an owner,
+// which grants RO over all of the service's resources, needs a second factor
+func TestServiceOwnersHandlersRequireStepUp(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	other, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("other")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	service := &models.Service{
+		Name:                    "Maestro",
+		PermissionName:          "Maestro",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	do := func(path, body string) (int, string) {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", fmt.Sprintf(
+			"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+		))
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		return rec.Code, rec.Body.String()
+	}
+	ownersPath := fmt.Sprintf("/services/%s/owners", service.ID)
+
+	code, body := do(ownersPath, fmt.Sprintf(`{"serviceAccountId": "%s"}`, other.ID))
+	if code != http.StatusForbidden || !strings.Contains(body, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError adding an owner. Got %d: %s", code, body)
+	}
+	code, body = do(ownersPath+"/transfer", fmt.Sprintf(
+		`{"from": "%s", "to": "%s"}`, rootSA.ID, other.ID,
+	))
+	if code != http.StatusForbidden || !strings.Contains(body, "MFARequiredError") {
+		t.Errorf("Expected MFARequiredError transferring ownership. Got %d: %s", code, body)
+	}
+	owners, err := helpers.GetServicesUseCase(t).ListOwners(service.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(owners) != 1 || owners[0].ID != rootSA.ID {
+		t.Errorf("Expected creator to still be the only owner. Got %v", owners)
+	}
+}
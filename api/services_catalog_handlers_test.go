@@ -0,0 +1,111 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func TestServicesCatalog(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	service := &models.Service{
+		Name:                    "Maestro",
+		PermissionName:          "Maestro",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	bts, _ := json.Marshal(models.ServiceCatalog{
+		Actions: []models.ServiceAction{{
+			Action:            "ListSchedulers",
+			Description:       "List schedulers",
+			ResourceHierarchy: "region::game",
+		}},
+	})
+	req, _ := http.NewRequest(
+		"PUT", fmt.Sprintf("/services/%s/catalog", service.ID),
+		bytes.NewBuffer(bts),
+	)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200. Got %d", rec.Code)
+	}
+
+	type testCase struct {
+		reqPath  string
+		prefixes []string
+		aliases  []string
+	}
+	testCases := []testCase{
+		testCase{
+			reqPath:  "/am?prefix=Maestro::List",
+			prefixes: []string{"Maestro::ListSchedulers"},
+			aliases:  []string{"List schedulers"},
+		},
+		testCase{
+			reqPath:  "/am?prefix=Maestro::ListSchedulers::",
+			prefixes: []string{"Maestro::ListSchedulers::*"},
+			aliases:  []string{"region"},
+		},
+		testCase{
+			reqPath:  "/am?prefix=Maestro::ListSchedulers::NA::",
+			prefixes: []string{"Maestro::ListSchedulers::NA::*"},
+			aliases:  []string{"game"},
+		},
+		testCase{
+			reqPath:  "/am?prefix=Maestro::ListSchedulers::NA::Sniper3D::",
+			prefixes: []string{},
+			aliases:  []string{},
+		},
+	}
+	for _, tt := range testCases {
+		req, _ := http.NewRequest("GET", tt.reqPath, nil)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200. Got %d", tt.reqPath, rec.Code)
+		}
+		ams := []models.AM{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &ams); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if len(ams) != len(tt.prefixes) {
+			t.Fatalf("%s: expected %v. Got %v", tt.reqPath, tt.prefixes, ams)
+		}
+		for i := range ams {
+			if ams[i].Prefix != tt.prefixes[i] || ams[i].Alias != tt.aliases[i] {
+				t.Errorf(
+					"%s: expected %s (%s). Got %s (%s)", tt.reqPath,
+					tt.prefixes[i], tt.aliases[i], ams[i].Prefix, ams[i].Alias,
+				)
+			}
+		}
+	}
+
+	for permission, expected := range map[string]int{
+		"Maestro::RL::ListSchedulerz::*":               http.StatusUnprocessableEntity,
+		"Maestro::RL::ListSchedulers::NA::Sniper3D::x": http.StatusUnprocessableEntity,
+		"Maestro::RL::ListSchedulers::NA::*":           http.StatusCreated,
+	} {
+		req, _ := http.NewRequest("POST", fmt.Sprintf(
+			"/roles/%s/permissions?permission=%s", rootSA.BaseRoleID, permission,
+		), nil)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d. Got %d", permission, expected, rec.Code)
+		}
+	}
+}
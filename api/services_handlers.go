@@ -2,13 +2,18 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/usecases"
 	"github.com/gorilla/mux"
 	"github.com/topfreegames/extensions/middleware"
+	"gopkg.in/yaml.v2"
 )
 
 func servicesListHandler(
@@ -68,7 +73,7 @@ func servicesCreateHandler(
 }
 
 func servicesGetHandler(
-	ssUC usecases.Services,
+	sasUC usecases.ServiceAccounts, ssUC usecases.Services,
 ) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := middleware.GetLogger(r.Context())
@@ -78,14 +83,55 @@ func servicesGetHandler(
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		// TODO: get service account and creator service account
-		bts, err := keepJSONFieldsBytes(s, "id", "name", "permissionName", "amUrl")
+		if s.ID == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		m, err := keepJSONFieldsOne(
+			s, "id", "name", "permissionName", "amUrl", "redirectOrigins",
+		)
 		if err != nil {
 			l.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		WriteBytes(w, 200, bts)
+		sasUC = sasUC.WithContext(r.Context())
+		sa, err := sasUC.Get(s.ServiceAccountID)
+		if err != nil {
+			l.WithError(err).Error("servicesGetHandler sasUC.Get failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		m["serviceAccount"] = keyPairInfo(sa)
+		m["creatorServiceAccount"] = nil
+		creator, err := sasUC.Get(s.CreatorServiceAccountID)
+		if err == nil {
+			m["creatorServiceAccount"] = map[string]string{
+				"id":    creator.ID,
+				"name":  creator.Name,
+				"email": creator.Email,
+			}
+		} else if _, ok := err.(*errors.EntityNotFoundError); !ok {
+			l.WithError(err).Error("servicesGetHandler sasUC.Get failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, m)
+	}
+}
+
+// keyPairInfo is what's shown of a key pair service account; its key
+// secret only in the response of a rotation
+func keyPairInfo(sa *models.ServiceAccount) map[string]interface{} {
+	var previousKeyExpiresAt interface{}
+	if sa.PreviousKeyExpiresAt.After(time.Now()) {
+		previousKeyExpiresAt = sa.PreviousKeyExpiresAt.Time
+	}
+	return map[string]interface{}{
+		"id":                   sa.ID,
+		"name":                 sa.Name,
+		"keyId":                sa.KeyID,
+		"previousKeyExpiresAt": previousKeyExpiresAt,
 	}
 }
 
@@ -114,7 +160,12 @@ func servicesUpdateHandler(
 			return
 		}
 		service.ID = mux.Vars(r)["id"]
-		if err := ssUC.WithContext(r.Context()).Update(service); err != nil {
+		err = ssUC.WithContext(r.Context()).Update(service)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			l.WithError(err).Error("servicesUpdateHandler ssUC.Update failed")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -122,3 +173,259 @@ func servicesUpdateHandler(
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// servicesRotateAMSecretHandler answers a new secret for the service to
+// verify Will.IAM's calls to its AM endpoint; it's only shown here
+func servicesRotateAMSecretHandler(
+	ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		secret, err := ssUC.WithContext(r.Context()).
+			RotateAMSecret(mux.Vars(r)["id"])
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("servicesRotateAMSecretHandler ssUC.RotateAMSecret failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, http.StatusCreated, map[string]string{"amSecret": secret})
+	}
+}
+
+func servicesGetCatalogHandler(
+	ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		catalog, err := ssUC.WithContext(r.Context()).
+			GetCatalog(mux.Vars(r)["id"])
+		if err != nil {
+			l.WithError(err).Error("servicesGetCatalogHandler ssUC.GetCatalog failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, catalog)
+	}
+}
+
+// servicesSetCatalogHandler replaces a service's catalog with the one in
+// the body, e.g. uploaded by its deploy
+func servicesSetCatalogHandler(
+	ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("servicesSetCatalogHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		catalog := &models.ServiceCatalog{}
+		if err := json.Unmarshal(body, catalog); err != nil {
+			Write(w, http.StatusBadRequest, `{"error": "body malformed"}`)
+			return
+		}
+		v := catalog.Validate()
+		if !v.Valid() {
+			WriteBytes(w, http.StatusUnprocessableEntity, v.Errors())
+			return
+		}
+		err = ssUC.WithContext(r.Context()).SetCatalog(mux.Vars(r)["id"], catalog)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("servicesSetCatalogHandler ssUC.SetCatalog failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// servicesApplyManifestHandler reconciles the service a manifest, JSON or
+// YAML, declares. Creating it requires CreateServices, updating it
+// EditService; either way the requester must own the dependencies it
+// grants, and the roles' permissions unless it's creating the service. If
+// the service is created or removed meanwhile, it answers 409
+func servicesApplyManifestHandler(
+	sasUC usecases.ServiceAccounts, ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("servicesApplyManifestHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		m := &models.ServiceManifest{}
+		if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+			err = yaml.Unmarshal(body, m)
+		} else {
+			err = json.Unmarshal(body, m)
+		}
+		if err != nil {
+			Write(w, http.StatusBadRequest, `{"error": "body malformed"}`)
+			return
+		}
+		permissionName := mux.Vars(r)["permissionName"]
+		if m.PermissionName == "" {
+			m.PermissionName = permissionName
+		}
+		if m.PermissionName != permissionName {
+			Write(w, http.StatusUnprocessableEntity, `{"error": "permissionName must match the url"}`)
+			return
+		}
+		v := m.Validate()
+		if !v.Valid() {
+			WriteBytes(w, http.StatusUnprocessableEntity, v.Errors())
+			return
+		}
+		ssUC = ssUC.WithContext(r.Context())
+		sasUC = sasUC.WithContext(r.Context())
+		service, err := ssUC.WithPermissionName(permissionName)
+		if err != nil {
+			l.WithError(err).Error("servicesApplyManifestHandler ssUC.WithPermissionName failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		required := models.BuildWillIAMPermissionLender("CreateServices", "*")
+		owned := m.Dependencies
+		if service.ID != "" {
+			required = models.BuildWillIAMPermissionLender("EditService", service.ID)
+			for _, mr := range m.Roles {
+				owned = append(owned, mr.Permissions...)
+			}
+		}
+		saID, _ := getServiceAccountID(r.Context())
+		has, err := sasUC.HasPermissionString(saID, required)
+		if err == nil && has {
+			var ps []models.Permission
+			if ps, err = models.BuildPermissions(owned); err == nil {
+				has, err = sasUC.HasAllOwnerPermissions(saID, ps)
+			}
+		}
+		if err != nil {
+			l.WithError(err).Error("servicesApplyManifestHandler permissions check failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !has {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		service, created, err := ssUC.ApplyManifest(saID, service.ID, m)
+		if conflictErr, ok := err.(*errors.ManifestConflictError); ok {
+			WriteBytes(w, conflictErr.StatusCode(), conflictErr.Serialize())
+			return
+		}
+		if err != nil {
+			if writePermissionGrantError(w, err) {
+				return
+			}
+			l.WithError(err).Error("servicesApplyManifestHandler ssUC.ApplyManifest failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		WriteJSON(w, status, map[string]string{
+			"id":               service.ID,
+			"name":             service.Name,
+			"permissionName":   service.PermissionName,
+			"serviceAccountId": service.ServiceAccountID,
+		})
+	}
+}
+
+func servicesGetServiceAccountHandler(
+	ssUC usecases.Services,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		sa, err := ssUC.WithContext(r.Context()).
+			GetServiceAccount(mux.Vars(r)["id"])
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("servicesGetServiceAccountHandler ssUC.GetServiceAccount failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, keyPairInfo(sa))
+	}
+}
+
+// keyRotationConfig bounds for how long a service account's previous key
+// pair keeps working after a rotation
+type keyRotationConfig struct {
+	overlap    time.Duration
+	maxOverlap time.Duration
+}
+
+// servicesRotateServiceAccountKeyHandler answers a new key pair for the
+// service's service account; it's only shown here. The body may set for
+// how long the previous one keeps working, e.g. {"overlap": "1h"}
+func servicesRotateServiceAccountKeyHandler(
+	ssUC usecases.Services, config keyRotationConfig,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("servicesRotateServiceAccountKeyHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		overlap := config.overlap
+		if len(body) > 0 {
+			rotation := struct {
+				Overlap *string `json:"overlap"`
+			}{}
+			if err := json.Unmarshal(body, &rotation); err != nil {
+				Write(w, http.StatusBadRequest, `{"error": "body malformed"}`)
+				return
+			}
+			if rotation.Overlap != nil {
+				overlap, err = time.ParseDuration(*rotation.Overlap)
+				if err != nil || overlap < 0 || overlap > config.maxOverlap {
+					Write(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+						`{"error": "overlap must be a duration up to %s"}`,
+						config.maxOverlap,
+					))
+					return
+				}
+			}
+		}
+		sa, err := ssUC.WithContext(r.Context()).
+			RotateServiceAccountKey(mux.Vars(r)["id"], overlap)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("servicesRotateServiceAccountKeyHandler ssUC.RotateServiceAccountKey failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		info := keyPairInfo(sa)
+		info["keySecret"] = sa.KeySecret
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, http.StatusCreated, info)
+	}
+}
@@ -0,0 +1,169 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+const maestroManifest = `
+name: Maestro
+permissionName: Maestro
+actions:
+- action: Deploy
+  description: Deploy schedulers
+  resourceHierarchy: region::game
+roles:
+- name: deployers
+  permissions:
+  - Maestro::RL::Deploy::*
+`
+
+func TestServicesApplyManifestHandler(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	app := helpers.GetApp(t)
+	apply := func(manifest string) (int, map[string]string) {
+		req, _ := http.NewRequest(
+			"PUT", "/services/Maestro/manifest", bytes.NewBufferString(manifest),
+		)
+		req.Header.Set("Authorization", authorization)
+		req.Header.Set("Content-Type", "application/yaml")
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		body := map[string]string{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	code, created := apply(maestroManifest)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201. Got %d", code)
+	}
+	code, updated := apply(maestroManifest)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200. Got %d", code)
+	}
+	if created["id"] != updated["id"] {
+		t.Errorf("Expected same service %s. Got %s", created["id"], updated["id"])
+	}
+
+	repo := helpers.GetRepo(t)
+	role, err := repo.Roles.GetByName("Maestro:deployers")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	ps, err := repo.Permissions.ForRole(role.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(ps) != 1 || ps[0].String() != "Maestro::RL::Deploy::*" {
+		t.Errorf("Expected only Maestro::RL::Deploy::*. Got %v", ps)
+	}
+	catalog, err := helpers.GetServicesUseCase(t).GetCatalog(created["id"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(catalog.Actions) != 1 || catalog.Actions[0].Action != "Deploy" {
+		t.Errorf("Expected only Deploy in catalog. Got %v", catalog.Actions)
+	}
+
+	code, _ = apply(maestroManifest + "  - Maestro::RL::Delete::*\n")
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for permission not in catalog. Got %d", code)
+	}
+}
+
+func TestServicesApplyManifestHandlerForeignRoles(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	app := helpers.GetApp(t)
+	apply := func(permissionName, manifest string) int {
+		req, _ := http.NewRequest(
+			"PUT", fmt.Sprintf("/services/%s/manifest", permissionName),
+			bytes.NewBufferString(manifest),
+		)
+		req.Header.Set("Authorization", authorization)
+		req.Header.Set("Content-Type", "application/yaml")
+		return helpers.DoRequest(t, req, app.GetRouter()).Code
+	}
+	repo := helpers.GetRepo(t)
+	if err := repo.Roles.Create(&models.Role{Name: "Maestro:deployers"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if code := apply("Maestro", maestroManifest); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a role Maestro didn't create. Got %d", code)
+	}
+
+	rootPermissions, err := repo.Permissions.ForServiceAccount(rootSA.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	code := apply("service-account", fmt.Sprintf(`
+name: service-account
+permissionName: service-account
+roles:
+- name: %s
+  permissions: []
+`, rootSA.ID))
+	if code != http.StatusConflict {
+		t.Errorf("Expected 409 for a base role. Got %d", code)
+	}
+	after, err := repo.Permissions.ForServiceAccount(rootSA.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(after) != len(rootPermissions) {
+		t.Errorf("Expected root's permissions to be kept. Got %v", after)
+	}
+}
+
+func TestServicesApplyManifestHandlerForbidden(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+	apply := func(sa *models.ServiceAccount) int {
+		req, _ := http.NewRequest(
+			"PUT", "/services/Maestro/manifest",
+			bytes.NewBufferString(maestroManifest),
+		)
+		req.Header.Set("Authorization", fmt.Sprintf(
+			"KeyPair %s:%s", sa.KeyID, sa.KeySecret,
+		))
+		req.Header.Set("Content-Type", "application/yaml")
+		return helpers.DoRequest(t, req, app.GetRouter()).Code
+	}
+	if code := apply(sa); code != http.StatusForbidden {
+		t.Errorf("Expected 403 creating a service without CreateServices. Got %d", code)
+	}
+	if code := apply(rootSA); code != http.StatusCreated {
+		t.Fatalf("Expected 201. Got %d", code)
+	}
+	service, err := helpers.GetServicesUseCase(t).WithPermissionName("Maestro")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// lent EditService, but owning none of the roles' permissions
+	p, _ := models.BuildPermission(
+		models.BuildWillIAMPermissionLender("EditService", service.ID),
+	)
+	if err := saUC.CreatePermission(sa.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if code := apply(sa); code != http.StatusForbidden {
+		t.Errorf("Expected 403 granting permissions not owned. Got %d", code)
+	}
+}
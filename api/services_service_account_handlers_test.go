@@ -0,0 +1,100 @@
+// +build integration
+
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func TestServicesRotateServiceAccountKeyHandler(t *testing.T) {
+	beforeEachServices(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	authorization := fmt.Sprintf("KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret)
+	service := &models.Service{
+		Name:                    "Maestro",
+		PermissionName:          "Maestro",
+		CreatorServiceAccountID: rootSA.ID,
+	}
+	if err := helpers.GetServicesUseCase(t).Create(service); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.Get(service.ServiceAccountID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	app := helpers.GetApp(t)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/services/%s", service.ID), nil)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200. Got %d", rec.Code)
+	}
+	got := map[string]map[string]interface{}{}
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got["serviceAccount"]["id"] != sa.ID {
+		t.Errorf("Expected serviceAccount.id %s. Got %v", sa.ID, got["serviceAccount"]["id"])
+	}
+	if _, ok := got["serviceAccount"]["keySecret"]; ok {
+		t.Errorf("Expected serviceAccount.keySecret not to be shown")
+	}
+	if got["creatorServiceAccount"]["id"] != rootSA.ID {
+		t.Errorf("Expected creatorServiceAccount.id %s. Got %v", rootSA.ID, got["creatorServiceAccount"]["id"])
+	}
+
+	rotate := func(body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(
+			"POST", fmt.Sprintf("/services/%s/service_account/key", service.ID),
+			bytes.NewBufferString(body),
+		)
+		req.Header.Set("Authorization", authorization)
+		rec := helpers.DoRequest(t, req, app.GetRouter())
+		res := map[string]interface{}{}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return rec.Code, res
+	}
+	authenticates := func(keyID, keySecret string) bool {
+		_, err := saUC.AuthenticateKeyPair(keyID, keySecret)
+		return err == nil
+	}
+
+	code, first := rotate("")
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201. Got %d", code)
+	}
+	if first["previousKeyExpiresAt"] == nil {
+		t.Errorf("Expected previousKeyExpiresAt to be set")
+	}
+	if !authenticates(sa.KeyID, sa.KeySecret) {
+		t.Errorf("Expected previous key pair to work during the overlap")
+	}
+	if !authenticates(first["keyId"].(string), first["keySecret"].(string)) {
+		t.Errorf("Expected new key pair to work")
+	}
+
+	if code, _ := rotate(`{"overlap": "720h"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for overlap over the max. Got %d", code)
+	}
+
+	code, second := rotate(`{"overlap": "0s"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201. Got %d", code)
+	}
+	if authenticates(first["keyId"].(string), first["keySecret"].(string)) {
+		t.Errorf("Expected previous key pair to stop working without overlap")
+	}
+	if authenticates(sa.KeyID, sa.KeySecret) {
+		t.Errorf("Expected first key pair to stop working")
+	}
+	if !authenticates(second["keyId"].(string), second["keySecret"].(string)) {
+		t.Errorf("Expected new key pair to work")
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+// canManageSessions is true if the requester is the service account
+// itself or may edit it. Scoped and impersonated tokens of the service
+// account itself need the permission too
+func canManageSessions(
+	r *http.Request, sasUC usecases.ServiceAccounts, id string,
+) (bool, error) {
+	saID, _ := getServiceAccountID(r.Context())
+	_, scoped := usecases.GetTokenScope(r.Context())
+	_, impersonated := getImpersonatorID(r.Context())
+	if saID == id && !scoped && !impersonated {
+		return true, nil
+	}
+	return sasUC.WithContext(r.Context()).HasPermissionString(
+		saID, models.BuildWillIAMPermissionLender("EditServiceAccount", id),
+	)
+}
+
+func serviceAccountsSessionsListHandler(
+	sasUC usecases.ServiceAccounts, ssnUC usecases.Sessions,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		id := mux.Vars(r)["id"]
+		can, err := canManageSessions(r, sasUC, id)
+		if err != nil {
+			l.WithError(err).Error("serviceAccountsSessionsListHandler")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !can {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		accessToken, _ := getAccessToken(r.Context())
+		sessions, err := ssnUC.WithContext(r.Context()).List(id, accessToken)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("ssnUC.List failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"results": sessions,
+		})
+	}
+}
+
+func serviceAccountsSessionsRevokeHandler(
+	sasUC usecases.ServiceAccounts, ssnUC usecases.Sessions,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		id := mux.Vars(r)["id"]
+		can, err := canManageSessions(r, sasUC, id)
+		if err != nil {
+			l.WithError(err).Error("serviceAccountsSessionsRevokeHandler")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !can {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		sessionID := mux.Vars(r)["sessionId"]
+		if _, err := uuid.FromString(sessionID); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		err = ssnUC.WithContext(r.Context()).Revoke(id, sessionID)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			l.WithError(err).Error("ssnUC.Revoke failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,169 @@
+// +build integration
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func listSessions(
+	t *testing.T, app *api.App, authorization, saID string,
+) (int, []models.Session) {
+	t.Helper()
+	req, _ := http.NewRequest(
+		"GET", fmt.Sprintf("/service_accounts/%s/sessions", saID), nil,
+	)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var res struct {
+		Results []models.Session `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return rec.Code, res.Results
+}
+
+func revokeSession(
+	t *testing.T, app *api.App, authorization, saID, sessionID string,
+) int {
+	t.Helper()
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf(
+		"/service_accounts/%s/sessions/%s", saID, sessionID,
+	), nil)
+	req.Header.Set("Authorization", authorization)
+	return helpers.DoRequest(t, req, app.GetRouter()).Code
+}
+
+func TestServiceAccountsSessionsHandlers(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	rootAuth := helpers.GetSteppedUpAuthorization(t, rootSA)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(sa, ""))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	ownToken := res["access_token"].(string)
+	status, sessions := listSessions(t, app, "Bearer "+ownToken, sa.ID)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", status)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session. Got %d", len(sessions))
+	}
+	if !sessions[0].Current {
+		t.Errorf("Expected session to be the current one")
+	}
+	status, token := impersonate(t, app, rootAuth, sa.ID)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	status, sessions = listSessions(t, app, rootAuth, sa.ID)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d", status)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions. Got %v", sessions)
+	}
+	var impersonation *models.Session
+	for i := range sessions {
+		if sessions[i].Current {
+			t.Errorf("Expected no session to be the current one")
+		}
+		if sessions[i].ImpersonatorID != "" {
+			impersonation = &sessions[i]
+		}
+	}
+	if impersonation == nil || impersonation.ImpersonatorID != rootSA.ID {
+		t.Fatalf("Expected a session impersonated by %s. Got %v", rootSA.ID, sessions)
+	}
+	if status := revokeSession(
+		t, app, rootAuth, rootSA.ID, impersonation.ID,
+	); status != http.StatusNotFound {
+		t.Errorf("Expected another account's session to 404. Got %d", status)
+	}
+	if status := revokeSession(
+		t, app, rootAuth, sa.ID, impersonation.ID,
+	); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "Maestro::RL::Deploy::NA",
+	); status != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to 401. Got %d", status)
+	}
+}
+
+// TestServiceAccountsSessionsHandlersNarrowedTokens checks impersonated and
+// scoped tokens don't manage their own account's sessions by being its
+// tokens, but only with EditServiceAccount over it
+func TestServiceAccountsSessionsHandlersNarrowedTokens(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, impersonated := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), sa.ID,
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	if status, _ := listSessions(
+		t, app, "Bearer "+impersonated, sa.ID,
+	); status != http.StatusForbidden {
+		t.Errorf("Expected impersonated token to get 403. Got %d", status)
+	}
+	status, res := postOAuth2Token(t, app, clientCredentialsValues(
+		rootSA, "SomeService::RL::Deploy::*",
+	))
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200. Got %d: %v", status, res)
+	}
+	scoped := res["access_token"].(string)
+	if status, _ := listSessions(
+		t, app, "Bearer "+scoped, rootSA.ID,
+	); status != http.StatusForbidden {
+		t.Errorf("Expected scoped token to get 403. Got %d", status)
+	}
+	if status := revokeSession(
+		t, app, "Bearer "+scoped, rootSA.ID, "00000000-0000-0000-0000-000000000000",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected scoped token to get 403 revoking. Got %d", status)
+	}
+}
+
+func TestServiceAccountsSessionsHandlersForbidden(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, _ := listSessions(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", sa.KeyID, sa.KeySecret,
+	), rootSA.ID)
+	if status != http.StatusForbidden {
+		t.Errorf("Expected status 403. Got %d", status)
+	}
+}
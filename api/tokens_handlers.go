@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/topfreegames/extensions/middleware"
+)
+
+type scopedTokenRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// tokensCreateScopedHandler mints a token of the requester restricted to a
+// subset of its permissions, e.g. to hand to a CI job
+func tokensCreateScopedHandler(
+	sasUC usecases.ServiceAccounts, o2sUC usecases.OAuth2Server,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := middleware.GetLogger(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			l.WithError(err).Error("tokensCreateScopedHandler ioutil.ReadAll failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req := &scopedTokenRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			Write(w, http.StatusUnprocessableEntity, `{ "error": "invalid body" }`)
+			return
+		}
+		if len(req.Permissions) == 0 {
+			Write(
+				w, http.StatusUnprocessableEntity,
+				`{ "error": "permissions is required" }`,
+			)
+			return
+		}
+		ps := make([]models.Permission, len(req.Permissions))
+		for i := range req.Permissions {
+			if ps[i], err = models.BuildPermission(req.Permissions[i]); err != nil {
+				WriteJSON(w, http.StatusUnprocessableEntity, map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+		saID, _ := getServiceAccountID(r.Context())
+		// r.Context() keeps the requester's own token scope, so a scoped
+		// token can only mint narrower ones
+		has, err := sasUC.WithContext(r.Context()).HasPermissions(saID, ps)
+		if err != nil {
+			l.WithError(err).Error("tokensCreateScopedHandler HasPermissions failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for i := range has {
+			if !has[i] {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		scope := make([]string, len(ps))
+		for i := range ps {
+			scope[i] = ps[i].String()
+		}
+		accessToken, _ := getAccessToken(r.Context())
+		token, err := o2sUC.WithContext(r.Context()).
+			IssueScopedToken(saID, accessToken, scope)
+		if err != nil {
+			l.WithError(err).Error("tokensCreateScopedHandler IssueScopedToken failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		WriteJSON(w, http.StatusCreated, map[string]interface{}{
+			"accessToken": token.AccessToken,
+			"expiresIn":   int(time.Until(token.Expiry).Seconds()),
+			"permissions": token.Scope,
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// +build integration
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/api"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func mintScopedToken(
+	t *testing.T, app *api.App, authorization string, permissions ...string,
+) (int, string) {
+	t.Helper()
+	bts, _ := json.Marshal(map[string][]string{"permissions": permissions})
+	req, _ := http.NewRequest(
+		"POST", "/tokens/scoped", strings.NewReader(string(bts)),
+	)
+	req.Header.Set("Authorization", authorization)
+	rec := helpers.DoRequest(t, req, app.GetRouter())
+	if rec.Code != http.StatusCreated {
+		return rec.Code, ""
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return rec.Code, res["accessToken"].(string)
+}
+
+func TestTokensCreateScopedHandler(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	status, token := mintScopedToken(t, app, fmt.Sprintf(
+		"KeyPair %s:%s", rootSA.KeyID, rootSA.KeySecret,
+	), "Maestro::RL::Deploy::*")
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "Maestro::RL::Deploy::NA",
+	); status != http.StatusOK {
+		t.Errorf("Expected permission in scope. Got %d", status)
+	}
+	if status := hasPermissionWithToken(
+		t, app, token, "Maestro::RL::Delete::NA",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected permission out of scope to be denied. Got %d", status)
+	}
+	if status, _ := mintScopedToken(
+		t, app, "Bearer "+token, "Maestro::RL::*::*",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected scoped token not to mint a broader one. Got %d", status)
+	}
+	if status, _ := mintScopedToken(
+		t, app, "Bearer "+token, "Maestro::RL::Deploy::NA",
+	); status != http.StatusCreated {
+		t.Errorf("Expected scoped token to mint a narrower one. Got %d", status)
+	}
+}
+
+func TestTokensCreateScopedHandlerRejectsPermissionsNotHeld(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	sa, err := helpers.GetServiceAccountsUseCase(t).CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	authorization := fmt.Sprintf("KeyPair %s:%s", sa.KeyID, sa.KeySecret)
+	if status, _ := mintScopedToken(
+		t, app, authorization, "Maestro::RL::Deploy::*",
+	); status != http.StatusForbidden {
+		t.Errorf("Expected status 403. Got %d", status)
+	}
+	if status, _ := mintScopedToken(t, app, authorization); status !=
+		http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422. Got %d", status)
+	}
+}
+
+func TestTokensCreateScopedHandlerKeepsImpersonation(t *testing.T) {
+	beforeEachOAuth2ServerHandlers(t)
+	app := helpers.GetApp(t)
+	rootSA := helpers.CreateRootServiceAccount(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateKeyPairType("some sa")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	p, _ := models.BuildPermission("Maestro::RL::Deploy::*")
+	if err := saUC.CreatePermission(sa.ID, &p); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	status, impersonation := impersonate(
+		t, app, helpers.GetSteppedUpAuthorization(t, rootSA), sa.ID,
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	status, token := mintScopedToken(
+		t, app, "Bearer "+impersonation, "Maestro::RL::Deploy::*",
+	)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201. Got %d", status)
+	}
+	repo := helpers.GetRepo(t)
+	parent, err := repo.Tokens.Get(impersonation)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	scoped, err := repo.Tokens.Get(token)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if scoped.ImpersonatorID != rootSA.ID {
+		t.Errorf("Expected impersonator %s. Got %s", rootSA.ID, scoped.ImpersonatorID)
+	}
+	if scoped.Expiry.After(parent.Expiry) {
+		t.Errorf("Expected scoped token not to outlive the impersonation token")
+	}
+}
@@ -1,8 +1,13 @@
 package cmd
 
 import (
-	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/utils"
+	"github.com/ghostec/Will.IAM/worker"
 	"github.com/spf13/cobra"
 )
 
@@ -10,9 +15,23 @@ import (
 var startWorkerCmd = &cobra.Command{
 	Use:   "start-worker",
 	Short: "starts the worker",
-	Long:  `starts the worker.`,
+	Long:  `starts the worker, which runs periodic jobs such as profile sync.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("start-worker called")
+		constants.Set(config)
+		log := utils.GetLogger("", 0, verbose, json)
+		log.Info("starting Will.IAM worker")
+		w, err := worker.NewWorker(config, log, nil)
+		if err != nil {
+			log.Panic(err.Error())
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			log.Info("stopping Will.IAM worker")
+			w.Stop()
+		}()
+		w.Start()
 	},
 }
 
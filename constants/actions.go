@@ -1,5 +1,7 @@
 package constants
 
+import "time"
+
 // RolesActions are all possible actions over roles
 var RolesActions = []string{
 	"CreateRoles",
@@ -10,6 +12,7 @@ var RolesActions = []string{
 var ServiceAccountsActions = []string{
 	"CreateServiceAccounts",
 	"EditServiceAccount",
+	"ImpersonateServiceAccount",
 }
 
 // ServicesActions are all possible actions over services
@@ -17,3 +20,37 @@ var ServicesActions = []string{
 	"CreateServices",
 	"EditService",
 }
+
+// SCIMActions are all possible actions over SCIM provisioning
+var SCIMActions = []string{
+	"ProvisionSCIM",
+}
+
+// AuditActions are all possible actions over the audit log
+var AuditActions = []string{
+	"ListAudit",
+}
+
+// WillIAMActions returns all of Will.IAM's own actions
+func WillIAMActions() []string {
+	all := []string{}
+	for _, actions := range [][]string{
+		RolesActions, ServiceAccountsActions, ServicesActions, SCIMActions,
+		AuditActions,
+	} {
+		all = append(all, actions...)
+	}
+	return all
+}
+
+// MFAStepUpMaxAge is how recent a second factor must be by default
+const MFAStepUpMaxAge = 5 * time.Minute
+
+// MFAStepUpActions are Will.IAM actions that need the requester's token to
+// have proved a second factor within the given duration. Granting RO over
+// every resource (e.g. SomeService::RO::SomeAction::*) needs it within
+// MFAStepUpMaxAge too, whichever route grants it
+var MFAStepUpActions = map[string]time.Duration{
+	"EditRole":                  MFAStepUpMaxAge,
+	"ImpersonateServiceAccount": MFAStepUpMaxAge,
+}
@@ -0,0 +1,70 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// InvalidMFAError happens when a second factor can't be enrolled or
+// verified, e.g. a wrong or reused TOTP code
+type InvalidMFAError struct {
+	reason string
+}
+
+// NewInvalidMFAError ctor
+func NewInvalidMFAError(reason string) *InvalidMFAError {
+	return &InvalidMFAError{reason: reason}
+}
+
+func (e *InvalidMFAError) Error() string {
+	return fmt.Sprintf("invalid second factor: %s", e.reason)
+}
+
+// Serialize returns the error serialized
+func (e *InvalidMFAError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-016",
+		"error":       "InvalidMFAError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *InvalidMFAError) StatusCode() int {
+	return 422
+}
+
+// MFARequiredError happens when an action needs a recent second factor
+// the requester's token doesn't have
+type MFARequiredError struct {
+	action string
+}
+
+// NewMFARequiredError ctor
+func NewMFARequiredError(action string) *MFARequiredError {
+	return &MFARequiredError{action: action}
+}
+
+func (e *MFARequiredError) Error() string {
+	return fmt.Sprintf("%s requires a recent second factor", e.action)
+}
+
+// Serialize returns the error serialized
+func (e *MFARequiredError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-017",
+		"error":       "MFARequiredError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *MFARequiredError) StatusCode() int {
+	return 403
+}
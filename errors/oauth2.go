@@ -30,3 +30,172 @@ func (e *NonAllowedEmailDomainError) Serialize() []byte {
 
 	return g
 }
+
+// TokenReuseError happens when an access token that was already rotated
+// is presented after its grace period. The whole token family is revoked
+type TokenReuseError struct {
+	familyID string
+}
+
+// NewTokenReuseError ctor
+func NewTokenReuseError(familyID string) *TokenReuseError {
+	return &TokenReuseError{familyID: familyID}
+}
+
+func (e *TokenReuseError) Error() string {
+	return fmt.Sprintf("rotated token reused, family %s revoked", e.familyID)
+}
+
+// Serialize returns the error serialized
+func (e *TokenReuseError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-013",
+		"error":       "TokenReuseError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *TokenReuseError) StatusCode() int {
+	return 401
+}
+
+// TokenExpiredError happens when a known access token is presented after
+// it expired or was revoked; unlike unknown tokens, it isn't a guess
+type TokenExpiredError struct{}
+
+// NewTokenExpiredError ctor
+func NewTokenExpiredError() *TokenExpiredError {
+	return &TokenExpiredError{}
+}
+
+func (e *TokenExpiredError) Error() string {
+	return "access token expired"
+}
+
+// Serialize returns the error serialized
+func (e *TokenExpiredError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-022",
+		"error":       "TokenExpiredError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *TokenExpiredError) StatusCode() int {
+	return 401
+}
+
+// UnverifiedEmailError happens when an identity provider doesn't vouch
+// for the email of the account logging in
+type UnverifiedEmailError struct {
+	email string
+}
+
+// NewUnverifiedEmailError ctor
+func NewUnverifiedEmailError(email string) *UnverifiedEmailError {
+	return &UnverifiedEmailError{email: email}
+}
+
+func (e *UnverifiedEmailError) Error() string {
+	return fmt.Sprintf("email not verified by provider: %s", e.email)
+}
+
+// Serialize returns the error serialized
+func (e *UnverifiedEmailError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-023",
+		"error":       "UnverifiedEmailError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *UnverifiedEmailError) StatusCode() int {
+	return 401
+}
+
+// OAuth2ProviderMismatchError happens when an account logs in through a
+// provider other than the one it belongs to
+type OAuth2ProviderMismatchError struct {
+	saID     string
+	provider string
+}
+
+// NewOAuth2ProviderMismatchError ctor
+func NewOAuth2ProviderMismatchError(
+	saID, provider string,
+) *OAuth2ProviderMismatchError {
+	return &OAuth2ProviderMismatchError{saID: saID, provider: provider}
+}
+
+func (e *OAuth2ProviderMismatchError) Error() string {
+	return fmt.Sprintf(
+		"service account %s doesn't log in through %s", e.saID, e.provider,
+	)
+}
+
+// Serialize returns the error serialized
+func (e *OAuth2ProviderMismatchError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-024",
+		"error":       "OAuth2ProviderMismatchError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *OAuth2ProviderMismatchError) StatusCode() int {
+	return 401
+}
+
+// OAuth2Error is an error response of Will.IAM's own authorization server.
+// Kind is one of RFC 6749's error codes, e.g. invalid_grant
+type OAuth2Error struct {
+	Kind        string
+	description string
+}
+
+// NewOAuth2Error ctor
+func NewOAuth2Error(kind, description string) *OAuth2Error {
+	return &OAuth2Error{Kind: kind, description: description}
+}
+
+func (e *OAuth2Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.description)
+}
+
+// Serialize returns the error serialized, with RFC 6749's fields besides
+// the usual ones
+func (e *OAuth2Error) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":              "ERR-014",
+		"error":             e.Kind,
+		"error_description": e.description,
+		"description":       e.Error(),
+		"success":           false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *OAuth2Error) StatusCode() int {
+	if e.Kind == "invalid_client" {
+		return 401
+	}
+	return 400
+}
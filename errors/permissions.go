@@ -104,3 +104,37 @@ func (e *UserDoesntHaveAllPermissionsError) Serialize() []byte {
 func (e *UserDoesntHaveAllPermissionsError) StatusCode() int {
 	return 403
 }
+
+// PermissionNotInCatalogError happens when a permission names an action or
+// a resource hierarchy its service's catalog doesn't have
+type PermissionNotInCatalogError struct {
+	permission string
+}
+
+// NewPermissionNotInCatalogError ctor
+func NewPermissionNotInCatalogError(
+	permission string,
+) *PermissionNotInCatalogError {
+	return &PermissionNotInCatalogError{permission: permission}
+}
+
+func (e *PermissionNotInCatalogError) Error() string {
+	return fmt.Sprintf("permission %s not in its service's catalog", e.permission)
+}
+
+// Serialize returns the error serialized
+func (e *PermissionNotInCatalogError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-018",
+		"error":       "PermissionNotInCatalogError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *PermissionNotInCatalogError) StatusCode() int {
+	return 422
+}
@@ -0,0 +1,134 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// InvalidSCIMFilterError happens when a SCIM filter is malformed or
+// filters by an attribute that isn't supported
+type InvalidSCIMFilterError struct {
+	filter string
+}
+
+// NewInvalidSCIMFilterError ctor
+func NewInvalidSCIMFilterError(filter string) *InvalidSCIMFilterError {
+	return &InvalidSCIMFilterError{filter: filter}
+}
+
+func (e *InvalidSCIMFilterError) Error() string {
+	return fmt.Sprintf("unsupported SCIM filter: %s", e.filter)
+}
+
+// Serialize returns the error serialized
+func (e *InvalidSCIMFilterError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-010",
+		"error":       "InvalidSCIMFilterError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *InvalidSCIMFilterError) StatusCode() int {
+	return 400
+}
+
+// InvalidSCIMPatchError happens when a SCIM PATCH operation can't be applied
+type InvalidSCIMPatchError struct {
+	reason string
+}
+
+// NewInvalidSCIMPatchError ctor
+func NewInvalidSCIMPatchError(reason string) *InvalidSCIMPatchError {
+	return &InvalidSCIMPatchError{reason: reason}
+}
+
+func (e *InvalidSCIMPatchError) Error() string {
+	return fmt.Sprintf("invalid SCIM patch: %s", e.reason)
+}
+
+// Serialize returns the error serialized
+func (e *InvalidSCIMPatchError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-011",
+		"error":       "InvalidSCIMPatchError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *InvalidSCIMPatchError) StatusCode() int {
+	return 400
+}
+
+// InvalidSCIMMemberError happens when a SCIM Group member isn't a user
+// provisioned through OAuth2, e.g. a key pair service account
+type InvalidSCIMMemberError struct {
+	id string
+}
+
+// NewInvalidSCIMMemberError ctor
+func NewInvalidSCIMMemberError(id string) *InvalidSCIMMemberError {
+	return &InvalidSCIMMemberError{id: id}
+}
+
+func (e *InvalidSCIMMemberError) Error() string {
+	return fmt.Sprintf("invalid SCIM group member: %s is not a user", e.id)
+}
+
+// Serialize returns the error serialized
+func (e *InvalidSCIMMemberError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-021",
+		"error":       "InvalidSCIMMemberError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *InvalidSCIMMemberError) StatusCode() int {
+	return 400
+}
+
+// SCIMUniquenessError happens when a SCIM resource would collide with an
+// existing one, e.g. a User with an already provisioned userName
+type SCIMUniquenessError struct {
+	attribute string
+	value     string
+}
+
+// NewSCIMUniquenessError ctor
+func NewSCIMUniquenessError(attribute, value string) *SCIMUniquenessError {
+	return &SCIMUniquenessError{attribute: attribute, value: value}
+}
+
+func (e *SCIMUniquenessError) Error() string {
+	return fmt.Sprintf("%s %s is already in use", e.attribute, e.value)
+}
+
+// Serialize returns the error serialized
+func (e *SCIMUniquenessError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-012",
+		"error":       "SCIMUniquenessError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *SCIMUniquenessError) StatusCode() int {
+	return 409
+}
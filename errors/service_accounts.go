@@ -0,0 +1,70 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DisabledServiceAccountError happens when a disabled service account
+// tries to authenticate
+type DisabledServiceAccountError struct {
+	id string
+}
+
+// NewDisabledServiceAccountError ctor
+func NewDisabledServiceAccountError(id string) *DisabledServiceAccountError {
+	return &DisabledServiceAccountError{id: id}
+}
+
+func (e *DisabledServiceAccountError) Error() string {
+	return fmt.Sprintf("service account %s is disabled", e.id)
+}
+
+// Serialize returns the error serialized
+func (e *DisabledServiceAccountError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-009",
+		"error":       "DisabledServiceAccountError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *DisabledServiceAccountError) StatusCode() int {
+	return 401
+}
+
+// ImpersonationError happens when an impersonation isn't allowed, e.g. an
+// impersonator trying to impersonate someone else
+type ImpersonationError struct {
+	reason string
+}
+
+// NewImpersonationError ctor
+func NewImpersonationError(reason string) *ImpersonationError {
+	return &ImpersonationError{reason: reason}
+}
+
+func (e *ImpersonationError) Error() string {
+	return fmt.Sprintf("impersonation not allowed: %s", e.reason)
+}
+
+// Serialize returns the error serialized
+func (e *ImpersonationError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-015",
+		"error":       "ImpersonationError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *ImpersonationError) StatusCode() int {
+	return 403
+}
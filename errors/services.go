@@ -0,0 +1,70 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LastServiceOwnerError happens when removing a service's only owner
+type LastServiceOwnerError struct {
+	serviceID string
+}
+
+// NewLastServiceOwnerError ctor
+func NewLastServiceOwnerError(serviceID string) *LastServiceOwnerError {
+	return &LastServiceOwnerError{serviceID: serviceID}
+}
+
+func (e *LastServiceOwnerError) Error() string {
+	return fmt.Sprintf("service %s must keep at least one owner", e.serviceID)
+}
+
+// Serialize returns the error serialized
+func (e *LastServiceOwnerError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-019",
+		"error":       "LastServiceOwnerError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *LastServiceOwnerError) StatusCode() int {
+	return 409
+}
+
+// ManifestConflictError happens when a manifest can't be applied over what
+// exists: a role it declares isn't the service's, or the service was
+// created or removed while the manifest was being authorized
+type ManifestConflictError struct {
+	description string
+}
+
+// NewManifestConflictError ctor
+func NewManifestConflictError(description string) *ManifestConflictError {
+	return &ManifestConflictError{description: description}
+}
+
+func (e *ManifestConflictError) Error() string {
+	return e.description
+}
+
+// Serialize returns the error serialized
+func (e *ManifestConflictError) Serialize() []byte {
+	g, _ := json.Marshal(map[string]interface{}{
+		"code":        "ERR-020",
+		"error":       "ManifestConflictError",
+		"description": e.Error(),
+		"success":     false,
+	})
+
+	return g
+}
+
+// StatusCode implements ErrorWithStatusCode
+func (e *ManifestConflictError) StatusCode() int {
+	return 409
+}
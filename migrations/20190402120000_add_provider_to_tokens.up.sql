@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN provider VARCHAR(100) NOT NULL DEFAULT 'google';
@@ -0,0 +1 @@
+ALTER TABLE role_bindings DROP COLUMN managed;
@@ -0,0 +1 @@
+ALTER TABLE role_bindings ADD COLUMN managed BOOLEAN NOT NULL DEFAULT false;
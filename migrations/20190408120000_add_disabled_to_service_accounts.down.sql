@@ -0,0 +1 @@
+ALTER TABLE service_accounts DROP COLUMN disabled;
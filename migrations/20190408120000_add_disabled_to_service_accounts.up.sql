@@ -0,0 +1 @@
+ALTER TABLE service_accounts ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
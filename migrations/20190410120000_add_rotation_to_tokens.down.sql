@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tokens_family_id;
+ALTER TABLE tokens DROP COLUMN successor_id;
+ALTER TABLE tokens DROP COLUMN family_id;
@@ -0,0 +1,7 @@
+ALTER TABLE tokens ADD COLUMN family_id UUID;
+UPDATE tokens SET family_id = id;
+ALTER TABLE tokens ALTER COLUMN family_id SET NOT NULL;
+ALTER TABLE tokens ALTER COLUMN family_id SET DEFAULT uuid_generate_v4();
+ALTER TABLE tokens ADD COLUMN successor_id UUID REFERENCES tokens (id) ON DELETE SET NULL;
+
+CREATE INDEX tokens_family_id ON tokens (family_id);
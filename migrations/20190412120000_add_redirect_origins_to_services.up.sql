@@ -0,0 +1 @@
+ALTER TABLE services ADD COLUMN redirect_origins TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+ALTER TABLE tokens DROP COLUMN client_id;
+DROP TABLE IF EXISTS oauth2_consents;
+DROP TABLE IF EXISTS oauth2_codes;
+DROP TABLE IF EXISTS oauth2_clients;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS oauth2_clients (
+	id UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+	client_id VARCHAR(100) NOT NULL,
+	client_secret VARCHAR(300) NOT NULL DEFAULT '',
+	service_id UUID NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	FOREIGN KEY(service_id) REFERENCES services (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX oauth2_clients_client_id ON oauth2_clients (client_id);
+
+CREATE TABLE IF NOT EXISTS oauth2_codes (
+	code VARCHAR(100) PRIMARY KEY NOT NULL,
+	client_id VARCHAR(100) NOT NULL,
+	service_account_id UUID NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	code_challenge VARCHAR(200) NOT NULL,
+	code_challenge_method VARCHAR(10) NOT NULL,
+	family_id UUID,
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	used_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	FOREIGN KEY(client_id) REFERENCES oauth2_clients (client_id) ON DELETE CASCADE,
+	FOREIGN KEY(service_account_id) REFERENCES service_accounts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS oauth2_consents (
+	id UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+	client_id VARCHAR(100) NOT NULL,
+	service_account_id UUID NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	FOREIGN KEY(client_id) REFERENCES oauth2_clients (client_id) ON DELETE CASCADE,
+	FOREIGN KEY(service_account_id) REFERENCES service_accounts (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX oauth2_consents_client_id_service_account_id
+	ON oauth2_consents (client_id, service_account_id);
+
+ALTER TABLE tokens ADD COLUMN client_id VARCHAR(100) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE tokens DROP COLUMN IF EXISTS service_account_id;
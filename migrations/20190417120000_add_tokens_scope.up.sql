@@ -0,0 +1,2 @@
+ALTER TABLE tokens ADD COLUMN service_account_id UUID REFERENCES service_accounts (id) ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN scope TEXT[];
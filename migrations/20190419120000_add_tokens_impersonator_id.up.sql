@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN impersonator_id UUID REFERENCES service_accounts (id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS mfa_at;
+DROP TABLE IF EXISTS totp_secrets;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS totp_secrets (
+	service_account_id UUID PRIMARY KEY NOT NULL,
+	secret VARCHAR(100) NOT NULL,
+	confirmed_at TIMESTAMP WITH TIME ZONE,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	FOREIGN KEY(service_account_id) REFERENCES service_accounts (id) ON DELETE CASCADE
+);
+
+ALTER TABLE tokens ADD COLUMN mfa_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE service_accounts ADD COLUMN certificate_subject VARCHAR(400);
+CREATE UNIQUE INDEX service_accounts_certificate_subject ON service_accounts (certificate_subject);
@@ -0,0 +1,4 @@
+ALTER TABLE service_accounts DROP COLUMN profile_synced_at;
+ALTER TABLE service_accounts DROP COLUMN last_login_at;
+ALTER TABLE service_accounts DROP COLUMN hosted_domain;
+ALTER TABLE service_accounts DROP COLUMN display_name;
@@ -0,0 +1,4 @@
+ALTER TABLE service_accounts ADD COLUMN display_name VARCHAR(200);
+ALTER TABLE service_accounts ADD COLUMN hosted_domain VARCHAR(200);
+ALTER TABLE service_accounts ADD COLUMN last_login_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE service_accounts ADD COLUMN profile_synced_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+ALTER TABLE tokens ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE tokens ADD COLUMN user_agent TEXT;
+ALTER TABLE tokens ADD COLUMN ip VARCHAR(64);
@@ -0,0 +1 @@
+ALTER TABLE services ADD COLUMN am_secret TEXT;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS service_actions (
+	service_id UUID NOT NULL,
+	action VARCHAR(200) NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	resource_hierarchy VARCHAR(500) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY(service_id, action),
+	FOREIGN KEY(service_id) REFERENCES services (id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS service_accounts_previous_key_id;
+ALTER TABLE service_accounts DROP COLUMN previous_key_expires_at;
+ALTER TABLE service_accounts DROP COLUMN previous_key_secret;
+ALTER TABLE service_accounts DROP COLUMN previous_key_id;
@@ -0,0 +1,4 @@
+ALTER TABLE service_accounts ADD COLUMN previous_key_id VARCHAR(200);
+ALTER TABLE service_accounts ADD COLUMN previous_key_secret VARCHAR(200);
+ALTER TABLE service_accounts ADD COLUMN previous_key_expires_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS service_accounts_previous_key_id ON service_accounts (previous_key_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS service_owners (
+	service_id UUID NOT NULL,
+	service_account_id UUID NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY(service_id, service_account_id),
+	FOREIGN KEY(service_id) REFERENCES services (id) ON DELETE CASCADE,
+	FOREIGN KEY(service_account_id) REFERENCES service_accounts (id) ON DELETE CASCADE
+);
+
+INSERT INTO service_owners (service_id, service_account_id)
+SELECT s.id, sa.id FROM services s
+JOIN service_accounts sa ON sa.id = s.creator_service_account_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (role_id, service, ownership_level, action, resource_hierarchy)
+SELECT sa.base_role_id, 'Will.IAM', 'RO', 'EditService', s.id::text FROM services s
+JOIN service_accounts sa ON sa.id = s.creator_service_account_id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+	actor_id UUID,
+	impersonator_id UUID,
+	action VARCHAR(100) NOT NULL,
+	target_type VARCHAR(50) NOT NULL,
+	target_id VARCHAR(200) NOT NULL DEFAULT '',
+	before JSONB,
+	after JSONB,
+	request_id VARCHAR(200) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id ON audit_log (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (target_type, target_id, created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
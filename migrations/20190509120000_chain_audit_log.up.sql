@@ -0,0 +1,32 @@
+ALTER TABLE audit_log ADD COLUMN seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN prev_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '';
+
+-- entries logged so far are numbered but stay unchained, with no hash
+ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only;
+UPDATE audit_log SET seq = numbered.seq FROM (
+	SELECT id, row_number() OVER (ORDER BY created_at, id) AS seq FROM audit_log
+) numbered WHERE audit_log.id = numbered.id;
+ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only;
+
+ALTER TABLE audit_log ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS audit_log_seq ON audit_log (seq);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+	id UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+	seq BIGINT NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	signature VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_checkpoints_seq ON audit_checkpoints (seq);
+
+CREATE TRIGGER audit_checkpoints_append_only BEFORE UPDATE OR DELETE ON audit_checkpoints
+FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
@@ -0,0 +1 @@
+ALTER TABLE roles DROP COLUMN service_id;
@@ -0,0 +1 @@
+ALTER TABLE roles ADD COLUMN service_id UUID REFERENCES services (id) ON DELETE SET NULL;
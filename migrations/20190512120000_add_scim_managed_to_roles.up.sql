@@ -0,0 +1 @@
+ALTER TABLE roles ADD COLUMN scim_managed BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+UPDATE service_accounts
+SET certificate_subject = substring(certificate_subject FROM position(':' IN certificate_subject) + 1)
+WHERE certificate_subject IS NOT NULL AND certificate_subject != '';
@@ -0,0 +1,8 @@
+-- subjects were untyped: URIs and emails are told apart by their shape and
+-- the rest, which used to match DNS SANs or common names, become DNS SANs
+UPDATE service_accounts SET certificate_subject = CASE
+  WHEN certificate_subject LIKE '%://%' THEN 'uri:' || certificate_subject
+  WHEN certificate_subject LIKE '%@%' THEN 'email:' || certificate_subject
+  ELSE 'dns:' || certificate_subject
+END
+WHERE certificate_subject IS NOT NULL AND certificate_subject != '';
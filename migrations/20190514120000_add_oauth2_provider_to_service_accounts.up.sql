@@ -0,0 +1,7 @@
+ALTER TABLE service_accounts ADD COLUMN oauth2_provider VARCHAR(100);
+-- accounts belong to the provider they last logged in through; the others
+-- are bound on their next login
+UPDATE service_accounts SET oauth2_provider = latest.provider FROM (
+  SELECT DISTINCT ON (email) email, provider FROM tokens
+  WHERE provider != 'Will.IAM' ORDER BY email, created_at DESC
+) latest WHERE service_accounts.email = latest.email;
@@ -1,10 +1,12 @@
 package models
 
-// AM represents an item from /am []
+// AM represents an item from /am []. Unavailable flags a service whose AM
+// endpoint couldn't be reached, so its suggestions are missing
 type AM struct {
-	Prefix   string `json:"prefix"`
-	Alias    string `json:"alias"`
-	Owner    bool   `json:"owner"`
-	Lender   bool   `json:"lender"`
-	Complete bool   `json:"complete"`
+	Prefix      string `json:"prefix"`
+	Alias       string `json:"alias"`
+	Owner       bool   `json:"owner"`
+	Lender      bool   `json:"lender"`
+	Complete    bool   `json:"complete"`
+	Unavailable bool   `json:"unavailable"`
 }
@@ -0,0 +1,64 @@
+// +build unit
+
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestAuditEntryComputeHash(t *testing.T) {
+	e := models.AuditEntry{
+		ID:         "9c5e3f64-5f5a-4bd0-9a57-1c1f8e8d2a10",
+		Seq:        2,
+		Action:     "roles.update",
+		TargetType: "role",
+		TargetID:   "some role",
+		Before:     json.RawMessage(`{"name": "a", "permissions": []}`),
+		After:      json.RawMessage(`{"permissions":[],"name":"b"}`),
+		PrevHash:   "prev",
+		CreatedAt:  time.Date(2019, 5, 9, 12, 0, 0, 1000, time.UTC),
+	}
+	hash, err := e.ComputeHash()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// jsonb reads back reformatted and timestamps in the session's zone
+	read := e
+	read.Before = json.RawMessage(`{"name":"a","permissions":[]}`)
+	read.After = json.RawMessage(`{"name": "b", "permissions": []}`)
+	read.CreatedAt = e.CreatedAt.In(time.FixedZone("BRT", -3*60*60))
+	if got, _ := read.ComputeHash(); got != hash {
+		t.Errorf("Expected hash to survive reading back. Got %s, want %s", got, hash)
+	}
+	for name, change := range map[string]func(*models.AuditEntry){
+		"prevHash": func(e *models.AuditEntry) { e.PrevHash = "other" },
+		"seq":      func(e *models.AuditEntry) { e.Seq = 3 },
+		"after":    func(e *models.AuditEntry) { e.After = json.RawMessage(`{"name":"c"}`) },
+		"actorId":  func(e *models.AuditEntry) { e.ActorID = "someone" },
+	} {
+		changed := e
+		change(&changed)
+		if got, _ := changed.ComputeHash(); got == hash {
+			t.Errorf("Expected changing %s to change the hash", name)
+		}
+	}
+}
+
+func TestAuditCheckpointVerify(t *testing.T) {
+	c := models.AuditCheckpoint{Seq: 10, Hash: "hash", CreatedAt: time.Now()}
+	c.Signature = c.Sign([]byte("secret"))
+	if !c.Verify([]byte("secret")) {
+		t.Errorf("Expected checkpoint to verify")
+	}
+	if c.Verify([]byte("other secret")) {
+		t.Errorf("Expected checkpoint not to verify with another secret")
+	}
+	c.Seq = 11
+	if c.Verify([]byte("secret")) {
+		t.Errorf("Expected changed checkpoint not to verify")
+	}
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// CertificateSubjectTypes are the prefixes certificate subjects are typed
+// with, so e.g. a DNS SAN never matches a subject meant as a common name
+var CertificateSubjectTypes = struct {
+	URI   string
+	DNS   string
+	Email string
+	CN    string
+}{
+	URI:   "uri:",
+	DNS:   "dns:",
+	Email: "email:",
+	CN:    "cn:",
+}
+
+// IsCertificateSubject checks if subject is typed by one of
+// CertificateSubjectTypes and has a value, e.g. dns:billing.internal
+func IsCertificateSubject(subject string) bool {
+	for _, prefix := range []string{
+		CertificateSubjectTypes.URI, CertificateSubjectTypes.DNS,
+		CertificateSubjectTypes.Email, CertificateSubjectTypes.CN,
+	} {
+		if strings.HasPrefix(subject, prefix) && len(subject) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CertificateSubjects returns the typed identities a client certificate
+// may be mapped to a service account by, most specific first: URI SANs
+// (e.g. SPIFFE ids), DNS SANs and email SANs. The subject's common name is
+// only used by certificates without SANs, as in RFC 6125
+func CertificateSubjects(cert *x509.Certificate) []string {
+	subjects := []string{}
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, CertificateSubjectTypes.URI+uri.String())
+	}
+	for _, name := range cert.DNSNames {
+		subjects = append(subjects, CertificateSubjectTypes.DNS+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		subjects = append(subjects, CertificateSubjectTypes.Email+email)
+	}
+	hasSANs := len(subjects) > 0 || len(cert.IPAddresses) > 0
+	if !hasSANs && cert.Subject.CommonName != "" {
+		subjects = append(
+			subjects, CertificateSubjectTypes.CN+cert.Subject.CommonName,
+		)
+	}
+	return subjects
+}
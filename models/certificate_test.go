@@ -0,0 +1,92 @@
+// +build unit
+
+package models_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestCertificateSubjects(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing"},
+		URIs:           []*url.URL{spiffe},
+		DNSNames:       []string{"billing.internal"},
+		EmailAddresses: []string{"billing@example.org"},
+	}
+	// the common name is ignored: the certificate has SANs
+	expected := []string{
+		"uri:spiffe://example.org/billing", "dns:billing.internal",
+		"email:billing@example.org",
+	}
+	if subjects := models.CertificateSubjects(cert); !reflect.DeepEqual(
+		subjects, expected,
+	) {
+		t.Errorf("Expected %v. Got %v", expected, subjects)
+	}
+}
+
+func TestCertificateSubjectsWithoutSANs(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing.internal"}}
+	expected := []string{"cn:billing.internal"}
+	if subjects := models.CertificateSubjects(cert); !reflect.DeepEqual(
+		subjects, expected,
+	) {
+		t.Errorf("Expected %v. Got %v", expected, subjects)
+	}
+	cert.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+	if subjects := models.CertificateSubjects(cert); len(subjects) != 0 {
+		t.Errorf("Expected common name to be ignored with IP SANs. Got %v", subjects)
+	}
+}
+
+func TestIsCertificateSubject(t *testing.T) {
+	tt := map[string]bool{
+		"dns:billing.internal":             true,
+		"uri:spiffe://example.org/billing": true,
+		"email:billing@example.org":        true,
+		"cn:billing":                       true,
+		"billing.internal":                 false,
+		"dns:":                             false,
+		"ip:10.0.0.1":                      false,
+	}
+	for subject, expected := range tt {
+		if got := models.IsCertificateSubject(subject); got != expected {
+			t.Errorf("Expected IsCertificateSubject(%s) to be %t. Got %t", subject, expected, got)
+		}
+	}
+}
+
+func TestServiceAccountInferAuthenticationType(t *testing.T) {
+	type testCase struct {
+		sa       *models.ServiceAccount
+		expected models.AuthenticationType
+	}
+	tt := []testCase{
+		{models.BuildKeyPairServiceAccount("kp"), models.AuthenticationTypes.KeyPair},
+		{
+			models.BuildOAuth2ServiceAccount("o", "o@example.org"),
+			models.AuthenticationTypes.OAuth2,
+		},
+		{
+			models.BuildCertificateServiceAccount("c", "dns:billing.internal"),
+			models.AuthenticationTypes.Certificate,
+		},
+	}
+	for _, tc := range tt {
+		tc.sa.InferAuthenticationType()
+		if tc.sa.AuthenticationType != tc.expected {
+			t.Errorf(
+				"Expected %s for %s. Got %s",
+				tc.expected, tc.sa.Name, tc.sa.AuthenticationType,
+			)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Reasons a Decision denies a permission
+const (
+	DecisionReasonNoGrant     = "noGrant"
+	DecisionReasonTokenScope  = "tokenScope"
+	DecisionReasonMFARequired = "mfaRequired"
+)
+
+// Decision is the outcome of checking if ServiceAccountID has Permission.
+// MatchedGrant is the permission of theirs that grants it, if any; Route
+// names the endpoint the check guarded, if it guarded one
+type Decision struct {
+	Time             time.Time `json:"time"`
+	ServiceAccountID string    `json:"serviceAccountId"`
+	ImpersonatorID   string    `json:"impersonatorId,omitempty"`
+	RequestID        string    `json:"requestId,omitempty"`
+	Route            string    `json:"route,omitempty"`
+	Permission       string    `json:"permission"`
+	Allowed          bool      `json:"allowed"`
+	MatchedGrant     string    `json:"matchedGrant,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// OAuth2Client is an application registered under a Service that gets
+// Will.IAM tokens through the authorization code flow. Clients without
+// ClientSecret are public (e.g. single page apps) and rely on PKCE only
+type OAuth2Client struct {
+	ID           string   `json:"id" pg:"id"`
+	ClientID     string   `json:"clientId" pg:"client_id"`
+	ClientSecret string   `json:"clientSecret,omitempty" pg:"client_secret"`
+	ServiceID    string   `json:"serviceId" pg:"service_id"`
+	Name         string   `json:"name" pg:"name"`
+	RedirectURIs []string `json:"redirectUris" sql:"redirect_uris,array"`
+	Confidential bool     `json:"confidential" sql:"-"`
+	CreatedUpdatedAt
+}
+
+// Validate OAuth2Client
+func (c OAuth2Client) Validate() Validation {
+	v := &Validation{}
+	if c.Name == "" {
+		v.AddError("name", "required")
+	}
+	if len(c.RedirectURIs) == 0 {
+		v.AddError("redirectUris", "required")
+	}
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || OriginOf(uri) == "" || u.Fragment != "" {
+			v.AddError(
+				"redirectUris", "must be absolute http(s) without fragment, got "+uri,
+			)
+		}
+	}
+	return *v
+}
+
+// AllowsRedirectURI checks if uri is exactly one of c's redirect uris
+func (c OAuth2Client) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if uri == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate checks secret for confidential clients; public clients have
+// no secret to check
+func (c OAuth2Client) Authenticate(secret string) bool {
+	if c.ClientSecret == "" {
+		return secret == ""
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(secret), []byte(c.ClientSecret),
+	) == 1
+}
+
+// BuildOAuth2Client generates random ClientID and, for confidential
+// clients, ClientSecret
+func BuildOAuth2Client(c *OAuth2Client) error {
+	var err error
+	if c.ClientID, err = RandomToken(16); err != nil {
+		return err
+	}
+	c.ClientSecret = ""
+	if c.Confidential {
+		if c.ClientSecret, err = RandomToken(32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OAuth2AuthorizationCode is issued by /oauth2/authorize and traded once
+// for tokens at /oauth2/token. FamilyID is the family of the tokens it
+// was traded for, so they can be revoked if the code is replayed
+type OAuth2AuthorizationCode struct {
+	Code                string      `pg:"code"`
+	ClientID            string      `pg:"client_id"`
+	ServiceAccountID    string      `pg:"service_account_id"`
+	RedirectURI         string      `pg:"redirect_uri"`
+	CodeChallenge       string      `pg:"code_challenge"`
+	CodeChallengeMethod string      `pg:"code_challenge_method"`
+	FamilyID            string      `pg:"family_id"`
+	ExpiresAt           time.Time   `pg:"expires_at"`
+	UsedAt              pg.NullTime `pg:"used_at"`
+}
+
+// VerifyCodeVerifier checks verifier against the PKCE challenge. Only
+// S256 is supported
+func (ac OAuth2AuthorizationCode) VerifyCodeVerifier(verifier string) bool {
+	if ac.CodeChallengeMethod != "S256" || len(verifier) < 43 ||
+		len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare(
+		[]byte(challenge), []byte(ac.CodeChallenge),
+	) == 1
+}
+
+// RandomToken returns n random bytes base64url encoded
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
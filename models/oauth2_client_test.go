@@ -0,0 +1,84 @@
+// +build unit
+
+package models_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestOAuth2ClientValidate(t *testing.T) {
+	type testCase struct {
+		client models.OAuth2Client
+		valid  bool
+	}
+	tt := []testCase{
+		testCase{client: models.OAuth2Client{
+			Name: "app", RedirectURIs: []string{"https://app.com/cb"},
+		}, valid: true},
+		testCase{client: models.OAuth2Client{
+			RedirectURIs: []string{"https://app.com/cb"},
+		}, valid: false},
+		testCase{client: models.OAuth2Client{Name: "app"}, valid: false},
+		testCase{client: models.OAuth2Client{
+			Name: "app", RedirectURIs: []string{"/cb"},
+		}, valid: false},
+		testCase{client: models.OAuth2Client{
+			Name: "app", RedirectURIs: []string{"https://app.com/cb#x"},
+		}, valid: false},
+	}
+	for _, tt := range tt {
+		if v := tt.client.Validate(); v.Valid() != tt.valid {
+			t.Errorf("Expected %v valid to be %v", tt.client.RedirectURIs, tt.valid)
+		}
+	}
+}
+
+func TestOAuth2ClientAuthenticate(t *testing.T) {
+	public := models.OAuth2Client{Name: "spa"}
+	if err := models.BuildOAuth2Client(&public); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if public.ClientID == "" || public.ClientSecret != "" {
+		t.Errorf("Expected public client with id and without secret")
+	}
+	if !public.Authenticate("") || public.Authenticate("any") {
+		t.Errorf("Expected public client to authenticate only without secret")
+	}
+	confidential := models.OAuth2Client{Name: "backend", Confidential: true}
+	if err := models.BuildOAuth2Client(&confidential); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !confidential.Authenticate(confidential.ClientSecret) {
+		t.Errorf("Expected confidential client to authenticate with its secret")
+	}
+	if confidential.Authenticate("") || confidential.Authenticate("other") {
+		t.Errorf("Expected confidential client to reject other secrets")
+	}
+}
+
+func TestOAuth2AuthorizationCodeVerifyCodeVerifier(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	ac := models.OAuth2AuthorizationCode{
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+	if !ac.VerifyCodeVerifier(verifier) {
+		t.Errorf("Expected verifier to match challenge")
+	}
+	if ac.VerifyCodeVerifier(strings.Repeat("w", 43)) {
+		t.Errorf("Expected other verifier not to match")
+	}
+	if ac.VerifyCodeVerifier("v") {
+		t.Errorf("Expected short verifier to be rejected")
+	}
+	ac.CodeChallengeMethod = "plain"
+	if ac.VerifyCodeVerifier(verifier) {
+		t.Errorf("Expected plain method to be rejected")
+	}
+}
@@ -168,6 +168,12 @@ func BuildPermissions(ps []string) ([]Permission, error) {
 
 // IsPresent checks if a permission is satisfied in a slice
 func (p Permission) IsPresent(permissions []Permission) bool {
+	_, ok := p.MatchingGrant(permissions)
+	return ok
+}
+
+// MatchingGrant returns the first of permissions that grants p
+func (p Permission) MatchingGrant(permissions []Permission) (Permission, bool) {
 	for _, pp := range permissions {
 		if (pp.Service != "*" && pp.Service != p.Service) ||
 			(pp.Action != "*" && pp.Action != p.Action) ||
@@ -175,10 +181,10 @@ func (p Permission) IsPresent(permissions []Permission) bool {
 			continue
 		}
 		if pp.ResourceHierarchy.Contains(p.ResourceHierarchy) {
-			return true
+			return pp, true
 		}
 	}
-	return false
+	return Permission{}, false
 }
 
 // String converts a permission to it's equivalent string format
@@ -205,6 +211,12 @@ func (p Permission) HasServiceFullOwnership() bool {
 	return p.HasServiceFullAccess() && p.OwnershipLevel == OwnershipLevels.Owner
 }
 
+// HasFullResourceOwnership checks if permission is RO over any resource
+// hierarchy, so its holder may grant any of it
+func (p Permission) HasFullResourceOwnership() bool {
+	return p.OwnershipLevel == OwnershipLevels.Owner && p.ResourceHierarchy.All()
+}
+
 // buildWillIAMPermission builds a permission in the format expected
 // by WillIAM handlers
 func buildWillIAMPermission(ro OwnershipLevel, action, rh string) string {
@@ -140,3 +140,35 @@ func TestHasPermission(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchingGrant(t *testing.T) {
+	permissions := buildPermissions([]string{
+		"Maestro::RL::ListSchedulers::Sniper3D::*",
+		"Maestro::RO::*::*",
+	})
+	permission, _ := models.BuildPermission(
+		"Maestro::RO::ListSchedulers::Sniper3D::sniper3d-game",
+	)
+	grant, ok := permission.MatchingGrant(permissions)
+	if !ok || grant.String() != "Maestro::RO::*::*" {
+		t.Errorf("Expected Maestro::RO::*::* to grant it. Got %s", grant.String())
+	}
+	permission, _ = models.BuildPermission("Metagame::RL::ListPlayers::*")
+	if _, ok := permission.MatchingGrant(permissions); ok {
+		t.Errorf("Expected no grant of another service")
+	}
+}
+
+func TestHasFullResourceOwnership(t *testing.T) {
+	for str, expected := range map[string]bool{
+		"*::RO::*::*":                        true,
+		"Maestro::RO::ListSchedulers::*":     true,
+		"Maestro::RL::*::*":                  false,
+		"Maestro::RO::ListSchedulers::NA::*": false,
+	} {
+		p, _ := models.BuildPermission(str)
+		if p.HasFullResourceOwnership() != expected {
+			t.Errorf("%s: expected %t", str, expected)
+		}
+	}
+}
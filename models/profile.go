@@ -0,0 +1,13 @@
+package models
+
+// Profile is what an identity provider tells about an OAuth2 account
+type Profile struct {
+	Name         string
+	Picture      string
+	HostedDomain string
+}
+
+// IsZero is true if the provider told nothing
+func (p Profile) IsZero() bool {
+	return p == Profile{}
+}
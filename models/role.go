@@ -1,18 +1,42 @@
 package models
 
 // Role type
+// ServiceID is set on roles a service's manifest declares; only that
+// service's manifests change them. SCIMManaged roles are the only ones
+// SCIM exposes as Groups: those it created and those marked by an admin
 type Role struct {
-	ID         string `json:"id" pg:"id"`
-	Name       string `json:"name" pg:"name"`
-	IsBaseRole bool   `json:"isBaseRole" pg:"is_base_role" sql:",notnull"`
+	ID          string `json:"id" pg:"id"`
+	Name        string `json:"name" pg:"name"`
+	IsBaseRole  bool   `json:"isBaseRole" pg:"is_base_role" sql:",notnull"`
+	ServiceID   string `json:"serviceId,omitempty" pg:"service_id"`
+	SCIMManaged bool   `json:"scimManaged" pg:"scim_managed" sql:",notnull"`
 	// Should change updatedAt when a permission is created for role
 	CreatedUpdatedAt
 }
 
 // RoleBinding type
+// Managed bindings are granted by an identity provider group mapping and
+// are only created or removed by syncing the service account's groups
 type RoleBinding struct {
 	ID               string `json:"id" pg:"id"`
 	ServiceAccountID string `json:"serviceAccountId" pg:"service_account_id"`
 	RoleID           string `json:"roleId" pg:"role_id"`
+	Managed          bool   `json:"managed" pg:"managed" sql:",notnull"`
 	CreatedUpdatedAt
 }
+
+// GroupRoleMapping binds members of an identity provider group to a role.
+// An empty Provider matches groups from any provider
+type GroupRoleMapping struct {
+	Provider string `json:"provider" mapstructure:"provider"`
+	Group    string `json:"group" mapstructure:"group"`
+	Role     string `json:"role" mapstructure:"role"`
+}
+
+// Matches checks if mapping applies to group coming from provider
+func (m GroupRoleMapping) Matches(provider, group string) bool {
+	if m.Provider != "" && m.Provider != provider {
+		return false
+	}
+	return m.Group == group
+}
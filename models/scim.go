@@ -0,0 +1,354 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SCIMSchemas are the schema URNs used by SCIM 2.0 resources and messages
+var SCIMSchemas = struct {
+	User         string
+	Group        string
+	ListResponse string
+	PatchOp      string
+	Error        string
+}{
+	User:         "urn:ietf:params:scim:schemas:core:2.0:User",
+	Group:        "urn:ietf:params:scim:schemas:core:2.0:Group",
+	ListResponse: "urn:ietf:params:scim:api:messages:2.0:ListResponse",
+	PatchOp:      "urn:ietf:params:scim:api:messages:2.0:PatchOp",
+	Error:        "urn:ietf:params:scim:api:messages:2.0:Error",
+}
+
+// SCIMMeta is the meta attribute of every SCIM resource
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// SCIMName is the name attribute of a SCIM User
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMMultiValued is used for emails, photos and members
+type SCIMMultiValued struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMUser maps to an OAuth2 ServiceAccount; UserName is its email
+type SCIMUser struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id,omitempty"`
+	ExternalID  string            `json:"externalId,omitempty"`
+	UserName    string            `json:"userName"`
+	Name        *SCIMName         `json:"name,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Active      *bool             `json:"active,omitempty"`
+	Emails      []SCIMMultiValued `json:"emails,omitempty"`
+	Photos      []SCIMMultiValued `json:"photos,omitempty"`
+	Meta        *SCIMMeta         `json:"meta,omitempty"`
+}
+
+// IsActive returns false only if active was explicitly set to false
+func (u SCIMUser) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// ServiceAccountName picks the best name available for the service account
+func (u SCIMUser) ServiceAccountName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != nil && u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	return u.UserName
+}
+
+// Validate SCIMUser
+func (u SCIMUser) Validate() Validation {
+	v := &Validation{}
+	if u.UserName == "" {
+		v.AddError("userName", "required")
+	}
+	return *v
+}
+
+// BuildSCIMUser builds a SCIMUser from a ServiceAccount
+func BuildSCIMUser(sa *ServiceAccount) SCIMUser {
+	active := !sa.Disabled
+	u := SCIMUser{
+		Schemas:     []string{SCIMSchemas.User},
+		ID:          sa.ID,
+		UserName:    sa.Email,
+		DisplayName: sa.Name,
+		Name:        &SCIMName{Formatted: sa.Name},
+		Active:      &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      sa.CreatedAt,
+			LastModified: sa.UpdatedAt,
+		},
+	}
+	if sa.Email != "" {
+		u.Emails = []SCIMMultiValued{{Value: sa.Email, Primary: true}}
+	}
+	if sa.Picture != "" {
+		u.Photos = []SCIMMultiValued{{Value: sa.Picture}}
+	}
+	return u
+}
+
+// SCIMGroup maps to a Role; members are its bound service accounts
+type SCIMGroup struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id,omitempty"`
+	ExternalID  string            `json:"externalId,omitempty"`
+	DisplayName string            `json:"displayName"`
+	Members     []SCIMMultiValued `json:"members"`
+	Meta        *SCIMMeta         `json:"meta,omitempty"`
+}
+
+// Validate SCIMGroup
+func (g SCIMGroup) Validate() Validation {
+	v := &Validation{}
+	if g.DisplayName == "" {
+		v.AddError("displayName", "required")
+	}
+	return *v
+}
+
+// MembersIDs returns the service accounts ids of all members
+func (g SCIMGroup) MembersIDs() []string {
+	ids := make([]string, len(g.Members))
+	for i := range g.Members {
+		ids[i] = g.Members[i].Value
+	}
+	return ids
+}
+
+// BuildSCIMGroup builds a SCIMGroup from a Role and its service accounts
+func BuildSCIMGroup(r *Role, sas []ServiceAccount) SCIMGroup {
+	members := make([]SCIMMultiValued, len(sas))
+	for i := range sas {
+		members[i] = SCIMMultiValued{Value: sas[i].ID, Display: sas[i].Name}
+	}
+	return SCIMGroup{
+		Schemas:     []string{SCIMSchemas.Group},
+		ID:          r.ID,
+		DisplayName: r.Name,
+		Members:     members,
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      r.CreatedAt,
+			LastModified: r.UpdatedAt,
+		},
+	}
+}
+
+// SCIMListResponse wraps SCIM list results
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchOperation is a single operation of a SCIM PATCH request
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// SCIMPatchOp is the body of a SCIM PATCH request
+type SCIMPatchOp struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMFilter is a parsed `attribute eq "value"` filter, the only kind of
+// filter identity providers need for provisioning
+type SCIMFilter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseSCIMFilter parses str; an empty str yields a nil filter
+func ParseSCIMFilter(str string) (*SCIMFilter, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(str, " ", 3)
+	if len(parts) != 3 || strings.ToLower(parts[1]) != "eq" {
+		return nil, fmt.Errorf("unsupported filter: %s", str)
+	}
+	value := strings.TrimSpace(parts[2])
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) ||
+		!strings.HasSuffix(value, `"`) {
+		return nil, fmt.Errorf("filter value must be quoted: %s", str)
+	}
+	return &SCIMFilter{
+		Attribute: parts[0],
+		Value:     value[1 : len(value)-1],
+	}, nil
+}
+
+// ApplyToUser applies operations to u. Attributes Will.IAM doesn't store
+// are ignored
+func (p SCIMPatchOp) ApplyToUser(u *SCIMUser) error {
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			return fmt.Errorf("unsupported op %s for User", op.Op)
+		}
+		values := map[string]interface{}{}
+		if op.Path == "" {
+			m, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("value must be an object when path is empty")
+			}
+			values = m
+		} else {
+			values[op.Path] = op.Value
+		}
+		for path, value := range values {
+			if err := applyToUserAttribute(u, path, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyToUserAttribute(u *SCIMUser, path string, value interface{}) error {
+	switch path {
+	case "active":
+		active, ok := value.(bool)
+		if !ok {
+			str, isStr := value.(string)
+			if !isStr || (str != "true" && str != "false") {
+				return fmt.Errorf("active must be a boolean")
+			}
+			active = str == "true"
+		}
+		u.Active = &active
+	case "displayName":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("displayName must be a string")
+		}
+		u.DisplayName = str
+	case "name.formatted":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("name.formatted must be a string")
+		}
+		u.Name = &SCIMName{Formatted: str}
+	}
+	return nil
+}
+
+// ApplyToGroup applies operations to g
+func (p SCIMPatchOp) ApplyToGroup(g *SCIMGroup) error {
+	for _, op := range p.Operations {
+		path := op.Path
+		switch {
+		case path == "displayName":
+			str, ok := op.Value.(string)
+			if !ok {
+				return fmt.Errorf("displayName must be a string")
+			}
+			g.DisplayName = str
+		case path == "members":
+			members, err := scimMembersFromValue(op.Value)
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(op.Op) {
+			case "add":
+				g.Members = append(g.Members, members...)
+			case "replace":
+				g.Members = members
+			case "remove":
+				if op.Value == nil {
+					g.Members = []SCIMMultiValued{}
+				} else {
+					g.Members = removeSCIMMembers(g.Members, members)
+				}
+			default:
+				return fmt.Errorf("unsupported op %s for Group", op.Op)
+			}
+		case strings.HasPrefix(path, "members[value eq "):
+			if strings.ToLower(op.Op) != "remove" {
+				return fmt.Errorf("unsupported op %s for path %s", op.Op, path)
+			}
+			id := strings.TrimSuffix(
+				strings.TrimPrefix(path, "members[value eq "), "]",
+			)
+			id = strings.Trim(id, `"`)
+			g.Members = removeSCIMMembers(
+				g.Members, []SCIMMultiValued{{Value: id}},
+			)
+		case path == "":
+			m, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("value must be an object when path is empty")
+			}
+			if name, ok := m["displayName"].(string); ok {
+				g.DisplayName = name
+			}
+		default:
+			return fmt.Errorf("unsupported path %s for Group", path)
+		}
+	}
+	return nil
+}
+
+func scimMembersFromValue(value interface{}) ([]SCIMMultiValued, error) {
+	if value == nil {
+		return []SCIMMultiValued{}, nil
+	}
+	sl, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("members must be an array")
+	}
+	members := make([]SCIMMultiValued, len(sl))
+	for i := range sl {
+		m, ok := sl[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("members must be objects")
+		}
+		v, ok := m["value"].(string)
+		if !ok {
+			return nil, fmt.Errorf("members[].value must be a string")
+		}
+		members[i] = SCIMMultiValued{Value: v}
+	}
+	return members, nil
+}
+
+func removeSCIMMembers(
+	members, remove []SCIMMultiValued,
+) []SCIMMultiValued {
+	drop := map[string]bool{}
+	for i := range remove {
+		drop[remove[i].Value] = true
+	}
+	kept := []SCIMMultiValued{}
+	for i := range members {
+		if !drop[members[i].Value] {
+			kept = append(kept, members[i])
+		}
+	}
+	return kept
+}
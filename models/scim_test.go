@@ -0,0 +1,113 @@
+// +build unit
+
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	type testCase struct {
+		str      string
+		expected *models.SCIMFilter
+		err      bool
+	}
+	tt := []testCase{
+		testCase{str: "", expected: nil},
+		testCase{
+			str:      `userName eq "some@email.com"`,
+			expected: &models.SCIMFilter{Attribute: "userName", Value: "some@email.com"},
+		},
+		testCase{
+			str:      `displayName EQ "some group"`,
+			expected: &models.SCIMFilter{Attribute: "displayName", Value: "some group"},
+		},
+		testCase{str: `userName sw "some"`, err: true},
+		testCase{str: `userName eq some`, err: true},
+		testCase{str: `userName`, err: true},
+	}
+	for _, tc := range tt {
+		f, err := models.ParseSCIMFilter(tc.str)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Expected error for %s", tc.str)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(f, tc.expected) {
+			t.Errorf("Expected %#v. Got %#v", tc.expected, f)
+		}
+	}
+}
+
+func TestSCIMPatchOpApplyToUser(t *testing.T) {
+	u := models.BuildSCIMUser(&models.ServiceAccount{
+		ID: "some-id", Name: "some name", Email: "some@email.com",
+	})
+	p := models.SCIMPatchOp{
+		Operations: []models.SCIMPatchOperation{
+			models.SCIMPatchOperation{Op: "replace", Path: "active", Value: false},
+			models.SCIMPatchOperation{
+				Op:    "Replace",
+				Value: map[string]interface{}{"displayName": "other name"},
+			},
+		},
+	}
+	if err := p.ApplyToUser(&u); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if u.IsActive() {
+		t.Errorf("Expected user to be inactive")
+	}
+	if u.ServiceAccountName() != "other name" {
+		t.Errorf("Expected name to be other name. Got %s", u.ServiceAccountName())
+	}
+	p = models.SCIMPatchOp{
+		Operations: []models.SCIMPatchOperation{
+			models.SCIMPatchOperation{Op: "remove", Path: "active"},
+		},
+	}
+	if err := p.ApplyToUser(&u); err == nil {
+		t.Errorf("Expected error for remove op")
+	}
+}
+
+func TestSCIMPatchOpApplyToGroup(t *testing.T) {
+	g := models.BuildSCIMGroup(
+		&models.Role{ID: "role-id", Name: "some role"},
+		[]models.ServiceAccount{
+			models.ServiceAccount{ID: "sa-1"}, models.ServiceAccount{ID: "sa-2"},
+		},
+	)
+	p := models.SCIMPatchOp{
+		Operations: []models.SCIMPatchOperation{
+			models.SCIMPatchOperation{
+				Op:    "add",
+				Path:  "members",
+				Value: []interface{}{map[string]interface{}{"value": "sa-3"}},
+			},
+			models.SCIMPatchOperation{
+				Op: "remove", Path: `members[value eq "sa-1"]`,
+			},
+			models.SCIMPatchOperation{
+				Op: "replace", Path: "displayName", Value: "other role",
+			},
+		},
+	}
+	if err := p.ApplyToGroup(&g); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(g.MembersIDs(), []string{"sa-2", "sa-3"}) {
+		t.Errorf("Expected members sa-2 and sa-3. Got %v", g.MembersIDs())
+	}
+	if g.DisplayName != "other role" {
+		t.Errorf("Expected displayName other role. Got %s", g.DisplayName)
+	}
+}
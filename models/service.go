@@ -1,13 +1,21 @@
 package models
 
-// Service type
+import (
+	"net/url"
+	"strings"
+)
+
+// Service type. AMSecret signs Will.IAM's calls to AMURL, see amsign; it's
+// never serialized
 type Service struct {
-	ID                      string `json:"id" pg:"id"`
-	Name                    string `json:"name" pg:"name"`
-	PermissionName          string `json:"permissionName" pg:"permission_name"`
-	ServiceAccountID        string `json:"serviceAccountID" pg:"service_account_id"`
-	CreatorServiceAccountID string `json:"creatorServiceAccountID" pg:"creator_service_account_id"`
-	AMURL                   string `json:"amUrl" sql:"am_url"`
+	ID                      string   `json:"id" pg:"id"`
+	Name                    string   `json:"name" pg:"name"`
+	PermissionName          string   `json:"permissionName" pg:"permission_name"`
+	ServiceAccountID        string   `json:"serviceAccountID" pg:"service_account_id"`
+	CreatorServiceAccountID string   `json:"creatorServiceAccountID" pg:"creator_service_account_id"`
+	AMURL                   string   `json:"amUrl" sql:"am_url"`
+	RedirectOrigins         []string `json:"redirectOrigins" sql:"redirect_origins,array"`
+	AMSecret                string   `json:"-" sql:"am_secret"`
 	CreatedUpdatedAt
 }
 
@@ -20,5 +28,53 @@ func (s Service) Validate(fields ...string) Validation {
 	if s.PermissionName == "" {
 		v.AddError("permissionName", "required")
 	}
+	for _, origin := range s.RedirectOrigins {
+		if !IsOrigin(origin) {
+			v.AddError(
+				"redirectOrigins", "must be scheme://host[:port], got "+origin,
+			)
+		}
+	}
 	return *v
 }
+
+// IsOrigin checks if str is exactly an http(s) origin, without path
+func IsOrigin(str string) bool {
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// OriginOf returns the origin of rawurl, or "" if it isn't an absolute
+// http(s) URL
+func OriginOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" || u.User != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// IsLocalPath checks if rawurl is a path in the current host, e.g.
+// /some/path?a=b, that browsers can't take as another host: it has no
+// scheme or host, doesn't start with "//" and has no backslashes or control
+// characters, which browsers strip or read as "/"
+func IsLocalPath(rawurl string) bool {
+	if !strings.HasPrefix(rawurl, "/") || strings.HasPrefix(rawurl, "//") {
+		return false
+	}
+	for _, r := range rawurl {
+		if r == '\\' || r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == "" && u.User == nil && u.Opaque == ""
+}
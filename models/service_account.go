@@ -1,17 +1,33 @@
 package models
 
-import "github.com/gofrs/uuid"
+import (
+	"github.com/go-pg/pg"
+	"github.com/gofrs/uuid"
+)
 
-// ServiceAccount type
+// ServiceAccount type. DisplayName, Picture and HostedDomain of OAuth2
+// service accounts are synced from their identity provider, OAuth2Provider,
+// the only one they log in through. After a key rotation the previous key
+// pair keeps working until PreviousKeyExpiresAt
 type ServiceAccount struct {
-	ID                 string             `json:"id" pg:"id"`
-	Name               string             `json:"name" pg:"name"`
-	KeyID              string             `json:"keyId" pg:"key_id"`
-	KeySecret          string             `json:"keySecret" pg:"key_secret"`
-	Email              string             `json:"email" pg:"email"`
-	Picture            string             `json:"picture" pg:"picture"`
-	BaseRoleID         string             `json:"baseRoleId" pg:"base_role_id"`
-	AuthenticationType AuthenticationType `json:"authenticationType" pg:"-"`
+	ID                   string             `json:"id" pg:"id"`
+	Name                 string             `json:"name" pg:"name"`
+	KeyID                string             `json:"keyId" pg:"key_id"`
+	KeySecret            string             `json:"keySecret" pg:"key_secret"`
+	Email                string             `json:"email" pg:"email"`
+	Picture              string             `json:"picture" pg:"picture"`
+	DisplayName          string             `json:"displayName" pg:"display_name"`
+	HostedDomain         string             `json:"hostedDomain" pg:"hosted_domain"`
+	PreviousKeyID        string             `json:"-" pg:"previous_key_id"`
+	PreviousKeySecret    string             `json:"-" pg:"previous_key_secret"`
+	PreviousKeyExpiresAt pg.NullTime        `json:"-" pg:"previous_key_expires_at"`
+	BaseRoleID           string             `json:"baseRoleId" pg:"base_role_id"`
+	CertificateSubject   string             `json:"certificateSubject" pg:"certificate_subject"`
+	OAuth2Provider       string             `json:"oauth2Provider" pg:"oauth2_provider"`
+	Disabled             bool               `json:"disabled" pg:"disabled" sql:",notnull"`
+	AuthenticationType   AuthenticationType `json:"authenticationType" pg:"-"`
+	LastLoginAt          pg.NullTime        `json:"lastLoginAt" pg:"last_login_at"`
+	ProfileSyncedAt      pg.NullTime        `json:"-" pg:"profile_synced_at"`
 	CreatedUpdatedAt
 }
 
@@ -20,21 +36,37 @@ type AuthenticationType string
 
 // AuthenticationTypes are the supported authentication types
 var AuthenticationTypes = struct {
-	OAuth2  AuthenticationType
-	KeyPair AuthenticationType
+	OAuth2      AuthenticationType
+	KeyPair     AuthenticationType
+	Certificate AuthenticationType
 }{
-	OAuth2:  "oauth2",
-	KeyPair: "keypair",
+	OAuth2:      "oauth2",
+	KeyPair:     "keypair",
+	Certificate: "certificate",
 }
 
 // Valid checks if at is a possible value
 func (at AuthenticationType) Valid() bool {
-	if string(at) == "oauth2" || string(at) == "keypair" {
+	if string(at) == "oauth2" || string(at) == "keypair" ||
+		string(at) == "certificate" {
 		return true
 	}
 	return false
 }
 
+// InferAuthenticationType sets AuthenticationType from the credentials
+// sa has
+func (sa *ServiceAccount) InferAuthenticationType() {
+	switch {
+	case sa.KeyID != "":
+		sa.AuthenticationType = AuthenticationTypes.KeyPair
+	case sa.CertificateSubject != "":
+		sa.AuthenticationType = AuthenticationTypes.Certificate
+	default:
+		sa.AuthenticationType = AuthenticationTypes.OAuth2
+	}
+}
+
 // BuildKeyPairServiceAccount generates random KeyID and KeySecret
 func BuildKeyPairServiceAccount(name string) *ServiceAccount {
 	return &ServiceAccount{
@@ -51,3 +83,13 @@ func BuildOAuth2ServiceAccount(name, email string) *ServiceAccount {
 		Email: email,
 	}
 }
+
+// BuildCertificateServiceAccount generates a ServiceAccount authenticated
+// by client certificates matching subject, typed as in
+// CertificateSubjectTypes
+func BuildCertificateServiceAccount(name, subject string) *ServiceAccount {
+	return &ServiceAccount{
+		Name:               name,
+		CertificateSubject: subject,
+	}
+}
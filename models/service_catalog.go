@@ -0,0 +1,98 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghostec/Will.IAM/constants"
+)
+
+// ServiceAction is an action in a service's catalog. ResourceHierarchy is
+// the template of its resources, e.g. "region::game::scheduler"; it's
+// empty for actions not over specific resources
+type ServiceAction struct {
+	ServiceID         string `json:"-" yaml:"-" pg:"service_id"`
+	Action            string `json:"action" yaml:"action" pg:"action"`
+	Description       string `json:"description" yaml:"description" pg:"description" sql:",notnull"`
+	ResourceHierarchy string `json:"resourceHierarchy" yaml:"resourceHierarchy" pg:"resource_hierarchy" sql:",notnull"`
+	CreatedUpdatedAt
+}
+
+// Levels returns the names of a's resource hierarchy levels
+func (a ServiceAction) Levels() []string {
+	if a.ResourceHierarchy == "" {
+		return []string{}
+	}
+	return strings.Split(a.ResourceHierarchy, "::")
+}
+
+// ServiceCatalog is what a service registers instead of, or besides,
+// answering /am: its actions and their resource hierarchies. Services
+// with an empty catalog accept any permission, but Will.IAM's own
+// permissions are always checked against WillIAMCatalog
+type ServiceCatalog struct {
+	Actions []ServiceAction `json:"actions"`
+}
+
+// Validate ServiceCatalog model
+func (c ServiceCatalog) Validate() Validation {
+	v := &Validation{}
+	seen := map[string]bool{}
+	for _, a := range c.Actions {
+		if a.Action == "" || a.Action == "*" || strings.Contains(a.Action, "::") {
+			v.AddError("actions", fmt.Sprintf("invalid action %q", a.Action))
+			continue
+		}
+		if seen[a.Action] {
+			v.AddError("actions", fmt.Sprintf("duplicate action %s", a.Action))
+		}
+		seen[a.Action] = true
+		for _, level := range a.Levels() {
+			if level == "" || level == "*" {
+				v.AddError("actions", fmt.Sprintf(
+					"invalid resourceHierarchy %q of %s", a.ResourceHierarchy, a.Action,
+				))
+				break
+			}
+		}
+	}
+	return *v
+}
+
+// Get returns the action named action
+func (c ServiceCatalog) Get(action string) (*ServiceAction, bool) {
+	for i := range c.Actions {
+		if c.Actions[i].Action == action {
+			return &c.Actions[i], true
+		}
+	}
+	return nil, false
+}
+
+// Allows checks if c has p's action and p's resource hierarchy fits it
+func (c ServiceCatalog) Allows(p Permission) bool {
+	if len(c.Actions) == 0 || p.Action.All() {
+		return true
+	}
+	a, ok := c.Get(p.Action.String())
+	if !ok {
+		return false
+	}
+	if p.ResourceHierarchy.All() {
+		return true
+	}
+	return len(strings.Split(p.ResourceHierarchy.String(), "::")) <=
+		len(a.Levels())
+}
+
+// WillIAMCatalog is the catalog of Will.IAM's own actions, whose resources
+// are the ids of roles, service accounts or services
+func WillIAMCatalog() ServiceCatalog {
+	c := ServiceCatalog{}
+	for _, action := range constants.WillIAMActions() {
+		c.Actions = append(c.Actions, ServiceAction{
+			Action: action, ResourceHierarchy: "id",
+		})
+	}
+	return c
+}
@@ -0,0 +1,113 @@
+// +build unit
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestServiceCatalogValidate(t *testing.T) {
+	type testCase struct {
+		catalog models.ServiceCatalog
+		valid   bool
+	}
+	testCases := []testCase{
+		testCase{
+			catalog: models.ServiceCatalog{Actions: []models.ServiceAction{
+				{Action: "ListSchedulers", ResourceHierarchy: "region::game"},
+				{Action: "Deploy"},
+			}},
+			valid: true,
+		},
+		testCase{
+			catalog: models.ServiceCatalog{Actions: []models.ServiceAction{
+				{Action: "Deploy"}, {Action: "Deploy"},
+			}},
+			valid: false,
+		},
+		testCase{
+			catalog: models.ServiceCatalog{Actions: []models.ServiceAction{
+				{Action: "List::Schedulers"},
+			}},
+			valid: false,
+		},
+		testCase{
+			catalog: models.ServiceCatalog{Actions: []models.ServiceAction{
+				{Action: "ListSchedulers", ResourceHierarchy: "region::*"},
+			}},
+			valid: false,
+		},
+	}
+	for i, tt := range testCases {
+		if v := tt.catalog.Validate(); v.Valid() != tt.valid {
+			t.Errorf("%d: expected valid to be %v. Got %v", i, tt.valid, v.Valid())
+		}
+	}
+}
+
+func TestServiceCatalogAllows(t *testing.T) {
+	catalog := models.ServiceCatalog{Actions: []models.ServiceAction{
+		{Action: "ListSchedulers", ResourceHierarchy: "region::game"},
+		{Action: "Deploy"},
+	}}
+	type testCase struct {
+		permission string
+		allowed    bool
+	}
+	testCases := []testCase{
+		{"Maestro::RL::ListSchedulers::*", true},
+		{"Maestro::RL::ListSchedulers::NA::*", true},
+		{"Maestro::RL::ListSchedulers::NA::Sniper3D", true},
+		{"Maestro::RL::ListSchedulers::NA::Sniper3D::red", false},
+		{"Maestro::RL::ListSchedulerz::*", false},
+		{"Maestro::RL::Deploy::*", true},
+		{"Maestro::RL::Deploy::NA", false},
+		{"Maestro::RO::*::*", true},
+	}
+	for _, tt := range testCases {
+		p, err := models.BuildPermission(tt.permission)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if allowed := catalog.Allows(p); allowed != tt.allowed {
+			t.Errorf(
+				"Expected %s allowed to be %v. Got %v",
+				tt.permission, tt.allowed, allowed,
+			)
+		}
+	}
+	p, _ := models.BuildPermission("Maestro::RL::Anything::NA")
+	if !(models.ServiceCatalog{}).Allows(p) {
+		t.Errorf("Expected empty catalog to allow anything")
+	}
+}
+
+func TestWillIAMCatalogAllows(t *testing.T) {
+	type testCase struct {
+		permission string
+		allowed    bool
+	}
+	testCases := []testCase{
+		{"Will.IAM::RO::EditRole::*", true},
+		{"Will.IAM::RL::EditServiceAccount::some-id", true},
+		{"Will.IAM::RL::ProvisionSCIM::*", true},
+		{"Will.IAM::RO::*::*", true},
+		{"Will.IAM::RO::EditRolez::*", false},
+		{"Will.IAM::RL::EditService::some-id::more", false},
+	}
+	catalog := models.WillIAMCatalog()
+	for _, tt := range testCases {
+		p, err := models.BuildPermission(tt.permission)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if allowed := catalog.Allows(p); allowed != tt.allowed {
+			t.Errorf(
+				"Expected %s allowed to be %v. Got %v",
+				tt.permission, tt.allowed, allowed,
+			)
+		}
+	}
+}
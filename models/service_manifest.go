@@ -0,0 +1,98 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceManifest declares a service as a whole, so deploys can register
+// it in one idempotent call. Roles are created as
+// "{PermissionName}:{Name}" holding permissions of the service only, and
+// belong to it: roles by that name it didn't create are never changed.
+// Dependencies are permissions in other services granted to the service's
+// own service account
+type ServiceManifest struct {
+	Name            string                `json:"name" yaml:"name"`
+	PermissionName  string                `json:"permissionName" yaml:"permissionName"`
+	AMURL           string                `json:"amUrl" yaml:"amUrl"`
+	RedirectOrigins []string              `json:"redirectOrigins" yaml:"redirectOrigins"`
+	Actions         []ServiceAction       `json:"actions" yaml:"actions"`
+	Roles           []ServiceManifestRole `json:"roles" yaml:"roles"`
+	Dependencies    []string              `json:"dependencies" yaml:"dependencies"`
+}
+
+// ServiceManifestRole is a role a ServiceManifest declares
+type ServiceManifestRole struct {
+	Name        string   `json:"name" yaml:"name"`
+	Permissions []string `json:"permissions" yaml:"permissions"`
+}
+
+// Service returns the service m declares
+func (m ServiceManifest) Service() *Service {
+	return &Service{
+		Name:            m.Name,
+		PermissionName:  m.PermissionName,
+		AMURL:           m.AMURL,
+		RedirectOrigins: m.RedirectOrigins,
+	}
+}
+
+// Catalog returns the catalog m declares
+func (m ServiceManifest) Catalog() *ServiceCatalog {
+	return &ServiceCatalog{Actions: m.Actions}
+}
+
+// RoleName returns the name the role named name in m is stored as
+func (m ServiceManifest) RoleName(name string) string {
+	return fmt.Sprintf("%s:%s", m.PermissionName, name)
+}
+
+// Validate ServiceManifest model
+func (m ServiceManifest) Validate() Validation {
+	v := m.Service().Validate()
+	if strings.Contains(m.PermissionName, ":") {
+		v.AddError("permissionName", "must not contain :")
+	}
+	if cv := m.Catalog().Validate(); !cv.Valid() {
+		v.AddError("actions", cv.jsonErrors["actions"])
+	}
+	seen := map[string]bool{}
+	for _, r := range m.Roles {
+		if r.Name == "" {
+			v.AddError("roles", "name is required")
+			continue
+		}
+		if strings.Contains(r.Name, ":") {
+			v.AddError("roles", fmt.Sprintf("%s: must not contain :", r.Name))
+		}
+		if seen[r.Name] {
+			v.AddError("roles", fmt.Sprintf("duplicate role %s", r.Name))
+		}
+		seen[r.Name] = true
+		for _, str := range r.Permissions {
+			p, err := BuildPermission(str)
+			if err != nil {
+				v.AddError("roles", fmt.Sprintf("%s: %s", str, err.Error()))
+				continue
+			}
+			if p.Service != m.PermissionName {
+				v.AddError("roles", fmt.Sprintf(
+					"%s: must be a permission of %s", str, m.PermissionName,
+				))
+			}
+		}
+	}
+	for _, str := range m.Dependencies {
+		p, err := BuildPermission(str)
+		if err != nil {
+			v.AddError("dependencies", fmt.Sprintf("%s: %s", str, err.Error()))
+			continue
+		}
+		if p.Service == m.PermissionName {
+			v.AddError("dependencies", fmt.Sprintf(
+				"%s: must be a permission of another service", str,
+			))
+		}
+	}
+	return v
+}
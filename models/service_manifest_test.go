@@ -0,0 +1,69 @@
+// +build unit
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestServiceManifestValidate(t *testing.T) {
+	type testCase struct {
+		manifest models.ServiceManifest
+		valid    bool
+	}
+	base := func() models.ServiceManifest {
+		return models.ServiceManifest{
+			Name:           "Maestro",
+			PermissionName: "Maestro",
+			Actions:        []models.ServiceAction{{Action: "Deploy"}},
+			Roles: []models.ServiceManifestRole{{
+				Name:        "deployers",
+				Permissions: []string{"Maestro::RL::Deploy::*"},
+			}},
+			Dependencies: []string{"Metagame::RO::ListPlayers::*"},
+		}
+	}
+	withoutName := base()
+	withoutName.Name = ""
+	duplicateRoles := base()
+	duplicateRoles.Roles = append(duplicateRoles.Roles, duplicateRoles.Roles[0])
+	foreignRolePermission := base()
+	foreignRolePermission.Roles[0].Permissions = []string{
+		"Metagame::RL::ListPlayers::*",
+	}
+	ownDependency := base()
+	ownDependency.Dependencies = []string{"Maestro::RL::Deploy::*"}
+	malformedDependency := base()
+	malformedDependency.Dependencies = []string{"Metagame"}
+	invalidActions := base()
+	invalidActions.Actions = []models.ServiceAction{{Action: "*"}}
+	colonRoleName := base()
+	colonRoleName.Roles[0].Name = "deployers:all"
+	colonPermissionName := base()
+	colonPermissionName.PermissionName = "service-account:x"
+	testCases := []testCase{
+		testCase{manifest: base(), valid: true},
+		testCase{manifest: withoutName, valid: false},
+		testCase{manifest: duplicateRoles, valid: false},
+		testCase{manifest: foreignRolePermission, valid: false},
+		testCase{manifest: ownDependency, valid: false},
+		testCase{manifest: malformedDependency, valid: false},
+		testCase{manifest: invalidActions, valid: false},
+		testCase{manifest: colonRoleName, valid: false},
+		testCase{manifest: colonPermissionName, valid: false},
+	}
+	for i, tt := range testCases {
+		if v := tt.manifest.Validate(); v.Valid() != tt.valid {
+			t.Errorf("%d: expected valid to be %v. Got %v", i, tt.valid, v.Valid())
+		}
+	}
+}
+
+func TestServiceManifestRoleName(t *testing.T) {
+	m := models.ServiceManifest{PermissionName: "Maestro"}
+	if name := m.RoleName("deployers"); name != "Maestro:deployers" {
+		t.Errorf("Expected Maestro:deployers. Got %s", name)
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Session is an active token of a service account as its owner sees it:
+// the token itself is never shown, ID identifies it. ClientID is set on
+// tokens issued to oauth2 clients and ImpersonatorID on impersonation
+// tokens
+type Session struct {
+	ID             string     `json:"id"`
+	Provider       string     `json:"provider"`
+	ClientID       string     `json:"clientId,omitempty"`
+	ImpersonatorID string     `json:"impersonatorId,omitempty"`
+	CreatedAt      string     `json:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+	UserAgent      string     `json:"userAgent"`
+	IP             string     `json:"ip"`
+	Current        bool       `json:"current"`
+}
+
+// BuildSession describes t as a Session
+func BuildSession(t *Token) Session {
+	s := Session{
+		ID:             t.ID,
+		Provider:       t.Provider,
+		ClientID:       t.ClientID,
+		ImpersonatorID: t.ImpersonatorID,
+		CreatedAt:      t.CreatedAt,
+		ExpiresAt:      t.Expiry,
+		UserAgent:      t.UserAgent,
+		IP:             t.IP,
+	}
+	if !t.LastUsedAt.IsZero() {
+		lastUsedAt := t.LastUsedAt.Time
+		s.LastUsedAt = &lastUsedAt
+	}
+	return s
+}
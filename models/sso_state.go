@@ -0,0 +1,95 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSOState is carried through the oauth2 provider as the state parameter.
+// Nonce must match the one kept in the browser's cookie, so a state can't
+// be replayed from another browser
+type SSOState struct {
+	Provider  string
+	Referer   string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// NewSSOState builds a state valid for ttl with a random nonce
+func NewSSOState(
+	provider, referer string, ttl time.Duration,
+) (*SSOState, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &SSOState{
+		Provider:  provider,
+		Referer:   referer,
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}, nil
+}
+
+func ssoStateMAC(payload string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign serializes s as payload.signature using HMAC-SHA256
+func (s SSOState) Sign(secret []byte) string {
+	v := url.Values{}
+	v.Add("provider", s.Provider)
+	v.Add("referer", s.Referer)
+	v.Add("nonce", s.Nonce)
+	v.Add("exp", strconv.FormatInt(s.ExpiresAt.Unix(), 10))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+	return fmt.Sprintf("%s.%s", payload, ssoStateMAC(payload, secret))
+}
+
+// VerifySSOState checks str's signature and expiration and parses it
+func VerifySSOState(str string, secret []byte) (*SSOState, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed state")
+	}
+	if !hmac.Equal(
+		[]byte(parts[1]), []byte(ssoStateMAC(parts[0], secret)),
+	) {
+		return nil, fmt.Errorf("invalid state signature")
+	}
+	bts, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	v, err := url.ParseQuery(string(bts))
+	if err != nil {
+		return nil, err
+	}
+	exp, err := strconv.ParseInt(v.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	s := &SSOState{
+		Provider:  v.Get("provider"),
+		Referer:   v.Get("referer"),
+		Nonce:     v.Get("nonce"),
+		ExpiresAt: time.Unix(exp, 0).UTC(),
+	}
+	if s.ExpiresAt.Before(time.Now().UTC()) {
+		return nil, fmt.Errorf("state expired")
+	}
+	if s.Nonce == "" {
+		return nil, fmt.Errorf("state without nonce")
+	}
+	return s, nil
+}
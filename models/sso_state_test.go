@@ -0,0 +1,96 @@
+// +build unit
+
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+func TestSSOStateSignAndVerify(t *testing.T) {
+	secret := []byte("some-secret")
+	state, err := models.NewSSOState("google", "http://localhost:3000/x", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	signed := state.Sign(secret)
+	verified, err := models.VerifySSOState(signed, secret)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if verified.Provider != "google" || verified.Referer != state.Referer ||
+		verified.Nonce != state.Nonce {
+		t.Errorf("Expected %#v. Got %#v", state, verified)
+	}
+	if _, err := models.VerifySSOState(signed, []byte("other-secret")); err == nil {
+		t.Errorf("Expected error verifying with another secret")
+	}
+	tampered := models.SSOState{
+		Provider: "google", Referer: "http://evil.com", Nonce: state.Nonce,
+		ExpiresAt: state.ExpiresAt,
+	}.Sign([]byte("other-secret"))
+	if _, err := models.VerifySSOState(tampered, secret); err == nil {
+		t.Errorf("Expected error verifying tampered state")
+	}
+	if _, err := models.VerifySSOState("http://localhost:3000", secret); err == nil {
+		t.Errorf("Expected error verifying raw referer")
+	}
+}
+
+func TestSSOStateExpired(t *testing.T) {
+	secret := []byte("some-secret")
+	state, err := models.NewSSOState("google", "http://localhost:3000", -time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := models.VerifySSOState(state.Sign(secret), secret); err == nil {
+		t.Errorf("Expected error verifying expired state")
+	}
+}
+
+func TestOriginOf(t *testing.T) {
+	tt := map[string]string{
+		"http://localhost:3000/some/path?a=b": "http://localhost:3000",
+		"https://domain.com":                  "https://domain.com",
+		"javascript:alert(1)":                 "",
+		"//domain.com/path":                   "",
+		"https://user@domain.com":             "",
+		"/relative":                           "",
+	}
+	for rawurl, expected := range tt {
+		if got := models.OriginOf(rawurl); got != expected {
+			t.Errorf("Expected origin of %s to be %s. Got %s", rawurl, expected, got)
+		}
+	}
+	if !models.IsOrigin("https://domain.com:8080") {
+		t.Errorf("Expected https://domain.com:8080 to be an origin")
+	}
+	if models.IsOrigin("https://domain.com/path") {
+		t.Errorf("Expected https://domain.com/path not to be an origin")
+	}
+}
+
+func TestIsLocalPath(t *testing.T) {
+	tt := map[string]bool{
+		"/oauth2/authorize?client_id=x": true,
+		"/":                             true,
+		"/%09/evil.com":                 true,
+		"//evil.com":                    false,
+		"///evil.com":                   false,
+		"/\\evil.com":                   false,
+		"/path\\..\\evil.com":           false,
+		"/\t/evil.com":                  false,
+		"/\n/evil.com":                  false,
+		"https://evil.com":              false,
+		"javascript:alert(1)":           false,
+		"relative":                      false,
+		"":                              false,
+	}
+	for rawurl, expected := range tt {
+		if got := models.IsLocalPath(rawurl); got != expected {
+			t.Errorf("Expected IsLocalPath(%q) to be %t. Got %t", rawurl, expected, got)
+		}
+	}
+}
@@ -6,18 +6,46 @@ import (
 	"github.com/go-pg/pg"
 )
 
-// Token type
+// Token type. ServiceAccountID is only set on tokens Will.IAM issued
+// itself; Scope, when set, restricts the token to those permissions;
+// ImpersonatorID is who acts as ServiceAccountID with this token; MFAAt
+// is when its bearer last proved a second factor; LastUsedAt, UserAgent
+// and IP describe its latest use, recorded in batches
 type Token struct {
-	ID           string      `json:"-" pg:"id"`
-	AccessToken  string      `json:"access_token" pg:"access_token"`
-	RefreshToken string      `json:"refresh_token" pg:"refresh_token"`
-	TokenType    string      `json:"token_type" pg:"token_type"`
-	Expiry       time.Time   `json:"expiry" pg:"expiry"`
-	ExpiredAt    pg.NullTime `json:"expiredAt" pg:"expired_at"`
-	Email        string      `json:"email" pg:"email"`
+	ID               string      `json:"-" pg:"id"`
+	AccessToken      string      `json:"access_token" pg:"access_token"`
+	RefreshToken     string      `json:"refresh_token" pg:"refresh_token"`
+	TokenType        string      `json:"token_type" pg:"token_type"`
+	Expiry           time.Time   `json:"expiry" pg:"expiry"`
+	ExpiredAt        pg.NullTime `json:"expiredAt" pg:"expired_at"`
+	Email            string      `json:"email" pg:"email"`
+	Provider         string      `json:"provider" pg:"provider"`
+	FamilyID         string      `json:"-" pg:"family_id"`
+	SuccessorID      string      `json:"-" pg:"successor_id"`
+	ClientID         string      `json:"-" pg:"client_id"`
+	ServiceAccountID string      `json:"-" pg:"service_account_id"`
+	Scope            []string    `json:"-" sql:"scope,array"`
+	ImpersonatorID   string      `json:"-" pg:"impersonator_id"`
+	MFAAt            pg.NullTime `json:"-" pg:"mfa_at"`
+	LastUsedAt       pg.NullTime `json:"-" pg:"last_used_at"`
+	UserAgent        string      `json:"-" pg:"user_agent"`
+	IP               string      `json:"-" pg:"ip"`
 	CreatedUpdatedAt
 }
 
+// TokenUsage is one use of an access token
+type TokenUsage struct {
+	AccessToken string
+	UserAgent   string
+	IP          string
+	UsedAt      time.Time
+}
+
+// IsValid returns true if t is neither expired nor revoked
+func (t Token) IsValid() bool {
+	return t.ExpiredAt.IsZero() && t.Expiry.After(time.Now().UTC())
+}
+
 // Clone a token
 func (t Token) Clone() *Token {
 	tt := &Token{}
@@ -31,11 +59,32 @@ type AccessTokenAuth struct {
 	ServiceAccountID string
 	AccessToken      string
 	Email            string
+	Scope            []string
+	ImpersonatorID   string
 }
 
-// AuthResult is the result of a successful authentication
+// AuthResult is the result of a successful authentication. Provider is
+// the name of the provider that authenticated it. ServiceAccountID, Scope
+// and ImpersonatorID are only set for tokens Will.IAM issued itself
 type AuthResult struct {
-	AccessToken string `json:"accessToken"`
-	Email       string `json:"email"`
-	Picture     string `json:"picture"`
+	AccessToken      string   `json:"accessToken"`
+	Email            string   `json:"email"`
+	Picture          string   `json:"picture"`
+	Name             string   `json:"-"`
+	HostedDomain     string   `json:"-"`
+	Groups           []string `json:"-"`
+	ServiceAccountID string   `json:"-"`
+	Scope            []string `json:"-"`
+	ImpersonatorID   string   `json:"-"`
+	Provider         string   `json:"-"`
+}
+
+// Profile returns what the provider told about the account; it's zero
+// when the provider wasn't asked, e.g. a still valid token
+func (ar AuthResult) Profile() Profile {
+	return Profile{
+		Name:         ar.Name,
+		Picture:      ar.Picture,
+		HostedDomain: ar.HostedDomain,
+	}
 }
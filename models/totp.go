@@ -0,0 +1,112 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// TOTPPeriod is how long each TOTP code is valid, per RFC 6238
+const TOTPPeriod = 30 * time.Second
+
+// TOTPDigits is the length of TOTP codes
+const TOTPDigits = 6
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPSecret is a service account's TOTP second factor. It only counts
+// once confirmed with a first code. LastUsedStep keeps codes from being
+// used twice
+type TOTPSecret struct {
+	ServiceAccountID string      `json:"-" pg:"service_account_id"`
+	Secret           string      `json:"-" pg:"secret"`
+	ConfirmedAt      pg.NullTime `json:"confirmedAt" pg:"confirmed_at"`
+	LastUsedStep     int64       `json:"-" pg:"last_used_step" sql:",notnull"`
+	CreatedUpdatedAt
+}
+
+// Confirmed checks if s was confirmed with a first code
+func (s TOTPSecret) Confirmed() bool {
+	return !s.ConfirmedAt.IsZero()
+}
+
+// GenerateTOTPSecret returns a random base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPStep returns the time step t is in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTPCode computes the code of secret for step (HMAC-SHA1, RFC 4226
+// dynamic truncation)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(
+		strings.TrimRight(strings.ToUpper(secret), "="),
+	)
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod), nil
+}
+
+// VerifyTOTP checks code against secret at now, allowing one step of clock
+// drift each way. Steps up to lastUsedStep are rejected. It returns the
+// step code matched
+func VerifyTOTP(
+	secret, code string, now time.Time, lastUsedStep int64,
+) (int64, bool) {
+	current := TOTPStep(now)
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI builds the otpauth:// URI authenticator apps read from QR codes
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	v.Set("period", fmt.Sprintf("%d", int(TOTPPeriod/time.Second)))
+	return fmt.Sprintf(
+		"otpauth://totp/%s:%s?%s",
+		url.PathEscape(issuer), url.PathEscape(account), v.Encode(),
+	)
+}
@@ -0,0 +1,60 @@
+// +build unit
+
+package models_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	type testCase struct {
+		unix int64
+		code string
+	}
+	tt := []testCase{
+		testCase{unix: 59, code: "287082"},
+		testCase{unix: 1111111109, code: "081804"},
+		testCase{unix: 1234567890, code: "005924"},
+		testCase{unix: 2000000000, code: "279037"},
+	}
+	for _, tt := range tt {
+		code, err := models.TOTPCode(secret, models.TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if code != tt.code {
+			t.Errorf("Expected %s at %d. Got %s", tt.code, tt.unix, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := models.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	now := time.Now()
+	step := models.TOTPStep(now)
+	code, _ := models.TOTPCode(secret, step)
+	matched, ok := models.VerifyTOTP(secret, code, now, 0)
+	if !ok || matched != step {
+		t.Errorf("Expected code to match step %d", step)
+	}
+	if _, ok := models.VerifyTOTP(secret, code, now, step); ok {
+		t.Errorf("Expected used step to be rejected")
+	}
+	previous, _ := models.TOTPCode(secret, step-1)
+	if _, ok := models.VerifyTOTP(secret, previous, now, 0); !ok {
+		t.Errorf("Expected previous step to be accepted for clock drift")
+	}
+	old, _ := models.TOTPCode(secret, step-2)
+	if _, ok := models.VerifyTOTP(secret, old, now, 0); ok {
+		t.Errorf("Expected code two steps old to be rejected")
+	}
+}
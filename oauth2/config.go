@@ -0,0 +1,56 @@
+package oauth2
+
+import (
+	"fmt"
+
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/repositories"
+	"github.com/spf13/viper"
+)
+
+// NewProvidersFromConfig builds Google, every oauth2.oidc.* provider and
+// the issuer of Will.IAM's own tokens as configured under oauth2. OIDC
+// providers must restrict allowedDomains
+func NewProvidersFromConfig(
+	config *viper.Viper, repo *repositories.All,
+) (*Providers, error) {
+	providers := NewProviders(repo)
+	providers.SetIssuer(constants.AppInfo.Name, NewLocal(repo))
+	google := NewGoogle(GoogleConfig{
+		ClientID:      config.GetString("oauth2.google.clientId"),
+		ClientSecret:  config.GetString("oauth2.google.clientSecret"),
+		RedirectURL:   config.GetString("oauth2.google.redirectUrl"),
+		HostedDomains: config.GetStringSlice("oauth2.google.hostedDomains"),
+		FetchGroups:   config.GetBool("oauth2.google.fetchGroups"),
+		AuthURL:       config.GetString("oauth2.google.authUrl"),
+		TokenURL:      config.GetString("oauth2.google.tokenUrl"),
+		UserInfoURL:   config.GetString("oauth2.google.userInfoUrl"),
+		GroupsURL:     config.GetString("oauth2.google.groupsUrl"),
+	}, repo)
+	providers.Add(GoogleProviderName, google)
+	for name := range config.GetStringMap("oauth2.oidc") {
+		prefix := fmt.Sprintf("oauth2.oidc.%s", name)
+		// any domain would let whoever sets up a matching email at this
+		// provider log in as someone else
+		if len(config.GetStringSlice(prefix+".allowedDomains")) == 0 {
+			return nil, fmt.Errorf("%s.allowedDomains is required", prefix)
+		}
+		providers.Add(name, NewOIDC(OIDCConfig{
+			Name:           name,
+			ClientID:       config.GetString(prefix + ".clientId"),
+			ClientSecret:   config.GetString(prefix + ".clientSecret"),
+			RedirectURL:    config.GetString(prefix + ".redirectUrl"),
+			AuthURL:        config.GetString(prefix + ".authUrl"),
+			TokenURL:       config.GetString(prefix + ".tokenUrl"),
+			UserInfoURL:    config.GetString(prefix + ".userInfoUrl"),
+			Scopes:         config.GetStringSlice(prefix + ".scopes"),
+			AllowedDomains: config.GetStringSlice(prefix + ".allowedDomains"),
+		}, repo))
+	}
+	if name := config.GetString("oauth2.default"); name != "" {
+		if err := providers.SetDefault(name); err != nil {
+			return nil, err
+		}
+	}
+	return providers, nil
+}
@@ -2,9 +2,6 @@ package oauth2
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -16,55 +13,82 @@ import (
 	extensionsHttp "github.com/topfreegames/extensions/http"
 )
 
-const tokenEndpoint = "https://www.googleapis.com/oauth2/v4/token"
-const userEndpoint = "https://www.googleapis.com/oauth2/v2/userinfo"
+// Google's endpoints, used when GoogleConfig doesn't override them
+const (
+	GoogleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	GoogleTokenURL    = "https://www.googleapis.com/oauth2/v4/token"
+	GoogleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+	GoogleGroupsURL   = "https://www.googleapis.com/admin/directory/v1/groups"
+)
+
+// GoogleProviderName is the name under which Google is registered in
+// Providers and stored in tokens.provider
+const GoogleProviderName = "google"
 
 // GoogleConfig are the basic required informations to use Google
 // as oauth2 provider
+// FetchGroups requests the directory groups readonly scope and lists the
+// user's Google groups on login, so they can be mapped to roles
+// AuthURL, TokenURL, UserInfoURL and GroupsURL default to Google's and
+// only need to be set to run against a stub server
 type GoogleConfig struct {
 	ClientID      string
 	ClientSecret  string
 	RedirectURL   string
 	HostedDomains []string
+	FetchGroups   bool
+	AuthURL       string
+	TokenURL      string
+	UserInfoURL   string
+	GroupsURL     string
 }
 
-var googleConfig GoogleConfig
-
-func buildURL(endpoint, queryStrings string) string {
-	return fmt.Sprintf("%s?%s", endpoint, queryStrings)
-}
-
-func mapToQueryStrings(m map[string]string) string {
-	s := []string{}
-	for k, v := range m {
-		s = append(s, fmt.Sprintf("%s=%s", k, v))
+func (c GoogleConfig) withDefaults() GoogleConfig {
+	if c.AuthURL == "" {
+		c.AuthURL = GoogleAuthURL
 	}
-	return strings.Join(s, "&")
+	if c.TokenURL == "" {
+		c.TokenURL = GoogleTokenURL
+	}
+	if c.UserInfoURL == "" {
+		c.UserInfoURL = GoogleUserInfoURL
+	}
+	if c.GroupsURL == "" {
+		c.GroupsURL = GoogleGroupsURL
+	}
+	return c
 }
 
 // Google implements Provider
 type Google struct {
-	config GoogleConfig
-	repo   *repositories.All
-	client *http.Client
+	config    GoogleConfig
+	repo      *repositories.All
+	client    *http.Client
+	refresher *refresher
 }
 
 // BuildAuthURL returns an URL authenticate with Google
 func (g *Google) BuildAuthURL(state string) string {
+	scopes := []string{
+		url.QueryEscape("https://www.googleapis.com/auth/userinfo.profile"),
+		url.QueryEscape("https://www.googleapis.com/auth/userinfo.email"),
+	}
+	if g.config.FetchGroups {
+		scopes = append(scopes, url.QueryEscape(
+			"https://www.googleapis.com/auth/admin.directory.group.readonly",
+		))
+	}
 	qs := mapToQueryStrings(map[string]string{
-		"state":        state,
-		"redirect_uri": g.config.RedirectURL,
-		"client_id":    g.config.ClientID,
-		"scope": strings.Join([]string{
-			url.QueryEscape("https://www.googleapis.com/auth/userinfo.profile"),
-			url.QueryEscape("https://www.googleapis.com/auth/userinfo.email"),
-		}, "+"),
+		"state":                  url.QueryEscape(state),
+		"redirect_uri":           g.config.RedirectURL,
+		"client_id":              g.config.ClientID,
+		"scope":                  strings.Join(scopes, "+"),
 		"access_type":            "offline",
 		"include_granted_scopes": "true",
 		"response_type":          "code",
 		"prompt":                 "consent",
 	})
-	return buildURL("https://accounts.google.com/o/oauth2/v2/auth", qs)
+	return buildURL(g.config.AuthURL, qs)
 }
 
 func (g *Google) buildExchangeCodeForm(code string) string {
@@ -92,15 +116,28 @@ func (g *Google) ExchangeCode(code string) (*models.AuthResult, error) {
 		return nil, errors.NewNonAllowedEmailDomainError(userInfo.HostedDomain)
 	}
 	t.Email = userInfo.Email
+	t.Provider = GoogleProviderName
+	// groups that couldn't be listed fail the login, rather than being
+	// synced as none
+	var groups []string
+	if g.config.FetchGroups {
+		if groups, err = g.getGroups(t.AccessToken, t.Email); err != nil {
+			return nil, err
+		}
+	}
 	// TODO: don't return sso_access_token to user, return 2 tokens to sso
 	t.Expiry = time.Now().UTC().Add(14 * 24 * 3600 * time.Second)
 	if err := g.repo.Tokens.Save(t); err != nil {
 		return nil, err
 	}
 	return &models.AuthResult{
-		AccessToken: t.AccessToken,
-		Email:       t.Email,
-		Picture:     userInfo.Picture,
+		AccessToken:  t.AccessToken,
+		Email:        t.Email,
+		Picture:      userInfo.Picture,
+		Name:         userInfo.Name,
+		HostedDomain: userInfo.HostedDomain,
+		Groups:       groups,
+		Provider:     GoogleProviderName,
 	}, nil
 }
 
@@ -130,27 +167,7 @@ func (gt GoogleToken) Validate() *models.Validation {
 func (g *Google) postToTokenEndpoint(
 	urlencoded string,
 ) (*GoogleToken, error) {
-	req, err := http.NewRequest("POST", tokenEndpoint, strings.NewReader(urlencoded))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	if err != nil {
-		return nil, err
-	}
-	res, err := g.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	gt := &GoogleToken{}
-	err = json.Unmarshal(body, gt)
-	if err != nil {
-		return nil, err
-	}
-	v := gt.Validate()
-	if !v.Valid() {
-		return nil, v.Error()
-	}
-	return gt, nil
+	return postToTokenEndpoint(g.client, g.config.TokenURL, urlencoded)
 }
 
 func (g *Google) tokenFromCode(code string) (*models.Token, error) {
@@ -171,30 +188,61 @@ func (g *Google) tokenFromCode(code string) (*models.Token, error) {
 
 type userInfo struct {
 	Email        string `json:"email"`
+	Name         string `json:"name"`
 	HostedDomain string `json:"hd"`
 	Picture      string `json:"picture"`
 }
 
-func (g *Google) getUserInfo(accessToken string) (*userInfo, error) {
-	req, err := http.NewRequest("GET", userEndpoint, nil)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	if err != nil {
-		return nil, err
-	}
-	res, err := g.client.Do(req)
-	if err != nil {
-		return nil, err
+func (ui userInfo) profile() *models.Profile {
+	return &models.Profile{
+		Name:         ui.Name,
+		Picture:      ui.Picture,
+		HostedDomain: ui.HostedDomain,
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
+}
+
+func (g *Google) getUserInfo(accessToken string) (*userInfo, error) {
 	ui := &userInfo{}
-	err = json.Unmarshal(body, ui)
-	if err != nil {
+	if err := getUserInfo(g.client, g.config.UserInfoURL, accessToken, ui); err != nil {
 		return nil, err
 	}
 	return ui, nil
 }
 
+type googleGroups struct {
+	Groups []struct {
+		Email string `json:"email"`
+	} `json:"groups"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// getGroups lists the emails of all Google groups email is a member of,
+// following every page
+func (g *Google) getGroups(accessToken, email string) ([]string, error) {
+	groups := []string{}
+	pageToken := ""
+	for {
+		v := url.Values{}
+		v.Add("userKey", email)
+		if pageToken != "" {
+			v.Add("pageToken", pageToken)
+		}
+		gg := &googleGroups{}
+		if err := getUserInfo(
+			g.client, buildURL(g.config.GroupsURL, v.Encode()), accessToken, gg,
+		); err != nil {
+			return nil, err
+		}
+		for i := range gg.Groups {
+			groups = append(groups, gg.Groups[i].Email)
+		}
+		if gg.NextPageToken == "" {
+			return groups, nil
+		}
+		pageToken = gg.NextPageToken
+	}
+}
+
 func (g *Google) checkHostedDomain(hd string) bool {
 	if g.config.HostedDomains == nil || len(g.config.HostedDomains) == 0 {
 		return true
@@ -216,77 +264,67 @@ func (g *Google) buildRefreshTokenForm(refreshToken string) string {
 	return v.Encode()
 }
 
-func (g *Google) maybeRefresh(t *models.Token) (*userInfo, error) {
-	if t.Expiry.After(time.Now().UTC()) || !t.ExpiredAt.IsZero() {
-		return nil, nil
-	}
-	rtf := g.buildRefreshTokenForm(t.RefreshToken)
-	gt, err := g.postToTokenEndpoint(rtf)
-	if err != nil {
-		return nil, err
-	}
-	oldT := t.Clone()
-	oldT.ExpiredAt.Time = time.Now().UTC()
-	t.ID = ""
-	t.AccessToken = gt.AccessToken
-	t.Expiry = time.Now().UTC().Add(
-		time.Second * time.Duration(gt.ExpiresIn),
-	)
-	userInfo, err := g.getUserInfo(t.AccessToken)
-	if err != nil {
-		return nil, err
-	}
-	if err := g.repo.WithPGTx(
-		context.Background(), func(repo *repositories.All,
-		) error {
-			if err := g.repo.Tokens.Save(t); err != nil {
-				return err
-			}
-			if err := g.repo.Tokens.Save(oldT); err != nil {
-				return err
-			}
-			return nil
-		}); err != nil {
-		return nil, err
-	}
-	return userInfo, nil
+func (g *Google) refreshToken(refreshToken string) (*GoogleToken, error) {
+	return g.postToTokenEndpoint(g.buildRefreshTokenForm(refreshToken))
 }
 
 // Authenticate verifies if an accessToken is valid and maybe refresh it
 func (g *Google) Authenticate(accessToken string) (*models.AuthResult, error) {
-	t, err := g.repo.Tokens.Get(accessToken)
-	if err != nil {
-		return nil, err
-	}
-	var userInfo *userInfo
-	if userInfo, err = g.maybeRefresh(t); err != nil {
-		return nil, err
-	}
+	t, refreshed, err := g.refresher.authenticate(accessToken)
 	if err != nil {
 		return nil, err
 	}
 	authResult := &models.AuthResult{
 		AccessToken: t.AccessToken,
 		Email:       t.Email,
+		Provider:    GoogleProviderName,
 	}
-	if userInfo != nil {
+	if refreshed {
+		userInfo, err := g.getUserInfo(t.AccessToken)
+		if err != nil {
+			return nil, err
+		}
 		authResult.Picture = userInfo.Picture
+		authResult.Name = userInfo.Name
+		authResult.HostedDomain = userInfo.HostedDomain
 	}
 	return authResult, nil
 }
 
+// Profile fetches the current profile of accessToken's account. An
+// expired accessToken is refreshed, but not rotated
+func (g *Google) Profile(accessToken string) (*models.Profile, error) {
+	current, err := g.refresher.peek(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	userInfo, err := g.getUserInfo(current)
+	if err != nil {
+		return nil, err
+	}
+	return userInfo.profile(), nil
+}
+
 // WithContext returns a new instance of *Google using ctx
 func (g Google) WithContext(ctx context.Context) Provider {
-	return NewGoogle(g.config, g.repo.WithContext(ctx))
+	c := &Google{
+		config: g.config,
+		repo:   g.repo.WithContext(ctx),
+		client: g.client,
+	}
+	c.refresher = g.refresher.withContext(ctx, c.repo)
+	return c
 }
 
 // NewGoogle ctor
 func NewGoogle(
 	config GoogleConfig, repo *repositories.All,
 ) *Google {
-	return &Google{
-		config: config,
+	g := &Google{
+		config: config.withDefaults(),
 		repo:   repo,
 		client: extensionsHttp.New(),
 	}
+	g.refresher = newRefresher(repo, g.refreshToken)
+	return g
 }
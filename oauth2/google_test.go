@@ -0,0 +1,218 @@
+// +build integration
+
+package oauth2_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/oauth2"
+	"github.com/ghostec/Will.IAM/oauth2/googletest"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+func getGoogle(
+	t *testing.T, server *googletest.Server, hostedDomains ...string,
+) oauth2.Provider {
+	t.Helper()
+	config := server.Config()
+	config.HostedDomains = hostedDomains
+	config.FetchGroups = true
+	return oauth2.NewGoogle(config, helpers.GetRepo(t)).
+		WithContext(context.Background())
+}
+
+func TestGoogleBuildAuthURL(t *testing.T) {
+	server := googletest.NewServer()
+	defer server.Close()
+	authURL := getGoogle(t, server).BuildAuthURL("some-state")
+	if !strings.HasPrefix(authURL, server.URL+"/o/oauth2/v2/auth?") {
+		t.Errorf("Expected auth URL to point to stub. Got %s", authURL)
+	}
+}
+
+func TestGoogleExchangeCode(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.AddCode("some-code", googletest.User{
+		Email:        "some@domain.com",
+		HostedDomain: "domain.com",
+		Picture:      "some-picture",
+		Groups:       []string{"eng@domain.com"},
+	})
+	authResult, err := getGoogle(t, server, "domain.com").
+		ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.Email != "some@domain.com" {
+		t.Errorf("Expected email some@domain.com. Got %s", authResult.Email)
+	}
+	if authResult.Picture != "some-picture" {
+		t.Errorf("Expected picture some-picture. Got %s", authResult.Picture)
+	}
+	if !reflect.DeepEqual(authResult.Groups, []string{"eng@domain.com"}) {
+		t.Errorf("Expected groups [eng@domain.com]. Got %v", authResult.Groups)
+	}
+	token, err := helpers.GetRepo(t).Tokens.Get(authResult.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if token.Provider != oauth2.GoogleProviderName {
+		t.Errorf("Expected provider google. Got %s", token.Provider)
+	}
+}
+
+func TestGoogleExchangeCodeFollowsGroupsPages(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.SetGroupsPageSize(2)
+	groups := []string{
+		"a@domain.com", "b@domain.com", "c@domain.com", "d@domain.com",
+		"e@domain.com",
+	}
+	server.AddCode("some-code", googletest.User{
+		Email: "some@domain.com", Groups: groups,
+	})
+	authResult, err := getGoogle(t, server).ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(authResult.Groups, groups) {
+		t.Errorf("Expected groups %v. Got %v", groups, authResult.Groups)
+	}
+}
+
+func TestGoogleExchangeCodeFailsWhenGroupsFail(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.FailGroups(http.StatusForbidden)
+	server.AddCode("some-code", googletest.User{
+		Email: "some@domain.com", Groups: []string{"eng@domain.com"},
+	})
+	if _, err := getGoogle(t, server).ExchangeCode("some-code"); err == nil {
+		t.Errorf("Expected error exchanging code when groups can't be listed")
+	}
+}
+
+func TestGoogleExchangeCodeRejectsHostedDomain(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.AddCode("some-code", googletest.User{
+		Email: "some@other.com", HostedDomain: "other.com",
+	})
+	_, err := getGoogle(t, server, "domain.com").ExchangeCode("some-code")
+	if _, ok := err.(*errors.NonAllowedEmailDomainError); !ok {
+		t.Errorf("Expected NonAllowedEmailDomainError. Got %v", err)
+	}
+}
+
+func TestGoogleExchangeCodeUnknownCode(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	if _, err := getGoogle(t, server).ExchangeCode("unknown"); err == nil {
+		t.Errorf("Expected error exchanging unknown code")
+	}
+}
+
+func TestGoogleAuthenticate(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.AddCode("some-code", googletest.User{Email: "some@domain.com"})
+	google := getGoogle(t, server)
+	exchanged, err := google.ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	authResult, err := google.Authenticate(exchanged.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.AccessToken != exchanged.AccessToken {
+		t.Errorf("Expected same access token. Got %s", authResult.AccessToken)
+	}
+	if server.Refreshes() != 0 {
+		t.Errorf("Expected no refresh. Got %d", server.Refreshes())
+	}
+	if _, err := google.Authenticate("unknown"); err == nil {
+		t.Errorf("Expected error authenticating unknown token")
+	}
+}
+
+func TestGoogleAuthenticateRefreshesExpiredToken(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.AddCode("some-code", googletest.User{
+		Email: "some@domain.com", Picture: "some-picture",
+	})
+	google := getGoogle(t, server)
+	exchanged, err := google.ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := helpers.GetStorage(t).PG.DB.Exec(
+		`UPDATE tokens SET expiry = now() - INTERVAL '1 min'`,
+	); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	authResult, err := google.Authenticate(exchanged.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.AccessToken == exchanged.AccessToken {
+		t.Errorf("Expected a new access token")
+	}
+	if authResult.Picture != "some-picture" {
+		t.Errorf("Expected picture some-picture. Got %s", authResult.Picture)
+	}
+	if server.Refreshes() != 1 {
+		t.Errorf("Expected 1 refresh. Got %d", server.Refreshes())
+	}
+	again, err := google.Authenticate(authResult.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if again.AccessToken != authResult.AccessToken {
+		t.Errorf("Expected refreshed token to be valid")
+	}
+}
+
+func TestGoogleProfile(t *testing.T) {
+	beforeEachRefresh(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	server.AddCode("some-code", googletest.User{
+		Email: "some@domain.com", Name: "Some One", HostedDomain: "domain.com",
+		Picture: "some-picture",
+	})
+	google := getGoogle(t, server)
+	exchanged, err := google.ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if exchanged.Name != "Some One" || exchanged.HostedDomain != "domain.com" {
+		t.Errorf("Expected login to return the profile. Got %v", exchanged.Profile())
+	}
+	profile, err := google.(oauth2.ProfileProvider).Profile(exchanged.AccessToken)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := models.Profile{
+		Name: "Some One", Picture: "some-picture", HostedDomain: "domain.com",
+	}
+	if *profile != expected {
+		t.Errorf("Expected profile %v. Got %v", expected, *profile)
+	}
+}
@@ -0,0 +1,248 @@
+// Package googletest provides a fake Google authorization server so the
+// Google oauth2 provider can be exercised end to end without network
+package googletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghostec/Will.IAM/oauth2"
+)
+
+// ClientID and ClientSecret are the only credentials the fake server accepts
+const (
+	ClientID     = "googletest-client-id"
+	ClientSecret = "googletest-client-secret"
+	RedirectURL  = "http://localhost/sso/auth/done"
+)
+
+// User is a Google account known to the fake server
+type User struct {
+	Email        string
+	Name         string
+	HostedDomain string
+	Picture      string
+	Groups       []string
+}
+
+// Server is a fake Google authorization server. It implements the auth,
+// token (authorization_code and refresh_token grants), userinfo and
+// directory groups endpoints
+type Server struct {
+	*httptest.Server
+	mu            sync.Mutex
+	seq           int
+	codes         map[string]User
+	accessTokens  map[string]User
+	refreshTokens map[string]User
+	refreshes     int
+	groupsPage    int
+	groupsStatus  int
+}
+
+// NewServer starts a fake Google server; Close it when done
+func NewServer() *Server {
+	s := &Server{
+		codes:         map[string]User{},
+		accessTokens:  map[string]User{},
+		refreshTokens: map[string]User{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/o/oauth2/v2/auth", s.authHandler)
+	mux.HandleFunc("/token", s.tokenHandler)
+	mux.HandleFunc("/userinfo", s.userInfoHandler)
+	mux.HandleFunc("/groups", s.groupsHandler)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a GoogleConfig pointing to s
+func (s *Server) Config() oauth2.GoogleConfig {
+	return oauth2.GoogleConfig{
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  RedirectURL,
+		AuthURL:      s.URL + "/o/oauth2/v2/auth",
+		TokenURL:     s.URL + "/token",
+		UserInfoURL:  s.URL + "/userinfo",
+		GroupsURL:    s.URL + "/groups",
+	}
+}
+
+// AddCode registers an authorization code that logs u in once
+func (s *Server) AddCode(code string, u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = u
+}
+
+// SetGroupsPageSize makes the groups endpoint answer at most n groups per
+// page, with a nextPageToken to the next one; 0, the default, is no limit
+func (s *Server) SetGroupsPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupsPage = n
+}
+
+// FailGroups makes the groups endpoint answer status with an error body;
+// 0, the default, answers groups
+func (s *Server) FailGroups(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupsStatus = status
+}
+
+// Refreshes returns how many refresh_token grants were served
+func (s *Server) Refreshes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+func (s *Server) nextToken(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s-%d", prefix, s.seq)
+}
+
+func writeJSON(w http.ResponseWriter, status int, i interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(i)
+}
+
+func writeError(w http.ResponseWriter, status int, err string) {
+	writeJSON(w, status, map[string]string{"error": err})
+}
+
+// authHandler logs in the user of login_hint right away, redirecting back
+// with a fresh code
+func (s *Server) authHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	if qs.Get("client_id") != ClientID {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	s.mu.Lock()
+	code := s.nextToken("code")
+	s.codes[code] = User{Email: qs.Get("login_hint")}
+	s.mu.Unlock()
+	v := url.Values{}
+	v.Add("code", code)
+	v.Add("state", qs.Get("state"))
+	http.Redirect(
+		w, r, fmt.Sprintf("%s?%s", qs.Get("redirect_uri"), v.Encode()),
+		http.StatusFound,
+	)
+}
+
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != ClientID ||
+		r.PostForm.Get("client_secret") != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var u User
+	refreshToken := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		var ok bool
+		code := r.PostForm.Get("code")
+		if u, ok = s.codes[code]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(s.codes, code)
+		refreshToken = s.nextToken("refresh")
+		s.refreshTokens[refreshToken] = u
+	case "refresh_token":
+		var ok bool
+		if u, ok = s.refreshTokens[r.PostForm.Get("refresh_token")]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		s.refreshes++
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	accessToken := s.nextToken("access")
+	s.accessTokens[accessToken] = u
+	// like Google, refresh_token is only sent on the authorization_code grant
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (s *Server) userFromRequest(r *http.Request) (User, bool) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.accessTokens[accessToken]
+	return u, ok
+}
+
+func (s *Server) userInfoHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.userFromRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"email":   u.Email,
+		"name":    u.Name,
+		"hd":      u.HostedDomain,
+		"picture": u.Picture,
+	})
+}
+
+func (s *Server) groupsHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.userFromRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	s.mu.Lock()
+	pageSize, status := s.groupsPage, s.groupsStatus
+	s.mu.Unlock()
+	if status != 0 {
+		writeError(w, status, "backendError")
+		return
+	}
+	start := 0
+	if pageToken := r.URL.Query().Get("pageToken"); pageToken != "" {
+		var err error
+		start, err = strconv.Atoi(pageToken)
+		if err != nil || start < 0 || start > len(u.Groups) {
+			writeError(w, http.StatusBadRequest, "invalid_page_token")
+			return
+		}
+	}
+	end := len(u.Groups)
+	if pageSize > 0 && start+pageSize < end {
+		end = start + pageSize
+	}
+	groups := []map[string]string{}
+	for i := start; i < end; i++ {
+		groups = append(groups, map[string]string{"email": u.Groups[i]})
+	}
+	body := map[string]interface{}{"groups": groups}
+	if end < len(u.Groups) {
+		body["nextPageToken"] = strconv.Itoa(end)
+	}
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,74 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func buildURL(endpoint, queryStrings string) string {
+	return fmt.Sprintf("%s?%s", endpoint, queryStrings)
+}
+
+func mapToQueryStrings(m map[string]string) string {
+	s := []string{}
+	for k, v := range m {
+		s = append(s, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(s, "&")
+}
+
+// postToTokenEndpoint posts an urlencoded form to a token endpoint and
+// parses the standard OAuth2 token response
+func postToTokenEndpoint(
+	client *http.Client, endpoint, urlencoded string,
+) (*GoogleToken, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(urlencoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	gt := &GoogleToken{}
+	err = json.Unmarshal(body, gt)
+	if err != nil {
+		return nil, err
+	}
+	v := gt.Validate()
+	if !v.Valid() {
+		return nil, v.Error()
+	}
+	return gt, nil
+}
+
+// getUserInfo fetches endpoint with accessToken as Bearer and unmarshals
+// the response into ui. Non 2xx responses are errors, so callers never
+// take an error body for an empty answer
+func getUserInfo(
+	client *http.Client, endpoint, accessToken string, ui interface{},
+) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf(
+			"GET %s answered %d: %s", res.Request.URL.Path, res.StatusCode, body,
+		)
+	}
+	return json.Unmarshal(body, ui)
+}
@@ -0,0 +1,62 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// Local authenticates tokens Will.IAM issued itself to its OAuth2 clients.
+// Its tokens aren't refreshed on Authenticate: clients use the
+// refresh_token grant of /oauth2/token
+type Local struct {
+	repo *repositories.All
+}
+
+// NewLocal ctor
+func NewLocal(repo *repositories.All) *Local {
+	return &Local{repo: repo}
+}
+
+// BuildAuthURL is not supported: Will.IAM logs users in through other
+// providers
+func (l *Local) BuildAuthURL(state string) string {
+	return ""
+}
+
+// ExchangeCode is not supported: codes are traded at /oauth2/token
+func (l *Local) ExchangeCode(code string) (*models.AuthResult, error) {
+	return nil, fmt.Errorf("local provider does not exchange codes")
+}
+
+// Authenticate checks accessToken is valid. Refreshed tokens stay valid
+// for the grace period Tokens.Get allows
+func (l *Local) Authenticate(accessToken string) (*models.AuthResult, error) {
+	t, err := l.repo.Tokens.Get(accessToken)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		if _, findErr := l.repo.Tokens.Find(accessToken); findErr == nil {
+			return nil, errors.NewTokenExpiredError()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsValid() && t.SuccessorID == "" {
+		return nil, errors.NewTokenExpiredError()
+	}
+	return &models.AuthResult{
+		AccessToken:      t.AccessToken,
+		Email:            t.Email,
+		ServiceAccountID: t.ServiceAccountID,
+		Scope:            t.Scope,
+		ImpersonatorID:   t.ImpersonatorID,
+	}, nil
+}
+
+// WithContext returns a new *Local using ctx
+func (l *Local) WithContext(ctx context.Context) Provider {
+	return &Local{repo: l.repo.WithContext(ctx)}
+}
@@ -0,0 +1,226 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+	extensionsHttp "github.com/topfreegames/extensions/http"
+)
+
+// OIDCConfig are the informations required to use a generic OpenID Connect
+// server as oauth2 provider
+type OIDCConfig struct {
+	Name           string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	AuthURL        string
+	TokenURL       string
+	UserInfoURL    string
+	Scopes         []string
+	AllowedDomains []string
+}
+
+// OIDC implements Provider for any OpenID Connect compliant server
+type OIDC struct {
+	config    OIDCConfig
+	repo      *repositories.All
+	client    *http.Client
+	refresher *refresher
+}
+
+type oidcUserInfo struct {
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+	Groups        []string `json:"groups"`
+}
+
+func (ui oidcUserInfo) domain() string {
+	parts := strings.Split(ui.Email, "@")
+	return parts[len(parts)-1]
+}
+
+func (ui oidcUserInfo) profile() *models.Profile {
+	return &models.Profile{
+		Name:         ui.Name,
+		Picture:      ui.Picture,
+		HostedDomain: ui.domain(),
+	}
+}
+
+// BuildAuthURL returns an URL to authenticate with the OIDC server
+func (o *OIDC) BuildAuthURL(state string) string {
+	scopes := o.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	escaped := make([]string, len(scopes))
+	for i := range scopes {
+		escaped[i] = url.QueryEscape(scopes[i])
+	}
+	qs := mapToQueryStrings(map[string]string{
+		"state":         url.QueryEscape(state),
+		"redirect_uri":  url.QueryEscape(o.config.RedirectURL),
+		"client_id":     url.QueryEscape(o.config.ClientID),
+		"scope":         strings.Join(escaped, "+"),
+		"response_type": "code",
+	})
+	return buildURL(o.config.AuthURL, qs)
+}
+
+func (o *OIDC) buildExchangeCodeForm(code string) string {
+	v := url.Values{}
+	v.Add("code", code)
+	v.Add("client_id", o.config.ClientID)
+	v.Add("client_secret", o.config.ClientSecret)
+	v.Add("redirect_uri", o.config.RedirectURL)
+	v.Add("grant_type", "authorization_code")
+	return v.Encode()
+}
+
+func (o *OIDC) buildRefreshTokenForm(refreshToken string) string {
+	v := url.Values{}
+	v.Add("refresh_token", refreshToken)
+	v.Add("client_id", o.config.ClientID)
+	v.Add("client_secret", o.config.ClientSecret)
+	v.Add("grant_type", "refresh_token")
+	return v.Encode()
+}
+
+func (o *OIDC) getUserInfo(accessToken string) (*oidcUserInfo, error) {
+	ui := &oidcUserInfo{}
+	if err := getUserInfo(
+		o.client, o.config.UserInfoURL, accessToken, ui,
+	); err != nil {
+		return nil, err
+	}
+	return ui, nil
+}
+
+// checkDomain checks domain is one of AllowedDomains; without any, no
+// domain is, see NewProvidersFromConfig
+func (o *OIDC) checkDomain(domain string) bool {
+	for _, allowed := range o.config.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ExchangeCode will trade code for full token with the OIDC server
+func (o *OIDC) ExchangeCode(code string) (*models.AuthResult, error) {
+	gt, err := postToTokenEndpoint(
+		o.client, o.config.TokenURL, o.buildExchangeCodeForm(code),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := o.getUserInfo(gt.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	// accounts are matched by email, so it must be the provider's to vouch
+	// for, not whatever the user typed in their profile
+	if !ui.EmailVerified {
+		return nil, errors.NewUnverifiedEmailError(ui.Email)
+	}
+	if !o.checkDomain(ui.domain()) {
+		return nil, errors.NewNonAllowedEmailDomainError(ui.domain())
+	}
+	t := &models.Token{
+		AccessToken:  gt.AccessToken,
+		RefreshToken: gt.RefreshToken,
+		TokenType:    gt.TokenType,
+		Expiry: time.Now().UTC().Add(
+			time.Second * time.Duration(gt.ExpiresIn),
+		),
+		Email:    ui.Email,
+		Provider: o.config.Name,
+	}
+	if err := o.repo.Tokens.Save(t); err != nil {
+		return nil, err
+	}
+	return &models.AuthResult{
+		AccessToken:  t.AccessToken,
+		Email:        t.Email,
+		Picture:      ui.Picture,
+		Name:         ui.Name,
+		HostedDomain: ui.domain(),
+		Groups:       ui.Groups,
+		Provider:     o.config.Name,
+	}, nil
+}
+
+func (o *OIDC) refreshToken(refreshToken string) (*GoogleToken, error) {
+	return postToTokenEndpoint(
+		o.client, o.config.TokenURL, o.buildRefreshTokenForm(refreshToken),
+	)
+}
+
+// Authenticate verifies if an accessToken is valid and maybe refresh it
+func (o *OIDC) Authenticate(accessToken string) (*models.AuthResult, error) {
+	t, refreshed, err := o.refresher.authenticate(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	authResult := &models.AuthResult{
+		AccessToken: t.AccessToken,
+		Email:       t.Email,
+		Provider:    o.config.Name,
+	}
+	if refreshed {
+		ui, err := o.getUserInfo(t.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		authResult.Picture = ui.Picture
+		authResult.Name = ui.Name
+		authResult.HostedDomain = ui.domain()
+	}
+	return authResult, nil
+}
+
+// Profile fetches the current profile of accessToken's account. An
+// expired accessToken is refreshed, but not rotated
+func (o *OIDC) Profile(accessToken string) (*models.Profile, error) {
+	current, err := o.refresher.peek(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := o.getUserInfo(current)
+	if err != nil {
+		return nil, err
+	}
+	return ui.profile(), nil
+}
+
+// WithContext returns a new instance of *OIDC using ctx
+func (o OIDC) WithContext(ctx context.Context) Provider {
+	c := &OIDC{
+		config: o.config,
+		repo:   o.repo.WithContext(ctx),
+		client: o.client,
+	}
+	c.refresher = o.refresher.withContext(ctx, c.repo)
+	return c
+}
+
+// NewOIDC ctor
+func NewOIDC(config OIDCConfig, repo *repositories.All) *OIDC {
+	o := &OIDC{
+		config: config,
+		repo:   repo,
+		client: extensionsHttp.New(),
+	}
+	o.refresher = newRefresher(repo, o.refreshToken)
+	return o
+}
@@ -0,0 +1,74 @@
+// +build integration
+
+package oauth2_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/oauth2"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+// newStubOIDCServer answers any code with a token whose userinfo is email,
+// verified or not
+func newStubOIDCServer(email string, verified bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "oidc-token", "token_type": "Bearer",
+		"expires_in": 3600}`)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"email": "%s", "email_verified": %t}`, email, verified)
+	})
+	return httptest.NewServer(mux)
+}
+
+func getOIDC(t *testing.T, server *httptest.Server) oauth2.Provider {
+	t.Helper()
+	return oauth2.NewOIDC(oauth2.OIDCConfig{
+		Name:           "okta",
+		TokenURL:       server.URL + "/token",
+		UserInfoURL:    server.URL + "/userinfo",
+		AllowedDomains: []string{"domain.com"},
+	}, helpers.GetRepo(t)).WithContext(context.Background())
+}
+
+func TestOIDCExchangeCode(t *testing.T) {
+	beforeEachRefresh(t)
+	server := newStubOIDCServer("some@domain.com", true)
+	defer server.Close()
+	authResult, err := getOIDC(t, server).ExchangeCode("some-code")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.Email != "some@domain.com" || authResult.Provider != "okta" {
+		t.Errorf("Expected some@domain.com from okta. Got %+v", *authResult)
+	}
+}
+
+func TestOIDCExchangeCodeRejectsUnverifiedEmail(t *testing.T) {
+	beforeEachRefresh(t)
+	server := newStubOIDCServer("some@domain.com", false)
+	defer server.Close()
+	_, err := getOIDC(t, server).ExchangeCode("some-code")
+	if _, ok := err.(*errors.UnverifiedEmailError); !ok {
+		t.Errorf("Expected UnverifiedEmailError. Got %v", err)
+	}
+}
+
+func TestOIDCExchangeCodeRejectsDomain(t *testing.T) {
+	beforeEachRefresh(t)
+	server := newStubOIDCServer("some@other.com", true)
+	defer server.Close()
+	_, err := getOIDC(t, server).ExchangeCode("some-code")
+	if _, ok := err.(*errors.NonAllowedEmailDomainError); !ok {
+		t.Errorf("Expected NonAllowedEmailDomainError. Got %v", err)
+	}
+}
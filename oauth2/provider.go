@@ -14,7 +14,15 @@ type Provider interface {
 	WithContext(context.Context) Provider
 }
 
-// ProviderBlankMock is a Provider mock will all dummy implementations
+// ProfileProvider is implemented by providers that can fetch an account's
+// profile with one of its stored access tokens, refreshing it if expired,
+// so it can be synced between logins
+type ProfileProvider interface {
+	Profile(string) (*models.Profile, error)
+}
+
+// ProviderBlankMock is a Provider mock will all dummy implementations. It
+// stands for Google
 type ProviderBlankMock struct {
 	Email string
 }
@@ -34,6 +42,7 @@ func (p *ProviderBlankMock) ExchangeCode(any string) (*models.AuthResult, error)
 	return &models.AuthResult{
 		AccessToken: "any",
 		Email:       "any",
+		Provider:    GoogleProviderName,
 	}, nil
 }
 
@@ -46,6 +55,7 @@ func (p *ProviderBlankMock) Authenticate(any string) (*models.AuthResult, error)
 	return &models.AuthResult{
 		AccessToken: any,
 		Email:       email,
+		Provider:    GoogleProviderName,
 	}, nil
 }
 
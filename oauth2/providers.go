@@ -0,0 +1,164 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// Providers holds every configured Provider by name. It implements Provider
+// itself: BuildAuthURL and ExchangeCode go to the default provider and
+// Authenticate is dispatched to the provider that issued the token.
+// Tokens of the issuer are the ones Will.IAM issued itself
+type Providers struct {
+	repo        *repositories.All
+	providers   map[string]Provider
+	names       []string
+	defaultName string
+	issuerName  string
+	issuer      Provider
+}
+
+// NewProviders ctor
+func NewProviders(repo *repositories.All) *Providers {
+	return &Providers{
+		repo:      repo,
+		providers: map[string]Provider{},
+		names:     []string{},
+	}
+}
+
+// Add registers a provider under name. The first provider added is the
+// default one, unless SetDefault is called
+func (ps *Providers) Add(name string, provider Provider) {
+	if _, ok := ps.providers[name]; !ok {
+		ps.names = append(ps.names, name)
+	}
+	ps.providers[name] = provider
+	if ps.defaultName == "" {
+		ps.defaultName = name
+	}
+}
+
+// SetDefault sets which provider is used when none is specified
+func (ps *Providers) SetDefault(name string) error {
+	if _, ok := ps.providers[name]; !ok {
+		return fmt.Errorf("oauth2 provider %s not registered", name)
+	}
+	ps.defaultName = name
+	return nil
+}
+
+// Default returns the default provider name
+func (ps *Providers) Default() string {
+	return ps.defaultName
+}
+
+// Names returns all registered provider names in registration order
+func (ps *Providers) Names() []string {
+	names := make([]string, len(ps.names))
+	copy(names, ps.names)
+	return names
+}
+
+// SetIssuer sets the provider that authenticates tokens whose provider is
+// name. It isn't used to log in, so it isn't listed by Names
+func (ps *Providers) SetIssuer(name string, provider Provider) {
+	ps.issuerName = name
+	ps.issuer = provider
+}
+
+// Get returns a provider by name; empty name means the default provider
+func (ps *Providers) Get(name string) (Provider, error) {
+	if name == "" {
+		name = ps.defaultName
+	}
+	provider, ok := ps.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("oauth2 provider %s not registered", name)
+	}
+	return provider, nil
+}
+
+// BuildAuthURL uses the default provider
+func (ps *Providers) BuildAuthURL(state string) string {
+	provider, err := ps.Get("")
+	if err != nil {
+		return ""
+	}
+	return provider.BuildAuthURL(state)
+}
+
+// ExchangeCode uses the default provider
+func (ps *Providers) ExchangeCode(code string) (*models.AuthResult, error) {
+	provider, err := ps.Get("")
+	if err != nil {
+		return nil, err
+	}
+	return provider.ExchangeCode(code)
+}
+
+// Authenticate finds which provider issued accessToken and asks it to
+// authenticate it
+func (ps *Providers) Authenticate(
+	accessToken string,
+) (*models.AuthResult, error) {
+	if ps.issuer != nil {
+		t, err := ps.repo.Tokens.Find(accessToken)
+		if err == nil && t.Provider == ps.issuerName {
+			return ps.issuer.Authenticate(accessToken)
+		}
+	}
+	if len(ps.names) == 1 {
+		return ps.providers[ps.names[0]].Authenticate(accessToken)
+	}
+	// Find instead of Get: rotated tokens past their grace period must still
+	// reach their provider so reuse is detected
+	t, err := ps.repo.Tokens.Find(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := ps.Get(t.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Authenticate(accessToken)
+}
+
+// Profile asks the provider that issued accessToken for its account's
+// profile
+func (ps *Providers) Profile(accessToken string) (*models.Profile, error) {
+	t, err := ps.repo.Tokens.Find(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := ps.Get(t.Provider)
+	if err != nil {
+		return nil, err
+	}
+	pp, ok := provider.(ProfileProvider)
+	if !ok {
+		return nil, fmt.Errorf("oauth2 provider %s can't fetch profiles", t.Provider)
+	}
+	return pp.Profile(accessToken)
+}
+
+// WithContext returns a new *Providers with every provider using ctx
+func (ps *Providers) WithContext(ctx context.Context) Provider {
+	c := &Providers{
+		repo:        ps.repo.WithContext(ctx),
+		providers:   make(map[string]Provider, len(ps.providers)),
+		names:       ps.names,
+		defaultName: ps.defaultName,
+		issuerName:  ps.issuerName,
+	}
+	if ps.issuer != nil {
+		c.issuer = ps.issuer.WithContext(ctx)
+	}
+	for name, provider := range ps.providers {
+		c.providers[name] = provider.WithContext(ctx)
+	}
+	return c
+}
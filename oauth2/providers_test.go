@@ -0,0 +1,98 @@
+// +build unit
+
+package oauth2_test
+
+import (
+	"testing"
+
+	"github.com/ghostec/Will.IAM/oauth2"
+	"github.com/spf13/viper"
+)
+
+func TestProvidersDefault(t *testing.T) {
+	ps := oauth2.NewProviders(nil)
+	ps.Add("google", oauth2.NewProviderBlankMock())
+	ps.Add("okta", oauth2.NewProviderBlankMock())
+	if ps.Default() != "google" {
+		t.Errorf("Expected default to be google. Got %s", ps.Default())
+	}
+	if err := ps.SetDefault("okta"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if ps.Default() != "okta" {
+		t.Errorf("Expected default to be okta. Got %s", ps.Default())
+	}
+	if err := ps.SetDefault("github"); err == nil {
+		t.Errorf("Expected error setting unregistered provider as default")
+	}
+}
+
+func TestProvidersGet(t *testing.T) {
+	google := oauth2.NewProviderBlankMock()
+	okta := oauth2.NewProviderBlankMock()
+	ps := oauth2.NewProviders(nil)
+	ps.Add("google", google)
+	ps.Add("okta", okta)
+	type testCase struct {
+		name     string
+		provider oauth2.Provider
+		err      bool
+	}
+	tt := []testCase{
+		testCase{name: "", provider: google},
+		testCase{name: "google", provider: google},
+		testCase{name: "okta", provider: okta},
+		testCase{name: "github", err: true},
+	}
+	for _, tt := range tt {
+		provider, err := ps.Get(tt.name)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Expected error for provider %s", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		if provider != tt.provider {
+			t.Errorf("Wrong provider returned for %s", tt.name)
+		}
+	}
+	names := ps.Names()
+	if len(names) != 2 || names[0] != "google" || names[1] != "okta" {
+		t.Errorf("Expected names to be [google okta]. Got %v", names)
+	}
+}
+
+func TestProvidersAuthenticateSingleProvider(t *testing.T) {
+	mock := oauth2.NewProviderBlankMock()
+	mock.Email = "some@email.com"
+	ps := oauth2.NewProviders(nil)
+	ps.Add("google", mock)
+	authResult, err := ps.Authenticate("token")
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+	if authResult.Email != "some@email.com" {
+		t.Errorf("Expected email to be some@email.com. Got %s", authResult.Email)
+	}
+}
+
+func TestNewProvidersFromConfigRequiresOIDCAllowedDomains(t *testing.T) {
+	config := viper.New()
+	config.Set("oauth2.oidc.okta.clientId", "some-client-id")
+	if _, err := oauth2.NewProvidersFromConfig(config, nil); err == nil {
+		t.Errorf("Expected error for OIDC provider without allowedDomains")
+	}
+	config.Set("oauth2.oidc.okta.allowedDomains", []string{"domain.com"})
+	providers, err := oauth2.NewProvidersFromConfig(config, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := providers.Get("okta"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
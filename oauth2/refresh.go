@@ -0,0 +1,223 @@
+package oauth2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// refreshFunc trades a refresh token for a new token at the provider
+type refreshFunc func(refreshToken string) (*GoogleToken, error)
+
+// refresher authenticates access tokens and rotates expired ones.
+// Concurrent refreshes of the same token in this process are collapsed
+// into one call and the token row is locked while it is rotated, so other
+// Will.IAM instances wait for and reuse the rotated token. A rotated token
+// presented after the grace period revokes its whole family
+type refresher struct {
+	repo    *repositories.All
+	ctx     context.Context
+	flights *flightGroup
+	refresh refreshFunc
+}
+
+func newRefresher(repo *repositories.All, refresh refreshFunc) *refresher {
+	return &refresher{
+		repo:    repo,
+		ctx:     context.Background(),
+		flights: newFlightGroup(),
+		refresh: refresh,
+	}
+}
+
+// withContext returns a copy of r using ctx that still shares r's flights
+func (r *refresher) withContext(
+	ctx context.Context, repo *repositories.All,
+) *refresher {
+	return &refresher{
+		repo:    repo,
+		ctx:     ctx,
+		flights: r.flights,
+		refresh: r.refresh,
+	}
+}
+
+// authenticate returns the current token for accessToken and whether it
+// was rotated by this call
+func (r *refresher) authenticate(
+	accessToken string,
+) (*models.Token, bool, error) {
+	t, err := r.repo.Tokens.Get(accessToken)
+	if err == nil && t.IsValid() {
+		return t, false, nil
+	}
+	if err != nil {
+		if _, ok := err.(*errors.EntityNotFoundError); !ok {
+			return nil, false, err
+		}
+	}
+	return r.flights.do(accessToken, func() (*models.Token, bool, error) {
+		return r.rotate(accessToken)
+	})
+}
+
+func (r *refresher) rotate(accessToken string) (*models.Token, bool, error) {
+	var current *models.Token
+	var rotated bool
+	var reusedFamilyID string
+	err := r.repo.WithPGTx(r.ctx, func(repo *repositories.All) error {
+		t, err := repo.Tokens.GetForUpdate(accessToken)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			old, findErr := repo.Tokens.Find(accessToken)
+			if findErr != nil {
+				return findErr
+			}
+			if old.SuccessorID == "" {
+				// revoked, or expired long ago
+				return errors.NewTokenExpiredError()
+			}
+			reusedFamilyID = old.FamilyID
+			return repo.Tokens.RevokeFamily(old.FamilyID)
+		}
+		if err != nil {
+			return err
+		}
+		if t.SuccessorID != "" {
+			current, err = latestSuccessor(repo, t)
+			return err
+		}
+		if !t.ExpiredAt.IsZero() || t.Expiry.After(time.Now().UTC()) {
+			current = t
+			return nil
+		}
+		gt, err := r.refresh(t.RefreshToken)
+		if err != nil {
+			return err
+		}
+		successor := &models.Token{
+			AccessToken:  gt.AccessToken,
+			RefreshToken: gt.RefreshToken,
+			TokenType:    gt.TokenType,
+			Expiry: time.Now().UTC().Add(
+				time.Second * time.Duration(gt.ExpiresIn),
+			),
+			Email:    t.Email,
+			Provider: t.Provider,
+			FamilyID: t.FamilyID,
+		}
+		if successor.RefreshToken == "" {
+			successor.RefreshToken = t.RefreshToken
+		}
+		if err := repo.Tokens.Create(successor); err != nil {
+			return err
+		}
+		if err := repo.Tokens.Rotate(t, successor); err != nil {
+			return err
+		}
+		current, rotated = successor, true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if reusedFamilyID != "" {
+		return nil, false, errors.NewTokenReuseError(reusedFamilyID)
+	}
+	return current, rotated, nil
+}
+
+// peek returns an access token to call the provider with as accessToken's
+// account, for Will.IAM's own use. An expired accessToken is refreshed
+// without being rotated, so its bearer's copy stays the current one and
+// isn't taken as reused later; a refresh token the provider rotated along
+// is kept for the bearer's next refresh. The token row is locked as in
+// rotate, so the two never refresh it at once
+func (r *refresher) peek(accessToken string) (string, error) {
+	var current string
+	err := r.repo.WithPGTx(r.ctx, func(repo *repositories.All) error {
+		t, err := repo.Tokens.GetForUpdate(accessToken)
+		if err != nil {
+			return err
+		}
+		if !t.ExpiredAt.IsZero() {
+			return errors.NewTokenExpiredError()
+		}
+		if t.Expiry.After(time.Now().UTC()) {
+			current = t.AccessToken
+			return nil
+		}
+		gt, err := r.refresh(t.RefreshToken)
+		if err != nil {
+			return err
+		}
+		if gt.RefreshToken != "" && gt.RefreshToken != t.RefreshToken {
+			if err := repo.Tokens.SetRefreshToken(
+				t.ID, gt.RefreshToken,
+			); err != nil {
+				return err
+			}
+		}
+		current = gt.AccessToken
+		return nil
+	})
+	return current, err
+}
+
+// latestSuccessor follows t's successors up to the token currently in use
+func latestSuccessor(
+	repo *repositories.All, t *models.Token,
+) (*models.Token, error) {
+	for t.SuccessorID != "" {
+		next, err := repo.Tokens.GetByID(t.SuccessorID)
+		if err != nil {
+			return nil, err
+		}
+		t = next
+	}
+	return t, nil
+}
+
+type flightCall struct {
+	wg      sync.WaitGroup
+	t       *models.Token
+	rotated bool
+	err     error
+}
+
+// flightGroup makes sure only one fn runs per key at a time; callers
+// arriving while it runs wait and get the same result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flightCall{}}
+}
+
+func (g *flightGroup) do(
+	key string, fn func() (*models.Token, bool, error),
+) (*models.Token, bool, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.t, false, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.t, c.rotated, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.t, c.rotated, c.err
+}
@@ -0,0 +1,197 @@
+// +build integration
+
+package oauth2_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/oauth2"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+// stubTokenEndpoint answers refresh_token grants with a new access token
+// and refresh token per call and counts how many refreshes happened
+type stubTokenEndpoint struct {
+	server    *httptest.Server
+	refreshes int32
+}
+
+func newStubTokenEndpoint() *stubTokenEndpoint {
+	s := &stubTokenEndpoint{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&s.refreshes, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "refreshed-%d", "token_type": "Bearer",
+		"refresh_token": "rotated-%d", "expires_in": 3600}`, n, n)
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"email": "some@email.com", "picture": "pic"}`)
+	})
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+func beforeEachRefresh(t *testing.T) {
+	t.Helper()
+	storage := helpers.GetStorage(t)
+	if _, err := storage.PG.DB.Exec("DELETE FROM tokens"); err != nil {
+		panic(err)
+	}
+}
+
+func getRefreshProvider(
+	t *testing.T, stub *stubTokenEndpoint,
+) oauth2.Provider {
+	t.Helper()
+	return oauth2.NewOIDC(oauth2.OIDCConfig{
+		Name:        "stub",
+		TokenURL:    stub.server.URL + "/token",
+		UserInfoURL: stub.server.URL + "/userinfo",
+	}, helpers.GetRepo(t)).WithContext(context.Background())
+}
+
+func saveExpiredToken(t *testing.T, accessToken string) {
+	t.Helper()
+	if err := helpers.GetRepo(t).Tokens.Save(&models.Token{
+		AccessToken:  accessToken,
+		RefreshToken: "some-refresh-token",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().UTC().Add(-time.Minute),
+		Email:        "some@email.com",
+		Provider:     "stub",
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestRefreshConcurrentRequestsRotateOnce(t *testing.T) {
+	beforeEachRefresh(t)
+	stub := newStubTokenEndpoint()
+	defer stub.server.Close()
+	provider := getRefreshProvider(t, stub)
+	saveExpiredToken(t, "expired")
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	errs := make([]error, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			authResult, err := provider.Authenticate("expired")
+			errs[i] = err
+			if err == nil {
+				results[i] = authResult.AccessToken
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("Unexpected error: %s", errs[i].Error())
+		}
+		if results[i] != "refreshed-1" {
+			t.Errorf("Expected refreshed-1. Got %s", results[i])
+		}
+	}
+	if stub.refreshes != 1 {
+		t.Errorf("Expected 1 refresh. Got %d", stub.refreshes)
+	}
+}
+
+func TestRefreshRotatedTokenWithinGracePeriod(t *testing.T) {
+	beforeEachRefresh(t)
+	stub := newStubTokenEndpoint()
+	defer stub.server.Close()
+	saveExpiredToken(t, "expired")
+	if _, err := getRefreshProvider(t, stub).Authenticate("expired"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// a fresh provider has no in-process state, like another instance would
+	authResult, err := getRefreshProvider(t, stub).Authenticate("expired")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.AccessToken != "refreshed-1" {
+		t.Errorf("Expected refreshed-1. Got %s", authResult.AccessToken)
+	}
+	if stub.refreshes != 1 {
+		t.Errorf("Expected 1 refresh. Got %d", stub.refreshes)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	beforeEachRefresh(t)
+	stub := newStubTokenEndpoint()
+	defer stub.server.Close()
+	provider := getRefreshProvider(t, stub)
+	saveExpiredToken(t, "expired")
+	if _, err := provider.Authenticate("expired"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	storage := helpers.GetStorage(t)
+	if _, err := storage.PG.DB.Exec(
+		`UPDATE tokens SET expired_at = now() - INTERVAL '5 min'
+		WHERE access_token = 'expired'`,
+	); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	_, err := provider.Authenticate("expired")
+	if _, ok := err.(*errors.TokenReuseError); !ok {
+		t.Fatalf("Expected TokenReuseError. Got %v", err)
+	}
+	_, err = provider.Authenticate("refreshed-1")
+	if _, ok := err.(*errors.TokenExpiredError); !ok {
+		t.Errorf("Expected successor to be revoked. Got %v", err)
+	}
+	_, err = provider.Authenticate("unknown")
+	if _, ok := err.(*errors.EntityNotFoundError); !ok {
+		t.Errorf("Expected unknown token not to be found. Got %v", err)
+	}
+}
+
+// TestRefreshProfileKeepsOwnersToken checks fetching a profile with an
+// expired token refreshes it without rotating it, so its owner can still
+// use and refresh it afterwards
+func TestRefreshProfileKeepsOwnersToken(t *testing.T) {
+	beforeEachRefresh(t)
+	stub := newStubTokenEndpoint()
+	defer stub.server.Close()
+	provider := getRefreshProvider(t, stub)
+	saveExpiredToken(t, "expired")
+	profile, err := provider.(oauth2.ProfileProvider).Profile("expired")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if profile.Picture != "pic" {
+		t.Errorf("Expected picture pic. Got %s", profile.Picture)
+	}
+	token, err := helpers.GetRepo(t).Tokens.Get("expired")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !token.ExpiredAt.IsZero() || token.SuccessorID != "" {
+		t.Errorf("Expected token not to be rotated. Got %+v", token)
+	}
+	if token.RefreshToken != "rotated-1" {
+		t.Errorf("Expected rotated-1 refresh token. Got %s", token.RefreshToken)
+	}
+	authResult, err := provider.Authenticate("expired")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if authResult.AccessToken != "refreshed-2" {
+		t.Errorf("Expected refreshed-2. Got %s", authResult.AccessToken)
+	}
+}
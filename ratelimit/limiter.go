@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// LimiterConfig configures a Limiter. Every key may make Requests per
+// Window, or Overrides[key] if set; a limit of 0 means unlimited
+type LimiterConfig struct {
+	Requests  int
+	Window    time.Duration
+	Overrides map[string]int
+}
+
+// Limiter rate limits requests by key
+type Limiter interface {
+	// Allow takes one request of key, returning false and how long until
+	// the next one is allowed if key is over its limit
+	Allow(string) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryLimiter is a token bucket per key: a bucket holds up to limit
+// tokens and refills limit tokens per Window, so bursts up to limit are
+// allowed
+type memoryLimiter struct {
+	mu        sync.Mutex
+	config    LimiterConfig
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter ctor; state lives in this process only
+func NewMemoryLimiter(config LimiterConfig) Limiter {
+	return &memoryLimiter{
+		config:    config,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (ml *memoryLimiter) limit(key string) int {
+	if limit, ok := ml.config.Overrides[key]; ok {
+		return limit
+	}
+	return ml.config.Requests
+}
+
+func (ml *memoryLimiter) Allow(key string) (bool, time.Duration) {
+	limit := ml.limit(key)
+	if limit <= 0 || ml.config.Window <= 0 {
+		return true, 0
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	ml.sweep(now)
+	perSecond := float64(limit) / ml.config.Window.Seconds()
+	b, ok := ml.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit), last: now}
+		ml.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * perSecond
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / perSecond
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets idle for a Window, which are full again anyway
+func (ml *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < ml.config.Window {
+		return
+	}
+	ml.lastSweep = now
+	for key, b := range ml.buckets {
+		if now.Sub(b.last) >= ml.config.Window {
+			delete(ml.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// LockoutConfig configures a Lockout. After MaxFailures failed attempts a
+// key is locked for BaseDelay, doubling with every further failure up to
+// MaxDelay. Failures are forgotten ResetAfter the last one. MaxFailures 0
+// disables the lockout
+type LockoutConfig struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	ResetAfter  time.Duration
+}
+
+// Lockout counts failed attempts by key and locks keys out with
+// exponential backoff
+type Lockout interface {
+	// Locked returns for how long key is still locked out
+	Locked(string) time.Duration
+	// Fail records a failed attempt of key and returns for how long it is
+	// locked out because of it
+	Fail(string) time.Duration
+	// Reset forgets the failures of key
+	Reset(string)
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type memoryLockout struct {
+	mu        sync.Mutex
+	config    LockoutConfig
+	entries   map[string]*lockoutEntry
+	lastSweep time.Time
+}
+
+// NewMemoryLockout ctor; state lives in this process only
+func NewMemoryLockout(config LockoutConfig) Lockout {
+	return &memoryLockout{
+		config:    config,
+		entries:   map[string]*lockoutEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (ml *memoryLockout) Locked(key string) time.Duration {
+	if ml.config.MaxFailures <= 0 {
+		return 0
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	e, ok := ml.entries[key]
+	if !ok {
+		return 0
+	}
+	return remaining(e.lockedUntil, time.Now())
+}
+
+func (ml *memoryLockout) Fail(key string) time.Duration {
+	if ml.config.MaxFailures <= 0 {
+		return 0
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	ml.sweep(now)
+	e, ok := ml.entries[key]
+	if !ok || ml.expired(e, now) {
+		e = &lockoutEntry{}
+		ml.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	if e.failures < ml.config.MaxFailures {
+		return 0
+	}
+	e.lockedUntil = now.Add(ml.delay(e.failures - ml.config.MaxFailures))
+	return remaining(e.lockedUntil, now)
+}
+
+func (ml *memoryLockout) Reset(key string) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	delete(ml.entries, key)
+}
+
+// delay is BaseDelay * 2^extra, capped at MaxDelay
+func (ml *memoryLockout) delay(extra int) time.Duration {
+	d := ml.config.BaseDelay
+	for i := 0; i < extra && d < ml.config.MaxDelay; i++ {
+		d *= 2
+	}
+	if ml.config.MaxDelay > 0 && d > ml.config.MaxDelay {
+		return ml.config.MaxDelay
+	}
+	return d
+}
+
+// sweep drops entries that would be reset anyway, at most once every
+// ResetAfter, so keys that are never retried don't pile up
+func (ml *memoryLockout) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < ml.config.ResetAfter {
+		return
+	}
+	ml.lastSweep = now
+	for key, e := range ml.entries {
+		if ml.expired(e, now) {
+			delete(ml.entries, key)
+		}
+	}
+}
+
+func (ml *memoryLockout) expired(e *lockoutEntry, now time.Time) bool {
+	return now.Sub(e.lastFailure) > ml.config.ResetAfter &&
+		!now.Before(e.lockedUntil)
+}
+
+func remaining(until, now time.Time) time.Duration {
+	if !now.Before(until) {
+		return 0
+	}
+	return until.Sub(now)
+}
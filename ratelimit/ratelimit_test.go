@@ -0,0 +1,140 @@
+// +build unit
+
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/ratelimit"
+)
+
+func TestLockoutLocksAfterMaxFailures(t *testing.T) {
+	l := ratelimit.NewMemoryLockout(ratelimit.LockoutConfig{
+		MaxFailures: 3,
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Hour,
+		ResetAfter:  time.Hour,
+	})
+	for i := 0; i < 2; i++ {
+		if d := l.Fail("key"); d != 0 {
+			t.Fatalf("Expected no lockout after %d failures. Got %v", i+1, d)
+		}
+	}
+	if d := l.Locked("key"); d != 0 {
+		t.Errorf("Expected key not to be locked. Got %v", d)
+	}
+	if d := l.Fail("key"); d <= 0 || d > time.Minute {
+		t.Errorf("Expected lockout of up to 1m. Got %v", d)
+	}
+	if d := l.Locked("key"); d <= 0 {
+		t.Errorf("Expected key to be locked")
+	}
+	if d := l.Locked("other"); d != 0 {
+		t.Errorf("Expected other key not to be locked. Got %v", d)
+	}
+	l.Reset("key")
+	if d := l.Locked("key"); d != 0 {
+		t.Errorf("Expected reset key not to be locked. Got %v", d)
+	}
+}
+
+func TestLockoutBacksOffExponentially(t *testing.T) {
+	l := ratelimit.NewMemoryLockout(ratelimit.LockoutConfig{
+		MaxFailures: 1,
+		BaseDelay:   time.Minute,
+		MaxDelay:    5 * time.Minute,
+		ResetAfter:  time.Hour,
+	})
+	expected := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute,
+		5 * time.Minute,
+	}
+	for i := range expected {
+		d := l.Fail("key")
+		if d > expected[i] || d < expected[i]-time.Second {
+			t.Errorf("Expected failure %d to lock for %v. Got %v", i+1, expected[i], d)
+		}
+	}
+}
+
+func TestLockoutForgetsOldFailures(t *testing.T) {
+	l := ratelimit.NewMemoryLockout(ratelimit.LockoutConfig{
+		MaxFailures: 2,
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Hour,
+		ResetAfter:  10 * time.Millisecond,
+	})
+	l.Fail("key")
+	time.Sleep(20 * time.Millisecond)
+	if d := l.Fail("key"); d != 0 {
+		t.Errorf("Expected old failure to be forgotten. Got lockout of %v", d)
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	l := ratelimit.NewMemoryLockout(ratelimit.LockoutConfig{})
+	for i := 0; i < 10; i++ {
+		l.Fail("key")
+	}
+	if d := l.Locked("key"); d != 0 {
+		t.Errorf("Expected disabled lockout to never lock. Got %v", d)
+	}
+}
+
+func TestLimiterAllowsUpToLimit(t *testing.T) {
+	l := ratelimit.NewMemoryLimiter(ratelimit.LimiterConfig{
+		Requests: 3,
+		Window:   time.Minute,
+	})
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("key"); !ok {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+	ok, retry := l.Allow("key")
+	if ok {
+		t.Fatalf("Expected request over the limit to be denied")
+	}
+	if retry <= 0 || retry > 20*time.Second {
+		t.Errorf("Expected retry within 20s. Got %v", retry)
+	}
+	if ok, _ := l.Allow("other"); !ok {
+		t.Errorf("Expected other key to be allowed")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	l := ratelimit.NewMemoryLimiter(ratelimit.LimiterConfig{
+		Requests: 1,
+		Window:   20 * time.Millisecond,
+	})
+	if ok, _ := l.Allow("key"); !ok {
+		t.Fatalf("Expected first request to be allowed")
+	}
+	if ok, _ := l.Allow("key"); ok {
+		t.Fatalf("Expected second request to be denied")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := l.Allow("key"); !ok {
+		t.Errorf("Expected request after a window to be allowed")
+	}
+}
+
+func TestLimiterOverrides(t *testing.T) {
+	l := ratelimit.NewMemoryLimiter(ratelimit.LimiterConfig{
+		Requests:  1,
+		Window:    time.Minute,
+		Overrides: map[string]int{"big": 5, "free": 0},
+	})
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("big"); !ok {
+			t.Fatalf("Expected request %d of overridden key to be allowed", i+1)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow("free"); !ok {
+			t.Fatalf("Expected unlimited key to be allowed")
+		}
+	}
+}
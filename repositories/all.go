@@ -8,10 +8,17 @@ import (
 
 // All holds a reference to each possible repository interface
 type All struct {
+	Audit           Audit
+	OAuth2Clients   OAuth2Clients
+	OAuth2Codes     OAuth2Codes
+	OAuth2Consents  OAuth2Consents
 	Permissions     Permissions
 	Roles           Roles
 	ServiceAccounts ServiceAccounts
+	ServiceActions  ServiceActions
+	ServiceOwners   ServiceOwners
 	Services        Services
+	TOTPSecrets     TOTPSecrets
 	Tokens          Tokens
 	Healthcheck     Healthcheck
 	storage         *Storage
@@ -20,10 +27,17 @@ type All struct {
 // New All ctor
 func New(s *Storage) *All {
 	return &All{
+		Audit:           NewAudit(s),
+		OAuth2Clients:   NewOAuth2Clients(s),
+		OAuth2Codes:     NewOAuth2Codes(s),
+		OAuth2Consents:  NewOAuth2Consents(s),
 		Permissions:     NewPermissions(s),
 		Roles:           NewRoles(s),
 		ServiceAccounts: NewServiceAccounts(s),
+		ServiceActions:  NewServiceActions(s),
+		ServiceOwners:   NewServiceOwners(s),
 		Services:        NewServices(s),
+		TOTPSecrets:     NewTOTPSecrets(s),
 		Tokens:          NewTokens(s),
 		Healthcheck:     NewHealthcheck(s),
 		storage:         s,
@@ -59,17 +73,31 @@ func (a *All) WithPGTx(ctx context.Context, fn func(repo *All) error) error {
 
 func (a *All) cloneWithStorage(s *Storage) *All {
 	c := &All{
+		Audit:           a.Audit.Clone(),
+		OAuth2Clients:   a.OAuth2Clients.Clone(),
+		OAuth2Codes:     a.OAuth2Codes.Clone(),
+		OAuth2Consents:  a.OAuth2Consents.Clone(),
 		Permissions:     a.Permissions.Clone(),
 		Roles:           a.Roles.Clone(),
 		ServiceAccounts: a.ServiceAccounts.Clone(),
+		ServiceActions:  a.ServiceActions.Clone(),
+		ServiceOwners:   a.ServiceOwners.Clone(),
 		Services:        a.Services.Clone(),
+		TOTPSecrets:     a.TOTPSecrets.Clone(),
 		Tokens:          a.Tokens.Clone(),
 		storage:         s,
 	}
+	c.Audit.setStorage(s)
+	c.OAuth2Clients.setStorage(s)
+	c.OAuth2Codes.setStorage(s)
+	c.OAuth2Consents.setStorage(s)
 	c.Permissions.setStorage(s)
 	c.Roles.setStorage(s)
 	c.ServiceAccounts.setStorage(s)
+	c.ServiceActions.setStorage(s)
+	c.ServiceOwners.setStorage(s)
 	c.Services.setStorage(s)
+	c.TOTPSecrets.setStorage(s)
 	c.Tokens.setStorage(s)
 	return c
 }
@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/gofrs/uuid"
+)
+
+// auditChainLock is the advisory lock entries are appended under, so each
+// is chained to the one before
+const auditChainLock = 4015770
+
+// Audit repository; it can only append to the audit log
+type Audit interface {
+	Chain(int64, int) ([]models.AuditEntry, error)
+	Checkpoints() ([]models.AuditCheckpoint, error)
+	Clone() Audit
+	Create(*models.AuditEntry) error
+	CreateCheckpoint(*models.AuditCheckpoint) error
+	Head() (*models.AuditEntry, error)
+	LastCheckpoint() (*models.AuditCheckpoint, error)
+	List(*models.AuditFilter, *ListOptions) ([]models.AuditEntry, error)
+	ListCount(*models.AuditFilter) (int64, error)
+	setStorage(*Storage)
+}
+
+type audit struct {
+	*withStorage
+}
+
+func (a *audit) Clone() Audit {
+	return NewAudit(a.storage.Clone())
+}
+
+// Create appends e to the audit log, chained to its head. It must be
+// called within a transaction, until whose end appends wait for it
+func (a audit) Create(e *models.AuditEntry) error {
+	if _, err := a.storage.PG.DB.Exec(
+		`SELECT pg_advisory_xact_lock(?)`, auditChainLock,
+	); err != nil {
+		return err
+	}
+	head, err := a.Head()
+	if err != nil {
+		return err
+	}
+	e.ID = uuid.Must(uuid.NewV4()).String()
+	e.Seq = head.Seq + 1
+	e.PrevHash = head.Hash
+	// stored timestamps keep microseconds, and hashes must survive reading
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if e.Hash, err = e.ComputeHash(); err != nil {
+		return err
+	}
+	_, err = a.storage.PG.DB.Exec(
+		`INSERT INTO audit_log (id, seq, actor_id, impersonator_id, action,
+		target_type, target_id, before, after, request_id, prev_hash, hash,
+		created_at) VALUES (?id, ?seq, NULLIF(?actor_id, '')::uuid,
+		NULLIF(?impersonator_id, '')::uuid, ?action, ?target_type, ?target_id,
+		?before, ?after, ?request_id, ?prev_hash, ?hash, ?created_at)`, e,
+	)
+	return err
+}
+
+// Head returns the audit log's last entry; an empty log's is the zero
+// entry
+func (a audit) Head() (*models.AuditEntry, error) {
+	e := new(models.AuditEntry)
+	if _, err := a.storage.PG.DB.Query(
+		e, `SELECT * FROM audit_log ORDER BY seq DESC LIMIT 1`,
+	); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Chain lists up to limit audit entries after seq, in chain order
+func (a audit) Chain(seq int64, limit int) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	if _, err := a.storage.PG.DB.Query(
+		&entries, `SELECT * FROM audit_log WHERE seq > ? ORDER BY seq LIMIT ?`,
+		seq, limit,
+	); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CreateCheckpoint appends c to the audit checkpoints
+func (a audit) CreateCheckpoint(c *models.AuditCheckpoint) error {
+	_, err := a.storage.PG.DB.Query(
+		c, `INSERT INTO audit_checkpoints (seq, hash, signature, created_at)
+		VALUES (?seq, ?hash, ?signature, ?created_at) RETURNING id`, c,
+	)
+	return err
+}
+
+// LastCheckpoint returns the checkpoint of the latest entry; without
+// checkpoints it's the zero checkpoint
+func (a audit) LastCheckpoint() (*models.AuditCheckpoint, error) {
+	c := new(models.AuditCheckpoint)
+	if _, err := a.storage.PG.DB.Query(
+		c, `SELECT * FROM audit_checkpoints ORDER BY seq DESC, created_at DESC
+		LIMIT 1`,
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Checkpoints lists every audit checkpoint, in chain order
+func (a audit) Checkpoints() ([]models.AuditCheckpoint, error) {
+	cs := []models.AuditCheckpoint{}
+	if _, err := a.storage.PG.DB.Query(
+		&cs, `SELECT * FROM audit_checkpoints ORDER BY seq, created_at`,
+	); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+const auditFilterCondition = `(?0 = '' OR actor_id::text = ?0)
+	AND (?1 = '' OR action = ?1) AND (?2 = '' OR target_type = ?2)
+	AND (?3 = '' OR target_id = ?3) AND (?4 = '' OR request_id = ?4)
+	AND (?5 OR created_at >= ?6) AND (?7 OR created_at < ?8)`
+
+func auditFilterParams(f *models.AuditFilter) []interface{} {
+	return []interface{}{
+		f.ActorID, f.Action, f.TargetType, f.TargetID, f.RequestID,
+		f.Since.IsZero(), f.Since, f.Until.IsZero(), f.Until,
+	}
+}
+
+// List audit entries matching f, newest first
+func (a audit) List(
+	f *models.AuditFilter, lo *ListOptions,
+) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	params := append(auditFilterParams(f), lo.Limit(), lo.Offset())
+	if _, err := a.storage.PG.DB.Query(
+		&entries, `SELECT * FROM audit_log WHERE `+auditFilterCondition+`
+		ORDER BY seq DESC LIMIT ?9 OFFSET ?10`, params...,
+	); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ListCount counts audit entries matching f
+func (a audit) ListCount(f *models.AuditFilter) (int64, error) {
+	var count int64
+	if _, err := a.storage.PG.DB.Query(
+		&count, `SELECT count(*) FROM audit_log WHERE `+auditFilterCondition,
+		auditFilterParams(f)...,
+	); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// NewAudit ctor
+func NewAudit(s *Storage) Audit {
+	return &audit{&withStorage{storage: s}}
+}
@@ -1,5 +1,7 @@
 package repositories
 
+import "strings"
+
 // ListOptions is used by repo.ListOptions functions
 type ListOptions struct {
 	PageSize int
@@ -15,3 +17,11 @@ func (lo ListOptions) Limit() int {
 func (lo ListOptions) Offset() int {
 	return lo.PageSize * lo.Page
 }
+
+// likePrefix builds a LIKE pattern matching what starts with prefix, with
+// prefix's wildcards escaped
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
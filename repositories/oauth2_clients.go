@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+)
+
+// OAuth2Clients repository
+type OAuth2Clients interface {
+	Clone() OAuth2Clients
+	Create(*models.OAuth2Client) error
+	Delete(string) error
+	ForService(string) ([]models.OAuth2Client, error)
+	Get(string) (*models.OAuth2Client, error)
+	setStorage(*Storage)
+}
+
+type oauth2Clients struct {
+	*withStorage
+}
+
+func (cs *oauth2Clients) Clone() OAuth2Clients {
+	return NewOAuth2Clients(cs.storage.Clone())
+}
+
+func (cs oauth2Clients) Create(c *models.OAuth2Client) error {
+	_, err := cs.storage.PG.DB.Query(
+		c, `INSERT INTO oauth2_clients (client_id, client_secret, service_id,
+		name, redirect_uris) VALUES (?client_id, ?client_secret, ?service_id,
+		?name, ?redirect_uris) RETURNING id`, c,
+	)
+	return err
+}
+
+// Get retrieves a client by its client_id
+func (cs oauth2Clients) Get(clientID string) (*models.OAuth2Client, error) {
+	c := new(models.OAuth2Client)
+	if _, err := cs.storage.PG.DB.Query(
+		c, `SELECT * FROM oauth2_clients WHERE client_id = ?`, clientID,
+	); err != nil {
+		return nil, err
+	}
+	if c.ID == "" {
+		return nil, errors.NewEntityNotFoundError(models.OAuth2Client{}, clientID)
+	}
+	c.Confidential = c.ClientSecret != ""
+	return c, nil
+}
+
+func (cs oauth2Clients) ForService(
+	serviceID string,
+) ([]models.OAuth2Client, error) {
+	cSl := []models.OAuth2Client{}
+	if _, err := cs.storage.PG.DB.Query(
+		&cSl, `SELECT * FROM oauth2_clients WHERE service_id = ?
+		ORDER BY name ASC`, serviceID,
+	); err != nil {
+		return nil, err
+	}
+	for i := range cSl {
+		cSl[i].Confidential = cSl[i].ClientSecret != ""
+	}
+	return cSl, nil
+}
+
+// Delete removes a client by its client_id; codes and consents are removed
+// by cascade
+func (cs oauth2Clients) Delete(clientID string) error {
+	_, err := cs.storage.PG.DB.Exec(
+		`DELETE FROM oauth2_clients WHERE client_id = ?`, clientID,
+	)
+	return err
+}
+
+// NewOAuth2Clients ctor
+func NewOAuth2Clients(s *Storage) OAuth2Clients {
+	return &oauth2Clients{&withStorage{storage: s}}
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+)
+
+// OAuth2Codes repository
+type OAuth2Codes interface {
+	Clone() OAuth2Codes
+	Create(*models.OAuth2AuthorizationCode) error
+	GetForUpdate(string) (*models.OAuth2AuthorizationCode, error)
+	MarkUsed(*models.OAuth2AuthorizationCode) error
+	setStorage(*Storage)
+}
+
+type oauth2Codes struct {
+	*withStorage
+}
+
+func (cs *oauth2Codes) Clone() OAuth2Codes {
+	return NewOAuth2Codes(cs.storage.Clone())
+}
+
+func (cs oauth2Codes) Create(ac *models.OAuth2AuthorizationCode) error {
+	_, err := cs.storage.PG.DB.Exec(
+		`INSERT INTO oauth2_codes (code, client_id, service_account_id,
+		redirect_uri, code_challenge, code_challenge_method, expires_at)
+		VALUES (?code, ?client_id, ?service_account_id, ?redirect_uri,
+		?code_challenge, ?code_challenge_method, ?expires_at)`, ac,
+	)
+	return err
+}
+
+// GetForUpdate retrieves a code, used or not, and locks it until the end
+// of the current transaction
+func (cs oauth2Codes) GetForUpdate(
+	code string,
+) (*models.OAuth2AuthorizationCode, error) {
+	ac := new(models.OAuth2AuthorizationCode)
+	if _, err := cs.storage.PG.DB.Query(
+		ac, `SELECT * FROM oauth2_codes WHERE code = ? FOR UPDATE`, code,
+	); err != nil {
+		return nil, err
+	}
+	if ac.Code == "" {
+		return nil, errors.NewEntityNotFoundError(
+			models.OAuth2AuthorizationCode{}, code,
+		)
+	}
+	return ac, nil
+}
+
+// MarkUsed records that ac was traded for the tokens of ac.FamilyID
+func (cs oauth2Codes) MarkUsed(ac *models.OAuth2AuthorizationCode) error {
+	_, err := cs.storage.PG.DB.Exec(
+		`UPDATE oauth2_codes SET used_at = now(), family_id = ?family_id
+		WHERE code = ?code`, ac,
+	)
+	return err
+}
+
+// NewOAuth2Codes ctor
+func NewOAuth2Codes(s *Storage) OAuth2Codes {
+	return &oauth2Codes{&withStorage{storage: s}}
+}
@@ -0,0 +1,43 @@
+package repositories
+
+// OAuth2Consents repository
+type OAuth2Consents interface {
+	Clone() OAuth2Consents
+	Create(string, string) error
+	Exists(string, string) (bool, error)
+	setStorage(*Storage)
+}
+
+type oauth2Consents struct {
+	*withStorage
+}
+
+func (cs *oauth2Consents) Clone() OAuth2Consents {
+	return NewOAuth2Consents(cs.storage.Clone())
+}
+
+// Create records that saID consented to clientID getting its tokens
+func (cs oauth2Consents) Create(clientID, saID string) error {
+	_, err := cs.storage.PG.DB.Exec(
+		`INSERT INTO oauth2_consents (client_id, service_account_id)
+		VALUES (?, ?) ON CONFLICT (client_id, service_account_id) DO NOTHING`,
+		clientID, saID,
+	)
+	return err
+}
+
+func (cs oauth2Consents) Exists(clientID, saID string) (bool, error) {
+	var count int64
+	if _, err := cs.storage.PG.DB.Query(
+		&count, `SELECT count(*) FROM oauth2_consents
+		WHERE client_id = ? AND service_account_id = ?`, clientID, saID,
+	); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// NewOAuth2Consents ctor
+func NewOAuth2Consents(s *Storage) OAuth2Consents {
+	return &oauth2Consents{&withStorage{storage: s}}
+}
@@ -14,6 +14,7 @@ type Permissions interface {
 	CreateRequest(string, *models.PermissionRequest) error
 	GetPermissionRequests(string) ([]models.PermissionRequest, error)
 	Delete(string) error
+	DeleteFromRole(string, models.Permission) error
 	Clone() Permissions
 	setStorage(*Storage)
 }
@@ -112,6 +113,16 @@ func (ps *permissions) Delete(id string) error {
 	return err
 }
 
+// DeleteFromRole removes p from role roleID, if it's there
+func (ps *permissions) DeleteFromRole(roleID string, p models.Permission) error {
+	_, err := ps.storage.PG.DB.Exec(
+		`DELETE FROM permissions WHERE role_id = ? AND service = ?
+		AND ownership_level = ? AND action = ? AND resource_hierarchy = ?`,
+		roleID, p.Service, p.OwnershipLevel, p.Action, p.ResourceHierarchy,
+	)
+	return err
+}
+
 // NewPermissions users ctor
 func NewPermissions(s *Storage) Permissions {
 	return &permissions{&withStorage{storage: s}}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
+	"github.com/go-pg/pg"
 )
 
 // Roles repository
@@ -12,17 +13,24 @@ type Roles interface {
 	Bind(*models.RoleBinding) error
 	Clone() Roles
 	Create(*models.Role) error
+	Delete(string) error
 	DropBindings(string) error
 	DropPermissions(string) error
 	ForServiceAccountID(string) ([]models.Role, error)
 	Get(string) (*models.Role, error)
+	GetByName(string) (*models.Role, error)
 	GetServiceAccounts(string) ([]models.ServiceAccount, error)
 	List(*ListOptions) ([]models.Role, error)
 	ListCount() (int64, error)
+	ListSCIMManaged(*ListOptions) ([]models.Role, error)
+	ListSCIMManagedCount() (int64, error)
+	ManagedBindings(string) ([]models.RoleBinding, error)
 	Search(string, *ListOptions) ([]models.Role, error)
 	SearchCount(string) (int64, error)
+	Unbind(*models.RoleBinding) error
 	Update(*models.Role) error
 	WithNamePrefix(string, int) ([]models.Role, error)
+	SearchPrefix(string, []string, *ListOptions) ([]models.Role, error)
 	setStorage(*Storage)
 }
 
@@ -69,8 +77,9 @@ func (rs roles) ForServiceAccountID(serviceAccountID string) ([]models.Role, err
 
 func (rs roles) Create(r *models.Role) error {
 	_, err := rs.storage.PG.DB.Query(
-		r, `INSERT INTO roles (name, is_base_role) VALUES (?name, ?is_base_role)
-		RETURNING id`, r,
+		r, `INSERT INTO roles (name, is_base_role, service_id, scim_managed)
+		VALUES (?name, ?is_base_role, NULLIF(?service_id, '')::uuid,
+		?scim_managed) RETURNING id`, r,
 	)
 	return err
 }
@@ -78,19 +87,42 @@ func (rs roles) Create(r *models.Role) error {
 func (rs roles) Update(r *models.Role) error {
 	// tx, err := rs.storage.PG.Begin(rs.storage.PG.DB)
 	_, err := rs.storage.PG.DB.Query(
-		r, `UPDATE roles SET name = ?name WHERE id = ?id`, r,
+		r, `UPDATE roles SET name = ?name, scim_managed = ?scim_managed
+		WHERE id = ?id`, r,
 	)
 	return err
 }
 
 func (rs roles) Bind(rb *models.RoleBinding) error {
 	_, err := rs.storage.PG.DB.Exec(
-		`INSERT INTO role_bindings (role_id, service_account_id)
-		VALUES (?role_id, ?service_account_id)`, rb,
+		`INSERT INTO role_bindings (role_id, service_account_id, managed)
+		VALUES (?role_id, ?service_account_id, ?managed)
+		ON CONFLICT (service_account_id, role_id) DO NOTHING`, rb,
 	)
 	return err
 }
 
+func (rs roles) Unbind(rb *models.RoleBinding) error {
+	_, err := rs.storage.PG.DB.Exec(
+		`DELETE FROM role_bindings WHERE role_id = ?role_id
+		AND service_account_id = ?service_account_id`, rb,
+	)
+	return err
+}
+
+// ManagedBindings returns all role bindings of a service account that were
+// granted through group mappings
+func (rs roles) ManagedBindings(saID string) ([]models.RoleBinding, error) {
+	var rbs []models.RoleBinding
+	if _, err := rs.storage.PG.DB.Query(
+		&rbs, `SELECT id, role_id, service_account_id, managed FROM role_bindings
+		WHERE service_account_id = ? AND managed = true`, saID,
+	); err != nil {
+		return nil, err
+	}
+	return rbs, nil
+}
+
 func (rs roles) WithNamePrefix(
 	prefix string, maxResults int,
 ) ([]models.Role, error) {
@@ -104,6 +136,23 @@ func (rs roles) WithNamePrefix(
 	return rsSl, nil
 }
 
+// SearchPrefix lists roles whose name or id starts with prefix, only the
+// ones in ids unless ids is nil
+func (rs roles) SearchPrefix(
+	prefix string, ids []string, lo *ListOptions,
+) ([]models.Role, error) {
+	rsSl := []models.Role{}
+	if _, err := rs.storage.PG.DB.Query(
+		&rsSl, `SELECT id, name FROM roles
+		WHERE (name ILIKE ?0 OR id::text LIKE ?0) AND (?1 OR id = ANY(?2))
+		ORDER BY name ASC, id ASC LIMIT ?3 OFFSET ?4`,
+		likePrefix(prefix), ids == nil, pg.Array(ids), lo.Limit(), lo.Offset(),
+	); err != nil {
+		return nil, err
+	}
+	return rsSl, nil
+}
+
 func (rs roles) List(lo *ListOptions) ([]models.Role, error) {
 	var rsSl []models.Role
 	if _, err := rs.storage.PG.DB.Query(
@@ -125,6 +174,29 @@ func (rs roles) ListCount() (int64, error) {
 	return count, nil
 }
 
+func (rs roles) ListSCIMManaged(lo *ListOptions) ([]models.Role, error) {
+	var rsSl []models.Role
+	if _, err := rs.storage.PG.DB.Query(
+		&rsSl, `SELECT id, name, scim_managed FROM roles
+		WHERE is_base_role = false AND scim_managed = true
+		ORDER BY name ASC LIMIT ? OFFSET ?`, lo.Limit(), lo.Offset(),
+	); err != nil {
+		return nil, err
+	}
+	return rsSl, nil
+}
+
+func (rs roles) ListSCIMManagedCount() (int64, error) {
+	var count int64
+	if _, err := rs.storage.PG.DB.Query(
+		&count, `SELECT count(*) FROM roles
+		WHERE is_base_role = false AND scim_managed = true`,
+	); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (rs roles) Search(term string, lo *ListOptions) ([]models.Role, error) {
 	var rsSl []models.Role
 	if _, err := rs.storage.PG.DB.Query(
@@ -153,7 +225,8 @@ func (rs roles) SearchCount(term string) (int64, error) {
 func (rs roles) Get(id string) (*models.Role, error) {
 	r := new(models.Role)
 	if _, err := rs.storage.PG.DB.Query(
-		r, `SELECT id, name, is_base_role, created_at, updated_at
+		r, `SELECT id, name, is_base_role, service_id, scim_managed, created_at,
+		updated_at
 		FROM roles WHERE id = ?`, id,
 	); err != nil {
 		return nil, err
@@ -164,6 +237,27 @@ func (rs roles) Get(id string) (*models.Role, error) {
 	return r, nil
 }
 
+func (rs roles) GetByName(name string) (*models.Role, error) {
+	r := new(models.Role)
+	if _, err := rs.storage.PG.DB.Query(
+		r, `SELECT id, name, is_base_role, service_id, scim_managed, created_at,
+		updated_at
+		FROM roles WHERE name = ?`, name,
+	); err != nil {
+		return nil, err
+	}
+	if r.ID == "" {
+		return nil, errors.NewEntityNotFoundError(models.Role{}, name)
+	}
+	return r, nil
+}
+
+// Delete removes a role; its permissions and bindings are removed by cascade
+func (rs roles) Delete(id string) error {
+	_, err := rs.storage.PG.DB.Exec(`DELETE FROM roles WHERE id = ?`, id)
+	return err
+}
+
 func (rs roles) DropPermissions(roleID string) error {
 	_, err := rs.storage.PG.DB.Exec(
 		`DELETE FROM permissions WHERE role_id = ?`, roleID,
@@ -173,7 +267,7 @@ func (rs roles) DropPermissions(roleID string) error {
 
 func (rs roles) DropBindings(roleID string) error {
 	_, err := rs.storage.PG.DB.Exec(
-		`DELETE FROM role_bindings WHERE role_id = ?`, roleID,
+		`DELETE FROM role_bindings WHERE role_id = ? AND managed = false`, roleID,
 	)
 	return err
 }
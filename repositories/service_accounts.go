@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
@@ -10,18 +11,29 @@ import (
 
 // ServiceAccounts repository
 type ServiceAccounts interface {
+	BindOAuth2Provider(string, string) (bool, error)
 	Clone() ServiceAccounts
 	Create(*models.ServiceAccount) error
+	Delete(string) error
 	DropBindings(string) error
 	ForEmail(string) (*models.ServiceAccount, error)
 	ForEmails([]string) ([]models.ServiceAccount, error)
+	ForCertificateSubjects([]string) ([]models.ServiceAccount, error)
 	ForKeyPair(string, string) (*models.ServiceAccount, error)
 	Get(string) (*models.ServiceAccount, error)
 	List(*ListOptions) ([]models.ServiceAccount, error)
 	ListCount() (int64, error)
+	ListProfileStale(time.Duration, int) ([]models.ServiceAccount, error)
+	ListWithEmail(*ListOptions) ([]models.ServiceAccount, error)
+	ListWithEmailCount() (int64, error)
 	Search(string, *ListOptions) ([]models.ServiceAccount, error)
 	SearchCount(string) (int64, error)
+	SearchPrefix(string, []string, *ListOptions) ([]models.ServiceAccount, error)
+	MarkProfileSynced(string) error
+	RotateKeyPair(string, string, string, time.Duration) error
+	SetLastLoginAt(string) error
 	Update(*models.ServiceAccount) error
+	UpdateProfile(string, models.Profile) error
 	setStorage(*Storage)
 }
 
@@ -37,9 +49,10 @@ func (sas serviceAccounts) Get(id string) (*models.ServiceAccount, error) {
 	sa := new(models.ServiceAccount)
 	if _, err := sas.storage.PG.DB.Query(
 		sa,
-		`SELECT id, name, key_id, key_secret, email, base_role_id, picture
-		FROM service_accounts
-		WHERE id = ?`,
+		`SELECT id, name, key_id, key_secret, email, base_role_id, picture,
+		display_name, hosted_domain, certificate_subject, oauth2_provider,
+		disabled, last_login_at, previous_key_expires_at, created_at,
+		updated_at FROM service_accounts WHERE id = ?`,
 		id,
 	); err != nil {
 		return nil, err
@@ -47,9 +60,7 @@ func (sas serviceAccounts) Get(id string) (*models.ServiceAccount, error) {
 	if sa.ID == "" {
 		return nil, errors.NewEntityNotFoundError(models.ServiceAccount{}, id)
 	}
-	if sa.KeyID != "" {
-		sa.AuthenticationType = models.AuthenticationTypes.KeyPair
-	}
+	sa.InferAuthenticationType()
 	return sa, nil
 }
 
@@ -61,7 +72,8 @@ func (sas serviceAccounts) DropBindings(saID string) error {
 		return err
 	}
 	_, err := sas.storage.PG.DB.Exec(
-		`DELETE FROM role_bindings WHERE service_account_id = ? AND role_id != ?`,
+		`DELETE FROM role_bindings WHERE service_account_id = ? AND role_id != ?
+		AND managed = false`,
 		saID, sa.BaseRoleID,
 	)
 	return err
@@ -73,17 +85,15 @@ func (sas serviceAccounts) List(
 	var saSl []models.ServiceAccount
 	if _, err := sas.storage.PG.DB.Query(
 		&saSl,
-		`SELECT id, name, email, picture, base_role_id FROM service_accounts
-		ORDER BY name ASC LIMIT ? OFFSET ?`, lo.Limit(), lo.Offset(),
+		`SELECT id, name, email, picture, display_name, base_role_id,
+		certificate_subject, disabled, last_login_at, created_at, updated_at
+		FROM service_accounts ORDER BY name ASC LIMIT ? OFFSET ?`,
+		lo.Limit(), lo.Offset(),
 	); err != nil {
 		return nil, err
 	}
 	for i := range saSl {
-		authType := models.AuthenticationTypes.OAuth2
-		if saSl[i].KeyID != "" {
-			authType = models.AuthenticationTypes.KeyPair
-		}
-		saSl[i].AuthenticationType = authType
+		saSl[i].InferAuthenticationType()
 	}
 	return saSl, nil
 }
@@ -99,25 +109,52 @@ func (sas serviceAccounts) ListCount() (int64, error) {
 	return count, nil
 }
 
+// ListWithEmail lists only OAuth2 service accounts
+func (sas serviceAccounts) ListWithEmail(
+	lo *ListOptions,
+) ([]models.ServiceAccount, error) {
+	saSl := []models.ServiceAccount{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl,
+		`SELECT id, name, email, picture, base_role_id, disabled, created_at,
+		updated_at FROM service_accounts WHERE email IS NOT NULL AND email != ''
+		ORDER BY email ASC LIMIT ? OFFSET ?`, lo.Limit(), lo.Offset(),
+	); err != nil {
+		return nil, err
+	}
+	for i := range saSl {
+		saSl[i].AuthenticationType = models.AuthenticationTypes.OAuth2
+	}
+	return saSl, nil
+}
+
+func (sas serviceAccounts) ListWithEmailCount() (int64, error) {
+	var count int64
+	if _, err := sas.storage.PG.DB.Query(
+		&count,
+		`SELECT count(*) FROM service_accounts
+		WHERE email IS NOT NULL AND email != ''`,
+	); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (sas serviceAccounts) Search(
 	term string, lo *ListOptions,
 ) ([]models.ServiceAccount, error) {
 	saSl := []models.ServiceAccount{}
 	if _, err := sas.storage.PG.DB.Query(
 		&saSl,
-		`SELECT id, name, email, picture, base_role_id FROM service_accounts
-		WHERE name ILIKE ?0 OR email ILIKE ?0
+		`SELECT id, name, email, picture, base_role_id, certificate_subject
+		FROM service_accounts WHERE name ILIKE ?0 OR email ILIKE ?0
 		ORDER BY name ASC LIMIT ?1 OFFSET ?2`,
 		fmt.Sprintf("%%%s%%", term), lo.Limit(), lo.Offset(),
 	); err != nil {
 		return nil, err
 	}
 	for i := range saSl {
-		authType := models.AuthenticationTypes.OAuth2
-		if saSl[i].KeyID != "" {
-			authType = models.AuthenticationTypes.KeyPair
-		}
-		saSl[i].AuthenticationType = authType
+		saSl[i].InferAuthenticationType()
 	}
 	return saSl, nil
 }
@@ -134,14 +171,34 @@ func (sas serviceAccounts) SearchCount(term string) (int64, error) {
 	return count, nil
 }
 
+// SearchPrefix lists service accounts whose name, email or id starts with
+// prefix, only the ones in ids unless ids is nil
+func (sas serviceAccounts) SearchPrefix(
+	prefix string, ids []string, lo *ListOptions,
+) ([]models.ServiceAccount, error) {
+	saSl := []models.ServiceAccount{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl, `SELECT id, name, email FROM service_accounts
+		WHERE (name ILIKE ?0 OR email ILIKE ?0 OR id::text LIKE ?0) AND
+		(?1 OR id = ANY(?2))
+		ORDER BY name ASC, id ASC LIMIT ?3 OFFSET ?4`,
+		likePrefix(prefix), ids == nil, pg.Array(ids), lo.Limit(), lo.Offset(),
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
 // ForEmail retrieves Service Account corresponding
 func (sas serviceAccounts) ForEmail(
 	email string,
 ) (*models.ServiceAccount, error) {
 	sa := new(models.ServiceAccount)
 	if _, err := sas.storage.PG.DB.Query(
-		sa, `SELECT id, name, key_id, key_secret, email, base_role_id, picture
-		FROM service_accounts WHERE email = ?`, email,
+		sa, `SELECT id, name, key_id, key_secret, email, base_role_id, picture,
+		display_name, hosted_domain, oauth2_provider, disabled, last_login_at,
+		created_at, updated_at FROM service_accounts WHERE email = ?`,
+		email,
 	); err != nil {
 		return nil, err
 	}
@@ -165,14 +222,17 @@ func (sas serviceAccounts) ForEmails(
 	return saSl, nil
 }
 
-// ForKeyPair retrieves Service Account corresponding
+// ForKeyPair retrieves Service Account corresponding, either to its key
+// pair or to the previous one while it hasn't expired
 func (sas serviceAccounts) ForKeyPair(
 	keyID, keySecret string,
 ) (*models.ServiceAccount, error) {
 	sa := new(models.ServiceAccount)
 	if _, err := sas.storage.PG.DB.Query(
-		sa, `SELECT id, name, key_id, key_secret, email, base_role_id
-		FROM service_accounts WHERE key_id = ? AND key_secret = ?`,
+		sa, `SELECT id, name, key_id, key_secret, email, base_role_id, disabled
+		FROM service_accounts WHERE (key_id = ?0 AND key_secret = ?1)
+		OR (previous_key_id = ?0 AND previous_key_secret = ?1
+		AND previous_key_expires_at > now()) LIMIT 1`,
 		keyID, keySecret,
 	); err != nil {
 		return nil, err
@@ -183,11 +243,28 @@ func (sas serviceAccounts) ForKeyPair(
 	return sa, nil
 }
 
+// ForCertificateSubjects retrieves Service Accounts whose certificate
+// subject is one of subjects
+func (sas serviceAccounts) ForCertificateSubjects(
+	subjects []string,
+) ([]models.ServiceAccount, error) {
+	saSl := []models.ServiceAccount{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl, `SELECT id, name, base_role_id, certificate_subject, disabled
+		FROM service_accounts WHERE certificate_subject = ANY(?)`,
+		pg.Array(subjects),
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
 func (sas serviceAccounts) Create(sa *models.ServiceAccount) error {
 	_, err := sas.storage.PG.DB.Query(
 		sa, `INSERT INTO service_accounts (id, name, email, key_id, key_secret,
-		base_role_id) VALUES (?id, ?name, ?email, ?key_id, ?key_secret,
-		?base_role_id) RETURNING id`, sa,
+		certificate_subject, oauth2_provider, base_role_id) VALUES (?id, ?name,
+		?email, ?key_id, ?key_secret, ?certificate_subject,
+		NULLIF(?oauth2_provider, ''), ?base_role_id) RETURNING id`, sa,
 	)
 	return err
 }
@@ -195,8 +272,94 @@ func (sas serviceAccounts) Create(sa *models.ServiceAccount) error {
 func (sas serviceAccounts) Update(sa *models.ServiceAccount) error {
 	_, err := sas.storage.PG.DB.Exec(
 		`UPDATE service_accounts SET name = ?name, email = ?email,
-		key_id = ?key_id, key_secret = ?key_secret, base_role_id = ?base_role_id,
-		picture = ?picture, updated_at = now() WHERE id = ?id`, sa,
+		key_id = ?key_id, key_secret = ?key_secret,
+		certificate_subject = ?certificate_subject, base_role_id = ?base_role_id,
+		picture = ?picture, disabled = ?disabled, updated_at = now()
+		WHERE id = ?id`, sa,
+	)
+	return err
+}
+
+// UpdateProfile sets what the identity provider told about id's account
+func (sas serviceAccounts) UpdateProfile(id string, p models.Profile) error {
+	_, err := sas.storage.PG.DB.Exec(
+		`UPDATE service_accounts SET display_name = ?, picture = ?,
+		hosted_domain = ?, profile_synced_at = now(), updated_at = now()
+		WHERE id = ?`, p.Name, p.Picture, p.HostedDomain, id,
+	)
+	return err
+}
+
+// MarkProfileSynced records a profile sync attempt that changed nothing,
+// so id's account goes to the end of ListProfileStale
+func (sas serviceAccounts) MarkProfileSynced(id string) error {
+	_, err := sas.storage.PG.DB.Exec(
+		`UPDATE service_accounts SET profile_synced_at = now() WHERE id = ?`, id,
+	)
+	return err
+}
+
+// BindOAuth2Provider makes provider the one id's account logs in
+// through, unless it already has one. It returns false if it had
+func (sas serviceAccounts) BindOAuth2Provider(
+	id, provider string,
+) (bool, error) {
+	res, err := sas.storage.PG.DB.Exec(
+		`UPDATE service_accounts SET oauth2_provider = ?, updated_at = now()
+		WHERE id = ? AND oauth2_provider IS NULL`, provider, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// SetLastLoginAt records that id's account logged in now
+func (sas serviceAccounts) SetLastLoginAt(id string) error {
+	_, err := sas.storage.PG.DB.Exec(
+		`UPDATE service_accounts SET last_login_at = now() WHERE id = ?`, id,
+	)
+	return err
+}
+
+// ListProfileStale lists up to limit enabled OAuth2 service accounts whose
+// profile wasn't synced in maxAge, least recently synced first
+func (sas serviceAccounts) ListProfileStale(
+	maxAge time.Duration, limit int,
+) ([]models.ServiceAccount, error) {
+	saSl := []models.ServiceAccount{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl, `SELECT id, name, email, profile_synced_at FROM service_accounts
+		WHERE email IS NOT NULL AND email != '' AND disabled = false AND
+		(profile_synced_at IS NULL OR
+		profile_synced_at < now() - ? * INTERVAL '1 second')
+		ORDER BY profile_synced_at ASC NULLS FIRST LIMIT ?`,
+		int64(maxAge.Seconds()), limit,
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
+// Delete removes a service account and its base role; role bindings
+// and base role permissions are removed by cascade
+func (sas serviceAccounts) Delete(id string) error {
+	sa := new(models.ServiceAccount)
+	if _, err := sas.storage.PG.DB.Query(
+		sa, "SELECT base_role_id FROM service_accounts WHERE id = ?", id,
+	); err != nil {
+		return err
+	}
+	if _, err := sas.storage.PG.DB.Exec(
+		`DELETE FROM service_accounts WHERE id = ?`, id,
+	); err != nil {
+		return err
+	}
+	if sa.BaseRoleID == "" {
+		return nil
+	}
+	_, err := sas.storage.PG.DB.Exec(
+		`DELETE FROM roles WHERE id = ?`, sa.BaseRoleID,
 	)
 	return err
 }
@@ -205,3 +368,27 @@ func (sas serviceAccounts) Update(sa *models.ServiceAccount) error {
 func NewServiceAccounts(s *Storage) ServiceAccounts {
 	return &serviceAccounts{&withStorage{storage: s}}
 }
+
+// RotateKeyPair gives key pair service account id a new key pair; the
+// current one keeps working for overlap, or stops right away if it's 0
+func (sas serviceAccounts) RotateKeyPair(
+	id, keyID, keySecret string, overlap time.Duration,
+) error {
+	res, err := sas.storage.PG.DB.Exec(
+		`UPDATE service_accounts SET
+		previous_key_id = CASE WHEN ?3 > 0 THEN key_id END,
+		previous_key_secret = CASE WHEN ?3 > 0 THEN key_secret END,
+		previous_key_expires_at = CASE WHEN ?3 > 0
+		THEN now() + ?3 * interval '1 second' END,
+		key_id = ?1, key_secret = ?2, updated_at = now()
+		WHERE id = ?0 AND key_id IS NOT NULL`,
+		id, keyID, keySecret, int64(overlap/time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return errors.NewEntityNotFoundError(models.ServiceAccount{}, id)
+	}
+	return nil
+}
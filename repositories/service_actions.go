@@ -0,0 +1,77 @@
+package repositories
+
+import "github.com/ghostec/Will.IAM/models"
+
+// ServiceActions repository; a service's actions are its catalog
+type ServiceActions interface {
+	Clone() ServiceActions
+	ForService(string) ([]models.ServiceAction, error)
+	ForPermissionName(string) ([]models.ServiceAction, error)
+	Replace(string, []models.ServiceAction) error
+	setStorage(*Storage)
+}
+
+type serviceActions struct {
+	*withStorage
+}
+
+func (sas *serviceActions) Clone() ServiceActions {
+	return NewServiceActions(sas.storage.Clone())
+}
+
+// ForService lists service id's actions
+func (sas serviceActions) ForService(
+	serviceID string,
+) ([]models.ServiceAction, error) {
+	saSl := []models.ServiceAction{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl, `SELECT * FROM service_actions WHERE service_id = ?
+		ORDER BY action`, serviceID,
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
+// ForPermissionName lists the actions of the service named permissionName
+// in permissions
+func (sas serviceActions) ForPermissionName(
+	permissionName string,
+) ([]models.ServiceAction, error) {
+	saSl := []models.ServiceAction{}
+	if _, err := sas.storage.PG.DB.Query(
+		&saSl, `SELECT sa.* FROM service_actions sa
+		JOIN services s ON s.id = sa.service_id
+		WHERE s.permission_name = ? ORDER BY sa.action`, permissionName,
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
+// Replace makes actions service id's whole catalog; call it in a tx
+func (sas serviceActions) Replace(
+	serviceID string, actions []models.ServiceAction,
+) error {
+	if _, err := sas.storage.PG.DB.Exec(
+		`DELETE FROM service_actions WHERE service_id = ?`, serviceID,
+	); err != nil {
+		return err
+	}
+	for i := range actions {
+		actions[i].ServiceID = serviceID
+		if _, err := sas.storage.PG.DB.Exec(
+			`INSERT INTO service_actions (service_id, action, description,
+			resource_hierarchy) VALUES (?service_id, ?action, ?description,
+			?resource_hierarchy)`, &actions[i],
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewServiceActions ctor
+func NewServiceActions(s *Storage) ServiceActions {
+	return &serviceActions{&withStorage{storage: s}}
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import "github.com/ghostec/Will.IAM/models"
+
+// ServiceOwners repository; a service's owners are the service accounts
+// given full access to it and EditService over it as such
+type ServiceOwners interface {
+	Clone() ServiceOwners
+	ForService(string) ([]models.ServiceAccount, error)
+	LockIDs(string) ([]string, error)
+	Add(string, string) error
+	Remove(string, string) error
+	setStorage(*Storage)
+}
+
+type serviceOwners struct {
+	*withStorage
+}
+
+func (sos *serviceOwners) Clone() ServiceOwners {
+	return NewServiceOwners(sos.storage.Clone())
+}
+
+// ForService lists service id's owners
+func (sos serviceOwners) ForService(
+	serviceID string,
+) ([]models.ServiceAccount, error) {
+	saSl := []models.ServiceAccount{}
+	if _, err := sos.storage.PG.DB.Query(
+		&saSl, `SELECT sa.id, sa.name, sa.email, sa.picture
+		FROM service_accounts sa
+		JOIN service_owners so ON so.service_account_id = sa.id
+		WHERE so.service_id = ? ORDER BY so.created_at, sa.id`, serviceID,
+	); err != nil {
+		return nil, err
+	}
+	return saSl, nil
+}
+
+// LockIDs lists the ids of service id's owners and locks them until the
+// transaction it's called in ends, so concurrent changes can't leave it
+// without owners
+func (sos serviceOwners) LockIDs(serviceID string) ([]string, error) {
+	var ids []string
+	if _, err := sos.storage.PG.DB.Query(
+		&ids, `SELECT service_account_id FROM service_owners
+		WHERE service_id = ? FOR UPDATE`, serviceID,
+	); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Add makes service account saID an owner of service id
+func (sos serviceOwners) Add(serviceID, saID string) error {
+	_, err := sos.storage.PG.DB.Exec(
+		`INSERT INTO service_owners (service_id, service_account_id)
+		VALUES (?, ?) ON CONFLICT DO NOTHING`, serviceID, saID,
+	)
+	return err
+}
+
+// Remove stops service account saID from being an owner of service id
+func (sos serviceOwners) Remove(serviceID, saID string) error {
+	_, err := sos.storage.PG.DB.Exec(
+		`DELETE FROM service_owners WHERE service_id = ?
+		AND service_account_id = ?`, serviceID, saID,
+	)
+	return err
+}
+
+// NewServiceOwners ctor
+func NewServiceOwners(s *Storage) ServiceOwners {
+	return &serviceOwners{&withStorage{storage: s}}
+}
@@ -1,14 +1,21 @@
 package repositories
 
-import "github.com/ghostec/Will.IAM/models"
+import (
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/go-pg/pg"
+)
 
 // Services repository
 type Services interface {
 	List() ([]models.Service, error)
 	Get(string) (*models.Service, error)
 	WithPermissionName(string) (*models.Service, error)
+	WithRedirectOrigin(string) ([]models.Service, error)
+	SearchPrefix(string, []string, *ListOptions) ([]models.Service, error)
 	Create(*models.Service) error
 	Update(*models.Service) error
+	SetAMSecret(string, string) error
 	Clone() Services
 	setStorage(*Storage)
 }
@@ -24,8 +31,9 @@ func (ss *services) Clone() Services {
 func (ss services) Create(s *models.Service) error {
 	_, err := ss.storage.PG.DB.Query(
 		s, `INSERT INTO services (name, permission_name, service_account_id,
-		creator_service_account_id, am_url) VALUES (?name, ?permission_name,
-		?service_account_id, ?creator_service_account_id, ?am_url) RETURNING id`,
+		creator_service_account_id, am_url, redirect_origins) VALUES (?name,
+		?permission_name, ?service_account_id, ?creator_service_account_id,
+		?am_url, ?redirect_origins) RETURNING id`,
 		s,
 	)
 	return err
@@ -66,14 +74,60 @@ func (ss services) WithPermissionName(
 	return s, nil
 }
 
+// SearchPrefix lists services whose name, permission name or id starts
+// with prefix, only the ones in ids unless ids is nil
+func (ss services) SearchPrefix(
+	prefix string, ids []string, lo *ListOptions,
+) ([]models.Service, error) {
+	ssSl := []models.Service{}
+	if _, err := ss.storage.PG.DB.Query(
+		&ssSl, `SELECT id, name, permission_name FROM services
+		WHERE (name ILIKE ?0 OR permission_name ILIKE ?0 OR id::text LIKE ?0)
+		AND (?1 OR id = ANY(?2))
+		ORDER BY name ASC, id ASC LIMIT ?3 OFFSET ?4`,
+		likePrefix(prefix), ids == nil, pg.Array(ids), lo.Limit(), lo.Offset(),
+	); err != nil {
+		return nil, err
+	}
+	return ssSl, nil
+}
+
+// WithRedirectOrigin lists services that allow SSO redirects to origin
+func (ss services) WithRedirectOrigin(
+	origin string,
+) ([]models.Service, error) {
+	var ssSl []models.Service
+	if _, err := ss.storage.PG.DB.Query(
+		&ssSl, `SELECT * FROM services WHERE ? = ANY(redirect_origins)`, origin,
+	); err != nil {
+		return nil, err
+	}
+	return ssSl, nil
+}
+
 func (ss services) Update(s *models.Service) error {
 	_, err := ss.storage.PG.DB.Exec(
 		`UPDATE services SET name = ?name, permission_name = ?permission_name,
-		am_url = ?am_url WHERE id = ?id`, s,
+		am_url = ?am_url, redirect_origins = ?redirect_origins WHERE id = ?id`, s,
 	)
 	return err
 }
 
+// SetAMSecret replaces the secret calls to service id's AM endpoint are
+// signed with
+func (ss services) SetAMSecret(id, secret string) error {
+	res, err := ss.storage.PG.DB.Exec(
+		`UPDATE services SET am_secret = ? WHERE id = ?`, secret, id,
+	)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return errors.NewEntityNotFoundError(models.Service{}, id)
+	}
+	return nil
+}
+
 // NewServices services ctor
 func NewServices(s *Storage) Services {
 	return &services{&withStorage{storage: s}}
@@ -1,14 +1,31 @@
 package repositories
 
 import (
+	"time"
+
 	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
+	"github.com/go-pg/pg"
 )
 
 // Tokens contract
 type Tokens interface {
+	Create(*models.Token) error
+	FamilyStartedSince(string, time.Time) (bool, error)
+	Find(string) (*models.Token, error)
 	Get(string) (*models.Token, error)
+	GetByID(string) (*models.Token, error)
+	GetByRefreshTokenForUpdate(string, string) (*models.Token, error)
+	GetForUpdate(string) (*models.Token, error)
+	LatestUsableForEmail(string) (*models.Token, error)
+	ListActive(string, string) ([]models.Token, error)
+	RecordUsage([]models.TokenUsage) error
+	RevokeFamily(string) error
+	RevokeForEmail(string) error
+	Rotate(*models.Token, *models.Token) error
 	Save(*models.Token) error
+	SetMFAAt(string) error
+	SetRefreshToken(string, string) error
 	Clone() Tokens
 	setStorage(*Storage)
 }
@@ -36,15 +53,218 @@ func (ts tokens) Get(accessToken string) (*models.Token, error) {
 	return t, nil
 }
 
+// GetForUpdate is Get, but locks the token row until the end of the
+// current transaction
+func (ts tokens) GetForUpdate(accessToken string) (*models.Token, error) {
+	t := new(models.Token)
+	if _, err := ts.storage.PG.DB.Query(
+		t, `SELECT * FROM tokens WHERE access_token = ?0 AND
+		(expired_at IS NULL OR expired_at > now() - INTERVAL '60 sec')
+		FOR UPDATE`,
+		accessToken,
+	); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.NewEntityNotFoundError(models.Token{}, accessToken)
+	}
+	return t, nil
+}
+
+// Find retrieves a token even if it was expired or revoked long ago
+func (ts tokens) Find(accessToken string) (*models.Token, error) {
+	t := new(models.Token)
+	if _, err := ts.storage.PG.DB.Query(
+		t, `SELECT * FROM tokens WHERE access_token = ?`, accessToken,
+	); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.NewEntityNotFoundError(models.Token{}, accessToken)
+	}
+	return t, nil
+}
+
+// FamilyStartedSince checks if the token family familyID started, i.e. its
+// bearer logged in, at or after since
+func (ts tokens) FamilyStartedSince(
+	familyID string, since time.Time,
+) (bool, error) {
+	var started bool
+	if _, err := ts.storage.PG.DB.Query(
+		&started, `SELECT COALESCE(min(created_at) >= ?, false) FROM tokens
+		WHERE family_id = ?`, since, familyID,
+	); err != nil {
+		return false, err
+	}
+	return started, nil
+}
+
+// LatestUsableForEmail retrieves the newest token an identity provider
+// issued for email that wasn't rotated or revoked and is either unexpired
+// or refreshable. Tokens Will.IAM issued itself are skipped
+func (ts tokens) LatestUsableForEmail(email string) (*models.Token, error) {
+	t := new(models.Token)
+	if _, err := ts.storage.PG.DB.Query(
+		t, `SELECT * FROM tokens WHERE email = ? AND service_account_id IS NULL
+		AND expired_at IS NULL AND (expiry > now() OR refresh_token != '')
+		ORDER BY created_at DESC LIMIT 1`, email,
+	); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.NewEntityNotFoundError(models.Token{}, email)
+	}
+	return t, nil
+}
+
+// ListActive lists the unexpired tokens of a service account: the ones
+// its identity provider issued for email and the ones Will.IAM issued for
+// serviceAccountID. Rotated tokens are expired, so each login shows once
+func (ts tokens) ListActive(
+	serviceAccountID, email string,
+) ([]models.Token, error) {
+	tSl := []models.Token{}
+	if _, err := ts.storage.PG.DB.Query(
+		&tSl, `SELECT * FROM tokens WHERE
+		(service_account_id = ?0 OR
+		(service_account_id IS NULL AND email = ?1 AND ?1 != ''))
+		AND expired_at IS NULL AND expiry > now()
+		ORDER BY created_at DESC`, serviceAccountID, email,
+	); err != nil {
+		return nil, err
+	}
+	return tSl, nil
+}
+
+// RecordUsage sets when, from where and by which user agent tokens were
+// last used, all in one statement
+func (ts tokens) RecordUsage(usages []models.TokenUsage) error {
+	if len(usages) == 0 {
+		return nil
+	}
+	accessTokens := make([]string, len(usages))
+	userAgents := make([]string, len(usages))
+	ips := make([]string, len(usages))
+	usedAts := make([]time.Time, len(usages))
+	for i := range usages {
+		accessTokens[i] = usages[i].AccessToken
+		userAgents[i] = usages[i].UserAgent
+		ips[i] = usages[i].IP
+		usedAts[i] = usages[i].UsedAt
+	}
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens SET
+	last_used_at = u.used_at, user_agent = u.user_agent, ip = u.ip
+	FROM (SELECT unnest(?::text[]) AS access_token,
+	unnest(?::text[]) AS user_agent, unnest(?::text[]) AS ip,
+	unnest(?::timestamptz[]) AS used_at) AS u
+	WHERE tokens.access_token = u.access_token AND
+	(tokens.last_used_at IS NULL OR tokens.last_used_at < u.used_at)`,
+		pg.Array(accessTokens), pg.Array(userAgents), pg.Array(ips),
+		pg.Array(usedAts),
+	)
+	return err
+}
+
+func (ts tokens) GetByID(id string) (*models.Token, error) {
+	t := new(models.Token)
+	if _, err := ts.storage.PG.DB.Query(
+		t, `SELECT * FROM tokens WHERE id = ?`, id,
+	); err != nil {
+		return nil, err
+	}
+	if t.ID == "" {
+		return nil, errors.NewEntityNotFoundError(models.Token{}, id)
+	}
+	return t, nil
+}
+
+// Create inserts a token in token.FamilyID's family, or in a new family
+// if it's empty
+func (ts tokens) Create(token *models.Token) error {
+	_, err := ts.storage.PG.DB.Query(token, `INSERT INTO tokens (access_token,
+	refresh_token, token_type, expiry, email, provider, client_id,
+	service_account_id, scope, impersonator_id, family_id, updated_at) VALUES
+	(?access_token, ?refresh_token, ?token_type, ?expiry, ?email, ?provider,
+	?client_id, NULLIF(?service_account_id, '')::uuid, ?scope,
+	NULLIF(?impersonator_id, '')::uuid,
+	COALESCE(NULLIF(?family_id, ''), uuid_generate_v4()::text)::uuid, now())
+	RETURNING id, family_id`, token)
+	return err
+}
+
+// GetByRefreshTokenForUpdate retrieves the token refreshToken belongs to,
+// even if it was already rotated, and locks it until the end of the
+// current transaction
+func (ts tokens) GetByRefreshTokenForUpdate(
+	refreshToken, provider string,
+) (*models.Token, error) {
+	t := new(models.Token)
+	if _, err := ts.storage.PG.DB.Query(
+		t, `SELECT * FROM tokens WHERE refresh_token = ? AND provider = ?
+		ORDER BY created_at DESC LIMIT 1 FOR UPDATE`, refreshToken, provider,
+	); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.NewEntityNotFoundError(models.Token{}, refreshToken)
+	}
+	return t, nil
+}
+
+// Rotate expires old and records successor as the token that replaced it
+func (ts tokens) Rotate(old, successor *models.Token) error {
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens
+	SET expired_at = now(), successor_id = ?, updated_at = now()
+	WHERE id = ?`, successor.ID, old.ID)
+	return err
+}
+
+// SetRefreshToken replaces the refresh token of token id, for when its
+// provider rotated it along a refresh that didn't rotate the token itself
+func (ts tokens) SetRefreshToken(id, refreshToken string) error {
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens
+	SET refresh_token = ?, updated_at = now()
+	WHERE id = ?`, refreshToken, id)
+	return err
+}
+
+// RevokeFamily expires every token ever rotated from the same login right
+// away, skipping the grace period Get allows for refreshed tokens
+func (ts tokens) RevokeFamily(familyID string) error {
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens
+	SET expired_at = now() - INTERVAL '1 day', updated_at = now()
+	WHERE family_id = ? AND
+	(expired_at IS NULL OR expired_at > now() - INTERVAL '60 sec')`, familyID)
+	return err
+}
+
 func (ts tokens) Save(token *models.Token) error {
 	_, err := ts.storage.PG.DB.Exec(`INSERT INTO tokens (access_token,
-	refresh_token, expired_at, token_type, expiry, email, updated_at)
+	refresh_token, expired_at, token_type, expiry, email, provider, updated_at)
 	VALUES (?access_token, ?refresh_token, ?expired_at, ?token_type,
-	?expiry, ?email, now()) ON CONFLICT (access_token) DO UPDATE SET
+	?expiry, ?email, ?provider, now()) ON CONFLICT (access_token) DO UPDATE SET
 	expired_at = ?expired_at, updated_at = now()`, token)
 	return err
 }
 
+// SetMFAAt records accessToken's bearer just proved a second factor
+func (ts tokens) SetMFAAt(accessToken string) error {
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens
+	SET mfa_at = now(), updated_at = now() WHERE access_token = ?`, accessToken)
+	return err
+}
+
+// RevokeForEmail expires every token of email right away, skipping the
+// grace period Get allows for refreshed tokens
+func (ts tokens) RevokeForEmail(email string) error {
+	_, err := ts.storage.PG.DB.Exec(`UPDATE tokens
+	SET expired_at = now() - INTERVAL '1 day', updated_at = now()
+	WHERE email = ? AND
+	(expired_at IS NULL OR expired_at > now() - INTERVAL '60 sec')`, email)
+	return err
+}
+
 // NewTokens ctor
 func NewTokens(storage *Storage) Tokens {
 	return &tokens{&withStorage{storage: storage}}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+)
+
+// TOTPSecrets repository
+type TOTPSecrets interface {
+	Clone() TOTPSecrets
+	Confirm(string, int64) (bool, error)
+	Get(string) (*models.TOTPSecret, error)
+	Upsert(*models.TOTPSecret) error
+	UseStep(string, int64) (bool, error)
+	setStorage(*Storage)
+}
+
+type totpSecrets struct {
+	*withStorage
+}
+
+func (ts *totpSecrets) Clone() TOTPSecrets {
+	return NewTOTPSecrets(ts.storage.Clone())
+}
+
+func (ts totpSecrets) Get(saID string) (*models.TOTPSecret, error) {
+	s := new(models.TOTPSecret)
+	if _, err := ts.storage.PG.DB.Query(
+		s, `SELECT * FROM totp_secrets WHERE service_account_id = ?`, saID,
+	); err != nil {
+		return nil, err
+	}
+	if s.ServiceAccountID == "" {
+		return nil, errors.NewEntityNotFoundError(models.TOTPSecret{}, saID)
+	}
+	return s, nil
+}
+
+// Upsert sets s.Secret as the (unconfirmed) secret of s.ServiceAccountID
+func (ts totpSecrets) Upsert(s *models.TOTPSecret) error {
+	_, err := ts.storage.PG.DB.Exec(
+		`INSERT INTO totp_secrets (service_account_id, secret, updated_at)
+		VALUES (?service_account_id, ?secret, now())
+		ON CONFLICT (service_account_id) DO UPDATE SET secret = ?secret,
+		confirmed_at = NULL, last_used_step = 0, updated_at = now()`, s,
+	)
+	return err
+}
+
+// Confirm confirms saID's secret with a code of step. It returns false if
+// step was already used
+func (ts totpSecrets) Confirm(saID string, step int64) (bool, error) {
+	res, err := ts.storage.PG.DB.Exec(
+		`UPDATE totp_secrets SET confirmed_at = now(), last_used_step = ?,
+		updated_at = now() WHERE service_account_id = ? AND last_used_step < ?`,
+		step, saID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// UseStep records a code of step was used. It returns false if it (or a
+// later one) already was, so concurrent requests can't reuse a code
+func (ts totpSecrets) UseStep(saID string, step int64) (bool, error) {
+	res, err := ts.storage.PG.DB.Exec(
+		`UPDATE totp_secrets SET last_used_step = ?, updated_at = now()
+		WHERE service_account_id = ? AND last_used_step < ?
+		AND confirmed_at IS NOT NULL`,
+		step, saID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// NewTOTPSecrets ctor
+func NewTOTPSecrets(s *Storage) TOTPSecrets {
+	return &totpSecrets{&withStorage{storage: s}}
+}
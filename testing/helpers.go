@@ -84,7 +84,7 @@ func GetServiceAccountsUseCase(t *testing.T) usecases.ServiceAccounts {
 	t.Helper()
 	repo := GetRepo(t)
 	providerBlankMock := oauth2.NewProviderBlankMock()
-	return usecases.NewServiceAccounts(repo, providerBlankMock).
+	return usecases.NewServiceAccounts(repo, providerBlankMock, nil).
 		WithContext(context.Background())
 }
 
@@ -111,3 +111,20 @@ func CreateRootServiceAccount(t *testing.T) *models.ServiceAccount {
 	}
 	return rootSA
 }
+
+// GetSteppedUpAuthorization returns an Authorization header of a token of
+// key pair service account sa that just proved a second factor, as
+// sensitive actions require
+func GetSteppedUpAuthorization(t *testing.T, sa *models.ServiceAccount) string {
+	t.Helper()
+	repo := GetRepo(t)
+	token, err := usecases.NewOAuth2Server(repo).
+		ClientCredentials(sa.KeyID, sa.KeySecret, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := repo.Tokens.SetMFAAt(token.AccessToken); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return "Bearer " + token.AccessToken
+}
@@ -2,42 +2,41 @@ package usecases
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
 
 	"github.com/ghostec/Will.IAM/constants"
 	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/repositories"
-	extensionsHttp "github.com/topfreegames/extensions/http"
+	"github.com/gofrs/uuid"
 )
 
 // AM define entrypoints for Access Management actions
 type AM interface {
-	List(string, string) ([]models.AM, error)
+	List(string, string, *repositories.ListOptions) ([]models.AM, error)
 	WithContext(context.Context) AM
 }
 
 type am struct {
-	repo *repositories.All
-	ctx  context.Context
-	http *http.Client
-	rsUC Roles
+	repo   *repositories.All
+	ctx    context.Context
+	client *AMClient
 }
 
 func (a am) WithContext(ctx context.Context) AM {
 	return &am{
 		a.repo.WithContext(ctx),
 		ctx,
-		a.http,
-		a.rsUC.WithContext(ctx),
+		a.client,
 	}
 }
 
-func (a am) List(saID string, prefix string) ([]models.AM, error) {
-	ams, err := a.listPermissions(prefix)
+// List suggests what saID may grant under prefix; lo pages Will.IAM's own
+// resources
+func (a am) List(
+	saID string, prefix string, lo *repositories.ListOptions,
+) ([]models.AM, error) {
+	ams, err := a.listPermissions(saID, prefix, lo)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +54,7 @@ func (a am) List(saID string, prefix string) ([]models.AM, error) {
 			is = append(is, i)
 		}
 	}
-	hasSl, err := serviceAccountHasPermissions(a.repo, saID, ps)
+	hasSl, err := serviceAccountHasPermissions(a.ctx, a.repo, saID, ps)
 	if err != nil {
 		return nil, err
 	}
@@ -108,48 +107,45 @@ func buildLenderAndOwnerPermissions(
 	return lender, owner, nil
 }
 
-func (a am) listPermissions(prefix string) ([]models.AM, error) {
+func (a am) listPermissions(
+	saID, prefix string, lo *repositories.ListOptions,
+) ([]models.AM, error) {
 	if !strings.Contains(prefix, "::") {
-		services, err := a.listServices(prefix)
-		if err != nil {
-			return nil, err
-		}
-		ams := make([]models.AM, len(services))
-		for i := range services {
-			ams[i] = models.AM{
-				Prefix:   services[i],
-				Complete: false,
-			}
-		}
-		return ams, nil
+		return a.listServices(prefix)
 	}
 	parts := strings.Split(prefix, "::")
 	service := parts[0]
 	if service == constants.AppInfo.Name {
-		return a.listWillIAMPermissions(prefix)
+		return a.listWillIAMPermissions(saID, prefix, lo)
 	}
-	return a.listServicePermissions(service, prefix)
+	return a.listServicePermissions(saID, service, prefix)
 }
 
-func (a am) listServices(prefix string) ([]string, error) {
+// listServices lists services matching prefix; the ones whose AM endpoint
+// has been failing are flagged unavailable
+func (a am) listServices(prefix string) ([]models.AM, error) {
 	services, err := a.repo.Services.List()
 	if err != nil {
 		return nil, err
 	}
-	svcs := []string{constants.AppInfo.Name}
-	for i := range services {
-		svcs = append(svcs, services[i].PermissionName)
+	filtered := []models.AM{}
+	if strings.HasPrefix(constants.AppInfo.Name, prefix) {
+		filtered = append(filtered, models.AM{Prefix: constants.AppInfo.Name})
 	}
-	filtered := []string{}
-	for i := range svcs {
-		if strings.HasPrefix(svcs[i], prefix) {
-			filtered = append(filtered, svcs[i])
+	for i := range services {
+		if strings.HasPrefix(services[i].PermissionName, prefix) {
+			filtered = append(filtered, models.AM{
+				Prefix:      services[i].PermissionName,
+				Unavailable: !a.client.Available(&services[i]),
+			})
 		}
 	}
 	return filtered, nil
 }
 
-func (a am) listWillIAMPermissions(prefix string) ([]models.AM, error) {
+func (a am) listWillIAMPermissions(
+	saID, prefix string, lo *repositories.ListOptions,
+) ([]models.AM, error) {
 	parts := strings.Split(prefix, "::")
 	if len(parts) == 2 {
 		actions, err := a.listWillIAMActions(parts[1])
@@ -165,7 +161,11 @@ func (a am) listWillIAMPermissions(prefix string) ([]models.AM, error) {
 		}
 		return ams, nil
 	}
-	ams, err := a.listWillIAMResourceHierarchies(parts[1], parts[2])
+	// Will.IAM's resources are a single level: their ids
+	if len(parts) > 3 {
+		return []models.AM{}, nil
+	}
+	ams, err := a.listWillIAMResourceHierarchies(saID, parts[1], parts[2], lo)
 	if err != nil {
 		return nil, err
 	}
@@ -176,8 +176,7 @@ func (a am) listWillIAMPermissions(prefix string) ([]models.AM, error) {
 }
 
 func (a am) listWillIAMActions(prefix string) ([]string, error) {
-	all := append(constants.RolesActions, constants.ServiceAccountsActions...)
-	all = append(all, constants.ServicesActions...)
+	all := constants.WillIAMActions()
 	keep := []string{}
 	for i := range all {
 		if ok := strings.HasPrefix(all[i], prefix); ok {
@@ -196,77 +195,159 @@ func actionsContains(actions []string, action string) bool {
 	return false
 }
 
+// listWillIAMResourceHierarchies lists, a page at a time, the resources
+// of action whose name, email or id starts with prefix. Only resources
+// saID has some permission of action over are listed
 func (a am) listWillIAMResourceHierarchies(
-	action, prefix string,
+	saID, action, prefix string, lo *repositories.ListOptions,
 ) ([]models.AM, error) {
+	if action == "CreateRoles" || action == "CreateServiceAccounts" ||
+		action == "CreateServices" || !actionsContains(append(
+		append(constants.RolesActions, constants.ServiceAccountsActions...),
+		constants.ServicesActions...,
+	), action) {
+		return []models.AM{}, nil
+	}
+	ids, err := a.willIAMResourceIDs(saID, action)
+	if err != nil {
+		return nil, err
+	}
+	if ids != nil && len(ids) == 0 {
+		return []models.AM{}, nil
+	}
+	ams := []models.AM{}
 	if actionsContains(constants.RolesActions, action) {
-		return a.listRolesActionsRH(action, prefix)
+		rs, err := a.repo.Roles.SearchPrefix(prefix, ids, lo)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rs {
+			ams = append(ams, models.AM{
+				Prefix: rs[i].ID, Alias: rs[i].Name, Complete: true,
+			})
+		}
+		return ams, nil
 	}
 	if actionsContains(constants.ServiceAccountsActions, action) {
-		return []models.AM{}, nil
+		sas, err := a.repo.ServiceAccounts.SearchPrefix(prefix, ids, lo)
+		if err != nil {
+			return nil, err
+		}
+		for i := range sas {
+			alias := sas[i].Name
+			if sas[i].Email != "" && sas[i].Email != sas[i].Name {
+				alias = fmt.Sprintf("%s (%s)", sas[i].Name, sas[i].Email)
+			}
+			ams = append(ams, models.AM{
+				Prefix: sas[i].ID, Alias: alias, Complete: true,
+			})
+		}
+		return ams, nil
 	}
-	if actionsContains(constants.ServicesActions, action) {
-		return []models.AM{}, nil
+	ss, err := a.repo.Services.SearchPrefix(prefix, ids, lo)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ss {
+		ams = append(ams, models.AM{
+			Prefix: ss[i].ID, Alias: ss[i].Name, Complete: true,
+		})
 	}
-	return []models.AM{}, nil
+	return ams, nil
 }
 
-func (a am) listRolesActionsRH(
-	action, prefix string,
-) ([]models.AM, error) {
-	if action == "CreateRoles" || action == "ListRoles" {
-		return []models.AM{}, nil
-	}
-	rs, err := a.rsUC.WithNamePrefix(prefix, 10)
+// willIAMResourceIDs returns the ids of the resources saID has a
+// permission of action over, or nil if it has one over all of them
+func (a am) willIAMResourceIDs(saID, action string) ([]string, error) {
+	ps, err := a.repo.Permissions.ForServiceAccount(saID)
 	if err != nil {
 		return nil, err
 	}
-	ams := make([]models.AM, len(rs))
-	for i := range rs {
-		ams[i] = models.AM{
-			Prefix:   rs[i].ID,
-			Alias:    rs[i].Name,
-			Complete: true,
+	ids := []string{}
+	for _, p := range ps {
+		if (p.Service != constants.AppInfo.Name && p.Service != "*") ||
+			(!p.Action.All() && p.Action.String() != action) {
+			continue
+		}
+		if p.ResourceHierarchy.All() {
+			return nil, nil
+		}
+		if _, err := uuid.FromString(p.ResourceHierarchy.String()); err == nil {
+			ids = append(ids, p.ResourceHierarchy.String())
 		}
 	}
-	return ams, nil
+	return ids, nil
 }
 
+// listServicePermissions asks service for saID's suggestions, or answers
+// from its catalog if it has no AM endpoint. If it can't answer, the
+// result is prefix flagged unavailable instead of an error, so the rest of
+// /am keeps working
 func (a am) listServicePermissions(
-	service, prefix string,
+	saID, service, prefix string,
 ) ([]models.AM, error) {
 	svc, err := a.repo.Services.WithPermissionName(service)
 	if err != nil {
 		return nil, err
 	}
+	if svc.AMURL == "" {
+		return a.listCatalogPermissions(svc, prefix)
+	}
 	prefixWOSvc := strings.Join(strings.Split(prefix, "::")[1:], "::")
-	req, err := http.NewRequest(
-		"GET", fmt.Sprintf("%s?prefix=%s", svc.AMURL, prefixWOSvc), nil,
-	)
+	ams, err := a.client.List(a.ctx, svc, saID, prefixWOSvc)
 	if err != nil {
-		return nil, err
+		return []models.AM{{Prefix: prefix, Unavailable: true}}, nil
 	}
-	res, err := a.http.Do(req.WithContext(a.ctx))
-	if err != nil {
-		return nil, err
+	for i := range ams {
+		ams[i].Prefix = fmt.Sprintf("%s::%s", service, ams[i].Prefix)
 	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	return ams, nil
+}
+
+// listCatalogPermissions suggests svc's cataloged actions matching prefix
+// and, after an action, the name of each resource hierarchy level as the
+// alias of a wildcard over it
+func (a am) listCatalogPermissions(
+	svc *models.Service, prefix string,
+) ([]models.AM, error) {
+	actions, err := a.repo.ServiceActions.ForService(svc.ID)
 	if err != nil {
 		return nil, err
 	}
-	var ams []models.AM
-	err = json.Unmarshal(body, &ams)
-	if err != nil {
-		return nil, err
+	parts := strings.Split(prefix, "::")
+	ams := []models.AM{}
+	if len(parts) == 2 {
+		for i := range actions {
+			if strings.HasPrefix(actions[i].Action, parts[1]) {
+				ams = append(ams, models.AM{
+					Prefix: fmt.Sprintf("%s::%s", parts[0], actions[i].Action),
+					Alias:  actions[i].Description,
+				})
+			}
+		}
+		return ams, nil
 	}
-	for i := range ams {
-		ams[i].Prefix = fmt.Sprintf("%s::%s", service, ams[i].Prefix)
+	action, ok := models.ServiceCatalog{Actions: actions}.Get(parts[1])
+	if !ok {
+		return ams, nil
 	}
-	return ams, nil
+	levels := action.Levels()
+	depth := len(parts) - 2
+	if depth > len(levels) {
+		return ams, nil
+	}
+	return append(ams, models.AM{
+		Prefix:   fmt.Sprintf("%s::*", strings.Join(parts[:len(parts)-1], "::")),
+		Alias:    levels[depth-1],
+		Complete: true,
+	}), nil
 }
 
-// NewAM ctor
-func NewAM(repo *repositories.All, rsUC Roles) AM {
-	return &am{repo: repo, http: extensionsHttp.New(), rsUC: rsUC}
+// NewAM ctor; client is shared, so its breakers and cache outlive the usecase
+func NewAM(repo *repositories.All, client *AMClient) AM {
+	return &am{
+		repo:   repo,
+		ctx:    context.Background(),
+		client: client,
+	}
 }
@@ -0,0 +1,229 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ghostec/Will.IAM/amsign"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/sirupsen/logrus"
+	extensionsHttp "github.com/topfreegames/extensions/http"
+)
+
+// amMaxBodySize bounds how much of a service's /am response is read
+const amMaxBodySize = 1 << 20
+
+// AMClientConfig configures how services' AM endpoints are called. Each
+// call may take up to Timeout. After MaxFailures failures in a row a
+// service isn't called for Cooldown; then one call is let through and
+// its result decides whether calls resume. Successful responses are
+// cached for CacheTTL, up to MaxCacheEntries
+type AMClientConfig struct {
+	Timeout         time.Duration
+	MaxFailures     int
+	Cooldown        time.Duration
+	CacheTTL        time.Duration
+	MaxCacheEntries int
+}
+
+// AMClient calls services' AM endpoints on behalf of service accounts,
+// signing calls to services with an AM secret (see amsign). It holds
+// state across requests, so it's shared by every AM usecase
+type AMClient struct {
+	http     *http.Client
+	logger   logrus.FieldLogger
+	config   AMClientConfig
+	mu       sync.Mutex
+	breakers map[string]*amBreaker
+	cache    map[string]amCacheEntry
+}
+
+// amBreaker is a service's circuit breaker; it's open while openUntil is
+// in the future, and probing while the call after it is in flight
+type amBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+type amCacheEntry struct {
+	ams       []models.AM
+	expiresAt time.Time
+}
+
+// NewAMClient ctor
+func NewAMClient(config AMClientConfig, logger logrus.FieldLogger) *AMClient {
+	return &AMClient{
+		http:     extensionsHttp.New(),
+		logger:   logger,
+		config:   config,
+		breakers: map[string]*amBreaker{},
+		cache:    map[string]amCacheEntry{},
+	}
+}
+
+// Available is false while svc's circuit breaker is open
+func (c *AMClient) Available(svc *models.Service) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[svc.ID]
+	return !ok || !time.Now().Before(b.openUntil)
+}
+
+// List asks svc's AM endpoint for saID's suggestions of prefix, without
+// the service's permission name. Services without AMURL have none
+func (c *AMClient) List(
+	ctx context.Context, svc *models.Service, saID, prefix string,
+) ([]models.AM, error) {
+	if svc.AMURL == "" {
+		return []models.AM{}, nil
+	}
+	// services may answer each service account differently
+	key := fmt.Sprintf("%s\n%s\n%s\n%s", svc.ID, svc.AMURL, saID, prefix)
+	if ams, ok := c.cached(key); ok {
+		return ams, nil
+	}
+	if !c.acquire(svc.ID) {
+		return nil, fmt.Errorf("am: circuit open for service %s", svc.ID)
+	}
+	ams, err := c.fetch(ctx, svc, saID, prefix)
+	c.release(svc.ID, err)
+	if err != nil {
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"service": svc.PermissionName,
+			"prefix":  prefix,
+		}).Warn("am: service unavailable")
+		return nil, err
+	}
+	c.store(key, ams)
+	return copyAMs(ams), nil
+}
+
+func (c *AMClient) fetch(
+	ctx context.Context, svc *models.Service, saID, prefix string,
+) ([]models.AM, error) {
+	u, err := url.Parse(svc.AMURL)
+	if err != nil {
+		return nil, err
+	}
+	qs := u.Query()
+	qs.Set("prefix", prefix)
+	u.RawQuery = qs.Encode()
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if svc.AMSecret != "" {
+		amsign.Sign(req, []byte(svc.AMSecret), saID, time.Now())
+	} else {
+		req.Header.Set(amsign.ServiceAccountHeader, saID)
+	}
+	res, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, amMaxBodySize))
+		return nil, fmt.Errorf("am: %s answered %d", u.Host, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, amMaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > amMaxBodySize {
+		return nil, fmt.Errorf("am: %s answered over %d bytes", u.Host, amMaxBodySize)
+	}
+	ams := []models.AM{}
+	if err := json.Unmarshal(body, &ams); err != nil {
+		return nil, err
+	}
+	return ams, nil
+}
+
+// acquire tells if serviceID may be called now; once its cooldown is over
+// only one caller gets through until that call finishes
+func (c *AMClient) acquire(serviceID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[serviceID]
+	if !ok || c.config.MaxFailures <= 0 || b.failures < c.config.MaxFailures {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (c *AMClient) release(serviceID string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.breakers, serviceID)
+		return
+	}
+	b, ok := c.breakers[serviceID]
+	if !ok {
+		b = &amBreaker{}
+		c.breakers[serviceID] = b
+	}
+	b.failures++
+	b.probing = false
+	if c.config.MaxFailures > 0 && b.failures >= c.config.MaxFailures {
+		b.openUntil = time.Now().Add(c.config.Cooldown)
+	}
+}
+
+func (c *AMClient) cached(key string) ([]models.AM, bool) {
+	if c.config.CacheTTL <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return copyAMs(entry.ams), true
+}
+
+func (c *AMClient) store(key string, ams []models.AM) {
+	if c.config.CacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.cache) >= c.config.MaxCacheEntries {
+		for k, entry := range c.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= c.config.MaxCacheEntries {
+			return
+		}
+	}
+	c.cache[key] = amCacheEntry{ams: copyAMs(ams), expiresAt: now.Add(c.config.CacheTTL)}
+}
+
+// copyAMs keeps callers, which prefix and flag results, off cached slices
+func copyAMs(ams []models.AM) []models.AM {
+	cp := make([]models.AM, len(ams))
+	copy(cp, ams)
+	return cp
+}
@@ -0,0 +1,129 @@
+// +build unit
+
+package usecases_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/amsign"
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+func TestAMClientListEscapesPrefixAndCaches(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			fmt.Fprintf(
+				w, `[{"prefix":%q,"complete":true}]`, r.URL.Query().Get("prefix"),
+			)
+		},
+	))
+	defer server.Close()
+	client := usecases.NewAMClient(usecases.AMClientConfig{
+		Timeout:         time.Second,
+		CacheTTL:        time.Minute,
+		MaxCacheEntries: 10,
+	}, helpers.GetLogger(t))
+	svc := &models.Service{ID: "svc", AMURL: server.URL + "/am"}
+	for i := 0; i < 2; i++ {
+		ams, err := client.List(context.Background(), svc, "sa-id", "RL::a&b=c")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if len(ams) != 1 || ams[0].Prefix != "RL::a&b=c" {
+			t.Fatalf("Expected prefix RL::a&b=c. Got %v", ams)
+		}
+		ams[0].Prefix = "changed"
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call to the service. Got %d", calls)
+	}
+}
+
+func TestAMClientListOpensCircuit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	))
+	defer server.Close()
+	client := usecases.NewAMClient(usecases.AMClientConfig{
+		Timeout:         time.Second,
+		MaxFailures:     2,
+		Cooldown:        time.Hour,
+		MaxCacheEntries: 10,
+	}, helpers.GetLogger(t))
+	svc := &models.Service{ID: "svc", AMURL: server.URL}
+	for i := 0; i < 3; i++ {
+		if _, err := client.List(context.Background(), svc, "sa-id", "RL::"); err == nil {
+			t.Fatalf("Expected error on call %d", i+1)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 calls to the service. Got %d", calls)
+	}
+	if client.Available(svc) {
+		t.Errorf("Expected service to be unavailable")
+	}
+}
+
+func TestAMClientListTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		},
+	))
+	defer server.Close()
+	defer close(done)
+	client := usecases.NewAMClient(usecases.AMClientConfig{
+		Timeout:         50 * time.Millisecond,
+		MaxCacheEntries: 10,
+	}, helpers.GetLogger(t))
+	svc := &models.Service{ID: "svc", AMURL: server.URL}
+	if _, err := client.List(context.Background(), svc, "sa-id", "RL::"); err == nil {
+		t.Errorf("Expected timeout error")
+	}
+}
+
+func TestAMClientListSignsRequests(t *testing.T) {
+	secret := "secret"
+	var saID string
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			saID, verifyErr = amsign.Verify(
+				r, []byte(secret), amsign.DefaultMaxSkew, time.Now(),
+			)
+			fmt.Fprint(w, `[]`)
+		},
+	))
+	defer server.Close()
+	client := usecases.NewAMClient(usecases.AMClientConfig{
+		Timeout:         time.Second,
+		MaxCacheEntries: 10,
+	}, helpers.GetLogger(t))
+	svc := &models.Service{ID: "svc", AMURL: server.URL, AMSecret: secret}
+	if _, err := client.List(
+		context.Background(), svc, "sa-id", "RL::",
+	); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if verifyErr != nil {
+		t.Fatalf("Unexpected error: %s", verifyErr.Error())
+	}
+	if saID != "sa-id" {
+		t.Errorf("Expected sa-id. Got %s", saID)
+	}
+}
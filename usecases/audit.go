@@ -0,0 +1,424 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+type auditActorCtxKeyType string
+
+const auditActorCtxKey = auditActorCtxKeyType("auditActor")
+
+// AuditActor is who changes made with a context are audited as done by
+type AuditActor struct {
+	ServiceAccountID string
+	ImpersonatorID   string
+	RequestID        string
+}
+
+// WithAuditActor returns a copy of ctx in which changes are audited as
+// done by actor
+func WithAuditActor(ctx context.Context, actor *AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorCtxKey, actor)
+}
+
+// GetAuditActor returns the actor WithAuditActor put in ctx
+func GetAuditActor(ctx context.Context) (*AuditActor, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	actor, ok := ctx.Value(auditActorCtxKey).(*AuditActor)
+	return actor, ok
+}
+
+// Types of audited targets
+const (
+	AuditTargetOAuth2Client      = "oauth2Client"
+	AuditTargetPermission        = "permission"
+	AuditTargetPermissionRequest = "permissionRequest"
+	AuditTargetRole              = "role"
+	AuditTargetService           = "service"
+	AuditTargetServiceAccount    = "serviceAccount"
+)
+
+// audit appends to the audit log, within repo's transaction, that the
+// actor in ctx did action over target. Without an actor, e.g. in workers,
+// the change is audited as done by no one
+func audit(
+	ctx context.Context, repo *repositories.All, action, targetType,
+	targetID string, before, after interface{},
+) error {
+	b, a, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	e := &models.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     b,
+		After:      a,
+	}
+	if actor, ok := GetAuditActor(ctx); ok {
+		e.ActorID = actor.ServiceAccountID
+		e.ImpersonatorID = actor.ImpersonatorID
+		e.RequestID = actor.RequestID
+	}
+	return repo.Audit.Create(e)
+}
+
+// auditDiff serializes before and after; when both are objects, only the
+// fields that differ are kept
+func auditDiff(before, after interface{}) (json.RawMessage, json.RawMessage, error) {
+	b, err := marshalAudited(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := marshalAudited(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bm, am map[string]interface{}
+	if json.Unmarshal(b, &bm) != nil || json.Unmarshal(a, &am) != nil ||
+		bm == nil || am == nil {
+		return b, a, nil
+	}
+	for k := range bm {
+		if v, ok := am[k]; ok && reflect.DeepEqual(bm[k], v) {
+			delete(bm, k)
+			delete(am, k)
+		}
+	}
+	if b, err = json.Marshal(bm); err != nil {
+		return nil, nil, err
+	}
+	if a, err = json.Marshal(am); err != nil {
+		return nil, nil, err
+	}
+	return b, a, nil
+}
+
+func marshalAudited(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// auditedRole is what's audited of a role
+func auditedRole(
+	repo *repositories.All, roleID string,
+) (map[string]interface{}, error) {
+	r, err := repo.Roles.Get(roleID)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := repo.Permissions.ForRole(roleID)
+	if err != nil {
+		return nil, err
+	}
+	sas, err := repo.Roles.GetServiceAccounts(roleID)
+	if err != nil {
+		return nil, err
+	}
+	saIDs := make([]string, len(sas))
+	for i := range sas {
+		saIDs[i] = sas[i].ID
+	}
+	sort.Strings(saIDs)
+	return map[string]interface{}{
+		"name":               r.Name,
+		"permissions":        permissionsStrings(ps),
+		"serviceAccountsIds": saIDs,
+		"scimManaged":        r.SCIMManaged,
+	}, nil
+}
+
+// auditedServiceAccount is what's audited of a service account; never
+// its credentials
+func auditedServiceAccount(
+	repo *repositories.All, saID string,
+) (map[string]interface{}, error) {
+	sa, err := repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := repo.Permissions.ForRole(sa.BaseRoleID)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := repo.Roles.ForServiceAccountID(saID)
+	if err != nil {
+		return nil, err
+	}
+	rolesIDs := []string{}
+	for i := range rs {
+		if rs[i].ID != sa.BaseRoleID {
+			rolesIDs = append(rolesIDs, rs[i].ID)
+		}
+	}
+	sort.Strings(rolesIDs)
+	return map[string]interface{}{
+		"name":               sa.Name,
+		"email":              sa.Email,
+		"certificateSubject": sa.CertificateSubject,
+		"disabled":           sa.Disabled,
+		"permissions":        permissionsStrings(ps),
+		"rolesIds":           rolesIDs,
+	}, nil
+}
+
+// auditedService is what's audited of a service; never its AM secret
+func auditedService(service *models.Service) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            service.Name,
+		"permissionName":  service.PermissionName,
+		"amUrl":           service.AMURL,
+		"redirectOrigins": service.RedirectOrigins,
+	}
+}
+
+// auditedOAuth2Client is what's audited of an OAuth2 client; never its
+// secret
+func auditedOAuth2Client(c *models.OAuth2Client) map[string]interface{} {
+	return map[string]interface{}{
+		"clientId":     c.ClientID,
+		"serviceId":    c.ServiceID,
+		"name":         c.Name,
+		"redirectUris": c.RedirectURIs,
+		"confidential": c.Confidential,
+	}
+}
+
+// auditedCatalog is what's audited of a service's catalog: its actions'
+// resource hierarchies by action
+func auditedCatalog(actions []models.ServiceAction) map[string]interface{} {
+	hierarchies := map[string]string{}
+	for _, a := range actions {
+		hierarchies[a.Action] = a.ResourceHierarchy
+	}
+	return map[string]interface{}{"actions": hierarchies}
+}
+
+func permissionsStrings(ps []models.Permission) []string {
+	strs := make([]string, len(ps))
+	for i := range ps {
+		strs[i] = ps[i].String()
+	}
+	return strs
+}
+
+// auditVerifyBatchSize is how many entries Verify reads at a time
+const auditVerifyBatchSize = 1000
+
+// Audit define entrypoints for reading the audit log
+type Audit interface {
+	Checkpoint([]byte) (*models.AuditCheckpoint, bool, error)
+	List(
+		*models.AuditFilter, *repositories.ListOptions,
+	) ([]models.AuditEntry, int64, error)
+	Verify([]byte) (*AuditVerification, error)
+	WithContext(context.Context) Audit
+}
+
+type auditLog struct {
+	repo *repositories.All
+	ctx  context.Context
+}
+
+func (al auditLog) WithContext(ctx context.Context) Audit {
+	return &auditLog{al.repo.WithContext(ctx), ctx}
+}
+
+// List audit entries matching filter, newest first
+func (al auditLog) List(
+	filter *models.AuditFilter, lo *repositories.ListOptions,
+) ([]models.AuditEntry, int64, error) {
+	entries, err := al.repo.Audit.List(filter, lo)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := al.repo.Audit.ListCount(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, count, nil
+}
+
+// Checkpoint signs the audit log's head with secret, unless it's already
+// checkpointed or unchained. It returns whether a checkpoint was made
+func (al auditLog) Checkpoint(
+	secret []byte,
+) (*models.AuditCheckpoint, bool, error) {
+	head, err := al.repo.Audit.Head()
+	if err != nil {
+		return nil, false, err
+	}
+	last, err := al.repo.Audit.LastCheckpoint()
+	if err != nil {
+		return nil, false, err
+	}
+	if head.Hash == "" || head.Seq <= last.Seq {
+		return last, false, nil
+	}
+	c := &models.AuditCheckpoint{
+		Seq:       head.Seq,
+		Hash:      head.Hash,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	c.Signature = c.Sign(secret)
+	if err := al.repo.Audit.CreateCheckpoint(c); err != nil {
+		return nil, false, err
+	}
+	return c, true, nil
+}
+
+// Verify walks the audit log's chain and its checkpoints, see
+// AuditVerifier. Without secret checkpoints' signatures aren't checked
+func (al auditLog) Verify(secret []byte) (*AuditVerification, error) {
+	cs, err := al.repo.Audit.Checkpoints()
+	if err != nil {
+		return nil, err
+	}
+	v := NewAuditVerifier(secret, cs)
+	var seq int64
+	for {
+		entries, err := al.repo.Audit.Chain(seq, auditVerifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			if !v.Add(entries[i]) {
+				return v.Finish(), nil
+			}
+		}
+		if len(entries) < auditVerifyBatchSize {
+			return v.Finish(), nil
+		}
+		seq = entries[len(entries)-1].Seq
+	}
+}
+
+// AuditVerification is what verifying the audit log found: how many
+// entries it has, how many of them predate the chain and how many
+// checkpoints vouched for it. BrokenAt is the seq of the first broken
+// link, if any, and Reason how it's broken
+type AuditVerification struct {
+	Entries            int64  `json:"entries"`
+	Unchained          int64  `json:"unchained"`
+	Checkpoints        int    `json:"checkpoints"`
+	SignaturesVerified bool   `json:"signaturesVerified"`
+	BrokenAt           int64  `json:"brokenAt,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+}
+
+// Intact is true if no link is broken
+func (v AuditVerification) Intact() bool {
+	return v.Reason == ""
+}
+
+// AuditVerifier checks audit entries, added in chain order, link to one
+// another: they're numbered from 1 with no gaps, each hash matches its
+// entry and the previous entry's hash, and checkpoints match the entries
+// they vouch for. Unchained entries may only come before chained ones
+type AuditVerifier struct {
+	secret      []byte
+	checkpoints []models.AuditCheckpoint
+	prev        models.AuditEntry
+	result      AuditVerification
+}
+
+// NewAuditVerifier ctor; checkpoints must be in chain order
+func NewAuditVerifier(
+	secret []byte, checkpoints []models.AuditCheckpoint,
+) *AuditVerifier {
+	return &AuditVerifier{
+		secret:      secret,
+		checkpoints: checkpoints,
+		result:      AuditVerification{SignaturesVerified: len(secret) > 0},
+	}
+}
+
+// Add checks e links to the entries added before; it's false once a link
+// is broken
+func (v *AuditVerifier) Add(e models.AuditEntry) bool {
+	if !v.result.Intact() {
+		return false
+	}
+	if reason := v.linkError(e); reason != "" {
+		return v.broken(e.Seq, reason)
+	}
+	v.result.Entries++
+	if e.Hash == "" {
+		v.result.Unchained++
+	}
+	for len(v.checkpoints) > 0 && v.checkpoints[0].Seq <= e.Seq {
+		c := v.checkpoints[0]
+		v.checkpoints = v.checkpoints[1:]
+		if c.Seq != e.Seq || c.Hash != e.Hash {
+			return v.broken(e.Seq, fmt.Sprintf(
+				"checkpoint %s doesn't match entry %d", c.ID, c.Seq,
+			))
+		}
+		if len(v.secret) > 0 && !c.Verify(v.secret) {
+			return v.broken(e.Seq, fmt.Sprintf(
+				"checkpoint %s has an invalid signature", c.ID,
+			))
+		}
+		v.result.Checkpoints++
+	}
+	v.prev = e
+	return true
+}
+
+func (v *AuditVerifier) linkError(e models.AuditEntry) string {
+	if e.Seq != v.prev.Seq+1 {
+		return fmt.Sprintf("entries %d to %d are missing", v.prev.Seq+1, e.Seq-1)
+	}
+	if e.Hash == "" {
+		if v.prev.Hash != "" {
+			return "unchained entry after the chain began"
+		}
+		return ""
+	}
+	if e.PrevHash != v.prev.Hash {
+		return fmt.Sprintf("previous hash doesn't match entry %d", v.prev.Seq)
+	}
+	hash, err := e.ComputeHash()
+	if err != nil || hash != e.Hash {
+		return "hash doesn't match the entry"
+	}
+	return ""
+}
+
+func (v *AuditVerifier) broken(seq int64, reason string) bool {
+	v.result.BrokenAt = seq
+	v.result.Reason = reason
+	return false
+}
+
+// Finish returns the verification of the entries added; checkpoints past
+// the last of them mean the log was truncated
+func (v *AuditVerifier) Finish() *AuditVerification {
+	if v.result.Intact() && len(v.checkpoints) > 0 {
+		c := v.checkpoints[0]
+		v.broken(v.prev.Seq+1, fmt.Sprintf(
+			"entries %d to %d, vouched for by checkpoint %s, are missing",
+			v.prev.Seq+1, c.Seq, c.ID,
+		))
+	}
+	r := v.result
+	return &r
+}
+
+// NewAudit ctor
+func NewAudit(repo *repositories.All) Audit {
+	return &auditLog{repo: repo}
+}
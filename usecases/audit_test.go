@@ -0,0 +1,153 @@
+// +build unit
+
+package usecases_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+func buildAuditChain(t *testing.T, unchained, chained int) []models.AuditEntry {
+	t.Helper()
+	entries := []models.AuditEntry{}
+	prev := ""
+	for i := 0; i < unchained+chained; i++ {
+		e := models.AuditEntry{
+			ID:         fmt.Sprintf("entry %d", i+1),
+			Seq:        int64(i + 1),
+			Action:     "roles.update",
+			TargetType: "role",
+			CreatedAt:  time.Now(),
+		}
+		if i >= unchained {
+			e.PrevHash = prev
+			hash, err := e.ComputeHash()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			e.Hash = hash
+		}
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func buildAuditCheckpoint(
+	secret string, e models.AuditEntry,
+) models.AuditCheckpoint {
+	c := models.AuditCheckpoint{
+		ID: fmt.Sprintf("checkpoint %d", e.Seq), Seq: e.Seq, Hash: e.Hash,
+		CreatedAt: time.Now(),
+	}
+	c.Signature = c.Sign([]byte(secret))
+	return c
+}
+
+func verifyAuditChain(
+	secret string, entries []models.AuditEntry,
+	checkpoints ...models.AuditCheckpoint,
+) *usecases.AuditVerification {
+	v := usecases.NewAuditVerifier([]byte(secret), checkpoints)
+	for _, e := range entries {
+		if !v.Add(e) {
+			break
+		}
+	}
+	return v.Finish()
+}
+
+func TestAuditVerifierIntact(t *testing.T) {
+	entries := buildAuditChain(t, 2, 3)
+	r := verifyAuditChain(
+		"secret", entries, buildAuditCheckpoint("secret", entries[3]),
+	)
+	if !r.Intact() {
+		t.Fatalf("Expected chain to be intact. Got %s at %d", r.Reason, r.BrokenAt)
+	}
+	if r.Entries != 5 || r.Unchained != 2 || r.Checkpoints != 1 {
+		t.Errorf("Unexpected verification %+v", r)
+	}
+}
+
+func TestAuditVerifierBroken(t *testing.T) {
+	type testCase struct {
+		name     string
+		tamper   func([]models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint)
+		brokenAt int64
+		reason   string
+	}
+	testCases := []testCase{
+		testCase{
+			name: "edited entry",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				es[2].Action = "roles.create"
+				return es, nil
+			},
+			brokenAt: 3,
+			reason:   "hash doesn't match",
+		},
+		testCase{
+			name: "rehashed entry",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				es[2].Action = "roles.create"
+				es[2].Hash, _ = es[2].ComputeHash()
+				return es, nil
+			},
+			brokenAt: 4,
+			reason:   "previous hash doesn't match",
+		},
+		testCase{
+			name: "deleted entry",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				return append(es[:1], es[2:]...), nil
+			},
+			brokenAt: 3,
+			reason:   "entries 2 to 2 are missing",
+		},
+		testCase{
+			name: "unchained after chained",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				es[3].Hash = ""
+				return es, nil
+			},
+			brokenAt: 4,
+			reason:   "unchained entry",
+		},
+		testCase{
+			name: "truncated",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				return es[:3], []models.AuditCheckpoint{buildAuditCheckpoint("secret", es[4])}
+			},
+			brokenAt: 4,
+			reason:   "vouched for by checkpoint",
+		},
+		testCase{
+			name: "forged checkpoint",
+			tamper: func(es []models.AuditEntry) ([]models.AuditEntry, []models.AuditCheckpoint) {
+				return es, []models.AuditCheckpoint{buildAuditCheckpoint("forged", es[4])}
+			},
+			brokenAt: 5,
+			reason:   "invalid signature",
+		},
+	}
+	for _, tt := range testCases {
+		entries, checkpoints := tt.tamper(buildAuditChain(t, 0, 5))
+		r := verifyAuditChain("secret", entries, checkpoints...)
+		if r.Intact() {
+			t.Errorf("%s: expected chain to be broken", tt.name)
+			continue
+		}
+		if r.BrokenAt != tt.brokenAt || !strings.Contains(r.Reason, tt.reason) {
+			t.Errorf(
+				"%s: expected broken at %d (%s). Got %d (%s)",
+				tt.name, tt.brokenAt, tt.reason, r.BrokenAt, r.Reason,
+			)
+		}
+	}
+}
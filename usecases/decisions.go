@@ -0,0 +1,192 @@
+package usecases
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/sirupsen/logrus"
+)
+
+type decisionRouteCtxKeyType string
+
+const decisionRouteCtxKey = decisionRouteCtxKeyType("decisionRoute")
+
+// WithDecisionRoute returns a copy of ctx in which permission checks are
+// logged as guarding route
+func WithDecisionRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, decisionRouteCtxKey, route)
+}
+
+func getDecisionRoute(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	route, _ := ctx.Value(decisionRouteCtxKey).(string)
+	return route
+}
+
+// DecisionSink is where a DecisionLogger writes decisions to. Write is
+// called on every permission check, so it must not block
+type DecisionSink interface {
+	Write(models.Decision)
+	Close() error
+}
+
+// DecisionLoggerConfig configures a DecisionLogger: the fraction, from 0
+// to 1, of allowed and denied decisions that are logged
+type DecisionLoggerConfig struct {
+	AllowedSampleRate float64
+	DeniedSampleRate  float64
+}
+
+// DecisionLogger logs a sample of permission checks' decisions to a sink.
+// A nil *DecisionLogger logs nothing
+type DecisionLogger struct {
+	sink   DecisionSink
+	config DecisionLoggerConfig
+}
+
+// NewDecisionLogger ctor
+func NewDecisionLogger(
+	sink DecisionSink, config DecisionLoggerConfig,
+) *DecisionLogger {
+	return &DecisionLogger{sink: sink, config: config}
+}
+
+// Log d, made with ctx, if it's sampled. Who made the request d was made
+// for, and the route it guarded, are taken from ctx
+func (dl *DecisionLogger) Log(ctx context.Context, d models.Decision) {
+	if dl == nil {
+		return
+	}
+	rate := dl.config.DeniedSampleRate
+	if d.Allowed {
+		rate = dl.config.AllowedSampleRate
+	}
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return
+	}
+	d.Time = time.Now().UTC()
+	if actor, ok := GetAuditActor(ctx); ok {
+		d.ImpersonatorID = actor.ImpersonatorID
+		d.RequestID = actor.RequestID
+	}
+	if d.Route == "" {
+		d.Route = getDecisionRoute(ctx)
+	}
+	dl.sink.Write(d)
+}
+
+// Close the logger's sink, writing what's pending
+func (dl *DecisionLogger) Close() error {
+	if dl == nil {
+		return nil
+	}
+	return dl.sink.Close()
+}
+
+// WriterDecisionSink writes decisions to an io.Writer as JSON lines, in
+// the background. Up to bufferSize decisions wait to be written; past
+// that, new ones are dropped rather than slowing checks down
+type WriterDecisionSink struct {
+	w       *bufio.Writer
+	closer  io.Closer
+	logger  logrus.FieldLogger
+	mu      sync.RWMutex
+	closed  bool
+	pending chan models.Decision
+	done    chan struct{}
+	dropped int64
+}
+
+// NewWriterDecisionSink ctor; it starts writing right away
+func NewWriterDecisionSink(
+	w io.Writer, bufferSize int, logger logrus.FieldLogger,
+) *WriterDecisionSink {
+	s := &WriterDecisionSink{
+		w:       bufio.NewWriter(w),
+		logger:  logger,
+		pending: make(chan models.Decision, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// NewFileDecisionSink is a WriterDecisionSink appending to the file at
+// path, which Close closes
+func NewFileDecisionSink(
+	path string, bufferSize int, logger logrus.FieldLogger,
+) (*WriterDecisionSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := NewWriterDecisionSink(f, bufferSize, logger)
+	s.closer = f
+	return s, nil
+}
+
+// Write queues d; it never blocks
+func (s *WriterDecisionSink) Write(d models.Decision) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.pending <- d:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Dropped is how many decisions didn't fit in the buffer
+func (s *WriterDecisionSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close writes what's queued and stops writing
+func (s *WriterDecisionSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.pending)
+	}
+	s.mu.Unlock()
+	<-s.done
+	if dropped := s.Dropped(); dropped > 0 {
+		s.logger.WithField("dropped", dropped).Warn("decisions dropped")
+	}
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+func (s *WriterDecisionSink) run() {
+	defer close(s.done)
+	enc := json.NewEncoder(s.w)
+	for d := range s.pending {
+		if err := enc.Encode(d); err != nil {
+			s.logger.WithError(err).Error("decision write failed")
+		}
+		// flush once caught up, so idle periods don't hold decisions back
+		if len(s.pending) == 0 {
+			if err := s.w.Flush(); err != nil {
+				s.logger.WithError(err).Error("decision flush failed")
+			}
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		s.logger.WithError(err).Error("decision flush failed")
+	}
+}
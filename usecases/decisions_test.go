@@ -0,0 +1,109 @@
+// +build unit
+
+package usecases_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+type memoryDecisionSink struct {
+	mu        sync.Mutex
+	decisions []models.Decision
+}
+
+func (s *memoryDecisionSink) Write(d models.Decision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, d)
+}
+
+func (s *memoryDecisionSink) Close() error {
+	return nil
+}
+
+func TestDecisionLoggerSamples(t *testing.T) {
+	sink := &memoryDecisionSink{}
+	dl := usecases.NewDecisionLogger(sink, usecases.DecisionLoggerConfig{
+		AllowedSampleRate: 0,
+		DeniedSampleRate:  1,
+	})
+	ctx := usecases.WithAuditActor(context.Background(), &usecases.AuditActor{
+		ServiceAccountID: "sa", ImpersonatorID: "admin", RequestID: "request",
+	})
+	ctx = usecases.WithDecisionRoute(ctx, "rolesUpdateHandler")
+	for i := 0; i < 10; i++ {
+		dl.Log(ctx, models.Decision{ServiceAccountID: "sa", Allowed: true})
+	}
+	dl.Log(ctx, models.Decision{
+		ServiceAccountID: "sa", Permission: "Will.IAM::RO::EditRole::r",
+		Reason: models.DecisionReasonNoGrant,
+	})
+	if len(sink.decisions) != 1 {
+		t.Fatalf("Expected only the denied decision. Got %d", len(sink.decisions))
+	}
+	d := sink.decisions[0]
+	if d.ImpersonatorID != "admin" || d.RequestID != "request" ||
+		d.Route != "rolesUpdateHandler" || d.Time.IsZero() {
+		t.Errorf("Expected decision to be filled from ctx. Got %+v", d)
+	}
+	var nilLogger *usecases.DecisionLogger
+	nilLogger.Log(ctx, d)
+}
+
+func TestWriterDecisionSinkWritesJSONLines(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := usecases.NewWriterDecisionSink(buf, 100, helpers.GetLogger(t))
+	for i := 0; i < 3; i++ {
+		sink.Write(models.Decision{ServiceAccountID: "sa", Allowed: i%2 == 0})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sink.Write(models.Decision{ServiceAccountID: "after close"})
+	lines := 0
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		d := models.Decision{}
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if d.ServiceAccountID != "sa" {
+			t.Errorf("Expected decision of sa. Got %s", d.ServiceAccountID)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 decisions written. Got %d", lines)
+	}
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestWriterDecisionSinkDropsWhenFull(t *testing.T) {
+	w := blockingWriter{unblock: make(chan struct{})}
+	sink := usecases.NewWriterDecisionSink(w, 2, helpers.GetLogger(t))
+	for i := 0; i < 100; i++ {
+		sink.Write(models.Decision{ServiceAccountID: "sa"})
+	}
+	if sink.Dropped() == 0 {
+		t.Errorf("Expected decisions past the buffer to be dropped")
+	}
+	close(w.unblock)
+	sink.Close()
+}
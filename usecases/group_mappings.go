@@ -0,0 +1,109 @@
+package usecases
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// GroupMappings synchronizes identity provider groups to managed role
+// bindings
+type GroupMappings interface {
+	Sync(string, string, []string) error
+	WithContext(context.Context) GroupMappings
+}
+
+type groupMappings struct {
+	repo     *repositories.All
+	ctx      context.Context
+	mappings []models.GroupRoleMapping
+}
+
+func (gm groupMappings) WithContext(ctx context.Context) GroupMappings {
+	return &groupMappings{gm.repo.WithContext(ctx), ctx, gm.mappings}
+}
+
+// rolesNamesFor returns the names of all roles mapped from groups
+func (gm groupMappings) rolesNamesFor(
+	provider string, groups []string,
+) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, m := range gm.mappings {
+		for _, group := range groups {
+			if m.Matches(provider, group) && !seen[m.Role] {
+				seen[m.Role] = true
+				names = append(names, m.Role)
+			}
+		}
+	}
+	return names
+}
+
+// Sync makes saID's managed role bindings match the roles mapped from
+// groups. Mapped roles that don't exist are ignored
+func (gm groupMappings) Sync(
+	saID, provider string, groups []string,
+) error {
+	if len(gm.mappings) == 0 {
+		return nil
+	}
+	return gm.repo.WithPGTx(gm.ctx, func(repo *repositories.All) error {
+		want := map[string]bool{}
+		for _, name := range gm.rolesNamesFor(provider, groups) {
+			role, err := repo.Roles.GetByName(name)
+			if _, ok := err.(*errors.EntityNotFoundError); ok {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			want[role.ID] = true
+		}
+		current, err := repo.Roles.ManagedBindings(saID)
+		if err != nil {
+			return err
+		}
+		unbound := []string{}
+		for i := range current {
+			if want[current[i].RoleID] {
+				delete(want, current[i].RoleID)
+				continue
+			}
+			if err := repo.Roles.Unbind(&current[i]); err != nil {
+				return err
+			}
+			unbound = append(unbound, current[i].RoleID)
+		}
+		bound := []string{}
+		for roleID := range want {
+			if err := repo.Roles.Bind(&models.RoleBinding{
+				RoleID:           roleID,
+				ServiceAccountID: saID,
+				Managed:          true,
+			}); err != nil {
+				return err
+			}
+			bound = append(bound, roleID)
+		}
+		if len(unbound) == 0 && len(bound) == 0 {
+			return nil
+		}
+		sort.Strings(bound)
+		return audit(
+			gm.ctx, repo, "groupMappings.sync", AuditTargetServiceAccount, saID,
+			map[string][]string{"managedRolesIds": unbound},
+			map[string][]string{"managedRolesIds": bound},
+		)
+	})
+}
+
+// NewGroupMappings ctor
+func NewGroupMappings(
+	repo *repositories.All, mappings []models.GroupRoleMapping,
+) GroupMappings {
+	return &groupMappings{repo: repo, mappings: mappings}
+}
@@ -0,0 +1,146 @@
+// +build integration
+
+package usecases_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ghostec/Will.IAM/models"
+	helpers "github.com/ghostec/Will.IAM/testing"
+	"github.com/ghostec/Will.IAM/usecases"
+)
+
+func beforeEachGroupMappings(t *testing.T) {
+	t.Helper()
+	storage := helpers.GetStorage(t)
+	rels := []string{"permissions", "role_bindings", "service_accounts", "roles"}
+	for _, rel := range rels {
+		if _, err := storage.PG.DB.Exec(
+			fmt.Sprintf("DELETE FROM %s;", rel),
+		); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func getGroupMappingsUseCase(t *testing.T) usecases.GroupMappings {
+	t.Helper()
+	return usecases.NewGroupMappings(helpers.GetRepo(t), []models.GroupRoleMapping{
+		models.GroupRoleMapping{
+			Provider: "google", Group: "eng@domain.com", Role: "engineering",
+		},
+		models.GroupRoleMapping{Group: "ops@domain.com", Role: "operations"},
+	}).WithContext(context.Background())
+}
+
+func roleIDsOf(roles []models.Role) map[string]bool {
+	ids := map[string]bool{}
+	for i := range roles {
+		ids[roles[i].ID] = true
+	}
+	return ids
+}
+
+func TestGroupMappingsSync(t *testing.T) {
+	beforeEachGroupMappings(t)
+	rsUC := helpers.GetRolesUseCase(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	gmUC := getGroupMappingsUseCase(t)
+	eng := &usecases.RoleWithNested{Name: "engineering"}
+	ops := &usecases.RoleWithNested{Name: "operations"}
+	for _, rwn := range []*usecases.RoleWithNested{eng, ops} {
+		if err := rsUC.Create(rwn); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	sa, err := saUC.CreateOAuth2Type("some sa", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := gmUC.Sync(
+		sa.ID, "google", []string{"eng@domain.com", "ops@domain.com"},
+	); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	roles, err := saUC.GetRoles(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	ids := roleIDsOf(roles)
+	if !ids[eng.ID] || !ids[ops.ID] {
+		t.Errorf("Expected sa to be bound to engineering and operations")
+	}
+	if err := gmUC.Sync(sa.ID, "okta", []string{"eng@domain.com"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	roles, err = saUC.GetRoles(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	ids = roleIDsOf(roles)
+	if ids[eng.ID] || ids[ops.ID] {
+		t.Errorf("Expected managed bindings to be removed after leaving groups")
+	}
+}
+
+func TestGroupMappingsSyncKeepsUnmanagedBindings(t *testing.T) {
+	beforeEachGroupMappings(t)
+	rsUC := helpers.GetRolesUseCase(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	gmUC := getGroupMappingsUseCase(t)
+	sa, err := saUC.CreateOAuth2Type("some sa", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	eng := &usecases.RoleWithNested{
+		Name: "engineering", ServiceAccountsIDs: []string{sa.ID},
+	}
+	if err := rsUC.Create(eng); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := gmUC.Sync(sa.ID, "google", []string{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	roles, err := saUC.GetRoles(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !roleIDsOf(roles)[eng.ID] {
+		t.Errorf("Expected unmanaged binding to be kept")
+	}
+}
+
+func TestServiceAccountsUpdateWithNestedKeepsManagedBindings(t *testing.T) {
+	beforeEachGroupMappings(t)
+	rsUC := helpers.GetRolesUseCase(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	gmUC := getGroupMappingsUseCase(t)
+	eng := &usecases.RoleWithNested{Name: "engineering"}
+	if err := rsUC.Create(eng); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sa, err := saUC.CreateOAuth2Type("some sa", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := gmUC.Sync(sa.ID, "google", []string{"eng@domain.com"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := saUC.UpdateWithNested(&usecases.ServiceAccountWithNested{
+		ID:                 sa.ID,
+		Name:               sa.Name,
+		AuthenticationType: models.AuthenticationTypes.OAuth2,
+		RolesIDs:           []string{},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sawn, err := saUC.GetWithNested(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(sawn.ManagedRolesIDs) != 1 || sawn.ManagedRolesIDs[0] != eng.ID {
+		t.Errorf("Expected managed binding to engineering to be kept")
+	}
+}
@@ -0,0 +1,252 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// TOTPEnrollment is what authenticator apps need to generate codes
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFA contract: second factors of service accounts and step-up of their
+// access tokens
+type MFA interface {
+	ConfirmTOTP(string, string, string) error
+	EnrollTOTP(string, string) (*TOTPEnrollment, error)
+	HasRecentStepUp(string, string, time.Duration) (bool, error)
+	StepUp(string, string, string) error
+	WithContext(context.Context) MFA
+}
+
+type accessTokenCtxKeyType string
+
+const accessTokenCtxKey = accessTokenCtxKeyType("accessToken")
+
+// WithAccessToken returns a copy of ctx in which changes are made with
+// accessToken, whose step-up sensitive changes check
+func WithAccessToken(ctx context.Context, accessToken string) context.Context {
+	return context.WithValue(ctx, accessTokenCtxKey, accessToken)
+}
+
+func getAccessToken(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	accessToken, _ := ctx.Value(accessTokenCtxKey).(string)
+	return accessToken
+}
+
+type mfa struct {
+	repo *repositories.All
+	ctx  context.Context
+}
+
+func (m mfa) WithContext(ctx context.Context) MFA {
+	return &mfa{m.repo.WithContext(ctx), ctx}
+}
+
+// EnrollTOTP sets a new, unconfirmed, TOTP secret for saID. Replacing a
+// confirmed secret requires accessToken to have stepped up recently; the
+// first enrollment requires it to come from a fresh login, so a stolen
+// token can't enroll a factor of its own. Scoped tokens can't enroll
+func (m mfa) EnrollTOTP(saID, accessToken string) (*TOTPEnrollment, error) {
+	sa, err := m.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := m.repo.Tokens.Get(accessToken)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return nil, errors.NewInvalidMFAError("requires an access token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Scope != nil {
+		return nil, errors.NewInvalidMFAError("scoped tokens can't enroll")
+	}
+	var before interface{}
+	current, err := m.repo.TOTPSecrets.Get(saID)
+	if err == nil {
+		before = map[string]bool{"totpConfirmed": current.Confirmed()}
+	}
+	if err == nil && current.Confirmed() {
+		ok, err := m.HasRecentStepUp(
+			saID, accessToken, constants.MFAStepUpMaxAge,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.NewMFARequiredError("ReplaceTOTP")
+		}
+	} else if _, ok := err.(*errors.EntityNotFoundError); err != nil && !ok {
+		return nil, err
+	} else {
+		fresh, err := m.repo.Tokens.FamilyStartedSince(
+			t.FamilyID, time.Now().UTC().Add(-constants.MFAStepUpMaxAge),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, errors.NewMFARequiredError("EnrollTOTP")
+		}
+	}
+	secret, err := models.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.repo.WithPGTx(m.ctx, func(repo *repositories.All) error {
+		if err := repo.TOTPSecrets.Upsert(&models.TOTPSecret{
+			ServiceAccountID: saID,
+			Secret:           secret,
+		}); err != nil {
+			return err
+		}
+		return audit(
+			m.ctx, repo, "mfa.enrollTOTP", AuditTargetServiceAccount, saID,
+			before, map[string]bool{"totpConfirmed": false},
+		)
+	}); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    models.TOTPURI(constants.AppInfo.Name, totpAccount(sa), secret),
+	}, nil
+}
+
+// ConfirmTOTP confirms saID's secret with its first code, which also
+// steps accessToken up
+func (m mfa) ConfirmTOTP(saID, accessToken, code string) error {
+	s, err := m.repo.TOTPSecrets.Get(saID)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return errors.NewInvalidMFAError("no TOTP enrollment")
+	}
+	if err != nil {
+		return err
+	}
+	step, ok := models.VerifyTOTP(s.Secret, code, time.Now(), s.LastUsedStep)
+	if !ok {
+		return errors.NewInvalidMFAError("wrong code")
+	}
+	return m.repo.WithPGTx(m.ctx, func(repo *repositories.All) error {
+		ok, err := repo.TOTPSecrets.Confirm(saID, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.NewInvalidMFAError("code already used")
+		}
+		if err := repo.Tokens.SetMFAAt(accessToken); err != nil {
+			return err
+		}
+		return audit(
+			m.ctx, repo, "mfa.confirmTOTP", AuditTargetServiceAccount, saID,
+			map[string]bool{"totpConfirmed": s.Confirmed()},
+			map[string]bool{"totpConfirmed": true},
+		)
+	})
+}
+
+// StepUp verifies code and records accessToken's bearer proved saID's
+// second factor now
+func (m mfa) StepUp(saID, accessToken, code string) error {
+	s, err := m.repo.TOTPSecrets.Get(saID)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return errors.NewInvalidMFAError("no TOTP enrollment")
+	}
+	if err != nil {
+		return err
+	}
+	if !s.Confirmed() {
+		return errors.NewInvalidMFAError("TOTP enrollment not confirmed")
+	}
+	step, ok := models.VerifyTOTP(s.Secret, code, time.Now(), s.LastUsedStep)
+	if !ok {
+		return errors.NewInvalidMFAError("wrong code")
+	}
+	if ok, err = m.repo.TOTPSecrets.UseStep(saID, step); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.NewInvalidMFAError("code already used")
+	}
+	return m.repo.Tokens.SetMFAAt(accessToken)
+}
+
+// HasRecentStepUp checks if accessToken stepped up within maxAge. Every
+// service account is subject to it, key pair ones included: they step up
+// the tokens they get from the client_credentials grant
+func (m mfa) HasRecentStepUp(
+	saID, accessToken string, maxAge time.Duration,
+) (bool, error) {
+	return hasRecentStepUp(m.repo, saID, accessToken, maxAge)
+}
+
+func hasRecentStepUp(
+	repo *repositories.All, saID, accessToken string, maxAge time.Duration,
+) (bool, error) {
+	if accessToken == "" {
+		return false, nil
+	}
+	t, err := repo.Tokens.Get(accessToken)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if t.ServiceAccountID != "" && t.ServiceAccountID != saID {
+		return false, nil
+	}
+	return !t.MFAAt.IsZero() &&
+		time.Now().UTC().Sub(t.MFAAt.Time) <= maxAge, nil
+}
+
+// requireStepUpToGrant checks whoever grants p in ctx stepped up recently
+// when p is RO over every resource, since it lets them hand it on. Changes
+// made outside of requests, with no actor in ctx, aren't checked
+func requireStepUpToGrant(
+	ctx context.Context, repo *repositories.All, p models.Permission,
+) error {
+	if !p.HasFullResourceOwnership() {
+		return nil
+	}
+	actor, ok := GetAuditActor(ctx)
+	if !ok {
+		return nil
+	}
+	stepped, err := hasRecentStepUp(
+		repo, actor.ServiceAccountID, getAccessToken(ctx),
+		constants.MFAStepUpMaxAge,
+	)
+	if err != nil {
+		return err
+	}
+	if !stepped {
+		return errors.NewMFARequiredError("GrantOwnership")
+	}
+	return nil
+}
+
+// totpAccount labels sa's secret in authenticator apps
+func totpAccount(sa *models.ServiceAccount) string {
+	if sa.Email != "" {
+		return sa.Email
+	}
+	return sa.Name
+}
+
+// NewMFA ctor
+func NewMFA(repo *repositories.All) MFA {
+	return &mfa{repo: repo, ctx: context.Background()}
+}
@@ -0,0 +1,453 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// OAuth2CodeTTL is how long an authorization code can be traded for tokens
+const OAuth2CodeTTL = 10 * time.Minute
+
+// OAuth2AccessTokenTTL is how long tokens issued by Will.IAM are valid
+const OAuth2AccessTokenTTL = time.Hour
+
+// ImpersonationTokenTTL is how long an impersonation token is valid
+const ImpersonationTokenTTL = 15 * time.Minute
+
+// OAuth2Server contract: Will.IAM as an authorization server for
+// first-party client applications
+type OAuth2Server interface {
+	Authorize(string, *models.OAuth2AuthorizationCode) error
+	ClientCredentials(string, string, []string) (*models.Token, error)
+	ClientForRedirect(string, string) (*models.OAuth2Client, error)
+	CreateClient(*models.OAuth2Client) error
+	ExchangeCode(string, string, string, string, string) (*models.Token, error)
+	HasConsent(string, string) (bool, error)
+	Impersonate(string, string) (*models.Token, error)
+	IssueScopedToken(string, string, []string) (*models.Token, error)
+	ListClients(string) ([]models.OAuth2Client, error)
+	Refresh(string, string, string) (*models.Token, error)
+	WithContext(context.Context) OAuth2Server
+}
+
+type oauth2Server struct {
+	repo *repositories.All
+	ctx  context.Context
+}
+
+func (o2s oauth2Server) WithContext(ctx context.Context) OAuth2Server {
+	return &oauth2Server{o2s.repo.WithContext(ctx), ctx}
+}
+
+// CreateClient registers c under its service. c.ClientSecret is only
+// available in c right after this call
+func (o2s oauth2Server) CreateClient(c *models.OAuth2Client) error {
+	if _, err := o2s.repo.Services.Get(c.ServiceID); err != nil {
+		return err
+	}
+	if err := models.BuildOAuth2Client(c); err != nil {
+		return err
+	}
+	return o2s.repo.WithPGTx(o2s.ctx, func(repo *repositories.All) error {
+		if err := repo.OAuth2Clients.Create(c); err != nil {
+			return err
+		}
+		return audit(
+			o2s.ctx, repo, "oauth2Server.createClient", AuditTargetOAuth2Client,
+			c.ID, nil, auditedOAuth2Client(c),
+		)
+	})
+}
+
+// ListClients returns the clients registered under serviceID
+func (o2s oauth2Server) ListClients(
+	serviceID string,
+) ([]models.OAuth2Client, error) {
+	return o2s.repo.OAuth2Clients.ForService(serviceID)
+}
+
+// ClientForRedirect returns clientID's client if it accepts redirectURI
+func (o2s oauth2Server) ClientForRedirect(
+	clientID, redirectURI string,
+) (*models.OAuth2Client, error) {
+	c, err := o2s.repo.OAuth2Clients.Get(clientID)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return nil, errors.NewOAuth2Error("invalid_request", "unknown client_id")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.AllowsRedirectURI(redirectURI) {
+		return nil, errors.NewOAuth2Error(
+			"invalid_request", "redirect_uri not registered for client",
+		)
+	}
+	return c, nil
+}
+
+// HasConsent checks if saID already approved clientID
+func (o2s oauth2Server) HasConsent(clientID, saID string) (bool, error) {
+	return o2s.repo.OAuth2Consents.Exists(clientID, saID)
+}
+
+// Authorize records saID's consent and issues ac.Code for ac.ClientID.
+// Scoped and impersonation tokens can't authorize clients: the tokens
+// clients get are neither
+func (o2s oauth2Server) Authorize(
+	saID string, ac *models.OAuth2AuthorizationCode,
+) error {
+	if _, err := o2s.ClientForRedirect(ac.ClientID, ac.RedirectURI); err != nil {
+		return err
+	}
+	if scope, ok := GetTokenScope(o2s.ctx); ok && scope.ServiceAccountID == saID {
+		return errors.NewOAuth2Error(
+			"access_denied", "scoped tokens can't authorize clients",
+		)
+	}
+	if actor, ok := GetAuditActor(o2s.ctx); ok && actor.ImpersonatorID != "" {
+		return errors.NewOAuth2Error(
+			"access_denied", "impersonation tokens can't authorize clients",
+		)
+	}
+	if ac.CodeChallenge == "" || ac.CodeChallengeMethod != "S256" {
+		return errors.NewOAuth2Error(
+			"invalid_request", "code_challenge with method S256 required",
+		)
+	}
+	sa, err := o2s.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return err
+	}
+	if sa.Email == "" {
+		return errors.NewOAuth2Error(
+			"invalid_request", "only oauth2 service accounts can authorize clients",
+		)
+	}
+	if ac.Code, err = models.RandomToken(32); err != nil {
+		return err
+	}
+	ac.ServiceAccountID = sa.ID
+	ac.ExpiresAt = time.Now().UTC().Add(OAuth2CodeTTL)
+	return o2s.repo.WithPGTx(o2s.ctx, func(repo *repositories.All) error {
+		if err := repo.OAuth2Consents.Create(ac.ClientID, sa.ID); err != nil {
+			return err
+		}
+		return repo.OAuth2Codes.Create(ac)
+	})
+}
+
+func authenticateOAuth2Client(
+	repo *repositories.All, clientID, secret string,
+) (*models.OAuth2Client, error) {
+	c, err := repo.OAuth2Clients.Get(clientID)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return nil, errors.NewOAuth2Error("invalid_client", "unknown client")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.Authenticate(secret) {
+		return nil, errors.NewOAuth2Error(
+			"invalid_client", "client authentication failed",
+		)
+	}
+	return c, nil
+}
+
+func buildOAuth2Token(
+	clientID string, sa *models.ServiceAccount, familyID string,
+) (*models.Token, error) {
+	accessToken, err := models.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := models.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Token{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		TokenType:        "Bearer",
+		Expiry:           time.Now().UTC().Add(OAuth2AccessTokenTTL),
+		Email:            sa.Email,
+		Provider:         constants.AppInfo.Name,
+		ClientID:         clientID,
+		ServiceAccountID: sa.ID,
+		FamilyID:         familyID,
+	}, nil
+}
+
+// ExchangeCode trades code for tokens. A code is traded only once;
+// presenting it again revokes the tokens it was traded for
+func (o2s oauth2Server) ExchangeCode(
+	clientID, secret, code, redirectURI, verifier string,
+) (*models.Token, error) {
+	c, err := authenticateOAuth2Client(o2s.repo, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	var token *models.Token
+	var reused bool
+	err = o2s.repo.WithPGTx(o2s.ctx, func(repo *repositories.All) error {
+		ac, err := repo.OAuth2Codes.GetForUpdate(code)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return errors.NewOAuth2Error("invalid_grant", "unknown code")
+		}
+		if err != nil {
+			return err
+		}
+		if ac.ClientID != c.ClientID {
+			return errors.NewOAuth2Error("invalid_grant", "code of another client")
+		}
+		if !ac.UsedAt.IsZero() {
+			reused = true
+			if ac.FamilyID == "" {
+				return nil
+			}
+			return repo.Tokens.RevokeFamily(ac.FamilyID)
+		}
+		if ac.ExpiresAt.Before(time.Now().UTC()) {
+			return errors.NewOAuth2Error("invalid_grant", "code expired")
+		}
+		if ac.RedirectURI != redirectURI {
+			return errors.NewOAuth2Error("invalid_grant", "redirect_uri mismatch")
+		}
+		if !ac.VerifyCodeVerifier(verifier) {
+			return errors.NewOAuth2Error("invalid_grant", "invalid code_verifier")
+		}
+		sa, err := repo.ServiceAccounts.Get(ac.ServiceAccountID)
+		if err != nil {
+			return err
+		}
+		if sa.Disabled {
+			return errors.NewOAuth2Error("invalid_grant", "service account disabled")
+		}
+		if token, err = buildOAuth2Token(c.ClientID, sa, ""); err != nil {
+			return err
+		}
+		if err := repo.Tokens.Create(token); err != nil {
+			return err
+		}
+		ac.FamilyID = token.FamilyID
+		return repo.OAuth2Codes.MarkUsed(ac)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, errors.NewOAuth2Error("invalid_grant", "code already used")
+	}
+	return token, nil
+}
+
+// Refresh trades refreshToken for new tokens in the same family. A refresh
+// token is traded only once; presenting it again revokes the whole family
+func (o2s oauth2Server) Refresh(
+	clientID, secret, refreshToken string,
+) (*models.Token, error) {
+	c, err := authenticateOAuth2Client(o2s.repo, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == "" {
+		return nil, errors.NewOAuth2Error("invalid_request", "refresh_token required")
+	}
+	var token *models.Token
+	var reused bool
+	err = o2s.repo.WithPGTx(o2s.ctx, func(repo *repositories.All) error {
+		t, err := repo.Tokens.GetByRefreshTokenForUpdate(
+			refreshToken, constants.AppInfo.Name,
+		)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return errors.NewOAuth2Error("invalid_grant", "unknown refresh_token")
+		}
+		if err != nil {
+			return err
+		}
+		if t.ClientID != c.ClientID {
+			return errors.NewOAuth2Error(
+				"invalid_grant", "refresh_token of another client",
+			)
+		}
+		if t.SuccessorID != "" {
+			reused = true
+			return repo.Tokens.RevokeFamily(t.FamilyID)
+		}
+		if !t.ExpiredAt.IsZero() {
+			return errors.NewOAuth2Error("invalid_grant", "refresh_token revoked")
+		}
+		sa, err := repo.ServiceAccounts.Get(t.ServiceAccountID)
+		if err != nil {
+			return err
+		}
+		if sa.Disabled {
+			return errors.NewOAuth2Error("invalid_grant", "service account disabled")
+		}
+		token, err = buildOAuth2Token(c.ClientID, sa, t.FamilyID)
+		if err != nil {
+			return err
+		}
+		if err := repo.Tokens.Create(token); err != nil {
+			return err
+		}
+		return repo.Tokens.Rotate(t, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, errors.NewOAuth2Error("invalid_grant", "refresh_token reused")
+	}
+	return token, nil
+}
+
+// ClientCredentials trades a key pair for an access token, optionally
+// restricted to scope. No refresh token is issued: the key pair can
+// always get another one
+func (o2s oauth2Server) ClientCredentials(
+	keyID, keySecret string, scope []string,
+) (*models.Token, error) {
+	sa, err := o2s.repo.ServiceAccounts.ForKeyPair(keyID, keySecret)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		return nil, errors.NewOAuth2Error(
+			"invalid_client", "client authentication failed",
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sa.Disabled {
+		return nil, errors.NewOAuth2Error(
+			"invalid_client", "service account disabled",
+		)
+	}
+	if len(scope) > 0 {
+		if err := o2s.checkScope(sa.ID, scope); err != nil {
+			return nil, err
+		}
+	}
+	token, err := buildOAuth2Token("", sa, "")
+	if err != nil {
+		return nil, err
+	}
+	token.RefreshToken = ""
+	if len(scope) > 0 {
+		token.Scope = scope
+	}
+	if err := o2s.repo.Tokens.Create(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// IssueScopedToken issues a token of saID restricted to scope. Callers
+// must check saID holds scope. parentAccessToken is the token saID
+// authenticated with, if any: tokens minted from an impersonation token
+// are impersonation tokens too, and expire no later than it
+func (o2s oauth2Server) IssueScopedToken(
+	saID, parentAccessToken string, scope []string,
+) (*models.Token, error) {
+	sa, err := o2s.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := buildOAuth2Token("", sa, "")
+	if err != nil {
+		return nil, err
+	}
+	token.RefreshToken = ""
+	token.Scope = scope
+	if parentAccessToken != "" {
+		parent, err := o2s.repo.Tokens.Get(parentAccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if parent.ImpersonatorID != "" {
+			token.ImpersonatorID = parent.ImpersonatorID
+			if parent.Expiry.Before(token.Expiry) {
+				token.Expiry = parent.Expiry
+			}
+		}
+	}
+	if err := o2s.repo.Tokens.Create(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Impersonate issues a token with which impersonatorID acts as saID.
+// Callers must check impersonatorID may impersonate saID and isn't
+// impersonating anyone already
+func (o2s oauth2Server) Impersonate(
+	impersonatorID, saID string,
+) (*models.Token, error) {
+	if impersonatorID == saID {
+		return nil, errors.NewImpersonationError("can't impersonate oneself")
+	}
+	sa, err := o2s.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return nil, err
+	}
+	if sa.Disabled {
+		return nil, errors.NewImpersonationError(
+			"can't impersonate a disabled service account",
+		)
+	}
+	token, err := buildOAuth2Token("", sa, "")
+	if err != nil {
+		return nil, err
+	}
+	token.RefreshToken = ""
+	token.Expiry = time.Now().UTC().Add(ImpersonationTokenTTL)
+	token.ImpersonatorID = impersonatorID
+	if err := o2s.repo.WithPGTx(o2s.ctx, func(repo *repositories.All) error {
+		if err := repo.Tokens.Create(token); err != nil {
+			return err
+		}
+		return audit(
+			o2s.ctx, repo, "oauth2Server.impersonate", AuditTargetServiceAccount,
+			saID, nil, map[string]interface{}{
+				"impersonatorId": impersonatorID,
+				"expiresAt":      token.Expiry,
+			},
+		)
+	}); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkScope validates saID holds every permission in scope
+func (o2s oauth2Server) checkScope(saID string, scope []string) error {
+	ps := make([]models.Permission, len(scope))
+	for i := range scope {
+		p, err := models.BuildPermission(scope[i])
+		if err != nil {
+			return errors.NewOAuth2Error("invalid_scope", err.Error())
+		}
+		ps[i] = p
+	}
+	has, err := serviceAccountHasPermissions(o2s.ctx, o2s.repo, saID, ps)
+	if err != nil {
+		return err
+	}
+	for i := range has {
+		if !has[i] {
+			return errors.NewOAuth2Error(
+				"invalid_scope", "service account lacks "+scope[i],
+			)
+		}
+	}
+	return nil
+}
+
+// NewOAuth2Server ctor
+func NewOAuth2Server(repo *repositories.All) OAuth2Server {
+	return &oauth2Server{repo: repo, ctx: context.Background()}
+}
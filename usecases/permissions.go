@@ -3,6 +3,7 @@ package usecases
 import (
 	"context"
 
+	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/repositories"
 )
@@ -49,18 +50,63 @@ func (ps permissions) Get(id string) (*models.Permission, error) {
 }
 
 func (ps permissions) Delete(id string) error {
-	return ps.repo.Permissions.Delete(id)
+	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		p, err := repo.Permissions.Get(id)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := repo.Permissions.Delete(id); err != nil {
+			return err
+		}
+		return audit(
+			ps.ctx, repo, "permissions.delete", AuditTargetPermission, id,
+			map[string]string{"roleId": p.RoleID, "permission": p.String()}, nil,
+		)
+	})
 }
 
 func (ps permissions) CreateRequest(
 	saID string, r *models.PermissionRequest,
 ) error {
 	r.State = models.PermissionRequestStates.Created
-	return ps.repo.Permissions.CreateRequest(saID, r)
+	if err := checkCatalog(ps.repo, models.Permission{
+		Service:           r.Service,
+		OwnershipLevel:    models.OwnershipLevels.Lender,
+		Action:            r.Action,
+		ResourceHierarchy: r.ResourceHierarchy,
+	}); err != nil {
+		return err
+	}
+	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		if err := repo.Permissions.CreateRequest(saID, r); err != nil {
+			return err
+		}
+		return audit(
+			ps.ctx, repo, "permissionRequests.create",
+			AuditTargetPermissionRequest, r.ID, nil, map[string]interface{}{
+				"serviceAccountId":  saID,
+				"service":           r.Service,
+				"action":            r.Action,
+				"resourceHierarchy": r.ResourceHierarchy,
+				"state":             r.State,
+			},
+		)
+	})
 }
 
 func (ps permissions) Create(p *models.Permission) error {
-	return ps.repo.Permissions.Create(p)
+	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		if err := createPermission(ps.ctx, repo, p); err != nil {
+			return err
+		}
+		return audit(
+			ps.ctx, repo, "permissions.create", AuditTargetRole, p.RoleID,
+			nil, map[string]string{"permission": p.String()},
+		)
+	})
 }
 
 func (ps permissions) GetPermissionRequests(
@@ -71,13 +117,22 @@ func (ps permissions) GetPermissionRequests(
 
 func (ps permissions) Attribute(pa *PermissionsAttribute) error {
 	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		after := map[string][]string{
+			"permissions": permissionsStrings(pa.Permissions),
+		}
 		for _, roleID := range pa.RolesIDs {
 			for _, permission := range pa.Permissions {
 				permission.RoleID = roleID
-				if err := repo.Permissions.Create(&permission); err != nil {
+				if err := createPermission(ps.ctx, repo, &permission); err != nil {
 					return err
 				}
 			}
+			if err := audit(
+				ps.ctx, repo, "permissions.attribute", AuditTargetRole, roleID,
+				nil, after,
+			); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -89,13 +144,22 @@ func (ps permissions) AttributeToEmails(pa *PermissionsAttributeToEmails) error
 		return err
 	}
 	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		after := map[string][]string{
+			"permissions": permissionsStrings(pa.Permissions),
+		}
 		for _, sa := range sas {
 			for _, permission := range pa.Permissions {
 				permission.RoleID = sa.BaseRoleID
-				if err := repo.Permissions.Create(&permission); err != nil {
+				if err := createPermission(ps.ctx, repo, &permission); err != nil {
 					return err
 				}
 			}
+			if err := audit(
+				ps.ctx, repo, "permissions.attributeToEmails",
+				AuditTargetServiceAccount, sa.ID, nil, after,
+			); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
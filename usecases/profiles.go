@@ -0,0 +1,117 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/oauth2"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// Profiles keeps OAuth2 service accounts' display name, picture and hosted
+// domain in sync with their identity provider. Emails are never synced:
+// they identify OAuth2 service accounts
+type Profiles interface {
+	SyncLogin(string, models.Profile) error
+	SyncStale(time.Duration, int) (*ProfileSyncResult, error)
+	WithContext(context.Context) Profiles
+}
+
+// ProfileSyncResult counts what a SyncStale run did. Skipped accounts
+// have no usable token to ask the provider with; they sync on next login
+type ProfileSyncResult struct {
+	Synced  int
+	Skipped int
+	Failed  int
+}
+
+type profiles struct {
+	repo     *repositories.All
+	ctx      context.Context
+	provider oauth2.Provider
+}
+
+func (ps profiles) WithContext(ctx context.Context) Profiles {
+	return &profiles{
+		repo:     ps.repo.WithContext(ctx),
+		ctx:      ctx,
+		provider: ps.provider.WithContext(ctx),
+	}
+}
+
+// SyncLogin records a login of saID, with the profile the provider
+// returned with it
+func (ps profiles) SyncLogin(saID string, profile models.Profile) error {
+	return ps.repo.WithPGTx(ps.ctx, func(repo *repositories.All) error {
+		if !profile.IsZero() {
+			if err := repo.ServiceAccounts.UpdateProfile(saID, profile); err != nil {
+				return err
+			}
+		}
+		return repo.ServiceAccounts.SetLastLoginAt(saID)
+	})
+}
+
+// SyncStale syncs up to limit service accounts not synced in maxAge using
+// their latest usable token. Expired tokens are refreshed through their
+// refresh token but not rotated, so their owner's copy stays the current
+// one. Accounts that can't be synced are still marked, so they don't
+// starve the others
+func (ps profiles) SyncStale(
+	maxAge time.Duration, limit int,
+) (*ProfileSyncResult, error) {
+	pp, ok := ps.provider.(oauth2.ProfileProvider)
+	if !ok {
+		return &ProfileSyncResult{}, nil
+	}
+	saSl, err := ps.repo.ServiceAccounts.ListProfileStale(maxAge, limit)
+	if err != nil {
+		return nil, err
+	}
+	result := &ProfileSyncResult{}
+	for i := range saSl {
+		t, err := ps.repo.Tokens.LatestUsableForEmail(saSl[i].Email)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			result.Skipped++
+			if err := ps.repo.ServiceAccounts.MarkProfileSynced(
+				saSl[i].ID,
+			); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// an empty profile is a provider answer gone wrong, not a profile
+		// to blank the account's with
+		profile, err := pp.Profile(t.AccessToken)
+		if err != nil || profile.IsZero() {
+			result.Failed++
+			if err := ps.repo.ServiceAccounts.MarkProfileSynced(
+				saSl[i].ID,
+			); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := ps.repo.ServiceAccounts.UpdateProfile(
+			saSl[i].ID, *profile,
+		); err != nil {
+			return nil, err
+		}
+		result.Synced++
+	}
+	return result, nil
+}
+
+// NewProfiles ctor
+func NewProfiles(repo *repositories.All, provider oauth2.Provider) Profiles {
+	return &profiles{
+		repo:     repo,
+		ctx:      context.Background(),
+		provider: provider,
+	}
+}
@@ -0,0 +1,212 @@
+// +build integration
+
+package usecases_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/oauth2"
+	"github.com/ghostec/Will.IAM/oauth2/googletest"
+	"github.com/ghostec/Will.IAM/usecases"
+	helpers "github.com/ghostec/Will.IAM/testing"
+)
+
+// profileProviderMock answers every profile request with profile
+type profileProviderMock struct {
+	*oauth2.ProviderBlankMock
+	profile models.Profile
+}
+
+func (p *profileProviderMock) Profile(string) (*models.Profile, error) {
+	return &p.profile, nil
+}
+
+func (p *profileProviderMock) WithContext(context.Context) oauth2.Provider {
+	return p
+}
+
+func beforeEachProfiles(t *testing.T) {
+	t.Helper()
+	beforeEachServiceAccounts(t)
+	storage := helpers.GetStorage(t)
+	if _, err := storage.PG.DB.Exec("DELETE FROM tokens"); err != nil {
+		panic(err)
+	}
+}
+
+func getProfilesUseCase(t *testing.T, profile models.Profile) usecases.Profiles {
+	t.Helper()
+	return usecases.NewProfiles(helpers.GetRepo(t), &profileProviderMock{
+		ProviderBlankMock: oauth2.NewProviderBlankMock(),
+		profile:           profile,
+	}).WithContext(context.Background())
+}
+
+func TestProfilesSyncStale(t *testing.T) {
+	beforeEachProfiles(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	withToken, err := saUC.CreateOAuth2Type("with token", "token@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	refreshable, err := saUC.CreateOAuth2Type("refreshable", "refresh@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := saUC.CreateOAuth2Type("no token", "none@domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := saUC.CreateOAuth2Type("expired", "expired@domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	repo := helpers.GetRepo(t)
+	for _, token := range []*models.Token{
+		{AccessToken: "some-token", Expiry: time.Now().Add(time.Hour),
+			Email: "token@domain.com"},
+		{AccessToken: "refreshable-token", RefreshToken: "some-refresh-token",
+			Expiry: time.Now().Add(-time.Hour), Email: "refresh@domain.com"},
+		{AccessToken: "expired-token", Expiry: time.Now().Add(-time.Hour),
+			Email: "expired@domain.com"},
+	} {
+		token.TokenType = "Bearer"
+		token.Provider = oauth2.GoogleProviderName
+		if err := repo.Tokens.Save(token); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	profile := models.Profile{
+		Name: "Some One", Picture: "some-picture", HostedDomain: "domain.com",
+	}
+	pfUC := getProfilesUseCase(t, profile)
+	result, err := pfUC.SyncStale(time.Hour, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if result.Synced != 2 || result.Skipped != 2 || result.Failed != 0 {
+		t.Errorf("Expected 2 synced and 2 skipped. Got %+v", *result)
+	}
+	for _, id := range []string{withToken.ID, refreshable.ID} {
+		sa, err := repo.ServiceAccounts.Get(id)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if sa.DisplayName != profile.Name || sa.Picture != profile.Picture ||
+			sa.HostedDomain != profile.HostedDomain {
+			t.Errorf("Expected profile %v. Got %v", profile, sa)
+		}
+	}
+	result, err = pfUC.SyncStale(time.Hour, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if result.Synced+result.Skipped+result.Failed != 0 {
+		t.Errorf("Expected nothing stale. Got %+v", *result)
+	}
+}
+
+func TestProfilesSyncStaleKeepsProfileOnProviderError(t *testing.T) {
+	beforeEachProfiles(t)
+	server := googletest.NewServer()
+	defer server.Close()
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateOAuth2Type("some name", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	repo := helpers.GetRepo(t)
+	profile := models.Profile{Name: "Some One", Picture: "some-picture"}
+	if err := repo.ServiceAccounts.UpdateProfile(sa.ID, profile); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// unknown to the server, which answers 401
+	if err := repo.Tokens.Save(&models.Token{
+		AccessToken: "revoked-token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+		Email:       "some@domain.com",
+		Provider:    oauth2.GoogleProviderName,
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for _, provider := range []oauth2.Provider{
+		oauth2.NewGoogle(server.Config(), repo),
+		&profileProviderMock{ProviderBlankMock: oauth2.NewProviderBlankMock()},
+	} {
+		if _, err := helpers.GetStorage(t).PG.DB.Exec(
+			"UPDATE service_accounts SET profile_synced_at = NULL",
+		); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		pfUC := usecases.NewProfiles(repo, provider).
+			WithContext(context.Background())
+		result, err := pfUC.SyncStale(time.Hour, 10)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if result.Synced != 0 || result.Failed != 1 {
+			t.Errorf("Expected 1 failed. Got %+v", *result)
+		}
+		synced, err := repo.ServiceAccounts.Get(sa.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if synced.DisplayName != profile.Name || synced.Picture != profile.Picture {
+			t.Errorf("Expected profile %v to be kept. Got %v", profile, synced)
+		}
+	}
+}
+
+func TestProfilesSyncLogin(t *testing.T) {
+	beforeEachProfiles(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateOAuth2Type("some name", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	pfUC := getProfilesUseCase(t, models.Profile{})
+	if err := pfUC.SyncLogin(sa.ID, models.Profile{Name: "Some One"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sawn, err := saUC.GetWithNested(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if sawn.DisplayName != "Some One" {
+		t.Errorf("Expected display name Some One. Got %s", sawn.DisplayName)
+	}
+	if sawn.LastLoginAt == nil {
+		t.Errorf("Expected lastLoginAt to be set")
+	}
+	if sawn.Email != "some@domain.com" {
+		t.Errorf("Expected email to be kept. Got %s", sawn.Email)
+	}
+}
+
+func TestServiceAccountsUpdateWithNestedKeepsEmail(t *testing.T) {
+	beforeEachServiceAccounts(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa, err := saUC.CreateOAuth2Type("some name", "some@domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := saUC.UpdateWithNested(&usecases.ServiceAccountWithNested{
+		ID:    sa.ID,
+		Name:  "other name",
+		Email: "other@domain.com",
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	updated, err := saUC.Get(sa.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if updated.Name != "other name" {
+		t.Errorf("Expected name other name. Got %s", updated.Name)
+	}
+	if updated.Email != "some@domain.com" {
+		t.Errorf("Expected email some@domain.com. Got %s", updated.Email)
+	}
+}
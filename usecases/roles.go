@@ -32,14 +32,14 @@ func (rs roles) WithContext(ctx context.Context) Roles {
 
 func (rs roles) Create(rwn *RoleWithNested) error {
 	return rs.repo.WithPGTx(rs.ctx, func(repo *repositories.All) error {
-		role := &models.Role{Name: rwn.Name}
+		role := &models.Role{Name: rwn.Name, SCIMManaged: rwn.SCIMManaged}
 		if err := repo.Roles.Create(role); err != nil {
 			return err
 		}
 		rwn.ID = role.ID
 		for i := range rwn.Permissions {
 			rwn.Permissions[i].RoleID = role.ID
-			if err := createPermission(repo, &rwn.Permissions[i]); err != nil {
+			if err := createPermission(rs.ctx, repo, &rwn.Permissions[i]); err != nil {
 				return err
 			}
 		}
@@ -51,16 +51,40 @@ func (rs roles) Create(rwn *RoleWithNested) error {
 				return err
 			}
 		}
-		return nil
+		after, err := auditedRole(repo, role.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			rs.ctx, repo, "roles.create", AuditTargetRole, role.ID, nil, after,
+		)
 	})
 }
 
 func (rs roles) CreatePermission(roleID string, p *models.Permission) error {
-	p.RoleID = roleID
-	return createPermission(rs.repo, p)
+	return rs.repo.WithPGTx(rs.ctx, func(repo *repositories.All) error {
+		p.RoleID = roleID
+		if err := createPermission(rs.ctx, repo, p); err != nil {
+			return err
+		}
+		return audit(
+			rs.ctx, repo, "roles.createPermission", AuditTargetRole, roleID,
+			nil, map[string]string{"permission": p.String()},
+		)
+	})
 }
 
-func createPermission(repo *repositories.All, p *models.Permission) error {
+// createPermission creates p if its service's catalog allows it and, when
+// it's RO over every resource, whoever grants it in ctx stepped up
+func createPermission(
+	ctx context.Context, repo *repositories.All, p *models.Permission,
+) error {
+	if err := checkCatalog(repo, *p); err != nil {
+		return err
+	}
+	if err := requireStepUpToGrant(ctx, repo, *p); err != nil {
+		return err
+	}
 	return repo.Permissions.Create(p)
 }
 
@@ -72,6 +96,7 @@ type RoleWithNested struct {
 	PermissionsAliases map[string]string   `json:"permissionsAliases"`
 	Permissions        []models.Permission `json:"-"`
 	ServiceAccountsIDs []string            `json:"serviceAccountsIds"`
+	SCIMManaged        bool                `json:"scimManaged"`
 }
 
 // Validate RoleWithNested fields
@@ -85,12 +110,16 @@ func (rwn RoleWithNested) Validate() models.Validation {
 
 func (rs roles) Update(rwn *RoleWithNested) error {
 	return rs.repo.WithPGTx(rs.ctx, func(repo *repositories.All) error {
+		before, err := auditedRole(repo, rwn.ID)
+		if err != nil {
+			return err
+		}
 		if err := repo.Roles.DropPermissions(rwn.ID); err != nil {
 			return err
 		}
 		for i := range rwn.Permissions {
 			rwn.Permissions[i].RoleID = rwn.ID
-			if err := createPermission(repo, &rwn.Permissions[i]); err != nil {
+			if err := createPermission(rs.ctx, repo, &rwn.Permissions[i]); err != nil {
 				return err
 			}
 		}
@@ -105,8 +134,19 @@ func (rs roles) Update(rwn *RoleWithNested) error {
 				return err
 			}
 		}
-		role := &models.Role{ID: rwn.ID, Name: rwn.Name}
-		return repo.Roles.Update(role)
+		role := &models.Role{
+			ID: rwn.ID, Name: rwn.Name, SCIMManaged: rwn.SCIMManaged,
+		}
+		if err := repo.Roles.Update(role); err != nil {
+			return err
+		}
+		after, err := auditedRole(repo, rwn.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			rs.ctx, repo, "roles.update", AuditTargetRole, rwn.ID, before, after,
+		)
 	})
 }
 
@@ -192,6 +232,7 @@ func (rs roles) Get(id string) (map[string]interface{}, error) {
 		"permissions":        permissions,
 		"permissionsAliases": permissionsAliases,
 		"serviceAccounts":    sasFiltered,
+		"scimManaged":        r.SCIMManaged,
 	}, nil
 }
 
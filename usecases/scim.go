@@ -0,0 +1,403 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+)
+
+// SCIM define entrypoints for SCIM 2.0 provisioning. Users are OAuth2
+// service accounts and Groups are non base roles
+type SCIM interface {
+	ListUsers(
+		*models.SCIMFilter, *repositories.ListOptions,
+	) ([]models.SCIMUser, int64, error)
+	GetUser(string) (*models.SCIMUser, error)
+	CreateUser(*models.SCIMUser) error
+	PatchUser(string, *models.SCIMPatchOp) (*models.SCIMUser, error)
+	DeleteUser(string) error
+	ListGroups(
+		*models.SCIMFilter, *repositories.ListOptions,
+	) ([]models.SCIMGroup, int64, error)
+	GetGroup(string) (*models.SCIMGroup, error)
+	CreateGroup(*models.SCIMGroup) error
+	PatchGroup(string, *models.SCIMPatchOp) (*models.SCIMGroup, error)
+	DeleteGroup(string) error
+	WithContext(context.Context) SCIM
+}
+
+type scim struct {
+	repo *repositories.All
+	ctx  context.Context
+}
+
+func (s scim) WithContext(ctx context.Context) SCIM {
+	return &scim{s.repo.WithContext(ctx), ctx}
+}
+
+// getUserServiceAccount returns a service account only if it is a SCIM user
+func getUserServiceAccount(
+	repo *repositories.All, id string,
+) (*models.ServiceAccount, error) {
+	sa, err := repo.ServiceAccounts.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if sa.KeyID != "" || sa.Email == "" {
+		return nil, errors.NewEntityNotFoundError(models.SCIMUser{}, id)
+	}
+	return sa, nil
+}
+
+func (s scim) ListUsers(
+	filter *models.SCIMFilter, lo *repositories.ListOptions,
+) ([]models.SCIMUser, int64, error) {
+	if filter != nil {
+		if filter.Attribute != "userName" {
+			return nil, 0, errors.NewInvalidSCIMFilterError(filter.Attribute)
+		}
+		sa, err := s.repo.ServiceAccounts.ForEmail(filter.Value)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return []models.SCIMUser{}, 0, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return []models.SCIMUser{models.BuildSCIMUser(sa)}, 1, nil
+	}
+	saSl, err := s.repo.ServiceAccounts.ListWithEmail(lo)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := s.repo.ServiceAccounts.ListWithEmailCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	users := make([]models.SCIMUser, len(saSl))
+	for i := range saSl {
+		users[i] = models.BuildSCIMUser(&saSl[i])
+	}
+	return users, count, nil
+}
+
+func (s scim) GetUser(id string) (*models.SCIMUser, error) {
+	sa, err := getUserServiceAccount(s.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	u := models.BuildSCIMUser(sa)
+	return &u, nil
+}
+
+func (s scim) CreateUser(u *models.SCIMUser) error {
+	return s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		_, err := repo.ServiceAccounts.ForEmail(u.UserName)
+		if err == nil {
+			return errors.NewSCIMUniquenessError("userName", u.UserName)
+		}
+		if _, ok := err.(*errors.EntityNotFoundError); !ok {
+			return err
+		}
+		sa := models.BuildOAuth2ServiceAccount(u.ServiceAccountName(), u.UserName)
+		sa.Disabled = !u.IsActive()
+		if err := createServiceAccount(sa, repo); err != nil {
+			return err
+		}
+		if sa.Disabled {
+			if err := repo.ServiceAccounts.Update(sa); err != nil {
+				return err
+			}
+		}
+		*u = models.BuildSCIMUser(sa)
+		after, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.createUser", AuditTargetServiceAccount, sa.ID,
+			nil, after,
+		)
+	})
+}
+
+func (s scim) PatchUser(
+	id string, p *models.SCIMPatchOp,
+) (*models.SCIMUser, error) {
+	var u models.SCIMUser
+	err := s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		sa, err := getUserServiceAccount(repo, id)
+		if err != nil {
+			return err
+		}
+		before, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		u = models.BuildSCIMUser(sa)
+		if err := p.ApplyToUser(&u); err != nil {
+			return errors.NewInvalidSCIMPatchError(err.Error())
+		}
+		wasDisabled := sa.Disabled
+		sa.Name = u.ServiceAccountName()
+		sa.Disabled = !u.IsActive()
+		if err := repo.ServiceAccounts.Update(sa); err != nil {
+			return err
+		}
+		if sa.Disabled && !wasDisabled {
+			if err := repo.Tokens.RevokeForEmail(sa.Email); err != nil {
+				return err
+			}
+		}
+		u = models.BuildSCIMUser(sa)
+		after, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.patchUser", AuditTargetServiceAccount, sa.ID,
+			before, after,
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s scim) DeleteUser(id string) error {
+	return s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		sa, err := getUserServiceAccount(repo, id)
+		if err != nil {
+			return err
+		}
+		before, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		if err := repo.Tokens.RevokeForEmail(sa.Email); err != nil {
+			return err
+		}
+		if err := repo.ServiceAccounts.Delete(sa.ID); err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.deleteUser", AuditTargetServiceAccount, sa.ID,
+			before, nil,
+		)
+	})
+}
+
+// getGroupRole returns a role only if it is a SCIM group
+func getGroupRole(repo *repositories.All, id string) (*models.Role, error) {
+	r, err := repo.Roles.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.IsBaseRole || !r.SCIMManaged {
+		return nil, errors.NewEntityNotFoundError(models.SCIMGroup{}, id)
+	}
+	return r, nil
+}
+
+// bindGroupMember binds the user saID to a Group's role; only users can be
+// members, so SCIM can't grant roles to key pair service accounts
+func bindGroupMember(repo *repositories.All, roleID, saID string) error {
+	if _, err := getUserServiceAccount(repo, saID); err != nil {
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return errors.NewInvalidSCIMMemberError(saID)
+		}
+		return err
+	}
+	return repo.Roles.Bind(&models.RoleBinding{
+		RoleID:           roleID,
+		ServiceAccountID: saID,
+	})
+}
+
+func buildSCIMGroup(
+	repo *repositories.All, r *models.Role,
+) (*models.SCIMGroup, error) {
+	sas, err := repo.Roles.GetServiceAccounts(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	g := models.BuildSCIMGroup(r, sas)
+	return &g, nil
+}
+
+func (s scim) ListGroups(
+	filter *models.SCIMFilter, lo *repositories.ListOptions,
+) ([]models.SCIMGroup, int64, error) {
+	var rsSl []models.Role
+	var count int64
+	if filter != nil {
+		if filter.Attribute != "displayName" {
+			return nil, 0, errors.NewInvalidSCIMFilterError(filter.Attribute)
+		}
+		r, err := s.repo.Roles.GetByName(filter.Value)
+		if _, ok := err.(*errors.EntityNotFoundError); ok {
+			return []models.SCIMGroup{}, 0, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if r.IsBaseRole || !r.SCIMManaged {
+			return []models.SCIMGroup{}, 0, nil
+		}
+		rsSl, count = []models.Role{*r}, 1
+	} else {
+		var err error
+		if rsSl, err = s.repo.Roles.ListSCIMManaged(lo); err != nil {
+			return nil, 0, err
+		}
+		if count, err = s.repo.Roles.ListSCIMManagedCount(); err != nil {
+			return nil, 0, err
+		}
+	}
+	groups := make([]models.SCIMGroup, len(rsSl))
+	for i := range rsSl {
+		g, err := buildSCIMGroup(s.repo, &rsSl[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		groups[i] = *g
+	}
+	return groups, count, nil
+}
+
+func (s scim) GetGroup(id string) (*models.SCIMGroup, error) {
+	r, err := getGroupRole(s.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	return buildSCIMGroup(s.repo, r)
+}
+
+func (s scim) CreateGroup(g *models.SCIMGroup) error {
+	return s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		_, err := repo.Roles.GetByName(g.DisplayName)
+		if err == nil {
+			return errors.NewSCIMUniquenessError("displayName", g.DisplayName)
+		}
+		if _, ok := err.(*errors.EntityNotFoundError); !ok {
+			return err
+		}
+		r := &models.Role{Name: g.DisplayName, SCIMManaged: true}
+		if err := repo.Roles.Create(r); err != nil {
+			return err
+		}
+		for _, saID := range g.MembersIDs() {
+			if err := bindGroupMember(repo, r.ID, saID); err != nil {
+				return err
+			}
+		}
+		r, err = repo.Roles.Get(r.ID)
+		if err != nil {
+			return err
+		}
+		created, err := buildSCIMGroup(repo, r)
+		if err != nil {
+			return err
+		}
+		*g = *created
+		after, err := auditedRole(repo, r.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.createGroup", AuditTargetRole, r.ID, nil, after,
+		)
+	})
+}
+
+func (s scim) PatchGroup(
+	id string, p *models.SCIMPatchOp,
+) (*models.SCIMGroup, error) {
+	var g *models.SCIMGroup
+	err := s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		r, err := getGroupRole(repo, id)
+		if err != nil {
+			return err
+		}
+		before, err := auditedRole(repo, r.ID)
+		if err != nil {
+			return err
+		}
+		current, err := buildSCIMGroup(repo, r)
+		if err != nil {
+			return err
+		}
+		patched := *current
+		patched.Members = append([]models.SCIMMultiValued{}, current.Members...)
+		if err := p.ApplyToGroup(&patched); err != nil {
+			return errors.NewInvalidSCIMPatchError(err.Error())
+		}
+		if patched.DisplayName != r.Name {
+			r.Name = patched.DisplayName
+			if err := repo.Roles.Update(r); err != nil {
+				return err
+			}
+		}
+		want := map[string]bool{}
+		for _, saID := range patched.MembersIDs() {
+			want[saID] = true
+		}
+		for _, saID := range current.MembersIDs() {
+			if want[saID] {
+				delete(want, saID)
+				continue
+			}
+			if err := repo.Roles.Unbind(&models.RoleBinding{
+				RoleID:           r.ID,
+				ServiceAccountID: saID,
+			}); err != nil {
+				return err
+			}
+		}
+		for saID := range want {
+			if err := bindGroupMember(repo, r.ID, saID); err != nil {
+				return err
+			}
+		}
+		g, err = buildSCIMGroup(repo, r)
+		if err != nil {
+			return err
+		}
+		after, err := auditedRole(repo, r.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.patchGroup", AuditTargetRole, r.ID, before, after,
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (s scim) DeleteGroup(id string) error {
+	return s.repo.WithPGTx(s.ctx, func(repo *repositories.All) error {
+		if _, err := getGroupRole(repo, id); err != nil {
+			return err
+		}
+		before, err := auditedRole(repo, id)
+		if err != nil {
+			return err
+		}
+		if err := repo.Roles.Delete(id); err != nil {
+			return err
+		}
+		return audit(
+			s.ctx, repo, "scim.deleteGroup", AuditTargetRole, id, before, nil,
+		)
+	})
+}
+
+// NewSCIM ctor
+func NewSCIM(repo *repositories.All) SCIM {
+	return &scim{repo: repo}
+}
@@ -3,6 +3,8 @@ package usecases
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
@@ -14,6 +16,7 @@ import (
 // ServiceAccounts define entrypoints for ServiceAccount actions
 type ServiceAccounts interface {
 	AuthenticateAccessToken(string) (*models.AccessTokenAuth, error)
+	AuthenticateCertificate([]string) (string, error)
 	AuthenticateKeyPair(string, string) (string, error)
 	Create(*models.ServiceAccount) error
 	CreateKeyPairType(string) (*models.ServiceAccount, error)
@@ -21,6 +24,7 @@ type ServiceAccounts interface {
 	CreatePermission(string, *models.Permission) error
 	CreateWithNested(*ServiceAccountWithNested) error
 	ForEmail(string) (*models.ServiceAccount, error)
+	ForOAuth2Login(string, string) (*models.ServiceAccount, error)
 	Get(string) (*models.ServiceAccount, error)
 	GetPermissions(string) ([]models.Permission, error)
 	GetRoles(string) ([]models.Role, error)
@@ -42,22 +46,27 @@ type serviceAccounts struct {
 	repo           *repositories.All
 	ctx            context.Context
 	oauth2Provider oauth2.Provider
+	decisions      *DecisionLogger
 }
 
 func (sas serviceAccounts) WithContext(ctx context.Context) ServiceAccounts {
 	return &serviceAccounts{
 		sas.repo.WithContext(ctx), ctx, sas.oauth2Provider.WithContext(ctx),
+		sas.decisions,
 	}
 }
 
-// NewServiceAccounts serviceAccounts ctor
+// NewServiceAccounts serviceAccounts ctor; permission checks' decisions
+// are logged to decisions, which may be nil
 func NewServiceAccounts(
 	repo *repositories.All,
 	provider oauth2.Provider,
+	decisions *DecisionLogger,
 ) ServiceAccounts {
 	return &serviceAccounts{
 		repo:           repo,
 		oauth2Provider: provider,
+		decisions:      decisions,
 	}
 }
 
@@ -67,12 +76,17 @@ type ServiceAccountWithNested struct {
 	Name               string                    `json:"name"`
 	Email              string                    `json:"email"`
 	Picture            string                    `json:"picture"`
+	DisplayName        string                    `json:"displayName"`
+	HostedDomain       string                    `json:"hostedDomain"`
+	LastLoginAt        *time.Time                `json:"lastLoginAt"`
 	PermissionsStrings []string                  `json:"permissions"`
 	PermissionsAliases map[string]string         `json:"permissionsAliases"`
 	Permissions        []models.Permission       `json:"-"`
 	RolesIDs           []string                  `json:"rolesIds,omitempty"`
 	Roles              []models.Role             `json:"roles"`
+	ManagedRolesIDs    []string                  `json:"managedRolesIds"`
 	AuthenticationType models.AuthenticationType `json:"authenticationType"`
+	CertificateSubject string                    `json:"certificateSubject"`
 }
 
 // Validate ServiceAccountWithNested fields
@@ -82,12 +96,22 @@ func (sawn ServiceAccountWithNested) Validate() models.Validation {
 		v.AddError("name", "required")
 	}
 	if !sawn.AuthenticationType.Valid() {
-		v.AddError("authenticatonType", "must be oauth2 or keypair")
+		v.AddError("authenticatonType", "must be oauth2, keypair or certificate")
 	}
 	if sawn.AuthenticationType == models.AuthenticationTypes.OAuth2 &&
 		sawn.Email == "" {
 		v.AddError("email", "required")
 	}
+	if sawn.AuthenticationType == models.AuthenticationTypes.Certificate &&
+		sawn.CertificateSubject == "" {
+		v.AddError("certificateSubject", "required")
+	}
+	if sawn.CertificateSubject != "" &&
+		!models.IsCertificateSubject(sawn.CertificateSubject) {
+		v.AddError(
+			"certificateSubject", "must be typed as uri:, dns:, email: or cn:",
+		)
+	}
 	return *v
 }
 
@@ -106,22 +130,34 @@ func (sas serviceAccounts) CreateWithNested(
 			if err := createServiceAccount(sa, repo); err != nil {
 				return err
 			}
+		} else if sawn.AuthenticationType ==
+			models.AuthenticationTypes.Certificate {
+			sa = models.BuildCertificateServiceAccount(
+				sawn.Name, sawn.CertificateSubject,
+			)
+			if err := createServiceAccount(sa, repo); err != nil {
+				return err
+			}
 		}
 		for i := range sawn.RolesIDs {
-			if err := repo.Roles.Bind(&models.RoleBinding{
-				ServiceAccountID: sa.ID,
-				RoleID:           sawn.RolesIDs[i],
-			}); err != nil {
+			if err := bindRole(sas.ctx, repo, sa.ID, sawn.RolesIDs[i]); err != nil {
 				return err
 			}
 		}
 		for i := range sawn.Permissions {
 			sawn.Permissions[i].RoleID = sa.BaseRoleID
-			if err := repo.Permissions.Create(&sawn.Permissions[i]); err != nil {
+			if err := createPermission(sas.ctx, repo, &sawn.Permissions[i]); err != nil {
 				return err
 			}
 		}
-		return nil
+		after, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			sas.ctx, repo, "serviceAccounts.create", AuditTargetServiceAccount,
+			sa.ID, nil, after,
+		)
 	})
 }
 
@@ -133,11 +169,21 @@ func (sas serviceAccounts) UpdateWithNested(
 		if err != nil {
 			return err
 		}
+		before, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		// emails identify OAuth2 service accounts, so they never change
 		sa.Name = sawn.Name
-		sa.Email = sawn.Name
+		if sa.AuthenticationType == models.AuthenticationTypes.Certificate &&
+			sawn.CertificateSubject != "" {
+			sa.CertificateSubject = sawn.CertificateSubject
+		}
 		if err := repo.ServiceAccounts.Update(sa); err != nil {
 			return err
 		}
+		// managed bindings are kept by DropBindings and Bind ignores
+		// bindings that already exist, so they can't be edited here
 		if err := repo.ServiceAccounts.DropBindings(sawn.ID); err != nil {
 			return err
 		}
@@ -145,10 +191,7 @@ func (sas serviceAccounts) UpdateWithNested(
 			if roleID == sa.BaseRoleID {
 				continue
 			}
-			if err := repo.Roles.Bind(&models.RoleBinding{
-				ServiceAccountID: sa.ID,
-				RoleID:           roleID,
-			}); err != nil {
+			if err := bindRole(sas.ctx, repo, sa.ID, roleID); err != nil {
 				return err
 			}
 		}
@@ -157,11 +200,18 @@ func (sas serviceAccounts) UpdateWithNested(
 		}
 		for i := range sawn.Permissions {
 			sawn.Permissions[i].RoleID = sa.BaseRoleID
-			if err := repo.Permissions.Create(&sawn.Permissions[i]); err != nil {
+			if err := createPermission(sas.ctx, repo, &sawn.Permissions[i]); err != nil {
 				return err
 			}
 		}
-		return nil
+		after, err := auditedServiceAccount(repo, sa.ID)
+		if err != nil {
+			return err
+		}
+		return audit(
+			sas.ctx, repo, "serviceAccounts.update", AuditTargetServiceAccount,
+			sa.ID, before, after,
+		)
 	})
 }
 
@@ -190,13 +240,30 @@ func (sas serviceAccounts) GetWithNested(
 	if err != nil {
 		return nil, err
 	}
+	managed, err := sas.repo.Roles.ManagedBindings(serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	managedRolesIDs := make([]string, len(managed))
+	for i := range managed {
+		managedRolesIDs[i] = managed[i].RoleID
+	}
+	var lastLoginAt *time.Time
+	if !sa.LastLoginAt.IsZero() {
+		lastLoginAt = &sa.LastLoginAt.Time
+	}
 	return &ServiceAccountWithNested{
 		ID:                 sa.ID,
 		Name:               sa.Name,
 		Email:              sa.Email,
 		Picture:            sa.Picture,
+		DisplayName:        sa.DisplayName,
+		HostedDomain:       sa.HostedDomain,
+		LastLoginAt:        lastLoginAt,
 		Roles:              roles,
+		ManagedRolesIDs:    managedRolesIDs,
 		AuthenticationType: sa.AuthenticationType,
+		CertificateSubject: sa.CertificateSubject,
 		PermissionsStrings: permissions,
 		PermissionsAliases: permissionsAliases,
 	}, nil
@@ -222,6 +289,26 @@ func (sas serviceAccounts) Create(sa *models.ServiceAccount) error {
 	})
 }
 
+// bindRole binds role roleID to service account saID if whoever binds it
+// in ctx could grant each of its permissions
+func bindRole(
+	ctx context.Context, repo *repositories.All, saID, roleID string,
+) error {
+	ps, err := repo.Permissions.ForRole(roleID)
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		if err := requireStepUpToGrant(ctx, repo, p); err != nil {
+			return err
+		}
+	}
+	return repo.Roles.Bind(&models.RoleBinding{
+		RoleID:           roleID,
+		ServiceAccountID: saID,
+	})
+}
+
 func createServiceAccount(
 	sa *models.ServiceAccount, repo *repositories.All,
 ) error {
@@ -298,6 +385,48 @@ func (sas serviceAccounts) ForEmail(
 	return sas.repo.ServiceAccounts.ForEmail(email)
 }
 
+// ForOAuth2Login returns email's service account for a login through
+// provider, which must be the one it belongs to
+func (sas serviceAccounts) ForOAuth2Login(
+	email, provider string,
+) (*models.ServiceAccount, error) {
+	sa, err := sas.repo.ServiceAccounts.ForEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := bindOAuth2Provider(sas.repo, sa, provider); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// bindOAuth2Provider checks sa logs in through provider. Accounts that
+// don't belong to one yet, e.g. provisioned through SCIM, are bound to the
+// first one they log in through
+func bindOAuth2Provider(
+	repo *repositories.All, sa *models.ServiceAccount, provider string,
+) error {
+	if sa.OAuth2Provider == "" {
+		bound, err := repo.ServiceAccounts.BindOAuth2Provider(sa.ID, provider)
+		if err != nil {
+			return err
+		}
+		if bound {
+			sa.OAuth2Provider = provider
+			return nil
+		}
+		current, err := repo.ServiceAccounts.Get(sa.ID)
+		if err != nil {
+			return err
+		}
+		sa.OAuth2Provider = current.OAuth2Provider
+	}
+	if sa.OAuth2Provider != provider {
+		return errors.NewOAuth2ProviderMismatchError(sa.ID, provider)
+	}
+	return nil
+}
+
 // List returns a list of all service accounts
 func (sas serviceAccounts) List(
 	lo *repositories.ListOptions,
@@ -336,21 +465,27 @@ func (sas *serviceAccounts) AuthenticateAccessToken(
 	if err != nil {
 		return nil, err
 	}
-	sa, err := sas.repo.ServiceAccounts.ForEmail(authResult.Email)
+	if authResult.ServiceAccountID != "" {
+		return sas.authenticateIssuedToken(authResult)
+	}
+	sa, err := sas.ForOAuth2Login(authResult.Email, authResult.Provider)
+	profile := authResult.Profile()
 	if _, ok := err.(*errors.EntityNotFoundError); ok {
-		sa = &models.ServiceAccount{
-			Name:    authResult.Email,
-			Email:   authResult.Email,
-			Picture: authResult.Picture,
-		}
+		sa = models.BuildOAuth2ServiceAccount(authResult.Email, authResult.Email)
+		sa.OAuth2Provider = authResult.Provider
 		if err = sas.Create(sa); err != nil {
 			return nil, err
 		}
 	} else if err != nil {
 		return nil, err
-	} else if authResult.Picture != "" && authResult.Picture != sa.Picture {
-		sa.Picture = authResult.Picture
-		if err = sas.repo.ServiceAccounts.Update(sa); err != nil {
+	} else if sa.Disabled {
+		return nil, errors.NewDisabledServiceAccountError(sa.ID)
+	}
+	// the provider is only asked for the profile when the token is refreshed
+	if !profile.IsZero() {
+		if err = sas.repo.ServiceAccounts.UpdateProfile(
+			sa.ID, profile,
+		); err != nil {
 			return nil, err
 		}
 	}
@@ -361,6 +496,38 @@ func (sas *serviceAccounts) AuthenticateAccessToken(
 	}, nil
 }
 
+// authenticateIssuedToken resolves tokens Will.IAM issued itself, which
+// already know their service account
+func (sas *serviceAccounts) authenticateIssuedToken(
+	authResult *models.AuthResult,
+) (*models.AccessTokenAuth, error) {
+	sa, err := sas.repo.ServiceAccounts.Get(authResult.ServiceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if sa.Disabled {
+		return nil, errors.NewDisabledServiceAccountError(sa.ID)
+	}
+	if authResult.ImpersonatorID != "" {
+		impersonator, err := sas.repo.ServiceAccounts.Get(
+			authResult.ImpersonatorID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if impersonator.Disabled {
+			return nil, errors.NewDisabledServiceAccountError(impersonator.ID)
+		}
+	}
+	return &models.AccessTokenAuth{
+		ServiceAccountID: sa.ID,
+		AccessToken:      authResult.AccessToken,
+		Email:            sa.Email,
+		Scope:            authResult.Scope,
+		ImpersonatorID:   authResult.ImpersonatorID,
+	}, nil
+}
+
 // AuthenticateKeyPair verifies if key pair is valid
 func (sas *serviceAccounts) AuthenticateKeyPair(
 	keyID, keySecret string,
@@ -369,23 +536,54 @@ func (sas *serviceAccounts) AuthenticateKeyPair(
 	if err != nil {
 		return "", err
 	}
+	if sa.Disabled {
+		return "", errors.NewDisabledServiceAccountError(sa.ID)
+	}
 	return sa.ID, nil
 }
 
+// AuthenticateCertificate finds the service account of a verified client
+// certificate by its typed subjects, ordered most specific first as
+// returned by models.CertificateSubjects
+func (sas *serviceAccounts) AuthenticateCertificate(
+	subjects []string,
+) (string, error) {
+	saSl, err := sas.repo.ServiceAccounts.ForCertificateSubjects(subjects)
+	if err != nil {
+		return "", err
+	}
+	for _, subject := range subjects {
+		for _, sa := range saSl {
+			if sa.CertificateSubject != subject {
+				continue
+			}
+			if sa.Disabled {
+				return "", errors.NewDisabledServiceAccountError(sa.ID)
+			}
+			return sa.ID, nil
+		}
+	}
+	return "", errors.NewEntityNotFoundError(
+		models.ServiceAccount{}, strings.Join(subjects, ","),
+	)
+}
+
 // HasPermissionString checks if user has the ownership level required to take an
 // action over a resource
 func (sas serviceAccounts) HasPermissionString(
 	serviceAccountID, permissionStr string,
 ) (bool, error) {
-	permissions, err := sas.GetPermissions(serviceAccountID)
+	permission, err := models.BuildPermission(permissionStr)
 	if err != nil {
 		return false, err
 	}
-	permission, err := models.BuildPermission(permissionStr)
+	has, err := sas.HasPermissions(
+		serviceAccountID, []models.Permission{permission},
+	)
 	if err != nil {
 		return false, err
 	}
-	return permission.IsPresent(permissions), nil
+	return has[0], nil
 }
 
 func (sas serviceAccounts) HasAllOwnerPermissions(
@@ -425,35 +623,74 @@ func (sas serviceAccounts) HasPermissionsStrings(
 }
 
 // HasPermissions returns an array of bools indicating whether a service
-// account has some permissions
+// account has some permissions. Each decision is logged
 func (sas serviceAccounts) HasPermissions(
 	serviceAccountID string, permissions []models.Permission,
 ) ([]bool, error) {
-	saPermissions, err := sas.GetPermissions(serviceAccountID)
+	ds, err := serviceAccountDecisions(
+		sas.ctx, sas.repo, serviceAccountID, permissions,
+	)
 	if err != nil {
 		return nil, err
 	}
-	has := make([]bool, len(permissions))
-	for i := range permissions {
-		has[i] = permissions[i].IsPresent(saPermissions)
+	has := make([]bool, len(ds))
+	for i := range ds {
+		sas.decisions.Log(sas.ctx, ds[i])
+		has[i] = ds[i].Allowed
 	}
 	return has, nil
 }
 
+// serviceAccountHasPermissions also applies the token scope in ctx: a
+// scoped token only has the permissions both it and its account have
 func serviceAccountHasPermissions(
+	ctx context.Context,
 	repo *repositories.All,
 	serviceAccountID string,
 	permissions []models.Permission,
 ) ([]bool, error) {
+	ds, err := serviceAccountDecisions(ctx, repo, serviceAccountID, permissions)
+	if err != nil {
+		return nil, err
+	}
+	has := make([]bool, len(ds))
+	for i := range ds {
+		has[i] = ds[i].Allowed
+	}
+	return has, nil
+}
+
+// serviceAccountDecisions decides, as serviceAccountHasPermissions, if a
+// service account has each of permissions and why
+func serviceAccountDecisions(
+	ctx context.Context,
+	repo *repositories.All,
+	serviceAccountID string,
+	permissions []models.Permission,
+) ([]models.Decision, error) {
 	saPermissions, err := serviceAccountGetPermissions(repo, serviceAccountID)
 	if err != nil {
 		return nil, err
 	}
-	has := make([]bool, len(permissions))
+	ds := make([]models.Decision, len(permissions))
 	for i := range permissions {
-		has[i] = permissions[i].IsPresent(saPermissions)
+		ds[i] = models.Decision{
+			ServiceAccountID: serviceAccountID,
+			Permission:       permissions[i].String(),
+		}
+		grant, ok := permissions[i].MatchingGrant(saPermissions)
+		if !ok {
+			ds[i].Reason = models.DecisionReasonNoGrant
+			continue
+		}
+		ds[i].MatchedGrant = grant.String()
+		if !tokenScopeAllows(ctx, serviceAccountID, permissions[i]) {
+			ds[i].Reason = models.DecisionReasonTokenScope
+			continue
+		}
+		ds[i].Allowed = true
 	}
-	return has, nil
+	return ds, nil
 }
 
 func (sas serviceAccounts) GetPermissions(
@@ -475,9 +712,15 @@ func (sas serviceAccounts) CreatePermission(
 	if err != nil {
 		return err
 	}
-	permission.RoleID = sa.BaseRoleID
-	if err := sas.repo.Permissions.Create(permission); err != nil {
-		return err
-	}
-	return nil
+	return sas.repo.WithPGTx(sas.ctx, func(repo *repositories.All) error {
+		permission.RoleID = sa.BaseRoleID
+		if err := createPermission(sas.ctx, repo, permission); err != nil {
+			return err
+		}
+		return audit(
+			sas.ctx, repo, "serviceAccounts.createPermission",
+			AuditTargetServiceAccount, sa.ID,
+			nil, map[string]string{"permission": permission.String()},
+		)
+	})
 }
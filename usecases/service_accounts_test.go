@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/oauth2"
 	helpers "github.com/ghostec/Will.IAM/testing"
 )
 
@@ -64,3 +66,44 @@ func TestServiceAccountsCreateShouldCreateRoleAndRoleBinding(t *testing.T) {
 		return
 	}
 }
+
+func TestServiceAccountsAuthenticateAccessTokenBindsProvider(t *testing.T) {
+	beforeEachServiceAccounts(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	unbound, err := saUC.CreateOAuth2Type("unbound", "any@email.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	auth, err := saUC.AuthenticateAccessToken("some-token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if auth.ServiceAccountID != unbound.ID {
+		t.Errorf("Expected %s. Got %s", unbound.ID, auth.ServiceAccountID)
+	}
+	sa, err := saUC.Get(unbound.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if sa.OAuth2Provider != oauth2.GoogleProviderName {
+		t.Errorf("Expected account to be bound to google. Got %s", sa.OAuth2Provider)
+	}
+}
+
+func TestServiceAccountsAuthenticateAccessTokenOtherProvider(t *testing.T) {
+	beforeEachServiceAccounts(t)
+	saUC := helpers.GetServiceAccountsUseCase(t)
+	sa := models.BuildOAuth2ServiceAccount("okta user", "any@email.com")
+	sa.OAuth2Provider = "okta"
+	if err := saUC.Create(sa); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	// the mock provider stands for google
+	_, err := saUC.AuthenticateAccessToken("some-token")
+	if _, ok := err.(*errors.OAuth2ProviderMismatchError); !ok {
+		t.Errorf("Expected OAuth2ProviderMismatchError. Got %v", err)
+	}
+	if _, err := saUC.ForOAuth2Login("any@email.com", "okta"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
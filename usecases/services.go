@@ -2,7 +2,11 @@ package usecases
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/ghostec/Will.IAM/constants"
+	"github.com/ghostec/Will.IAM/errors"
 	"github.com/ghostec/Will.IAM/models"
 	"github.com/ghostec/Will.IAM/repositories"
 )
@@ -11,8 +15,22 @@ import (
 type Services interface {
 	List() ([]models.Service, error)
 	Get(string) (*models.Service, error)
+	WithPermissionName(string) (*models.Service, error)
 	Create(*models.Service) error
 	Update(*models.Service) error
+	ApplyManifest(
+		string, string, *models.ServiceManifest,
+	) (*models.Service, bool, error)
+	RotateAMSecret(string) (string, error)
+	GetServiceAccount(string) (*models.ServiceAccount, error)
+	RotateServiceAccountKey(string, time.Duration) (*models.ServiceAccount, error)
+	GetCatalog(string) (*models.ServiceCatalog, error)
+	SetCatalog(string, *models.ServiceCatalog) error
+	ListOwners(string) ([]models.ServiceAccount, error)
+	AddOwner(string, string) error
+	RemoveOwner(string, string) error
+	TransferOwnership(string, string, string) error
+	IsRedirectAllowed(string) (bool, error)
 	WithContext(context.Context) Services
 }
 
@@ -27,40 +45,204 @@ func (ss services) WithContext(ctx context.Context) Services {
 
 // Create a new service with unique name and permission name
 // Also creates an associate Service Account with full access
-// and attributes full access to creator
+// and makes creator its first owner
 func (ss services) Create(service *models.Service) error {
 	creatorSA, err := ss.repo.ServiceAccounts.Get(service.CreatorServiceAccountID)
 	if err != nil {
 		return err
 	}
 	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
-		sa := models.BuildKeyPairServiceAccount(service.Name)
-		if err := createServiceAccount(sa, repo); err != nil {
+		if err := createService(repo, service, creatorSA); err != nil {
 			return err
 		}
-		service.ServiceAccountID = sa.ID
-		if err := repo.Services.Create(service); err != nil {
+		return audit(
+			ss.ctx, repo, "services.create", AuditTargetService, service.ID,
+			nil, auditedService(service),
+		)
+	})
+}
+
+func createService(
+	repo *repositories.All, service *models.Service,
+	creatorSA *models.ServiceAccount,
+) error {
+	sa := models.BuildKeyPairServiceAccount(service.Name)
+	if err := createServiceAccount(sa, repo); err != nil {
+		return err
+	}
+	service.ServiceAccountID = sa.ID
+	if err := repo.Services.Create(service); err != nil {
+		return err
+	}
+	if err := repo.Permissions.Create(
+		buildServiceFullAccessPermission(service, sa.BaseRoleID),
+	); err != nil {
+		return err
+	}
+	return addServiceOwner(repo, service, creatorSA)
+}
+
+// addServiceOwner gives sa full access to service and EditService over it
+func addServiceOwner(
+	repo *repositories.All, service *models.Service, sa *models.ServiceAccount,
+) error {
+	if err := repo.ServiceOwners.Add(service.ID, sa.ID); err != nil {
+		return err
+	}
+	for _, p := range ServiceOwnerPermissions(service) {
+		p.RoleID = sa.BaseRoleID
+		if err := repo.Permissions.Create(&p); err != nil {
 			return err
 		}
-		buildFullAccessPermissionForRoleID := func(
-			roleID string,
-		) *models.Permission {
-			return &models.Permission{
-				Service:           service.PermissionName,
-				OwnershipLevel:    models.OwnershipLevels.Owner,
-				Action:            models.Action("*"),
-				ResourceHierarchy: models.ResourceHierarchy("*"),
-				RoleID:            roleID,
+	}
+	return nil
+}
+
+// ServiceOwnerPermissions are the permissions owners of service have as
+// such
+func ServiceOwnerPermissions(service *models.Service) []models.Permission {
+	editService, _ := models.BuildPermission(
+		models.BuildWillIAMPermissionOwner("EditService", service.ID),
+	)
+	return []models.Permission{
+		*buildServiceFullAccessPermission(service, ""), editService,
+	}
+}
+
+func buildServiceFullAccessPermission(
+	service *models.Service, roleID string,
+) *models.Permission {
+	return &models.Permission{
+		Service:           service.PermissionName,
+		OwnershipLevel:    models.OwnershipLevels.Owner,
+		Action:            models.Action("*"),
+		ResourceHierarchy: models.ResourceHierarchy("*"),
+		RoleID:            roleID,
+	}
+}
+
+// ApplyManifest reconciles the service m declares, in one transaction,
+// and tells whether it had to be created, by creatorSAID. The service's
+// catalog is replaced, each declared role gets exactly its permissions and
+// the service's service account gets full access to the service plus its
+// dependencies; roles no longer declared are left untouched. serviceID is
+// the service the caller was authorized to update, empty if it was
+// authorized to create it; if that's no longer so, nothing is applied
+func (ss services) ApplyManifest(
+	creatorSAID, serviceID string, m *models.ServiceManifest,
+) (*models.Service, bool, error) {
+	var service *models.Service
+	created := false
+	err := ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		var err error
+		service, err = repo.Services.WithPermissionName(m.PermissionName)
+		if err != nil {
+			return err
+		}
+		if service.ID != serviceID {
+			return errors.NewManifestConflictError(fmt.Sprintf(
+				"service %s changed while applying its manifest", m.PermissionName,
+			))
+		}
+		var before interface{}
+		if service.ID != "" {
+			before = auditedService(service)
+		}
+		if service.ID == "" {
+			creatorSA, err := repo.ServiceAccounts.Get(creatorSAID)
+			if err != nil {
+				return err
+			}
+			service = m.Service()
+			service.CreatorServiceAccountID = creatorSAID
+			if err := createService(repo, service, creatorSA); err != nil {
+				return err
+			}
+			created = true
+		} else {
+			service.Name = m.Name
+			service.AMURL = m.AMURL
+			service.RedirectOrigins = m.RedirectOrigins
+			if err := repo.Services.Update(service); err != nil {
+				return err
 			}
 		}
-		repo.Permissions.Create(
-			buildFullAccessPermissionForRoleID(sa.BaseRoleID),
-		)
-		repo.Permissions.Create(
-			buildFullAccessPermissionForRoleID(creatorSA.BaseRoleID),
+		if err := repo.ServiceActions.Replace(service.ID, m.Actions); err != nil {
+			return err
+		}
+		for _, mr := range m.Roles {
+			if err := applyManifestRole(
+				ss.ctx, repo, service, m.RoleName(mr.Name), mr.Permissions,
+			); err != nil {
+				return err
+			}
+		}
+		sa, err := repo.ServiceAccounts.Get(service.ServiceAccountID)
+		if err != nil {
+			return err
+		}
+		if err := repo.Roles.DropPermissions(sa.BaseRoleID); err != nil {
+			return err
+		}
+		if err := repo.Permissions.Create(
+			buildServiceFullAccessPermission(service, sa.BaseRoleID),
+		); err != nil {
+			return err
+		}
+		if err := createPermissionsStrings(
+			ss.ctx, repo, sa.BaseRoleID, m.Dependencies,
+		); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "services.applyManifest", AuditTargetService,
+			service.ID, before, m,
 		)
-		return nil
 	})
+	if err != nil {
+		return nil, false, err
+	}
+	return service, created, nil
+}
+
+// applyManifestRole makes role name, of service, exist with exactly
+// permissions. Roles by that name service didn't create are refused
+func applyManifestRole(
+	ctx context.Context, repo *repositories.All, service *models.Service,
+	name string, permissions []string,
+) error {
+	role, err := repo.Roles.GetByName(name)
+	if _, ok := err.(*errors.EntityNotFoundError); ok {
+		role = &models.Role{Name: name, ServiceID: service.ID}
+		err = repo.Roles.Create(role)
+	} else if err == nil {
+		if role.IsBaseRole || role.ServiceID != service.ID {
+			return errors.NewManifestConflictError(fmt.Sprintf(
+				"role %s doesn't belong to service %s", name, service.PermissionName,
+			))
+		}
+		err = repo.Roles.DropPermissions(role.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return createPermissionsStrings(ctx, repo, role.ID, permissions)
+}
+
+func createPermissionsStrings(
+	ctx context.Context, repo *repositories.All, roleID string, strs []string,
+) error {
+	ps, err := models.BuildPermissions(strs)
+	if err != nil {
+		return err
+	}
+	for i := range ps {
+		ps[i].RoleID = roleID
+		if err := createPermission(ctx, repo, &ps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (ss services) List() ([]models.Service, error) {
@@ -71,8 +253,271 @@ func (ss services) Get(id string) (*models.Service, error) {
 	return ss.repo.Services.Get(id)
 }
 
+// WithPermissionName returns the service named permissionName; its ID is
+// empty if there's none
+func (ss services) WithPermissionName(
+	permissionName string,
+) (*models.Service, error) {
+	return ss.repo.Services.WithPermissionName(permissionName)
+}
+
 func (ss services) Update(service *models.Service) error {
-	return ss.repo.Services.Update(service)
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		current, err := ss.getService(repo, service.ID)
+		if err != nil {
+			return err
+		}
+		if err := repo.Services.Update(service); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "services.update", AuditTargetService, service.ID,
+			auditedService(current), auditedService(service),
+		)
+	})
+}
+
+// RotateAMSecret gives service id a new secret to verify Will.IAM's calls
+// to its AM endpoint with; the previous one stops being used right away
+func (ss services) RotateAMSecret(id string) (string, error) {
+	secret, err := models.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		if err := repo.Services.SetAMSecret(id, secret); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "services.rotateAMSecret", AuditTargetService, id,
+			nil, nil,
+		)
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetServiceAccount returns the key pair service account service id acts
+// as, see Create
+func (ss services) GetServiceAccount(id string) (*models.ServiceAccount, error) {
+	svc, err := ss.repo.Services.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if svc.ID == "" {
+		return nil, errors.NewEntityNotFoundError(models.Service{}, id)
+	}
+	return ss.repo.ServiceAccounts.Get(svc.ServiceAccountID)
+}
+
+// RotateServiceAccountKey gives service id's service account a new key
+// pair, returned with its secret; the previous one keeps working for
+// overlap so the service can be redeployed with the new one
+func (ss services) RotateServiceAccountKey(
+	id string, overlap time.Duration,
+) (*models.ServiceAccount, error) {
+	sa, err := ss.GetServiceAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	kp := models.BuildKeyPairServiceAccount(sa.Name)
+	err = ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		if err := repo.ServiceAccounts.RotateKeyPair(
+			sa.ID, kp.KeyID, kp.KeySecret, overlap,
+		); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "services.rotateServiceAccountKey", AuditTargetService,
+			id, map[string]string{"keyId": sa.KeyID},
+			map[string]string{"keyId": kp.KeyID, "overlap": overlap.String()},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ss.repo.ServiceAccounts.Get(sa.ID)
+}
+
+// GetCatalog returns service id's catalog, empty if it registered none
+func (ss services) GetCatalog(id string) (*models.ServiceCatalog, error) {
+	actions, err := ss.repo.ServiceActions.ForService(id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ServiceCatalog{Actions: actions}, nil
+}
+
+// SetCatalog replaces service id's catalog. Permissions already granted
+// are kept even if the new catalog doesn't have them
+func (ss services) SetCatalog(id string, catalog *models.ServiceCatalog) error {
+	svc, err := ss.repo.Services.Get(id)
+	if err != nil {
+		return err
+	}
+	if svc.ID == "" {
+		return errors.NewEntityNotFoundError(models.Service{}, id)
+	}
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		current, err := repo.ServiceActions.ForService(id)
+		if err != nil {
+			return err
+		}
+		if err := repo.ServiceActions.Replace(id, catalog.Actions); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "services.setCatalog", AuditTargetService, id,
+			auditedCatalog(current), auditedCatalog(catalog.Actions),
+		)
+	})
+}
+
+// ListOwners lists service id's owners
+func (ss services) ListOwners(id string) ([]models.ServiceAccount, error) {
+	if _, err := ss.getService(ss.repo, id); err != nil {
+		return nil, err
+	}
+	return ss.repo.ServiceOwners.ForService(id)
+}
+
+// AddOwner makes service account saID an owner of service id
+func (ss services) AddOwner(id, saID string) error {
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		return ss.addOwner(repo, id, saID)
+	})
+}
+
+// RemoveOwner stops service account saID from owning service id, unless
+// it's the only owner
+func (ss services) RemoveOwner(id, saID string) error {
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		return ss.removeOwner(repo, id, saID)
+	})
+}
+
+// TransferOwnership makes service account toSAID an owner of service id
+// in place of fromSAID
+func (ss services) TransferOwnership(id, fromSAID, toSAID string) error {
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		if err := ss.addOwner(repo, id, toSAID); err != nil {
+			return err
+		}
+		if fromSAID == toSAID {
+			return nil
+		}
+		return ss.removeOwner(repo, id, fromSAID)
+	})
+}
+
+func (ss services) getService(
+	repo *repositories.All, id string,
+) (*models.Service, error) {
+	svc, err := repo.Services.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if svc.ID == "" {
+		return nil, errors.NewEntityNotFoundError(models.Service{}, id)
+	}
+	return svc, nil
+}
+
+func (ss services) addOwner(repo *repositories.All, id, saID string) error {
+	svc, err := ss.getService(repo, id)
+	if err != nil {
+		return err
+	}
+	if _, err := repo.ServiceOwners.LockIDs(id); err != nil {
+		return err
+	}
+	// creators own the services they create without stepping up, but
+	// anyone else made an owner gets RO over all of the service's resources
+	for _, p := range ServiceOwnerPermissions(svc) {
+		if err := requireStepUpToGrant(ss.ctx, repo, p); err != nil {
+			return err
+		}
+	}
+	sa, err := repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return err
+	}
+	if err := addServiceOwner(repo, svc, sa); err != nil {
+		return err
+	}
+	return audit(
+		ss.ctx, repo, "services.addOwner", AuditTargetService, id,
+		nil, map[string]string{"serviceAccountId": saID},
+	)
+}
+
+func (ss services) removeOwner(repo *repositories.All, id, saID string) error {
+	svc, err := ss.getService(repo, id)
+	if err != nil {
+		return err
+	}
+	ids, err := repo.ServiceOwners.LockIDs(id)
+	if err != nil {
+		return err
+	}
+	isOwner := false
+	for i := range ids {
+		isOwner = isOwner || ids[i] == saID
+	}
+	if !isOwner {
+		return errors.NewEntityNotFoundError(models.ServiceAccount{}, saID)
+	}
+	if len(ids) == 1 {
+		return errors.NewLastServiceOwnerError(id)
+	}
+	if err := repo.ServiceOwners.Remove(id, saID); err != nil {
+		return err
+	}
+	sa, err := repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return err
+	}
+	for _, p := range ServiceOwnerPermissions(svc) {
+		if err := repo.Permissions.DeleteFromRole(sa.BaseRoleID, p); err != nil {
+			return err
+		}
+	}
+	return audit(
+		ss.ctx, repo, "services.removeOwner", AuditTargetService, id,
+		map[string]string{"serviceAccountId": saID}, nil,
+	)
+}
+
+// checkCatalog checks p against its service's catalog
+func checkCatalog(repo *repositories.All, p models.Permission) error {
+	catalog := models.WillIAMCatalog()
+	if p.Service != constants.AppInfo.Name {
+		actions, err := repo.ServiceActions.ForPermissionName(p.Service)
+		if err != nil {
+			return err
+		}
+		catalog = models.ServiceCatalog{Actions: actions}
+	}
+	if !catalog.Allows(p) {
+		return errors.NewPermissionNotInCatalogError(p.String())
+	}
+	return nil
+}
+
+// IsRedirectAllowed checks if some service allows SSO to redirect to
+// referer's origin
+func (ss services) IsRedirectAllowed(referer string) (bool, error) {
+	origin := models.OriginOf(referer)
+	if origin == "" {
+		return false, nil
+	}
+	ssSl, err := ss.repo.Services.WithRedirectOrigin(origin)
+	if err != nil {
+		return false, err
+	}
+	return len(ssSl) > 0, nil
 }
 
 // NewServices services' ctor
@@ -0,0 +1,171 @@
+package usecases
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ghostec/Will.IAM/errors"
+	"github.com/ghostec/Will.IAM/models"
+	"github.com/ghostec/Will.IAM/repositories"
+	"github.com/sirupsen/logrus"
+)
+
+// Sessions define entrypoints for a service account's active tokens
+type Sessions interface {
+	List(string, string) ([]models.Session, error)
+	Revoke(string, string) error
+	WithContext(context.Context) Sessions
+}
+
+type sessions struct {
+	repo *repositories.All
+	ctx  context.Context
+}
+
+func (ss sessions) WithContext(ctx context.Context) Sessions {
+	return &sessions{ss.repo.WithContext(ctx), ctx}
+}
+
+// List returns saID's sessions; the one of currentAccessToken is marked
+// as current
+func (ss sessions) List(
+	saID, currentAccessToken string,
+) ([]models.Session, error) {
+	sa, err := ss.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return nil, err
+	}
+	tSl, err := ss.repo.Tokens.ListActive(sa.ID, sa.Email)
+	if err != nil {
+		return nil, err
+	}
+	sSl := make([]models.Session, len(tSl))
+	for i := range tSl {
+		sSl[i] = models.BuildSession(&tSl[i])
+		sSl[i].Current = tSl[i].AccessToken == currentAccessToken
+	}
+	return sSl, nil
+}
+
+// Revoke expires sessionID and every token rotated from the same login,
+// so its refresh token can't bring it back
+func (ss sessions) Revoke(saID, sessionID string) error {
+	sa, err := ss.repo.ServiceAccounts.Get(saID)
+	if err != nil {
+		return err
+	}
+	t, err := ss.repo.Tokens.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+	owned := t.ServiceAccountID == sa.ID ||
+		(t.ServiceAccountID == "" && sa.Email != "" && t.Email == sa.Email)
+	if !owned {
+		return errors.NewEntityNotFoundError(models.Session{}, sessionID)
+	}
+	return ss.repo.WithPGTx(ss.ctx, func(repo *repositories.All) error {
+		if err := repo.Tokens.RevokeFamily(t.FamilyID); err != nil {
+			return err
+		}
+		return audit(
+			ss.ctx, repo, "sessions.revoke", AuditTargetServiceAccount, sa.ID,
+			map[string]string{"sessionId": sessionID}, nil,
+		)
+	})
+}
+
+// NewSessions ctor
+func NewSessions(repo *repositories.All) Sessions {
+	return &sessions{repo: repo, ctx: context.Background()}
+}
+
+// TokenUsageRecorder collects token uses and writes them every interval in
+// one batch, so recording them doesn't add a write to every request. Only
+// the latest use of each token is kept; past maxPending distinct tokens,
+// uses of new ones are dropped until the next flush
+type TokenUsageRecorder struct {
+	repo       *repositories.All
+	logger     logrus.FieldLogger
+	interval   time.Duration
+	maxPending int
+	mu         sync.Mutex
+	pending    map[string]models.TokenUsage
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewTokenUsageRecorder ctor; call Start to flush periodically
+func NewTokenUsageRecorder(
+	repo *repositories.All, logger logrus.FieldLogger,
+	interval time.Duration, maxPending int,
+) *TokenUsageRecorder {
+	return &TokenUsageRecorder{
+		repo:       repo,
+		logger:     logger,
+		interval:   interval,
+		maxPending: maxPending,
+		pending:    map[string]models.TokenUsage{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Record a use of accessToken; it never blocks on storage
+func (tur *TokenUsageRecorder) Record(accessToken, userAgent, ip string) {
+	tur.mu.Lock()
+	defer tur.mu.Unlock()
+	if _, ok := tur.pending[accessToken]; !ok &&
+		len(tur.pending) >= tur.maxPending {
+		return
+	}
+	tur.pending[accessToken] = models.TokenUsage{
+		AccessToken: accessToken,
+		UserAgent:   userAgent,
+		IP:          ip,
+		UsedAt:      time.Now().UTC(),
+	}
+}
+
+// Flush writes every pending use
+func (tur *TokenUsageRecorder) Flush() error {
+	tur.mu.Lock()
+	pending := tur.pending
+	tur.pending = map[string]models.TokenUsage{}
+	tur.mu.Unlock()
+	usages := make([]models.TokenUsage, 0, len(pending))
+	for _, usage := range pending {
+		usages = append(usages, usage)
+	}
+	return tur.repo.Tokens.RecordUsage(usages)
+}
+
+// Start flushes every interval in the background until Stop
+func (tur *TokenUsageRecorder) Start() {
+	go func() {
+		defer close(tur.done)
+		ticker := time.NewTicker(tur.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tur.stop:
+				tur.flush()
+				return
+			case <-ticker.C:
+				tur.flush()
+			}
+		}
+	}()
+}
+
+// Stop flushes what's pending and stops the background flushes
+func (tur *TokenUsageRecorder) Stop() {
+	close(tur.stop)
+	<-tur.done
+}
+
+func (tur *TokenUsageRecorder) flush() {
+	if err := tur.Flush(); err != nil {
+		tur.logger.WithError(err).Error("token usage flush failed")
+	}
+}
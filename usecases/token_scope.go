@@ -0,0 +1,58 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/ghostec/Will.IAM/models"
+)
+
+type tokenScopeCtxKeyType string
+
+const tokenScopeCtxKey = tokenScopeCtxKeyType("tokenScope")
+
+// TokenScope restricts what ServiceAccountID can do with the token it
+// authenticated with to Permissions, on top of its own permissions
+type TokenScope struct {
+	ServiceAccountID string
+	Permissions      []models.Permission
+}
+
+// BuildTokenScope parses a token's scope
+func BuildTokenScope(saID string, scope []string) (*TokenScope, error) {
+	ps := make([]models.Permission, len(scope))
+	for i := range scope {
+		p, err := models.BuildPermission(scope[i])
+		if err != nil {
+			return nil, err
+		}
+		ps[i] = p
+	}
+	return &TokenScope{ServiceAccountID: saID, Permissions: ps}, nil
+}
+
+// WithTokenScope returns a copy of ctx in which permission checks of
+// scope.ServiceAccountID are restricted to scope
+func WithTokenScope(ctx context.Context, scope *TokenScope) context.Context {
+	return context.WithValue(ctx, tokenScopeCtxKey, scope)
+}
+
+// GetTokenScope returns the scope WithTokenScope put in ctx
+func GetTokenScope(ctx context.Context) (*TokenScope, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	scope, ok := ctx.Value(tokenScopeCtxKey).(*TokenScope)
+	return scope, ok
+}
+
+// tokenScopeAllows checks if the token scope in ctx, if any, allows saID
+// to use permission. Scopes of other service accounts don't apply
+func tokenScopeAllows(
+	ctx context.Context, saID string, permission models.Permission,
+) bool {
+	scope, ok := GetTokenScope(ctx)
+	if !ok || scope.ServiceAccountID != saID {
+		return true
+	}
+	return permission.IsPresent(scope.Permissions)
+}
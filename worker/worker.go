@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ghostec/Will.IAM/oauth2"
+	"github.com/ghostec/Will.IAM/repositories"
+	"github.com/ghostec/Will.IAM/usecases"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Worker runs Will.IAM's periodic jobs
+type Worker struct {
+	config  *viper.Viper
+	logger  logrus.FieldLogger
+	storage *repositories.Storage
+	jobs    []job
+	stop    chan struct{}
+}
+
+// job runs every interval; jobs with no interval are disabled
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context) error
+}
+
+// NewWorker creates a new worker
+func NewWorker(
+	config *viper.Viper, logger logrus.FieldLogger,
+	storageOrNil *repositories.Storage,
+) (*Worker, error) {
+	if storageOrNil == nil {
+		storageOrNil = repositories.NewStorage()
+	}
+	w := &Worker{
+		config:  config,
+		logger:  logger,
+		storage: storageOrNil,
+		stop:    make(chan struct{}),
+	}
+	if err := w.configure(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Worker) configure() error {
+	if w.storage.PG == nil {
+		if err := w.storage.ConfigurePG(w.config); err != nil {
+			return err
+		}
+	}
+	repo := repositories.New(w.storage)
+	providers, err := oauth2.NewProvidersFromConfig(w.config, repo)
+	if err != nil {
+		return err
+	}
+	w.jobs = append(w.jobs, w.profileSyncJob(
+		usecases.NewProfiles(repo, providers),
+	))
+	w.jobs = append(w.jobs, w.auditCheckpointJob(usecases.NewAudit(repo)))
+	return nil
+}
+
+// auditCheckpointJob signs the audit log's head with
+// audit.checkpoints.secret; it's disabled without one. Checkpoints are
+// logged too, so copies of them live outside the database
+func (w *Worker) auditCheckpointJob(auditUC usecases.Audit) job {
+	secret := w.config.GetString("audit.checkpoints.secret")
+	interval := w.config.GetDuration("audit.checkpoints.interval")
+	if secret == "" {
+		interval = 0
+	}
+	return job{
+		name:     "auditCheckpoint",
+		interval: interval,
+		run: func(ctx context.Context) error {
+			c, created, err := auditUC.WithContext(ctx).Checkpoint([]byte(secret))
+			if err != nil || !created {
+				return err
+			}
+			w.logger.WithFields(logrus.Fields{
+				"seq":       c.Seq,
+				"hash":      c.Hash,
+				"signature": c.Signature,
+				"createdAt": c.CreatedAt,
+			}).Info("audit log checkpointed")
+			return nil
+		},
+	}
+}
+
+// profileSyncJob syncs every stale profile, worker.profileSync.batchSize
+// accounts at a time
+func (w *Worker) profileSyncJob(pfUC usecases.Profiles) job {
+	maxAge := w.config.GetDuration("worker.profileSync.maxAge")
+	batchSize := w.config.GetInt("worker.profileSync.batchSize")
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return job{
+		name:     "profileSync",
+		interval: w.config.GetDuration("worker.profileSync.interval"),
+		run: func(ctx context.Context) error {
+			total := &usecases.ProfileSyncResult{}
+			for {
+				result, err := pfUC.WithContext(ctx).SyncStale(maxAge, batchSize)
+				if err != nil {
+					return err
+				}
+				total.Synced += result.Synced
+				total.Skipped += result.Skipped
+				total.Failed += result.Failed
+				// synced accounts leave the stale list, so this ends
+				if result.Synced+result.Skipped+result.Failed < batchSize {
+					break
+				}
+			}
+			w.logger.WithFields(logrus.Fields{
+				"synced":  total.Synced,
+				"skipped": total.Skipped,
+				"failed":  total.Failed,
+			}).Info("profiles synced")
+			return nil
+		},
+	}
+}
+
+// Start runs every enabled job right away and then every interval, until
+// Stop is called
+func (w *Worker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, j := range w.jobs {
+		if j.interval <= 0 {
+			w.logger.WithField("job", j.name).Info("job disabled")
+			continue
+		}
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			w.loop(ctx, j)
+		}(j)
+	}
+	<-w.stop
+	cancel()
+	wg.Wait()
+}
+
+// Stop makes Start return once running jobs finish
+func (w *Worker) Stop() {
+	close(w.stop)
+}
+
+func (w *Worker) loop(ctx context.Context, j job) {
+	l := w.logger.WithField("job", j.name)
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.run(ctx); err != nil {
+			l.WithError(err).Error("job failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}